@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// apiKeyPrefix marks keys generated by "gauditor apikey".
+const apiKeyPrefix = "gdk_"
+
+// apiKeyEntry is one key of the keys file. Only the SHA-256 of the key is
+// stored: keys are 256 random bits, so a fast hash does not weaken them.
+// An entry with cert instead of hash grants a mutual TLS client certificate.
+type apiKeyEntry struct {
+	ID      string   `yaml:"id"`             // names the key in logs and errors
+	Hash    string   `yaml:"hash,omitempty"` // "sha256:<hex>"
+	Cert    string   `yaml:"cert,omitempty"` // certificate URI or DNS SAN, or "CN=<name>"
+	Tenants []string `yaml:"tenants"`        // "*" grants every tenant
+	Scopes  []string `yaml:"scopes"`         // ingest, query, admin
+}
+
+type apiKeyFile struct {
+	Keys []apiKeyEntry `yaml:"keys"`
+}
+
+// hashAPIKey returns the keys file hash of key.
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// parseAPIKeys validates a YAML or JSON keys file and indexes its keys by
+// hash, and its certificate entries by "cert:<identity>".
+func parseAPIKeys(data []byte) (map[string]*principal, error) {
+	var f apiKeyFile
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&f); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	keys := make(map[string]*principal, len(f.Keys))
+	ids := make(map[string]bool, len(f.Keys))
+	for i, k := range f.Keys {
+		index, name := strings.ToLower(k.Hash), "key "+k.ID
+		if k.Cert != "" {
+			index, name = "cert:"+k.Cert, "client "+k.ID
+		}
+		switch {
+		case k.ID == "":
+			return nil, fmt.Errorf("keys[%d]: id is required", i)
+		case ids[k.ID]:
+			return nil, fmt.Errorf("key %s: id used twice", k.ID)
+		case k.Cert != "" && k.Hash != "":
+			return nil, fmt.Errorf("key %s: set hash or cert, not both", k.ID)
+		case k.Cert == "" && !validHash(k.Hash):
+			return nil, fmt.Errorf("key %s: hash must be sha256:<64 hex digits>", k.ID)
+		case keys[index] != nil:
+			return nil, fmt.Errorf("key %s: same hash or cert as %s", k.ID, keys[index].Name)
+		case len(k.Tenants) == 0 || slices.Contains(k.Tenants, ""):
+			return nil, fmt.Errorf("key %s: tenants must be non-empty", k.ID)
+		case len(k.Scopes) == 0:
+			return nil, fmt.Errorf("key %s: scopes are required", k.ID)
+		}
+		for _, s := range k.Scopes {
+			if !slices.Contains(knownScopes, s) {
+				return nil, fmt.Errorf("key %s: unknown scope %q (want %s)", k.ID, s, strings.Join(knownScopes, ", "))
+			}
+		}
+		ids[k.ID] = true
+		keys[index] = &principal{Name: name, Key: k.ID, Tenants: k.Tenants, Scopes: k.Scopes}
+	}
+	return keys, nil
+}
+
+func validHash(h string) bool {
+	digest, ok := strings.CutPrefix(h, "sha256:")
+	if !ok || len(digest) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(digest)
+	return err == nil
+}
+
+// apiKeys authenticates requests against a keys file. The file is re-read
+// by watch, so keys are added, rotated and revoked without a restart: to
+// rotate, add the new key, move clients to it, then remove the old one.
+type apiKeys struct {
+	path string
+	keys atomic.Pointer[map[string]*principal]
+
+	mu   sync.Mutex // serializes reloads
+	last []byte     // file contents last loaded
+}
+
+// newAPIKeys loads the keys file at path.
+func newAPIKeys(path string) (*apiKeys, error) {
+	k := &apiKeys{path: path}
+	if _, err := k.reload(); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// reload re-reads the keys file and reports whether it changed. An invalid
+// file keeps the previous keys in use.
+func (k *apiKeys) reload() (bool, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	data, err := os.ReadFile(k.path)
+	if err != nil {
+		return false, fmt.Errorf("api keys: %w", err)
+	}
+	if k.last != nil && bytes.Equal(data, k.last) {
+		return false, nil
+	}
+	keys, err := parseAPIKeys(data)
+	if err != nil {
+		return false, fmt.Errorf("api keys %s: %w", k.path, err)
+	}
+	k.keys.Store(&keys)
+	k.last = data
+	return true, nil
+}
+
+// watch reloads the keys file every interval until stop is closed.
+func (k *apiKeys) watch(stop <-chan struct{}, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+			changed, err := k.reload()
+			if err != nil {
+				log.Printf("%v; keeping the previous keys", err)
+			} else if changed {
+				log.Printf("gauditor API keys reloaded: %d keys", k.len())
+			}
+		}
+	}
+}
+
+func (k *apiKeys) len() int { return len(*k.keys.Load()) }
+
+// authenticate accepts the key as "Authorization: Bearer <key>" or in the
+// X-API-Key header. Requests without a key are matched by their verified
+// client certificate, if any.
+func (k *apiKeys) authenticate(r *http.Request) (*principal, error) {
+	key := r.Header.Get("X-API-Key")
+	if key == "" {
+		key, _ = strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	}
+	if key == "" {
+		return k.clientCert(r)
+	}
+	if p := (*k.keys.Load())[hashAPIKey(key)]; p != nil {
+		return p, nil
+	}
+	return nil, errUnauthenticated
+}
+
+// clientCert maps the client certificate of a mutual TLS connection to the
+// first cert entry naming one of its identities (see certIdentities).
+func (k *apiKeys) clientCert(r *http.Request) (*principal, error) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return nil, errUnauthenticated
+	}
+	leaf := r.TLS.VerifiedChains[0][0]
+	keys := *k.keys.Load()
+	for _, id := range certIdentities(leaf) {
+		if p := keys["cert:"+id]; p != nil {
+			return p, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: client certificate %q has no cert entry", errUnauthenticated, leaf.Subject.String())
+}
+
+// runAPIKey implements "gauditor apikey [flags]": it prints a new random key
+// and the keys file entry holding its hash to stdout, and usage and errors to
+// stderr. The key itself is not stored anywhere; hand it to the client.
+func runAPIKey(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("gauditor apikey", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	id := fs.String("id", "", "key name shown in logs (required)")
+	tenants := fs.String("tenants", "", `comma-separated tenants, or "*" for all (required)`)
+	scopes := fs.String("scopes", scopeIngest+","+scopeQuery, "comma-separated scopes: ingest, query, admin")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	entry := apiKeyEntry{ID: *id, Tenants: splitList(*tenants), Scopes: splitList(*scopes)}
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		fmt.Fprintln(stderr, "apikey:", err)
+		return 1
+	}
+	key := apiKeyPrefix + base64.RawURLEncoding.EncodeToString(raw)
+	entry.Hash = hashAPIKey(key)
+	out, err := yaml.Marshal(apiKeyFile{Keys: []apiKeyEntry{entry}})
+	if err == nil {
+		_, err = parseAPIKeys(out)
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, "apikey:", err)
+		return 2
+	}
+	fmt.Fprintf(stdout, "key: %s\n\n# keys file entry\n%s", key, out)
+	return 0
+}
+
+func splitList(s string) []string {
+	var out []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	g "github.com/antoniomarcosferreira/gauditor/pkg/gauditor"
+)
+
+// keysFile writes a keys file granting each key its tenants and scopes.
+func keysFile(t *testing.T, path string, keys map[string][2]string) {
+	t.Helper()
+	var b strings.Builder
+	b.WriteString("keys:\n")
+	for key, grant := range keys {
+		b.WriteString("  - id: " + key + "\n    hash: " + hashAPIKey(key) + "\n")
+		b.WriteString("    tenants: [" + grant[0] + "]\n    scopes: [" + grant[1] + "]\n")
+	}
+	if err := os.WriteFile(path, []byte(b.String()), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func do(t *testing.T, method, url, key, body string) (int, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key != "" {
+		req.Header.Set("Authorization", "Bearer "+key)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	raw, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(raw)
+}
+
+func TestParseAPIKeys_Validation(t *testing.T) {
+	h := hashAPIKey("k")
+	for name, file := range map[string]string{
+		"missing id":    "keys: [{hash: " + h + ", tenants: [a], scopes: [query]}]",
+		"bad hash":      "keys: [{id: a, hash: md5:00, tenants: [a], scopes: [query]}]",
+		"no tenants":    "keys: [{id: a, hash: " + h + ", scopes: [query]}]",
+		"no scopes":     "keys: [{id: a, hash: " + h + ", tenants: [a]}]",
+		"unknown scope": "keys: [{id: a, hash: " + h + ", tenants: [a], scopes: [delete]}]",
+		"unknown field": "keys: [{id: a, key: secret, hash: " + h + ", tenants: [a], scopes: [query]}]",
+		"duplicate id":  "keys: [{id: a, hash: " + h + ", tenants: [a], scopes: [query]}, {id: a, hash: " + hashAPIKey("j") + ", tenants: [a], scopes: [query]}]",
+		"same hash":     "keys: [{id: a, hash: " + h + ", tenants: [a], scopes: [query]}, {id: b, hash: " + h + ", tenants: [a], scopes: [query]}]",
+	} {
+		if _, err := parseAPIKeys([]byte(file)); err == nil {
+			t.Errorf("%s: want an error", name)
+		}
+	}
+	keys, err := parseAPIKeys([]byte(`{"keys": [{"id": "a", "hash": "` + strings.ToUpper(h[7:]) + `", "tenants": ["a"], "scopes": ["query"]}]}`))
+	if err == nil || keys != nil {
+		t.Fatalf("a hash without its sha256: prefix must be rejected")
+	}
+	if keys, err := parseAPIKeys([]byte(`{"keys": [{"id": "a", "hash": "sha256:` + strings.ToUpper(h[7:]) + `", "tenants": ["a"], "scopes": ["query"]}]}`)); err != nil || len(keys) != 1 {
+		t.Fatalf("JSON keys file with an upper-case hash: %v", err)
+	}
+}
+
+func TestAPIKeys_ScopesAndTenants(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.yaml")
+	keysFile(t, path, map[string][2]string{
+		"acme-ingest": {"acme", "ingest"},
+		"acme-read":   {"acme", "query"},
+		"multi":       {"acme, beta", "ingest, query"},
+		"ops":         {`"*"`, "query, admin"},
+	})
+	keys, err := newAPIKeys(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := g.NewMemoryStorage()
+	_, _ = store.Save(context.Background(), g.Event{ID: "b1", Tenant: "beta", Action: "x"})
+	srv := httptest.NewServer(newServer(g.NewRecorder(store), withAuth(keys)))
+	t.Cleanup(srv.Close)
+	events := srv.URL + "/v1/events"
+
+	// Single-tenant keys force their tenant onto events.
+	status, body := do(t, "POST", events, "acme-ingest", `{"id":"a1","tenant":"beta","action":"login"}`)
+	if status != http.StatusCreated || !strings.Contains(body, `"tenant":"acme"`) {
+		t.Fatalf("forced tenant: %d %s", status, body)
+	}
+	for _, tc := range []struct {
+		method, url, key, body string
+		want                   int
+	}{
+		{"POST", events, "", `{"tenant":"acme","action":"x"}`, http.StatusUnauthorized},
+		{"POST", events, "wrong", `{"tenant":"acme","action":"x"}`, http.StatusUnauthorized},
+		{"POST", events, "acme-read", `{"tenant":"acme","action":"x"}`, http.StatusForbidden},
+		{"POST", events, "multi", `{"tenant":"gamma","action":"x"}`, http.StatusForbidden},
+		{"POST", events, "multi", `{"tenant":"beta","action":"x"}`, http.StatusCreated},
+		{"GET", events, "acme-ingest", "", http.StatusForbidden},
+		{"GET", events + "?tenant=beta", "acme-read", "", http.StatusForbidden},
+		{"GET", events, "multi", "", http.StatusForbidden},
+		{"GET", events + "?tenant=beta", "multi", "", http.StatusOK},
+		{"GET", events + "/b1", "acme-read", "", http.StatusNotFound},
+		{"GET", events + "/b1?tenant=beta", "acme-read", "", http.StatusForbidden},
+		{"GET", events + "/b1?tenant=beta", "ops", "", http.StatusOK},
+		{"GET", events + "/b1", "ops", "", http.StatusBadRequest},
+		{"DELETE", events, "", "", http.StatusMethodNotAllowed},
+	} {
+		if status, body := do(t, tc.method, tc.url, tc.key, tc.body); status != tc.want {
+			t.Errorf("%s %s as %q: want %d, got %d %s", tc.method, tc.url, tc.key, tc.want, status, body)
+		}
+	}
+
+	// Reads without a tenant are scoped to the key's only tenant.
+	if status, body := do(t, "GET", events, "acme-read", ""); status != http.StatusOK || !strings.Contains(body, `"a1"`) || strings.Contains(body, "beta") {
+		t.Fatalf("scoped query: %d %s", status, body)
+	}
+	if status, body := do(t, "GET", events+"/a1", "acme-read", ""); status != http.StatusOK {
+		t.Fatalf("scoped get: %d %s", status, body)
+	}
+	if status, body := do(t, "GET", events, "ops", ""); status != http.StatusOK || !strings.Contains(body, "beta") || !strings.Contains(body, "acme") {
+		t.Fatalf(`"*" key reads every tenant: %d %s`, status, body)
+	}
+
+	req, _ := http.NewRequest("POST", srv.URL+"/v1/events:batch", strings.NewReader(`[{"tenant":"beta","action":"x"},{"tenant":"gamma","action":"x"}]`))
+	req.Header.Set("X-API-Key", "multi")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var res batchResult
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil || res.Created != 1 || res.Results[1].Status != batchInvalid || !strings.Contains(res.Results[1].Error, "gamma") {
+		t.Fatalf("batch with a foreign tenant: %+v %v", res, err)
+	}
+	if status, _ := do(t, "POST", srv.URL+"/v1/events:batch", "", `[]`); status != http.StatusUnauthorized {
+		t.Fatalf("unauthenticated batch: want 401, got %d", status)
+	}
+}
+
+func TestAPIKeys_RotationWithoutRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.yaml")
+	keysFile(t, path, map[string][2]string{"old": {"acme", "query"}})
+	keys, err := newAPIKeys(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stop := make(chan struct{})
+	t.Cleanup(func() { close(stop) })
+	go keys.watch(stop, 5*time.Millisecond)
+	srv := httptest.NewServer(newServer(g.NewRecorder(g.NewMemoryStorage()), withAuth(keys)))
+	t.Cleanup(srv.Close)
+
+	waitFor := func(key string, want int) {
+		t.Helper()
+		deadline := time.Now().Add(2 * time.Second)
+		for {
+			status, _ := do(t, "GET", srv.URL+"/v1/events", key, "")
+			if status == want {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("key %s: want %d, still %d", key, want, status)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+	waitFor("old", http.StatusOK)
+	keysFile(t, path, map[string][2]string{"old": {"acme", "query"}, "new": {"acme", "query"}})
+	waitFor("new", http.StatusOK)
+	waitFor("old", http.StatusOK)
+	keysFile(t, path, map[string][2]string{"new": {"acme", "query"}})
+	waitFor("old", http.StatusUnauthorized)
+
+	// A broken file keeps the last valid keys.
+	if err := os.WriteFile(path, []byte("keys: [{id: x}]"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := keys.reload(); err == nil {
+		t.Fatal("want an error for an invalid keys file")
+	}
+	waitFor("new", http.StatusOK)
+}
+
+func TestRunAPIKey(t *testing.T) {
+	var out bytes.Buffer
+	if code := runAPIKey([]string{"-id", "ci", "-tenants", "acme,beta", "-scopes", "ingest"}, &out, io.Discard); code != 0 {
+		t.Fatalf("exit %d: %s", code, out.String())
+	}
+	key, entry, ok := strings.Cut(strings.TrimPrefix(out.String(), "key: "), "\n")
+	if !ok || !strings.HasPrefix(key, apiKeyPrefix) {
+		t.Fatalf("unexpected output: %s", out.String())
+	}
+	keys, err := parseAPIKeys([]byte(entry))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := keys[hashAPIKey(key)]
+	if p == nil || strings.Join(p.Tenants, ",") != "acme,beta" || strings.Join(p.Scopes, ",") != "ingest" {
+		t.Fatalf("entry does not grant the key: %+v", p)
+	}
+
+	out.Reset()
+	var errOut bytes.Buffer
+	if code := runAPIKey([]string{"-id", "ci"}, &out, &errOut); code != 2 || !strings.Contains(errOut.String(), "tenants") || out.Len() != 0 {
+		t.Fatalf("want a usage error without tenants on stderr only, got %d: stdout %q stderr %q", code, out.String(), errOut.String())
+	}
+}
+
+func TestRealMain_APIKeys(t *testing.T) {
+	t.Setenv("GAUDITOR_STORAGE", "")
+	t.Setenv("GAUDITOR_CONFIG", "")
+	t.Setenv("GAUDITOR_NO_SERVE", "1")
+	path := filepath.Join(t.TempDir(), "keys.yaml")
+	keysFile(t, path, map[string][2]string{"k": {"acme", "query"}})
+	if code, out := runMain(t, "-api-keys", path); code != 0 || !strings.Contains(out, "1 keys") {
+		t.Fatalf("want startup with keys, got %d: %s", code, out)
+	}
+	if code, out := runMain(t, "-api-keys", filepath.Join(t.TempDir(), "missing.yaml")); code != 1 || !strings.Contains(out, "api keys") {
+		t.Fatalf("want exit 1 for a missing keys file, got %d: %s", code, out)
+	}
+}
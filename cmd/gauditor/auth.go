@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/antoniomarcosferreira/gauditor/pkg/gauditor"
+)
+
+// Scopes granted to a caller.
+const (
+	scopeIngest = "ingest" // POST /v1/events, POST /v1/events:batch
+	scopeQuery  = "query"  // GET /v1/events, GET /v1/events/{id}
+	scopeAdmin  = "admin"  // administrative endpoints
+)
+
+var knownScopes = []string{scopeIngest, scopeQuery, scopeAdmin}
+
+// allTenants in a caller's tenants grants every tenant.
+const allTenants = "*"
+
+// errUnauthenticated is returned by authenticators for requests without
+// valid credentials.
+var errUnauthenticated = errors.New("missing or invalid credentials")
+
+// principal is an authenticated caller: the tenants it may act on and the
+// scopes it was granted.
+type principal struct {
+	Name    string
+	Subject string // user or service behind a token; the actor of its events
+	Key     string // id of the keys file entry, if any; see limitsFile
+	Tenants []string
+	Scopes  []string
+}
+
+// authenticator identifies the caller of a request, or fails with an error
+// wrapping errUnauthenticated.
+type authenticator interface {
+	authenticate(r *http.Request) (*principal, error)
+}
+
+type principalKey struct{}
+
+// principalFrom returns the caller stored by authorize, or nil when the
+// server runs without authentication.
+func principalFrom(ctx context.Context) *principal {
+	p, _ := ctx.Value(principalKey{}).(*principal)
+	return p
+}
+
+func (p *principal) hasScope(scope string) bool { return slices.Contains(p.Scopes, scope) }
+
+func (p *principal) allTenants() bool { return slices.Contains(p.Tenants, allTenants) }
+
+// prepareEvent applies the caller to an event being ingested: its tenant
+// (see ingestTenant) and, when the producer names no actor, its subject.
+func (p *principal) prepareEvent(e *gauditor.Event) error {
+	tenant, err := p.ingestTenant(e.Tenant)
+	if err != nil {
+		return err
+	}
+	e.Tenant = tenant
+	if p != nil && e.Actor.ID == "" {
+		e.Actor.ID = p.Subject
+	}
+	return nil
+}
+
+// ingestTenant returns the tenant an event is recorded under. A caller bound
+// to a single tenant forces it onto every event; one bound to several must
+// name one of them.
+func (p *principal) ingestTenant(tenant string) (string, error) {
+	switch {
+	case p == nil || p.allTenants():
+		return tenant, nil
+	case len(p.Tenants) == 1:
+		return p.Tenants[0], nil
+	case slices.Contains(p.Tenants, tenant):
+		return tenant, nil
+	}
+	return "", fmt.Errorf("%s may not write tenant %q", p.Name, tenant)
+}
+
+// queryTenant returns the tenant a read is scoped to. An empty tenant means
+// the caller's only tenant; reading another tenant, or every tenant, needs
+// the "*" grant.
+func (p *principal) queryTenant(tenant string) (string, error) {
+	switch {
+	case p == nil || p.allTenants():
+		return tenant, nil
+	case tenant == "" && len(p.Tenants) == 1:
+		return p.Tenants[0], nil
+	case tenant == "":
+		return "", fmt.Errorf("%s is bound to several tenants: set tenant", p.Name)
+	case slices.Contains(p.Tenants, tenant):
+		return tenant, nil
+	}
+	return "", fmt.Errorf("%s may not read tenant %q", p.Name, tenant)
+}
+
+// authorize authenticates requests whose method is in scopes and requires
+// the scope listed for it; other methods reach next unauthenticated, which
+// rejects them. With a nil auth every request passes.
+func authorize(auth authenticator, scopes map[string]string, next http.HandlerFunc) http.HandlerFunc {
+	if auth == nil {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		scope, ok := scopes[r.Method]
+		if !ok {
+			next(w, r)
+			return
+		}
+		p, err := auth.authenticate(r)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="gauditor"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(err.Error()))
+			return
+		}
+		if !p.hasScope(scope) {
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = fmt.Fprintf(w, "%s lacks the %s scope", p.Name, scope)
+			return
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, p)))
+	}
+}
+
+// anyAuth accepts requests accepted by any of its authenticators. When all
+// fail, the most specific error is returned, such as an expired token.
+type anyAuth []authenticator
+
+func (a anyAuth) authenticate(r *http.Request) (*principal, error) {
+	err := errUnauthenticated
+	for _, auth := range a {
+		p, e := auth.authenticate(r)
+		if e == nil {
+			return p, nil
+		}
+		if e != errUnauthenticated {
+			err = e
+		}
+	}
+	return nil, err
+}
+
+// authFlags are the command-line settings of request authentication.
+type authFlags struct {
+	apiKeys       string
+	apiKeysReload time.Duration
+	jwks          string
+	jwksRefresh   time.Duration
+	jwt           jwtConfig
+}
+
+func (f *authFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.apiKeys, "api-keys", os.Getenv("GAUDITOR_API_KEYS"), "YAML/JSON file of hashed API keys (see gauditor apikey)")
+	fs.DurationVar(&f.apiKeysReload, "api-keys-reload", 10*time.Second, "how often to re-read the API keys file (0 = never)")
+	fs.StringVar(&f.jwks, "jwks", os.Getenv("GAUDITOR_JWKS"), "JWKS file or http(s) URL; enables JWT bearer tokens")
+	fs.DurationVar(&f.jwksRefresh, "jwks-refresh", 5*time.Minute, "how often to refresh the JWKS (0 = only for unknown key IDs)")
+	fs.StringVar(&f.jwt.Issuer, "jwt-issuer", os.Getenv("GAUDITOR_JWT_ISSUER"), "required JWT iss claim")
+	fs.StringVar(&f.jwt.Audience, "jwt-audience", os.Getenv("GAUDITOR_JWT_AUDIENCE"), "required JWT aud claim; mandatory with -jwks")
+	fs.StringVar(&f.jwt.TenantClaim, "jwt-tenant-claim", "tenant", "JWT claim holding the tenant(s)")
+	fs.StringVar(&f.jwt.ScopeClaim, "jwt-scope-claim", "scope", "JWT claim holding the scopes (ingest, query, admin)")
+}
+
+// open builds the configured authenticators, or returns nil when none is
+// configured and the API is open. Background reloads stop with stop.
+func (f authFlags) open(ctx context.Context, stop <-chan struct{}) (authenticator, error) {
+	var auth anyAuth
+	if f.apiKeys != "" {
+		keys, err := newAPIKeys(f.apiKeys)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("gauditor API key authentication: %d keys from %s", keys.len(), f.apiKeys)
+		if f.apiKeysReload > 0 {
+			go keys.watch(stop, f.apiKeysReload)
+		}
+		auth = append(auth, keys)
+	}
+	if f.jwks != "" {
+		if f.jwt.Audience == "" {
+			return nil, errors.New("-jwks needs -jwt-audience: without it, tokens the issuer signs for any other client are accepted")
+		}
+		set, err := newJWKS(ctx, f.jwks)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("gauditor JWT authentication: %d keys from %s, tenant claim %q, scope claim %q", set.len(), f.jwks, f.jwt.TenantClaim, f.jwt.ScopeClaim)
+		if f.jwksRefresh > 0 {
+			go set.watch(stop, f.jwksRefresh)
+		}
+		auth = append(auth, &jwtAuth{cfg: f.jwt, jwks: set})
+	}
+	if len(auth) == 0 {
+		log.Println("gauditor: no -api-keys or -jwks; the API accepts unauthenticated requests for any tenant")
+		return nil, nil
+	}
+	return auth, nil
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/antoniomarcosferreira/gauditor/pkg/gauditor"
+)
+
+// Limits of POST /v1/events:batch.
+const (
+	maxBatchItems = 1000
+	maxBatchBytes = 10 << 20 // 10MB
+)
+
+// Per-item statuses reported by POST /v1/events:batch.
+const (
+	batchCreated   = "created"
+	batchDuplicate = "duplicate"
+	batchInvalid   = "invalid"
+	batchFailed    = "failed" // storage error; the item may be retried
+)
+
+type batchItemResult struct {
+	Index  int    `json:"index"`
+	ID     string `json:"id,omitempty"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+type batchResult struct {
+	Created   int               `json:"created"`
+	Duplicate int               `json:"duplicate"`
+	Invalid   int               `json:"invalid"`
+	Failed    int               `json:"failed"`
+	Results   []batchItemResult `json:"results"`
+}
+
+func (b *batchResult) add(r batchItemResult) {
+	switch r.Status {
+	case batchCreated:
+		b.Created++
+	case batchDuplicate:
+		b.Duplicate++
+	case batchInvalid:
+		b.Invalid++
+	default:
+		b.Failed++
+	}
+	b.Results = append(b.Results, r)
+}
+
+// batchHandler serves POST /v1/events:batch: a JSON array or NDJSON stream of
+// events, each validated and recorded independently. The response reports the
+// status of every item in order; the request only fails as a whole when the
+// body is malformed or over the limits, including the rate limits of limits.
+// Those are charged per tenant for the events that pass validation and are
+// not repeated in the batch; events the storage then refuses are refunded.
+func batchHandler(recorder *gauditor.Recorder, limits *limiter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBatchBytes))
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				w.WriteHeader(http.StatusRequestEntityTooLarge)
+				_, _ = fmt.Fprintf(w, "batch body exceeds %d bytes", maxBatchBytes)
+				return
+			}
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(err.Error()))
+			return
+		}
+		items, err := splitBatch(body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(err.Error()))
+			return
+		}
+		if len(items) > maxBatchItems {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			_, _ = fmt.Fprintf(w, "batch has %d events, the limit is %d", len(items), maxBatchItems)
+			return
+		}
+
+		caller := principalFrom(r.Context())
+		events := make([]gauditor.Event, len(items))
+		errs := make([]error, len(items))
+		repeated := make([]bool, len(items))
+		seen := make(map[string]bool, len(items))
+		perTenant := make(map[string]int)
+		for i, raw := range items {
+			events[i], errs[i] = decodeBatchEvent(raw)
+			if errs[i] == nil {
+				errs[i] = caller.prepareEvent(&events[i])
+			}
+			if errs[i] == nil {
+				errs[i] = checkEvent(events[i])
+			}
+			if errs[i] != nil {
+				continue
+			}
+			if id := events[i].Tenant + "\x00" + events[i].ID; events[i].ID != "" {
+				repeated[i], seen[id] = seen[id], true
+			}
+			if !repeated[i] {
+				perTenant[events[i].Tenant]++
+			}
+		}
+		if len(perTenant) > 0 && !limits.admit(w, caller, perTenant) {
+			return
+		}
+
+		res := batchResult{Results: make([]batchItemResult, 0, len(items))}
+		refund := make(map[string]int)
+		for i, e := range events {
+			item := batchItemResult{Index: i, ID: e.ID}
+			switch err := errs[i]; {
+			case err != nil:
+				item.Status, item.Error = batchInvalid, err.Error()
+			case repeated[i]:
+				item.Status, item.Error = batchDuplicate, "id repeated in batch"
+			default:
+				out, err := recorder.Record(r.Context(), e)
+				item.ID = out.ID
+				switch {
+				case err == nil:
+					item.Status = batchCreated
+				case errors.Is(err, gauditor.ErrDuplicateEvent):
+					item.Status, item.Error = batchDuplicate, err.Error()
+				case errors.Is(err, gauditor.ErrInvalidEvent):
+					item.Status, item.Error = batchInvalid, err.Error()
+				default:
+					item.Status, item.Error = batchFailed, err.Error()
+				}
+				if err != nil {
+					refund[e.Tenant]++
+				}
+			}
+			res.add(item)
+		}
+		limits.refund(caller, refund)
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(res)
+	}
+}
+
+// splitBatch returns the raw events of a JSON array, or of NDJSON (one event
+// per line, blank lines ignored).
+func splitBatch(body []byte) ([]json.RawMessage, error) {
+	body = bytes.TrimSpace(body)
+	if len(body) == 0 {
+		return nil, errors.New("empty batch")
+	}
+	if body[0] == '[' {
+		var items []json.RawMessage
+		if err := json.Unmarshal(body, &items); err != nil {
+			return nil, fmt.Errorf("invalid JSON array: %w", err)
+		}
+		return items, nil
+	}
+	var items []json.RawMessage
+	for _, line := range bytes.Split(body, []byte("\n")) {
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			items = append(items, line)
+		}
+	}
+	return items, nil
+}
+
+// checkEvent rejects the events Recorder.Record refuses as invalid, so they
+// are turned away before they are charged to a tenant's limits.
+func checkEvent(e gauditor.Event) error {
+	if e.Tenant == "" || e.Action == "" {
+		return fmt.Errorf("%w: tenant and action are required", gauditor.ErrInvalidEvent)
+	}
+	return nil
+}
+
+// decodeBatchEvent decodes one event as strictly as POST /v1/events does.
+func decodeBatchEvent(raw []byte) (gauditor.Event, error) {
+	var e gauditor.Event
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&e); err != nil {
+		return gauditor.Event{}, fmt.Errorf("invalid JSON: %w", err)
+	}
+	if err := dec.Decode(new(struct{})); err != io.EOF {
+		return gauditor.Event{}, errors.New("invalid JSON: trailing content")
+	}
+	return e, nil
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	g "github.com/antoniomarcosferreira/gauditor/pkg/gauditor"
+)
+
+func postBatch(t *testing.T, srv *httptest.Server, contentType string, body []byte) (int, batchResult, string) {
+	t.Helper()
+	resp, err := http.Post(srv.URL+"/v1/events:batch", contentType, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	raw, _ := io.ReadAll(resp.Body)
+	var res batchResult
+	if resp.StatusCode == http.StatusOK {
+		if err := json.Unmarshal(raw, &res); err != nil {
+			t.Fatalf("decode %s: %v", raw, err)
+		}
+	}
+	return resp.StatusCode, res, string(raw)
+}
+
+func statuses(res batchResult) string {
+	var out []string
+	for _, r := range res.Results {
+		out = append(out, r.Status)
+	}
+	return strings.Join(out, ",")
+}
+
+func TestBatch_JSONArrayAndNDJSON(t *testing.T) {
+	store := g.NewMemoryStorage()
+	srv := httptest.NewServer(newServer(g.NewRecorder(store)))
+	t.Cleanup(srv.Close)
+
+	array := `[
+		{"id":"e1","tenant":"acme","action":"login","actor":{"id":"u1"}},
+		{"tenant":"acme","actor":{"id":"u1"}},
+		{"id":"e1","tenant":"acme","action":"login"},
+		{"tenant":"acme","action":"view","unknown":1},
+		{"tenant":"acme","action":"logout"}
+	]`
+	status, res, raw := postBatch(t, srv, "application/json", []byte(array))
+	if status != http.StatusOK || statuses(res) != "created,invalid,duplicate,invalid,created" {
+		t.Fatalf("array: %d %s", status, raw)
+	}
+	if res.Created != 2 || res.Invalid != 2 || res.Duplicate != 1 || res.Failed != 0 {
+		t.Fatalf("array counts: %+v", res)
+	}
+	if res.Results[4].ID == "" || !strings.Contains(res.Results[3].Error, "unknown") {
+		t.Fatalf("want generated IDs and reasons: %s", raw)
+	}
+
+	// Duplicates are also detected against stored events.
+	ndjson := "{\"id\":\"e1\",\"tenant\":\"acme\",\"action\":\"login\"}\n\n{\"tenant\":\"acme\",\"action\":\"x\"}\n{not json}\n"
+	status, res, raw = postBatch(t, srv, "application/x-ndjson", []byte(ndjson))
+	if status != http.StatusOK || statuses(res) != "duplicate,created,invalid" || res.Results[2].Index != 2 {
+		t.Fatalf("ndjson: %d %s", status, raw)
+	}
+	if store.Len() != 3 {
+		t.Fatalf("want 3 stored events, got %d", store.Len())
+	}
+}
+
+func TestBatch_Limits(t *testing.T) {
+	srv := httptest.NewServer(newServer(g.NewRecorder(g.NewMemoryStorage())))
+	t.Cleanup(srv.Close)
+
+	tooMany := strings.Repeat("{\"tenant\":\"t\",\"action\":\"a\"}\n", maxBatchItems+1)
+	if status, _, raw := postBatch(t, srv, "application/x-ndjson", []byte(tooMany)); status != http.StatusRequestEntityTooLarge || !strings.Contains(raw, "limit") {
+		t.Fatalf("item limit: %d %s", status, raw)
+	}
+	huge := `[{"tenant":"t","action":"a","data":{"x":"` + strings.Repeat("a", maxBatchBytes) + `"}}]`
+	if status, _, raw := postBatch(t, srv, "application/json", []byte(huge)); status != http.StatusRequestEntityTooLarge {
+		t.Fatalf("size limit: %d %s", status, raw)
+	}
+	for _, body := range []string{"", "  \n", "[{", `{"a":1}]`} {
+		status, _, raw := postBatch(t, srv, "application/json", []byte(body))
+		if body == `{"a":1}]` {
+			// Not an array: read as a single NDJSON line with trailing content.
+			if status != http.StatusOK || !strings.Contains(raw, "invalid") {
+				t.Errorf("%q: %d %s", body, status, raw)
+			}
+			continue
+		}
+		if status != http.StatusBadRequest {
+			t.Errorf("%q: want 400, got %d %s", body, status, raw)
+		}
+	}
+	resp, err := http.Get(srv.URL + "/v1/events:batch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Fatalf("want 405, got %d", resp.StatusCode)
+	}
+}
+
+func TestHTTP_DuplicateEventConflict(t *testing.T) {
+	srv := httptest.NewServer(newServer(g.NewRecorder(g.NewMemoryStorage())))
+	t.Cleanup(srv.Close)
+	for i, want := range []int{http.StatusCreated, http.StatusConflict} {
+		resp, err := http.Post(srv.URL+"/v1/events", "application/json", strings.NewReader(`{"id":"x","tenant":"t","action":"a"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Fatalf("post %d: want %d, got %d", i, want, resp.StatusCode)
+		}
+	}
+}
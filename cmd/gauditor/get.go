@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/antoniomarcosferreira/gauditor/pkg/gauditor"
+)
+
+// getHandler serves GET /v1/events/{id}?tenant=<tenant>. Event IDs are only
+// unique within a tenant, so the tenant is required unless the caller is
+// bound to a single one; an event of another tenant is reported as missing.
+func getHandler(recorder *gauditor.Recorder) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		tenant, err := tenantParam(r)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(err.Error()))
+			return
+		}
+		if tenant, err = principalFrom(r.Context()).queryTenant(tenant); err != nil {
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(err.Error()))
+			return
+		}
+		if tenant == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte("parameter tenant is required"))
+			return
+		}
+		e, err := recorder.Get(r.Context(), tenant, r.PathValue("id"))
+		if errors.Is(err, gauditor.ErrNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(err.Error()))
+			return
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			_, _ = w.Write([]byte(err.Error()))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(e)
+	}
+}
+
+// tenantParam returns the tenant parameter; others are ignored as in parseQuery.
+func tenantParam(r *http.Request) (string, error) {
+	vs := r.URL.Query()["tenant"]
+	if len(vs) > 1 {
+		return "", errors.New("parameter tenant given more than once")
+	}
+	if len(vs) == 0 {
+		return "", nil
+	}
+	return vs[0], nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/antoniomarcosferreira/gauditor/pkg/gauditor"
+)
+
+// readyTimeout bounds the storage ping of GET /readyz.
+const readyTimeout = 2 * time.Second
+
+// healthHandler serves GET /healthz: the process is up and serving.
+func healthHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	_, _ = w.Write([]byte("ok"))
+}
+
+// readyHandler serves GET /readyz: 200 when the storage answers a ping (see
+// gauditor.Pinger), 503 otherwise. The cause is logged rather than returned,
+// since the endpoint is not authenticated.
+func readyHandler(recorder *gauditor.Recorder) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), readyTimeout)
+		defer cancel()
+		if err := recorder.Ping(ctx); err != nil {
+			log.Println("gauditor not ready: storage ping:", err)
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = w.Write([]byte("storage unreachable"))
+			return
+		}
+		_, _ = w.Write([]byte("ready"))
+	}
+}
+
+// shutdownOnSignal shuts srv down when the process receives SIGINT or
+// SIGTERM: listeners close at once and in-flight requests get up to timeout
+// to finish before their connections are closed. The returned function stops
+// watching for signals and, after one arrived, waits for the drain and
+// returns its error.
+func shutdownOnSignal(srv *http.Server, timeout time.Duration) (wait func() error) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	quit := make(chan struct{})
+	done := make(chan error, 1)
+	go func() {
+		select {
+		case <-quit:
+			done <- nil
+		case sig := <-sigs:
+			log.Printf("gauditor received %v, draining requests (up to %s)", sig, timeout)
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+			err := srv.Shutdown(ctx)
+			if err != nil {
+				_ = srv.Close()
+				err = fmt.Errorf("shutdown: %w", err)
+			}
+			done <- err
+		}
+	}()
+	return func() error {
+		signal.Stop(sigs)
+		close(quit)
+		return <-done
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	g "github.com/antoniomarcosferreira/gauditor/pkg/gauditor"
+	"github.com/antoniomarcosferreira/gauditor/pkg/gauditor/blob"
+	"github.com/antoniomarcosferreira/gauditor/pkg/gauditor/s3store"
+)
+
+// pingStore is a storage whose Ping fails with err.
+type pingStore struct {
+	*g.MemoryStorage
+	err error
+}
+
+func (p *pingStore) Ping(context.Context) error { return p.err }
+
+func TestHTTP_HealthAndReady(t *testing.T) {
+	store := &pingStore{MemoryStorage: g.NewMemoryStorage(), err: errors.New("dial tcp 10.0.0.7:5432: connection refused")}
+	path := filepath.Join(t.TempDir(), "keys.yaml")
+	keysFile(t, path, map[string][2]string{"k": {"acme", "query"}})
+	keys, err := newAPIKeys(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(newServer(g.NewRecorder(store), withAuth(keys)))
+	t.Cleanup(srv.Close)
+
+	if status, body := do(t, "GET", srv.URL+"/healthz", "", ""); status != http.StatusOK || body != "ok" {
+		t.Fatalf("healthz without credentials: %d %s", status, body)
+	}
+	status, body := do(t, "GET", srv.URL+"/readyz", "", "")
+	if status != http.StatusServiceUnavailable || strings.Contains(body, "10.0.0.7") {
+		t.Fatalf("readyz with the storage down: %d %s", status, body)
+	}
+	store.err = nil
+	if status, body := do(t, "GET", srv.URL+"/readyz", "", ""); status != http.StatusOK {
+		t.Fatalf("readyz: %d %s", status, body)
+	}
+	if status, _ := do(t, "HEAD", srv.URL+"/readyz", "", ""); status != http.StatusOK {
+		t.Fatalf("HEAD readyz: %d", status)
+	}
+	for _, path := range []string{"/healthz", "/readyz"} {
+		if status, _ := do(t, "POST", srv.URL+path, "", ""); status != http.StatusMethodNotAllowed {
+			t.Errorf("POST %s: want 405, got %d", path, status)
+		}
+	}
+}
+
+// freeAddr returns a loopback address nothing listens on.
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	return addr
+}
+
+// waitUp polls url until the server answers.
+func waitUp(t *testing.T, url string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		resp, err := http.Get(url)
+		if err == nil {
+			resp.Body.Close()
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("server at %s did not come up: %v", url, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func sigterm(t *testing.T) {
+	t.Helper()
+	p, err := os.FindProcess(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Signal(syscall.SIGTERM); err != nil {
+		t.Skipf("cannot signal the test process: %v", err)
+	}
+}
+
+func TestRun_DrainsOnSIGTERM(t *testing.T) {
+	for _, env := range []string{"GAUDITOR_TEST_EXIT", "GAUDITOR_TEST_NORMAL", "GAUDITOR_TEST_DEFAULT"} {
+		t.Setenv(env, "")
+	}
+	started, release := make(chan struct{}), make(chan struct{})
+	mux := http.NewServeMux()
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		_, _ = w.Write([]byte("done"))
+	})
+	mux.HandleFunc("/", func(http.ResponseWriter, *http.Request) {})
+	addr := freeAddr(t)
+	ran := make(chan error, 1)
+	go func() { ran <- run(addr, mux, nil, 5*time.Second) }()
+	waitUp(t, "http://"+addr+"/")
+
+	type result struct {
+		body string
+		err  error
+	}
+	slow := make(chan result, 1)
+	go func() {
+		resp, err := http.Get("http://" + addr + "/slow")
+		if err != nil {
+			slow <- result{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		b, err := io.ReadAll(resp.Body)
+		slow <- result{string(b), err}
+	}()
+	<-started
+	sigterm(t)
+
+	// New connections are refused while the in-flight request finishes.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		c, err := net.Dial("tcp", addr)
+		if err != nil {
+			break
+		}
+		c.Close()
+		if time.Now().After(deadline) {
+			t.Fatal("listener still open after SIGTERM")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	select {
+	case err := <-ran:
+		t.Fatalf("run returned before the in-flight request finished: %v", err)
+	default:
+	}
+	close(release)
+	if r := <-slow; r.err != nil || r.body != "done" {
+		t.Fatalf("in-flight request: %q %v", r.body, r.err)
+	}
+	if err := <-ran; err != nil {
+		t.Fatalf("run: %v", err)
+	}
+}
+
+func TestRun_ShutdownTimeout(t *testing.T) {
+	for _, env := range []string{"GAUDITOR_TEST_EXIT", "GAUDITOR_TEST_NORMAL", "GAUDITOR_TEST_DEFAULT"} {
+		t.Setenv(env, "")
+	}
+	started, release := make(chan struct{}), make(chan struct{})
+	t.Cleanup(func() { close(release) })
+	mux := http.NewServeMux()
+	mux.HandleFunc("/stuck", func(http.ResponseWriter, *http.Request) {
+		close(started)
+		<-release
+	})
+	mux.HandleFunc("/", func(http.ResponseWriter, *http.Request) {})
+	addr := freeAddr(t)
+	ran := make(chan error, 1)
+	go func() { ran <- run(addr, mux, nil, 50*time.Millisecond) }()
+	waitUp(t, "http://"+addr+"/")
+	go func() {
+		if resp, err := http.Get("http://" + addr + "/stuck"); err == nil {
+			resp.Body.Close()
+		}
+	}()
+	<-started
+	sigterm(t)
+	if err := <-ran; err == nil || !strings.Contains(err.Error(), "shutdown") {
+		t.Fatalf("want a shutdown error after the timeout, got %v", err)
+	}
+}
+
+func TestRealMain_FlushesStorageOnSIGTERM(t *testing.T) {
+	for _, env := range []string{"GAUDITOR_TEST_EXIT", "GAUDITOR_TEST_NORMAL", "GAUDITOR_TEST_DEFAULT", "GAUDITOR_ADDR", "GAUDITOR_STORAGE"} {
+		t.Setenv(env, "")
+	}
+	t.Setenv("GAUDITOR_NO_SERVE", "0")
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "archive")
+	config := filepath.Join(dir, "gauditor.yaml")
+	writeFile(t, config, []byte("storage:\n  backend: s3\n  options: {dir: "+archive+", prefix: logs, segmentBytes: 1048576, segmentAge: 1h}\n"))
+	addr := freeAddr(t)
+
+	type exit struct {
+		code int
+		out  string
+	}
+	exited := make(chan exit, 1)
+	go func() {
+		code, out := runMain(t, "-config", config, "-addr", addr)
+		exited <- exit{code, out}
+	}()
+	waitUp(t, "http://"+addr+"/healthz")
+	if status, body := do(t, "GET", "http://"+addr+"/readyz", "", ""); status != http.StatusOK {
+		t.Fatalf("readyz: %d %s", status, body)
+	}
+	if status, body := do(t, "POST", "http://"+addr+"/v1/events", "", `{"tenant":"acme","action":"login"}`); status != http.StatusCreated {
+		t.Fatalf("ingest: %d %s", status, body)
+	}
+	sigterm(t)
+	res := <-exited
+	if res.code != 0 || !strings.Contains(res.out, "draining") {
+		t.Fatalf("want a clean exit, got %d: %s", res.code, res.out)
+	}
+
+	// The buffered segment was written on the way out.
+	b, err := blob.NewDir(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	events, err := s3store.NewWithBucket(b, "logs").Query(context.Background(), g.Query{Tenant: "acme"})
+	if err != nil || len(events) != 1 {
+		t.Fatalf("want the buffered event flushed, got %v %v", events, err)
+	}
+}
@@ -0,0 +1,416 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// jwtLeeway tolerates clock skew between the issuer and the server.
+const jwtLeeway = time.Minute
+
+// jwtConfig selects the claims a token is checked and mapped with.
+type jwtConfig struct {
+	Issuer      string // required iss, if set
+	Audience    string // required in aud; tokens of the IdP's other clients lack it
+	TenantClaim string // string or array of tenants
+	ScopeClaim  string // space-separated string or array; unknown scopes are ignored
+}
+
+// jwtAuth authenticates "Authorization: Bearer <JWT>" requests with the
+// public keys of a JWKS file or URL. Tokens must be signed with RSA
+// (RS*/PS*), ECDSA (ES*) or Ed25519 (EdDSA) and carry exp. The subject
+// becomes the principal's name and the actor of events that name none.
+// Tokens never grant every tenant: "*" is reserved to API keys.
+type jwtAuth struct {
+	cfg  jwtConfig
+	jwks *jwks
+}
+
+func (a *jwtAuth) authenticate(r *http.Request) (*principal, error) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || strings.Count(token, ".") != 2 {
+		return nil, errUnauthenticated
+	}
+	claims, err := a.verify(r.Context(), token, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errUnauthenticated, err)
+	}
+	sub, _ := claims["sub"].(string)
+	p := &principal{Name: "token " + sub, Subject: sub, Tenants: claimList(claims, a.cfg.TenantClaim)}
+	if len(p.Tenants) == 0 || slices.Contains(p.Tenants, "") {
+		return nil, fmt.Errorf("%w: token has no %s claim", errUnauthenticated, a.cfg.TenantClaim)
+	}
+	if p.allTenants() {
+		return nil, fmt.Errorf("%w: token %s claim may not grant every tenant (%q)", errUnauthenticated, a.cfg.TenantClaim, allTenants)
+	}
+	for _, s := range claimList(claims, a.cfg.ScopeClaim) {
+		if slices.Contains(knownScopes, s) {
+			p.Scopes = append(p.Scopes, s)
+		}
+	}
+	return p, nil
+}
+
+// claimList reads a claim holding a string (split on spaces) or an array of
+// strings. A name missing at the top level is tried as a dot-separated path
+// into nested objects, such as "realm_access.roles".
+func claimList(claims map[string]any, name string) []string {
+	v, ok := claims[name]
+	if !ok {
+		var cur any = claims
+		for _, part := range strings.Split(name, ".") {
+			obj, isObj := cur.(map[string]any)
+			if !isObj {
+				return nil
+			}
+			cur = obj[part]
+		}
+		v = cur
+	}
+	switch v := v.(type) {
+	case string:
+		return strings.Fields(v)
+	case []any:
+		out := make([]string, 0, len(v))
+		for _, s := range v {
+			if s, ok := s.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+// verify checks the token's signature and registered claims and returns its
+// claims.
+func (a *jwtAuth) verify(ctx context.Context, token string, now time.Time) (map[string]any, error) {
+	parts := strings.Split(token, ".")
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("header: %w", err)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("signature is not base64url")
+	}
+	key, err := a.jwks.key(ctx, header.Kid, header.Alg)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifySignature(header.Alg, key, []byte(parts[0]+"."+parts[1]), sig); err != nil {
+		return nil, err
+	}
+	var claims map[string]any
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("claims: %w", err)
+	}
+	exp, ok := claims["exp"].(float64)
+	switch {
+	case !ok:
+		return nil, errors.New("token has no exp")
+	case now.After(time.Unix(int64(exp), 0).Add(jwtLeeway)):
+		return nil, errors.New("token expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(jwtLeeway).Before(time.Unix(int64(nbf), 0)) {
+		return nil, errors.New("token not valid yet")
+	}
+	if iss, _ := claims["iss"].(string); a.cfg.Issuer != "" && iss != a.cfg.Issuer {
+		return nil, fmt.Errorf("issuer %q not accepted", iss)
+	}
+	if a.cfg.Audience != "" && !slices.Contains(claimList(claims, "aud"), a.cfg.Audience) {
+		return nil, errors.New("token not issued for this audience")
+	}
+	return claims, nil
+}
+
+func decodeSegment(seg string, v any) error {
+	raw, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return errors.New("not base64url")
+	}
+	return json.Unmarshal(raw, v)
+}
+
+// verifySignature checks sig over signed for the JWS algorithm alg. HMAC and
+// "none" are not accepted: the server only holds public keys.
+func verifySignature(alg string, key crypto.PublicKey, signed, sig []byte) error {
+	var hash crypto.Hash
+	switch alg[min(2, len(alg)):] {
+	case "256":
+		hash = crypto.SHA256
+	case "384":
+		hash = crypto.SHA384
+	case "512":
+		hash = crypto.SHA512
+	}
+	digest := func() []byte {
+		switch hash {
+		case crypto.SHA384:
+			sum := sha512.Sum384(signed)
+			return sum[:]
+		case crypto.SHA512:
+			sum := sha512.Sum512(signed)
+			return sum[:]
+		}
+		sum := sha256.Sum256(signed)
+		return sum[:]
+	}
+	ok := false
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		switch {
+		case hash != 0 && strings.HasPrefix(alg, "RS"):
+			ok = rsa.VerifyPKCS1v15(k, hash, digest(), sig) == nil
+		case hash != 0 && strings.HasPrefix(alg, "PS"):
+			ok = rsa.VerifyPSS(k, hash, digest(), sig, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash}) == nil
+		default:
+			return fmt.Errorf("algorithm %q does not match an RSA key", alg)
+		}
+	case *ecdsa.PublicKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if hash == 0 || !strings.HasPrefix(alg, "ES") || len(sig) != 2*size {
+			return fmt.Errorf("algorithm %q does not match an ECDSA key", alg)
+		}
+		r, s := new(big.Int).SetBytes(sig[:size]), new(big.Int).SetBytes(sig[size:])
+		ok = ecdsa.Verify(k, digest(), r, s)
+	case ed25519.PublicKey:
+		if alg != "EdDSA" {
+			return fmt.Errorf("algorithm %q does not match an Ed25519 key", alg)
+		}
+		ok = ed25519.Verify(k, signed, sig)
+	default:
+		return fmt.Errorf("unsupported algorithm %q", alg)
+	}
+	if !ok {
+		return errors.New("invalid signature")
+	}
+	return nil
+}
+
+// jwk is one JSON Web Key; only public signing keys are used.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+type publicKey struct {
+	kid, alg string
+	key      crypto.PublicKey
+}
+
+// jwks holds the keys of a JWKS file or http(s) URL. It is refreshed by
+// watch and, at most once per minRefresh, when a token names an unknown
+// key ID, so keys rotated by the issuer are picked up without a restart.
+type jwks struct {
+	location   string
+	client     *http.Client
+	minRefresh time.Duration
+
+	keys atomic.Pointer[[]publicKey]
+
+	mu        sync.Mutex // serializes refreshes
+	attempted time.Time  // last refresh, successful or not
+}
+
+// newJWKS loads the key set at location, a file path or http(s) URL.
+func newJWKS(ctx context.Context, location string) (*jwks, error) {
+	j := &jwks{location: location, client: &http.Client{Timeout: 10 * time.Second}, minRefresh: time.Minute}
+	if err := j.refresh(ctx); err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+func (j *jwks) refresh(ctx context.Context) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.refreshLocked(ctx)
+}
+
+func (j *jwks) refreshLocked(ctx context.Context) error {
+	// Failed attempts count too: while the issuer is down, tokens with made-up
+	// key IDs must not each wait on, and add to, another fetch.
+	j.attempted = time.Now()
+	raw, err := j.fetch(ctx)
+	if err != nil {
+		return fmt.Errorf("jwks %s: %w", j.location, err)
+	}
+	keys, err := parseJWKS(raw)
+	if err != nil {
+		return fmt.Errorf("jwks %s: %w", j.location, err)
+	}
+	j.keys.Store(&keys)
+	return nil
+}
+
+func (j *jwks) fetch(ctx context.Context) ([]byte, error) {
+	if !strings.HasPrefix(j.location, "https://") && !strings.HasPrefix(j.location, "http://") {
+		return os.ReadFile(j.location)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, j.location, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := j.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status %s", resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+}
+
+// watch refreshes the key set every interval until stop is closed; failures
+// keep the previous keys.
+func (j *jwks) watch(stop <-chan struct{}, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+			if err := j.refresh(context.Background()); err != nil {
+				log.Printf("%v; keeping the previous keys", err)
+			}
+		}
+	}
+}
+
+func (j *jwks) len() int { return len(*j.keys.Load()) }
+
+// key returns the key a token signed with alg names, refreshing the set
+// once for an unknown key ID.
+func (j *jwks) key(ctx context.Context, kid, alg string) (crypto.PublicKey, error) {
+	if k := findKey(*j.keys.Load(), kid, alg); k != nil {
+		return k, nil
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if k := findKey(*j.keys.Load(), kid, alg); k != nil {
+		return k, nil // refreshed while waiting
+	}
+	if time.Since(j.attempted) >= j.minRefresh {
+		if err := j.refreshLocked(ctx); err != nil {
+			log.Print(err)
+		} else if k := findKey(*j.keys.Load(), kid, alg); k != nil {
+			return k, nil
+		}
+	}
+	return nil, fmt.Errorf("no key %q for %s", kid, alg)
+}
+
+// findKey matches a key by ID, or the only key when the token names none.
+// A key restricted to an algorithm is not used with another.
+func findKey(keys []publicKey, kid, alg string) crypto.PublicKey {
+	var match []publicKey
+	for _, k := range keys {
+		if (kid == "" || k.kid == kid) && (k.alg == "" || k.alg == alg) {
+			match = append(match, k)
+		}
+	}
+	if len(match) != 1 {
+		return nil
+	}
+	return match[0].key
+}
+
+func parseJWKS(raw []byte) ([]publicKey, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.NewDecoder(bytes.NewReader(raw)).Decode(&set); err != nil {
+		return nil, err
+	}
+	keys := make([]publicKey, 0, len(set.Keys))
+	for i, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("keys[%d] (kid %q): %w", i, k.Kid, err)
+		}
+		keys = append(keys, publicKey{kid: k.Kid, alg: k.Alg, key: key})
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no signing keys")
+	}
+	return keys, nil
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	b64 := func(s string) []byte {
+		b, _ := base64.RawURLEncoding.DecodeString(s)
+		return b
+	}
+	switch k.Kty {
+	case "RSA":
+		n, e := b64(k.N), b64(k.E)
+		if len(n) == 0 || len(e) == 0 || len(e) > 4 {
+			return nil, errors.New("invalid RSA key")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		curves := map[string]struct {
+			ecdsa elliptic.Curve
+			ecdh  ecdh.Curve
+		}{"P-256": {elliptic.P256(), ecdh.P256()}, "P-384": {elliptic.P384(), ecdh.P384()}, "P-521": {elliptic.P521(), ecdh.P521()}}
+		curve, ok := curves[k.Crv]
+		if !ok {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		size := (curve.ecdsa.Params().BitSize + 7) / 8
+		x, y := b64(k.X), b64(k.Y)
+		if len(x) != size || len(y) != size {
+			return nil, errors.New("invalid EC key")
+		}
+		// ecdh rejects points that are not on the curve.
+		if _, err := curve.ecdh.NewPublicKey(append(append([]byte{4}, x...), y...)); err != nil {
+			return nil, errors.New("invalid EC key")
+		}
+		return &ecdsa.PublicKey{Curve: curve.ecdsa, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	case "OKP":
+		x := b64(k.X)
+		if k.Crv != "Ed25519" || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid OKP key (only Ed25519 is supported)")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	g "github.com/antoniomarcosferreira/gauditor/pkg/gauditor"
+)
+
+// testSigner is a locally generated signing key standing in for an identity
+// provider.
+type testSigner struct {
+	kid, alg string
+	key      crypto.Signer
+}
+
+func newTestSigner(t *testing.T, kid, alg string) testSigner {
+	t.Helper()
+	var key crypto.Signer
+	var err error
+	switch alg {
+	case "RS256", "PS256":
+		key, err = rsa.GenerateKey(rand.Reader, 2048)
+	case "ES256":
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case "EdDSA":
+		_, key, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		t.Fatalf("unsupported test algorithm %s", alg)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testSigner{kid: kid, alg: alg, key: key}
+}
+
+func (s testSigner) jwk() map[string]string {
+	b64 := base64.RawURLEncoding.EncodeToString
+	k := map[string]string{"kid": s.kid, "use": "sig"}
+	switch pub := s.key.Public().(type) {
+	case *rsa.PublicKey:
+		k["kty"], k["n"], k["e"] = "RSA", b64(pub.N.Bytes()), b64([]byte{1, 0, 1})
+	case *ecdsa.PublicKey:
+		x, y := make([]byte, 32), make([]byte, 32)
+		k["kty"], k["crv"], k["x"], k["y"] = "EC", "P-256", b64(pub.X.FillBytes(x)), b64(pub.Y.FillBytes(y))
+	case ed25519.PublicKey:
+		k["kty"], k["crv"], k["x"] = "OKP", "Ed25519", b64(pub)
+	}
+	return k
+}
+
+// sign returns a compact JWS of claims.
+func (s testSigner) sign(t *testing.T, claims map[string]any) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": s.alg, "kid": s.kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	var sig []byte
+	var err error
+	switch s.alg {
+	case "EdDSA":
+		sig, err = s.key.Sign(rand.Reader, []byte(signed), crypto.Hash(0))
+	case "ES256":
+		sum := sha256.Sum256([]byte(signed))
+		r, ss, e := ecdsa.Sign(rand.Reader, s.key.(*ecdsa.PrivateKey), sum[:])
+		sig, err = append(r.FillBytes(make([]byte, 32)), ss.FillBytes(make([]byte, 32))...), e
+	case "PS256":
+		sum := sha256.Sum256([]byte(signed))
+		sig, err = rsa.SignPSS(rand.Reader, s.key.(*rsa.PrivateKey), crypto.SHA256, sum[:], &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+	default:
+		sum := sha256.Sum256([]byte(signed))
+		sig, err = s.key.Sign(rand.Reader, sum[:], crypto.SHA256)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func jwksJSON(t *testing.T, signers ...testSigner) []byte {
+	t.Helper()
+	keys := make([]map[string]string, len(signers))
+	for i, s := range signers {
+		keys[i] = s.jwk()
+	}
+	raw, err := json.Marshal(map[string]any{"keys": keys})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+func jwksFile(t *testing.T, signers ...testSigner) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, jwksJSON(t, signers...), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func claims(extra map[string]any) map[string]any {
+	c := map[string]any{
+		"iss":    "https://idp.example",
+		"aud":    "gauditor",
+		"sub":    "alice",
+		"tenant": "acme",
+		"scope":  "openid ingest query",
+		"exp":    time.Now().Add(time.Hour).Unix(),
+	}
+	for k, v := range extra {
+		if v == nil {
+			delete(c, k)
+		} else {
+			c[k] = v
+		}
+	}
+	return c
+}
+
+func TestJWT_Verify(t *testing.T) {
+	rs, ps, es, ed := newTestSigner(t, "rs", "RS256"), newTestSigner(t, "ps", "PS256"), newTestSigner(t, "es", "ES256"), newTestSigner(t, "ed", "EdDSA")
+	set, err := newJWKS(context.Background(), jwksFile(t, rs, ps, es, ed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := &jwtAuth{cfg: jwtConfig{Issuer: "https://idp.example", Audience: "gauditor", TenantClaim: "tenant", ScopeClaim: "scope"}, jwks: set}
+	check := func(token string) (*principal, error) {
+		r := httptest.NewRequest("GET", "/v1/events", nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		return auth.authenticate(r)
+	}
+
+	for _, s := range []testSigner{rs, ps, es, ed} {
+		p, err := check(s.sign(t, claims(nil)))
+		if err != nil {
+			t.Fatalf("%s: %v", s.alg, err)
+		}
+		if p.Subject != "alice" || strings.Join(p.Tenants, ",") != "acme" || strings.Join(p.Scopes, ",") != "ingest,query" {
+			t.Fatalf("%s: unexpected principal %+v", s.alg, p)
+		}
+	}
+
+	// A key ID signed by another key, and tokens an attacker can forge
+	// without the private key, are rejected.
+	forged := newTestSigner(t, "rs", "RS256")
+	hs := rs.sign(t, claims(nil))
+	head, _ := json.Marshal(map[string]string{"alg": "HS256", "kid": "rs"})
+	body := strings.Split(hs, ".")[1]
+	mac := hmac.New(sha256.New, []byte(rs.jwk()["n"]))
+	mac.Write([]byte(base64.RawURLEncoding.EncodeToString(head) + "." + body))
+	none, _ := json.Marshal(map[string]string{"alg": "none"})
+	for name, token := range map[string]string{
+		"forged signature": forged.sign(t, claims(nil)),
+		"HS256":            base64.RawURLEncoding.EncodeToString(head) + "." + body + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil)),
+		"alg none":         base64.RawURLEncoding.EncodeToString(none) + "." + body + ".",
+		"expired":          rs.sign(t, claims(map[string]any{"exp": time.Now().Add(-2 * time.Minute).Unix()})),
+		"no exp":           rs.sign(t, claims(map[string]any{"exp": nil})),
+		"not yet valid":    rs.sign(t, claims(map[string]any{"nbf": time.Now().Add(time.Hour).Unix()})),
+		"wrong issuer":     rs.sign(t, claims(map[string]any{"iss": "https://evil.example"})),
+		"wrong audience":   rs.sign(t, claims(map[string]any{"aud": []string{"other"}})),
+		"no tenant":        rs.sign(t, claims(map[string]any{"tenant": nil})),
+		"every tenant":     rs.sign(t, claims(map[string]any{"tenant": []string{"acme", "*"}})),
+		"unknown kid":      newTestSigner(t, "new", "ES256").sign(t, claims(nil)),
+	} {
+		if p, err := check(token); err == nil {
+			t.Errorf("%s: accepted as %+v", name, p)
+		}
+	}
+
+	// Expiry within the leeway is tolerated; aud may be an array.
+	if _, err := check(es.sign(t, claims(map[string]any{"exp": time.Now().Add(-10 * time.Second).Unix(), "aud": []string{"x", "gauditor"}}))); err != nil {
+		t.Fatalf("token within leeway: %v", err)
+	}
+}
+
+func TestJWT_ClaimMapping(t *testing.T) {
+	s := newTestSigner(t, "k", "ES256")
+	set, err := newJWKS(context.Background(), jwksFile(t, s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := &jwtAuth{cfg: jwtConfig{TenantClaim: "org.tenants", ScopeClaim: "realm_access.roles"}, jwks: set}
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Authorization", "Bearer "+s.sign(t, claims(map[string]any{
+		"org":          map[string]any{"tenants": []string{"acme", "beta"}},
+		"realm_access": map[string]any{"roles": []string{"query", "admin", "offline_access"}},
+	})))
+	p, err := auth.authenticate(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(p.Tenants, ",") != "acme,beta" || strings.Join(p.Scopes, ",") != "query,admin" {
+		t.Fatalf("nested claims: %+v", p)
+	}
+
+	// A flat claim whose name contains dots is read as is.
+	auth.cfg.TenantClaim = "https://gauditor.example/tenant"
+	r.Header.Set("Authorization", "Bearer "+s.sign(t, claims(map[string]any{"https://gauditor.example/tenant": "acme"})))
+	if p, err := auth.authenticate(r); err != nil || strings.Join(p.Tenants, ",") != "acme" {
+		t.Fatalf("namespaced claim: %+v %v", p, err)
+	}
+}
+
+func TestJWT_KeyRotation(t *testing.T) {
+	old, next := newTestSigner(t, "2025", "RS256"), newTestSigner(t, "2026", "EdDSA")
+	var mu sync.Mutex
+	current := jwksJSON(t, old)
+	idp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		_, _ = w.Write(current)
+	}))
+	t.Cleanup(idp.Close)
+	set, err := newJWKS(context.Background(), idp.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	set.minRefresh = 0
+	srv := httptest.NewServer(newServer(g.NewRecorder(g.NewMemoryStorage()), withAuth(&jwtAuth{cfg: jwtConfig{TenantClaim: "tenant", ScopeClaim: "scope"}, jwks: set})))
+	t.Cleanup(srv.Close)
+
+	if status, body := do(t, "GET", srv.URL+"/v1/events", old.sign(t, claims(nil)), ""); status != http.StatusOK {
+		t.Fatalf("old key: %d %s", status, body)
+	}
+	if status, _ := do(t, "GET", srv.URL+"/v1/events", next.sign(t, claims(nil)), ""); status != http.StatusUnauthorized {
+		t.Fatalf("key not yet published: want 401, got %d", status)
+	}
+	mu.Lock()
+	current = jwksJSON(t, old, next)
+	mu.Unlock()
+	if status, body := do(t, "GET", srv.URL+"/v1/events", next.sign(t, claims(nil)), ""); status != http.StatusOK {
+		t.Fatalf("an unknown key ID refreshes the set: %d %s", status, body)
+	}
+}
+
+func TestJWT_FailedRefreshesAreLimited(t *testing.T) {
+	signer := newTestSigner(t, "2025", "RS256")
+	var fetches atomic.Int32
+	idp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fetches.Add(1) > 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write(jwksJSON(t, signer))
+	}))
+	t.Cleanup(idp.Close)
+	set, err := newJWKS(context.Background(), idp.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	set.minRefresh = time.Hour
+	set.attempted = time.Time{} // the next unknown key ID may refresh
+	for i := 0; i < 5; i++ {
+		if _, err := set.key(context.Background(), fmt.Sprint("made-up-", i), "RS256"); err == nil {
+			t.Fatal("want no key")
+		}
+	}
+	if n := fetches.Load(); n != 2 {
+		t.Fatalf("want the initial fetch and one failed refresh, got %d fetches", n)
+	}
+	if _, err := set.key(context.Background(), "2025", "RS256"); err != nil {
+		t.Fatalf("known keys keep working: %v", err)
+	}
+}
+
+func TestJWT_SubjectFillsActor(t *testing.T) {
+	s := newTestSigner(t, "k", "ES256")
+	set, err := newJWKS(context.Background(), jwksFile(t, s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	keysPath := filepath.Join(t.TempDir(), "keys.yaml")
+	keysFile(t, keysPath, map[string][2]string{"ci-key": {"acme", "ingest"}})
+	keys, err := newAPIKeys(keysPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := anyAuth{keys, &jwtAuth{cfg: jwtConfig{TenantClaim: "tenant", ScopeClaim: "scope"}, jwks: set}}
+	srv := httptest.NewServer(newServer(g.NewRecorder(g.NewMemoryStorage()), withAuth(auth)))
+	t.Cleanup(srv.Close)
+	events := srv.URL + "/v1/events"
+	token := s.sign(t, claims(nil))
+
+	if status, body := do(t, "POST", events, token, `{"action":"login"}`); status != http.StatusCreated || !strings.Contains(body, `"actor":{"id":"alice"`) || !strings.Contains(body, `"tenant":"acme"`) {
+		t.Fatalf("subject as actor: %d %s", status, body)
+	}
+	if status, body := do(t, "POST", events, token, `{"action":"login","actor":{"id":"bob"}}`); status != http.StatusCreated || !strings.Contains(body, `"actor":{"id":"bob"`) {
+		t.Fatalf("producer's actor is kept: %d %s", status, body)
+	}
+	// API keys keep working next to tokens; they carry no subject.
+	if status, body := do(t, "POST", events, "ci-key", `{"action":"deploy"}`); status != http.StatusCreated || strings.Contains(body, "alice") {
+		t.Fatalf("API key: %d %s", status, body)
+	}
+	status, body := do(t, "POST", events, s.sign(t, claims(map[string]any{"exp": time.Now().Add(-time.Hour).Unix()})), `{"action":"x"}`)
+	if status != http.StatusUnauthorized || !strings.Contains(body, "expired") {
+		t.Fatalf("expired token: want 401 naming the cause, got %d %s", status, body)
+	}
+}
+
+func TestRealMain_JWKS(t *testing.T) {
+	t.Setenv("GAUDITOR_STORAGE", "")
+	t.Setenv("GAUDITOR_CONFIG", "")
+	t.Setenv("GAUDITOR_NO_SERVE", "1")
+	t.Setenv("GAUDITOR_JWT_AUDIENCE", "")
+	path := jwksFile(t, newTestSigner(t, "k", "EdDSA"))
+	if code, out := runMain(t, "-jwks", path); code != 1 || !strings.Contains(out, "-jwks needs -jwt-audience") {
+		t.Fatalf("want exit 1 without an audience, got %d: %s", code, out)
+	}
+	if code, out := runMain(t, "-jwks", path, "-jwt-audience", "gauditor", "-jwt-tenant-claim", "org"); code != 0 || !strings.Contains(out, "JWT authentication: 1 keys") || !strings.Contains(out, `"org"`) {
+		t.Fatalf("want startup with a JWKS, got %d: %s", code, out)
+	}
+	bad := filepath.Join(t.TempDir(), "bad.json")
+	if err := os.WriteFile(bad, []byte(`{"keys":[{"kty":"EC","crv":"P-256","x":"AA","y":"AA"}]}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []string{filepath.Join(t.TempDir(), "missing.json"), bad} {
+		if code, out := runMain(t, "-jwks", p, "-jwt-audience", "gauditor"); code != 1 || !strings.Contains(out, "jwks") {
+			t.Fatalf("want exit 1 for JWKS %s, got %d: %s", p, code, out)
+		}
+	}
+}
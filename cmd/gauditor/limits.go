@@ -0,0 +1,363 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// limit bounds the events a tenant or key may ingest. Zero fields are
+// unlimited.
+type limit struct {
+	Rate  float64 `yaml:"rate" json:"rate,omitempty"`   // events per second refilling the bucket
+	Burst int     `yaml:"burst" json:"burst,omitempty"` // bucket size; defaults to one second of rate
+	Daily int64   `yaml:"daily" json:"daily,omitempty"` // events per UTC day
+}
+
+func (l limit) burst() float64 {
+	if l.Burst > 0 {
+		return float64(l.Burst)
+	}
+	return max(1, math.Ceil(l.Rate))
+}
+
+func (l limit) validate() error {
+	switch {
+	case l.Rate < 0 || l.Burst < 0 || l.Daily < 0:
+		return errors.New("rate, burst and daily must not be negative")
+	case l.Burst > 0 && l.Rate == 0:
+		return errors.New("burst needs a rate")
+	}
+	return nil
+}
+
+// limitsFile configures ingestion limits. Tenants without an entry get the
+// default; keys (API key and certificate entries of the keys file, by id)
+// are only limited when listed.
+type limitsFile struct {
+	Default limit            `yaml:"default"`
+	Tenants map[string]limit `yaml:"tenants"`
+	Keys    map[string]limit `yaml:"keys"`
+}
+
+// parseLimits validates a YAML or JSON limits file.
+func parseLimits(data []byte) (limitsFile, error) {
+	var f limitsFile
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&f); err != nil && !errors.Is(err, io.EOF) {
+		return limitsFile{}, err
+	}
+	if err := f.Default.validate(); err != nil {
+		return limitsFile{}, fmt.Errorf("default: %w", err)
+	}
+	for kind, m := range map[string]map[string]limit{"tenant": f.Tenants, "key": f.Keys} {
+		for name, l := range m {
+			if name == "" {
+				return limitsFile{}, fmt.Errorf("%s with an empty name", kind)
+			}
+			if err := l.validate(); err != nil {
+				return limitsFile{}, fmt.Errorf("%s %s: %w", kind, name, err)
+			}
+		}
+	}
+	return f, nil
+}
+
+// loadLimits reads the limits file at path.
+func loadLimits(path string) (limitsFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return limitsFile{}, fmt.Errorf("limits: %w", err)
+	}
+	f, err := parseLimits(data)
+	if err != nil {
+		return limitsFile{}, fmt.Errorf("limits %s: %w", path, err)
+	}
+	return f, nil
+}
+
+// usage is the state of one tenant or key: a token bucket refilled at the
+// limit's rate and the events counted since the start of the UTC day.
+type usage struct {
+	lim      limit
+	tokens   float64
+	last     time.Time
+	day      string
+	today    int64
+	rejected int64 // requests refused today
+}
+
+// advance refills the bucket up to now and starts a new day's counters.
+func (u *usage) advance(now time.Time) {
+	if day := now.UTC().Format(time.DateOnly); day != u.day {
+		u.day, u.today, u.rejected = day, 0, 0
+	}
+	if u.lim.Rate > 0 {
+		u.tokens = min(u.lim.burst(), u.tokens+now.Sub(u.last).Seconds()*u.lim.Rate)
+	}
+	u.last = now
+}
+
+// give back n events taken from u.
+func (u *usage) give(n int) {
+	u.today = max(0, u.today-int64(n))
+	if u.lim.Rate > 0 {
+		u.tokens = min(u.lim.burst(), u.tokens+float64(n))
+	}
+}
+
+// idle reports whether u holds nothing worth keeping: no events today and a
+// full bucket.
+func (u *usage) idle() bool {
+	return u.today == 0 && u.rejected == 0 && (u.lim.Rate == 0 || u.tokens >= u.lim.burst())
+}
+
+// errTooLarge marks requests that no wait would admit, because they exceed
+// a bucket or a daily quota on their own.
+var errTooLarge = errors.New("split the request")
+
+// check reports how long n more events have to wait, and why.
+func (u *usage) check(n int, now time.Time) (time.Duration, error) {
+	if u.lim.Rate > 0 && float64(n) > u.lim.burst() {
+		return 0, fmt.Errorf("%d events exceed the burst of %g at once: %w", n, u.lim.burst(), errTooLarge)
+	}
+	if u.lim.Daily > 0 && int64(n) > u.lim.Daily {
+		return 0, fmt.Errorf("%d events exceed the daily quota of %d: %w", n, u.lim.Daily, errTooLarge)
+	}
+	if u.lim.Daily > 0 && u.today+int64(n) > u.lim.Daily {
+		tomorrow := now.UTC().Truncate(24 * time.Hour).Add(24 * time.Hour)
+		return tomorrow.Sub(now), fmt.Errorf("daily quota of %d events reached (%d used)", u.lim.Daily, u.today)
+	}
+	if u.lim.Rate > 0 && u.tokens < float64(n) {
+		wait := time.Duration((float64(n) - u.tokens) / u.lim.Rate * float64(time.Second))
+		return wait, fmt.Errorf("rate limit of %g events/s exceeded", u.lim.Rate)
+	}
+	return 0, nil
+}
+
+// limiter applies the limits of a limitsFile to ingestion and counts usage
+// per tenant and key, limited or not. Idle entries are dropped when the UTC
+// day rolls over, so tenant names seen once do not pile up.
+type limiter struct {
+	cfg limitsFile
+	now func() time.Time
+
+	mu    sync.Mutex
+	day   string               // of the last sweep
+	usage map[[2]string]*usage // by {"tenant"|"key", name}
+}
+
+func newLimiter(cfg limitsFile) *limiter {
+	return &limiter{cfg: cfg, now: time.Now, usage: make(map[[2]string]*usage)}
+}
+
+// limitOf returns the limit of a tenant or key.
+func (l *limiter) limitOf(kind, name string) limit {
+	if kind == "key" {
+		return l.cfg.Keys[name]
+	}
+	if t, ok := l.cfg.Tenants[name]; ok {
+		return t
+	}
+	return l.cfg.Default
+}
+
+// get returns the usage of a tenant or key, advanced to now.
+func (l *limiter) get(kind, name string, now time.Time) *usage {
+	u := l.usage[[2]string{kind, name}]
+	if u == nil {
+		lim := l.limitOf(kind, name)
+		u = &usage{lim: lim, tokens: lim.burst(), last: now}
+		l.usage[[2]string{kind, name}] = u
+	}
+	u.advance(now)
+	return u
+}
+
+// sweep drops idle entries the first time it runs on a new UTC day.
+func (l *limiter) sweep(now time.Time) {
+	day := now.UTC().Format(time.DateOnly)
+	if day == l.day {
+		return
+	}
+	l.day = day
+	for id, u := range l.usage {
+		if u.advance(now); u.idle() {
+			delete(l.usage, id)
+		}
+	}
+}
+
+// take admits events from caller, counted per tenant, or returns how long to
+// wait before retrying. Either all events are admitted or none.
+func (l *limiter) take(caller *principal, events map[string]int) (time.Duration, error) {
+	type demand struct {
+		u    *usage
+		what string
+		n    int
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	l.sweep(now)
+	var demands []demand
+	total := 0
+	for tenant, n := range events {
+		demands = append(demands, demand{l.get("tenant", tenant, now), "tenant " + tenant, n})
+		total += n
+	}
+	if caller != nil && caller.Key != "" {
+		demands = append(demands, demand{l.get("key", caller.Key, now), caller.Name, total})
+	}
+	var wait time.Duration
+	var err error
+	for _, d := range demands {
+		if w, e := d.u.check(d.n, now); e != nil {
+			d.u.rejected++
+			if errors.Is(err, errTooLarge) {
+				continue
+			}
+			if err == nil || w > wait || errors.Is(e, errTooLarge) {
+				wait, err = w, fmt.Errorf("%s: %w", d.what, e)
+			}
+		}
+	}
+	if err != nil {
+		return wait, err
+	}
+	for _, d := range demands {
+		d.u.today += int64(d.n)
+		if d.u.lim.Rate > 0 {
+			d.u.tokens -= float64(d.n)
+		}
+	}
+	return 0, nil
+}
+
+// refund gives back events take admitted that were not recorded, such as
+// duplicates and storage failures, so they do not use up the limits.
+func (l *limiter) refund(caller *principal, events map[string]int) {
+	if len(events) == 0 {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	total := 0
+	for tenant, n := range events {
+		l.get("tenant", tenant, now).give(n)
+		total += n
+	}
+	if caller != nil && caller.Key != "" {
+		l.get("key", caller.Key, now).give(total)
+	}
+}
+
+// admit takes events from the limiter, or answers 429 with Retry-After in
+// whole seconds. Requests over a burst or daily quota on their own get 413.
+func (l *limiter) admit(w http.ResponseWriter, caller *principal, events map[string]int) bool {
+	wait, err := l.take(caller, events)
+	if err == nil {
+		return true
+	}
+	if errors.Is(err, errTooLarge) {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		_, _ = w.Write([]byte(err.Error()))
+		return false
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(max(1, int(math.Ceil(wait.Seconds())))))
+	w.WriteHeader(http.StatusTooManyRequests)
+	_, _ = w.Write([]byte(err.Error()))
+	return false
+}
+
+// usageReport is the usage of one tenant or key.
+type usageReport struct {
+	Name string `json:"name"`
+	limit
+	Tokens   *float64 `json:"tokens,omitempty"` // left in the bucket, when rate limited
+	Today    int64    `json:"today"`
+	Rejected int64    `json:"rejected"`
+}
+
+type usageResponse struct {
+	Day     string        `json:"day"`
+	Tenants []usageReport `json:"tenants"`
+	Keys    []usageReport `json:"keys,omitempty"`
+}
+
+// report returns the usage of tenant, or of every tenant and key when
+// tenant is empty. A tenant without usage is reported from its limit alone.
+func (l *limiter) report(tenant string) usageResponse {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	l.sweep(now)
+	res := usageResponse{Day: now.UTC().Format(time.DateOnly), Tenants: []usageReport{}}
+	if id := [2]string{"tenant", tenant}; tenant != "" && l.usage[id] == nil {
+		lim := l.limitOf("tenant", tenant)
+		r := usageReport{Name: tenant, limit: lim}
+		if lim.Rate > 0 {
+			tokens := lim.burst()
+			r.Tokens = &tokens
+		}
+		res.Tenants = append(res.Tenants, r)
+		return res
+	}
+	for id, u := range l.usage {
+		if tenant != "" && id != [2]string{"tenant", tenant} {
+			continue
+		}
+		u.advance(now)
+		r := usageReport{Name: id[1], limit: u.lim, Today: u.today, Rejected: u.rejected}
+		if u.lim.Rate > 0 {
+			tokens := math.Floor(u.tokens*100) / 100
+			r.Tokens = &tokens
+		}
+		if id[0] == "key" {
+			res.Keys = append(res.Keys, r)
+		} else {
+			res.Tenants = append(res.Tenants, r)
+		}
+	}
+	sort.Slice(res.Tenants, func(i, j int) bool { return res.Tenants[i].Name < res.Tenants[j].Name })
+	sort.Slice(res.Keys, func(i, j int) bool { return res.Keys[i].Name < res.Keys[j].Name })
+	return res
+}
+
+// usageHandler serves GET /v1/admin/usage?tenant=<tenant>: the limits and
+// today's usage of the caller's tenants. Callers granted every tenant see
+// all tenants and keys when they name none.
+func usageHandler(limits *limiter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		tenant, err := tenantParam(r)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(err.Error()))
+			return
+		}
+		if tenant, err = principalFrom(r.Context()).queryTenant(tenant); err != nil {
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(err.Error()))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(limits.report(tenant))
+	}
+}
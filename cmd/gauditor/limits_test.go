@@ -0,0 +1,296 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	g "github.com/antoniomarcosferreira/gauditor/pkg/gauditor"
+)
+
+// fakeClock is a settable time source for limiters.
+type fakeClock struct{ t time.Time }
+
+func (c *fakeClock) now() time.Time          { return c.t }
+func (c *fakeClock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func testLimiter(t *testing.T, file string) (*limiter, *fakeClock) {
+	t.Helper()
+	cfg, err := parseLimits([]byte(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := &fakeClock{t: time.Date(2026, 3, 1, 23, 0, 0, 0, time.UTC)}
+	l := newLimiter(cfg)
+	l.now = clock.now
+	return l, clock
+}
+
+func TestLimiter_TokenBucket(t *testing.T) {
+	l, clock := testLimiter(t, `
+default: {rate: 2, burst: 3}
+tenants:
+  big: {rate: 100}
+`)
+	for i := 0; i < 3; i++ {
+		if _, err := l.take(nil, map[string]int{"acme": 1}); err != nil {
+			t.Fatalf("event %d within the burst: %v", i, err)
+		}
+	}
+	wait, err := l.take(nil, map[string]int{"acme": 1})
+	if err == nil || wait != 500*time.Millisecond || !strings.Contains(err.Error(), "tenant acme") {
+		t.Fatalf("want a 500ms wait, got %v %v", wait, err)
+	}
+	if _, err := l.take(nil, map[string]int{"big": 50}); err != nil {
+		t.Fatalf("a tenant entry replaces the default: %v", err)
+	}
+	clock.advance(500 * time.Millisecond)
+	if _, err := l.take(nil, map[string]int{"acme": 1}); err != nil {
+		t.Fatalf("after refill: %v", err)
+	}
+
+	// Batches are charged in full; one larger than the bucket never fits.
+	clock.advance(time.Minute)
+	if _, err := l.take(nil, map[string]int{"acme": 10}); !errors.Is(err, errTooLarge) || !strings.Contains(err.Error(), "burst of 3") {
+		t.Fatalf("batch over the burst: %v", err)
+	}
+	if _, err := l.take(nil, map[string]int{"acme": 3}); err != nil {
+		t.Fatalf("batch of the burst on a full bucket: %v", err)
+	}
+	if wait, err := l.take(nil, map[string]int{"acme": 2}); err == nil || wait != time.Second {
+		t.Fatalf("want a 1s wait for 2 events, got %v %v", wait, err)
+	}
+
+	// All tenants of a request are admitted, or none.
+	clock.advance(time.Minute)
+	if _, err := l.take(nil, map[string]int{"acme": 3, "beta": 1}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.take(nil, map[string]int{"acme": 1, "beta": 1}); err == nil {
+		t.Fatal("acme is out of tokens")
+	}
+	if _, err := l.take(nil, map[string]int{"beta": 2}); err != nil {
+		t.Fatalf("beta must not pay for the refused request: %v", err)
+	}
+}
+
+func TestLimiter_DailyQuotaAndKeys(t *testing.T) {
+	l, clock := testLimiter(t, `
+tenants:
+  acme: {daily: 5}
+keys:
+  ci: {daily: 3}
+`)
+	ci := &principal{Name: "key ci", Key: "ci", Tenants: []string{"*"}}
+	other := &principal{Name: "key other", Key: "other", Tenants: []string{"*"}}
+	if _, err := l.take(ci, map[string]int{"acme": 2, "beta": 1}); err != nil {
+		t.Fatal(err)
+	}
+	wait, err := l.take(ci, map[string]int{"beta": 1})
+	if err == nil || !strings.Contains(err.Error(), "key ci: daily quota of 3") || wait != time.Hour {
+		t.Fatalf("key quota spans tenants and resets at UTC midnight: %v %v", wait, err)
+	}
+	if _, err := l.take(other, map[string]int{"acme": 3}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.take(other, map[string]int{"acme": 1}); err == nil || !strings.Contains(err.Error(), "tenant acme") {
+		t.Fatalf("tenant quota: %v", err)
+	}
+
+	res := l.report("")
+	if res.Day != "2026-03-01" || len(res.Tenants) != 2 || res.Tenants[0].Name != "acme" || res.Tenants[0].Today != 5 || res.Tenants[0].Rejected != 1 || res.Tenants[0].Tokens != nil {
+		t.Fatalf("tenant usage: %+v", res)
+	}
+	if len(res.Keys) != 2 || res.Keys[0].Name != "ci" || res.Keys[0].Today != 3 || res.Keys[0].Daily != 3 || res.Keys[0].Rejected != 1 {
+		t.Fatalf("key usage: %+v", res.Keys)
+	}
+
+	clock.advance(time.Hour)
+	if _, err := l.take(ci, map[string]int{"acme": 3}); err != nil {
+		t.Fatalf("a new day: %v", err)
+	}
+	if res := l.report("beta"); res.Day != "2026-03-02" || len(res.Tenants) != 1 || res.Tenants[0].Today != 0 || res.Keys != nil {
+		t.Fatalf("one tenant's usage: %+v", res)
+	}
+}
+
+func TestLimiter_EvictsIdleEntries(t *testing.T) {
+	l, clock := testLimiter(t, "tenants: {acme: {rate: 1, burst: 5}}")
+	for _, tenant := range []string{"a", "b", "c", "acme"} {
+		if _, err := l.take(nil, map[string]int{tenant: 1}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if res := l.report("unseen"); len(res.Tenants) != 1 || res.Tenants[0].Today != 0 || len(l.usage) != 4 {
+		t.Fatalf("reading an unseen tenant allocated state: %+v, %d entries", res, len(l.usage))
+	}
+	if res := l.report("acme"); res.Tenants[0].Rate != 1 || *res.Tenants[0].Tokens != 4 {
+		t.Fatalf("acme: %+v", res.Tenants[0])
+	}
+
+	// After midnight, yesterday's entries are idle once their buckets refill.
+	clock.advance(time.Hour)
+	if _, err := l.take(nil, map[string]int{"b": 1}); err != nil {
+		t.Fatal(err)
+	}
+	if len(l.usage) != 1 || l.usage[[2]string{"tenant", "b"}] == nil {
+		t.Fatalf("want only b left, got %v", l.usage)
+	}
+}
+
+func TestParseLimits_Validation(t *testing.T) {
+	for name, file := range map[string]string{
+		"negative rate":  "default: {rate: -1}",
+		"burst no rate":  "tenants: {acme: {burst: 10}}",
+		"negative daily": "keys: {ci: {daily: -5}}",
+		"unknown field":  "default: {rps: 10}",
+		"empty tenant":   `tenants: {"": {rate: 1}}`,
+	} {
+		if _, err := parseLimits([]byte(file)); err == nil {
+			t.Errorf("%s: want an error", name)
+		}
+	}
+	if _, err := parseLimits([]byte(`{"default": {"rate": 0.5, "daily": 100}, "tenants": {"acme": {}}}`)); err != nil {
+		t.Fatalf("JSON limits file: %v", err)
+	}
+}
+
+func TestHTTP_RateLimits(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.yaml")
+	keysFile(t, path, map[string][2]string{
+		"writer": {"acme, beta", "ingest"},
+		"admin":  {`"*"`, "admin"},
+		"acme":   {"acme", "admin"},
+	})
+	keys, err := newAPIKeys(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := parseLimits([]byte("tenants:\n  acme: {rate: 0.001, burst: 2}\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(newServer(g.NewRecorder(g.NewMemoryStorage()), withAuth(keys), withLimits(cfg)))
+	t.Cleanup(srv.Close)
+	events := srv.URL + "/v1/events"
+
+	if status, body := do(t, "POST", events, "writer", `{"tenant":"acme","action":"a"}`); status != http.StatusCreated {
+		t.Fatalf("%d %s", status, body)
+	}
+	// The batch needs 2 tokens of acme, only 1 is left: nothing is recorded.
+	if status, body := do(t, "POST", srv.URL+"/v1/events:batch", "writer", `[{"tenant":"acme","action":"b"},{"tenant":"acme","action":"c"},{"tenant":"beta","action":"d"}]`); status != http.StatusTooManyRequests || !strings.Contains(body, "tenant acme") {
+		t.Fatalf("batch over the limit: %d %s", status, body)
+	}
+	if status, body := do(t, "POST", srv.URL+"/v1/events:batch", "writer", `[{"tenant":"acme","action":"b"},{"tenant":"acme","action":"c"},{"tenant":"acme","action":"d"}]`); status != http.StatusRequestEntityTooLarge || !strings.Contains(body, "burst of 2") {
+		t.Fatalf("batch over the burst: %d %s", status, body)
+	}
+	if status, body := do(t, "POST", events, "writer", `{"tenant":"acme","action":"e"}`); status != http.StatusCreated {
+		t.Fatalf("%d %s", status, body)
+	}
+	req, _ := http.NewRequest("POST", events, strings.NewReader(`{"tenant":"acme","action":"f"}`))
+	req.Header.Set("X-API-Key", "writer")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusTooManyRequests || resp.Header.Get("Retry-After") != "1000" {
+		t.Fatalf("want 429 with Retry-After 1000, got %d %q", resp.StatusCode, resp.Header.Get("Retry-After"))
+	}
+	if status, body := do(t, "POST", srv.URL+"/v1/events:batch", "writer", `[{"tenant":"beta","action":"g"},{"tenant":"gamma","action":"h"}]`); status != http.StatusOK || !strings.Contains(body, `"created":1`) {
+		t.Fatalf("other tenants are not limited: %d %s", status, body)
+	}
+
+	status, body := do(t, "GET", srv.URL+"/v1/admin/usage", "admin", "")
+	var res usageResponse
+	if err := json.Unmarshal([]byte(body), &res); status != http.StatusOK || err != nil {
+		t.Fatalf("usage: %d %s", status, body)
+	}
+	if len(res.Tenants) != 2 || res.Tenants[0].Name != "acme" || res.Tenants[0].Today != 2 || res.Tenants[0].Rejected != 3 || res.Tenants[0].Burst != 2 || res.Tenants[0].Tokens == nil {
+		t.Fatalf("acme usage: %s", body)
+	}
+	if res.Tenants[1].Name != "beta" || res.Tenants[1].Today != 1 || len(res.Keys) != 1 || res.Keys[0].Name != "writer" || res.Keys[0].Today != 3 {
+		t.Fatalf("beta and key usage: %s", body)
+	}
+	if status, body := do(t, "GET", srv.URL+"/v1/admin/usage", "acme", ""); status != http.StatusOK || strings.Contains(body, "beta") || strings.Contains(body, "writer") {
+		t.Fatalf("an admin of one tenant sees only it: %d %s", status, body)
+	}
+	for key, want := range map[string]int{"": http.StatusUnauthorized, "writer": http.StatusForbidden} {
+		if status, _ := do(t, "GET", srv.URL+"/v1/admin/usage", key, ""); status != want {
+			t.Errorf("usage as %q: want %d, got %d", key, want, status)
+		}
+	}
+	if status, _ := do(t, "GET", srv.URL+"/v1/admin/usage?tenant=beta", "acme", ""); status != http.StatusForbidden {
+		t.Errorf("another tenant's usage: want 403, got %d", status)
+	}
+}
+
+func TestHTTP_LimitsChargeRecordedEvents(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.yaml")
+	keysFile(t, path, map[string][2]string{
+		"writer": {"acme", "ingest"},
+		"root":   {`"*"`, "ingest, admin"},
+	})
+	keys, err := newAPIKeys(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := parseLimits([]byte("default: {daily: 10}\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(newServer(g.NewRecorder(g.NewMemoryStorage()), withAuth(keys), withLimits(cfg)))
+	t.Cleanup(srv.Close)
+	events := srv.URL + "/v1/events"
+
+	for _, tc := range []struct {
+		key, body string
+		want      int
+	}{
+		{"writer", `{"tenant":"acme"}`, http.StatusBadRequest},
+		{"root", `{"action":"a"}`, http.StatusBadRequest},
+		{"writer", `{"id":"x","tenant":"acme","action":"a"}`, http.StatusCreated},
+		{"writer", `{"id":"x","tenant":"acme","action":"a"}`, http.StatusConflict},
+	} {
+		if status, body := do(t, "POST", events, tc.key, tc.body); status != tc.want {
+			t.Fatalf("%s as %s: want %d, got %d %s", tc.body, tc.key, tc.want, status, body)
+		}
+	}
+	batch := `[{"id":"y","tenant":"acme","action":"b"},{"id":"y","tenant":"acme","action":"b"},{"tenant":"acme"},{"id":"x","tenant":"acme","action":"a"}]`
+	if status, body := do(t, "POST", srv.URL+"/v1/events:batch", "writer", batch); status != http.StatusOK || !strings.Contains(body, `"created":1,"duplicate":2,"invalid":1`) {
+		t.Fatalf("batch: %d %s", status, body)
+	}
+
+	status, body := do(t, "GET", srv.URL+"/v1/admin/usage", "root", "")
+	var res usageResponse
+	if err := json.Unmarshal([]byte(body), &res); status != http.StatusOK || err != nil {
+		t.Fatalf("usage: %d %s", status, body)
+	}
+	if len(res.Tenants) != 1 || res.Tenants[0].Name != "acme" || res.Tenants[0].Today != 2 {
+		t.Fatalf("only the two recorded events count, and no empty tenant: %s", body)
+	}
+	if len(res.Keys) != 1 || res.Keys[0].Name != "writer" || res.Keys[0].Today != 2 {
+		t.Fatalf("key usage: %s", body)
+	}
+}
+
+func TestRealMain_Limits(t *testing.T) {
+	t.Setenv("GAUDITOR_STORAGE", "")
+	t.Setenv("GAUDITOR_CONFIG", "")
+	t.Setenv("GAUDITOR_NO_SERVE", "1")
+	dir := t.TempDir()
+	good, bad := filepath.Join(dir, "limits.yaml"), filepath.Join(dir, "bad.yaml")
+	writeFile(t, good, []byte("default: {rate: 50, daily: 100000}\ntenants: {acme: {rate: 500}}\n"))
+	writeFile(t, bad, []byte("default: {burst: 5}\n"))
+	if code, out := runMain(t, "-limits", good); code != 0 || !strings.Contains(out, "1 tenants") {
+		t.Fatalf("want startup with limits, got %d: %s", code, out)
+	}
+	if code, out := runMain(t, "-limits", bad); code != 1 || !strings.Contains(out, "burst needs a rate") {
+		t.Fatalf("want exit 1 for invalid limits, got %d: %s", code, out)
+	}
+}
@@ -2,7 +2,9 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"flag"
 	"io"
 	"log"
@@ -10,7 +12,6 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
-	"strconv"
 	"strings"
 	"time"
 
@@ -42,15 +43,49 @@ func currentVersion() string {
 	return "-unknown-"
 }
 
+// serverOption configures newServer.
+type serverOption func(*serverConfig)
+
+type serverConfig struct {
+	auth   authenticator
+	limits *limiter
+}
+
+// withAuth requires credentials accepted by auth on every route; callers are
+// limited to their scopes and tenants. Without it the API is open.
+func withAuth(auth authenticator) serverOption {
+	return func(c *serverConfig) { c.auth = auth }
+}
+
+// withLimits rate limits ingestion per tenant and key. Without it usage is
+// counted but not limited.
+func withLimits(limits limitsFile) serverOption {
+	return func(c *serverConfig) { c.limits = newLimiter(limits) }
+}
+
 // newServer returns an http.Handler with routes configured for the recorder.
 //
 // Routes:
 //
-//	POST /v1/events  - ingest an event (JSON body of gauditor.Event)
-//	GET  /v1/events  - query events with optional filters tenant, actorId, action, targetId, limit
-func newServer(recorder *gauditor.Recorder) http.Handler {
+//	POST /v1/events        - ingest an event (JSON body of gauditor.Event)
+//	POST /v1/events:batch  - ingest a JSON array or NDJSON stream of events (see batchHandler)
+//	GET  /v1/events        - query events with optional filters tenant, actorId, action, targetId,
+//	                         since, until (RFC 3339), data[<path>] and limit (see parseQuery)
+//	GET  /v1/events/{id}   - fetch one event of the tenant given by ?tenant= (see getHandler)
+//	GET  /v1/admin/usage   - limits and today's ingestion per tenant and key (see usageHandler)
+//	GET  /healthz          - liveness; always 200 while the process serves
+//	GET  /readyz           - readiness; 503 while the storage does not answer a ping
+//
+// Ingestion beyond the limits is answered with 429 and Retry-After, or 413 when
+// a batch exceeds a burst or daily quota on its own. The health endpoints are
+// never authenticated.
+func newServer(recorder *gauditor.Recorder, opts ...serverOption) http.Handler {
+	cfg := serverConfig{limits: newLimiter(limitsFile{})}
+	for _, o := range opts {
+		o(&cfg)
+	}
 	mux := http.NewServeMux()
-	mux.HandleFunc("/v1/events", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/v1/events", authorize(cfg.auth, map[string]string{http.MethodPost: scopeIngest, http.MethodGet: scopeQuery}, func(w http.ResponseWriter, r *http.Request) {
 		switch r.Method {
 		case http.MethodPost:
 			// Limit request body and decode strictly
@@ -58,7 +93,8 @@ func newServer(recorder *gauditor.Recorder) http.Handler {
 			dec := json.NewDecoder(r.Body)
 			dec.DisallowUnknownFields()
 			var e gauditor.Event
-			if err := dec.Decode(&e); err != nil {
+			err := dec.Decode(&e)
+			if err != nil {
 				w.WriteHeader(http.StatusBadRequest)
 				_, _ = w.Write([]byte("invalid JSON"))
 				return
@@ -69,7 +105,29 @@ func newServer(recorder *gauditor.Recorder) http.Handler {
 				_, _ = w.Write([]byte("invalid JSON: trailing content"))
 				return
 			}
+			caller := principalFrom(r.Context())
+			if err = caller.prepareEvent(&e); err != nil {
+				w.WriteHeader(http.StatusForbidden)
+				_, _ = w.Write([]byte(err.Error()))
+				return
+			}
+			if err = checkEvent(e); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				_, _ = w.Write([]byte(err.Error()))
+				return
+			}
+			if !cfg.limits.admit(w, caller, map[string]int{e.Tenant: 1}) {
+				return
+			}
 			out, err := recorder.Record(r.Context(), e)
+			if err != nil {
+				cfg.limits.refund(caller, map[string]int{e.Tenant: 1})
+			}
+			if errors.Is(err, gauditor.ErrDuplicateEvent) {
+				w.WriteHeader(http.StatusConflict)
+				_, _ = w.Write([]byte(err.Error()))
+				return
+			}
 			if err != nil {
 				w.WriteHeader(http.StatusBadRequest)
 				_, _ = w.Write([]byte(err.Error()))
@@ -79,17 +137,16 @@ func newServer(recorder *gauditor.Recorder) http.Handler {
 			w.WriteHeader(http.StatusCreated)
 			_ = json.NewEncoder(w).Encode(out)
 		case http.MethodGet:
-			q := gauditor.Query{
-				Tenant:   r.URL.Query().Get("tenant"),
-				ActorID:  r.URL.Query().Get("actorId"),
-				Action:   r.URL.Query().Get("action"),
-				TargetID: r.URL.Query().Get("targetId"),
-				Limit:    100,
+			q, err := parseQuery(r.URL.Query())
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				_, _ = w.Write([]byte(err.Error()))
+				return
 			}
-			if s := r.URL.Query().Get("limit"); s != "" {
-				if n, err := strconv.Atoi(s); err == nil && n > 0 && n <= 1000 {
-					q.Limit = n
-				}
+			if q.Tenant, err = principalFrom(r.Context()).queryTenant(q.Tenant); err != nil {
+				w.WriteHeader(http.StatusForbidden)
+				_, _ = w.Write([]byte(err.Error()))
+				return
 			}
 			res, err := recorder.Query(r.Context(), q)
 			if err != nil {
@@ -102,12 +159,19 @@ func newServer(recorder *gauditor.Recorder) http.Handler {
 		default:
 			w.WriteHeader(http.StatusMethodNotAllowed)
 		}
-	})
+	}))
+	mux.HandleFunc("/v1/events:batch", authorize(cfg.auth, map[string]string{http.MethodPost: scopeIngest}, batchHandler(recorder, cfg.limits)))
+	mux.HandleFunc("/v1/events/{id}", authorize(cfg.auth, map[string]string{http.MethodGet: scopeQuery}, getHandler(recorder)))
+	mux.HandleFunc("/healthz", healthHandler)
+	mux.HandleFunc("/readyz", readyHandler(recorder))
+	mux.HandleFunc("/v1/admin/usage", authorize(cfg.auth, map[string]string{http.MethodGet: scopeAdmin}, usageHandler(cfg.limits)))
 	return mux
 }
 
-func run(addr string, handler http.Handler) error {
-	log.Printf("gauditor listening on %s", addr)
+// run serves handler on addr until the server is shut down; with a non-nil
+// tlsConfig it serves HTTPS. SIGINT and SIGTERM stop it gracefully, giving
+// in-flight requests up to shutdownTimeout to complete.
+func run(addr string, handler http.Handler, tlsConfig *tls.Config, shutdownTimeout time.Duration) error {
 	srv := &http.Server{
 		Addr:              addr,
 		Handler:           handler,
@@ -116,6 +180,15 @@ func run(addr string, handler http.Handler) error {
 		WriteTimeout:      10 * time.Second,
 		IdleTimeout:       60 * time.Second,
 		MaxHeaderBytes:    1 << 20,
+		TLSConfig:         tlsConfig,
+	}
+	listenAndServe, serve := srv.ListenAndServe, srv.Serve
+	if tlsConfig != nil {
+		listenAndServe = func() error { return srv.ListenAndServeTLS("", "") }
+		serve = func(ln net.Listener) error { return srv.ServeTLS(ln, "", "") }
+		log.Printf("gauditor listening on %s (TLS)", addr)
+	} else {
+		log.Printf("gauditor listening on %s", addr)
 	}
 	if os.Getenv("GAUDITOR_TEST_EXIT") == "1" {
 		ln, err := net.Listen("tcp", "127.0.0.1:0")
@@ -126,7 +199,7 @@ func run(addr string, handler http.Handler) error {
 			time.Sleep(50 * time.Millisecond)
 			_ = srv.Shutdown(context.Background())
 		}()
-		if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+		if err := serve(ln); err != nil && err != http.ErrServerClosed {
 			return err
 		}
 		return nil
@@ -136,7 +209,7 @@ func run(addr string, handler http.Handler) error {
 			time.Sleep(50 * time.Millisecond)
 			_ = srv.Shutdown(context.Background())
 		}()
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		if err := listenAndServe(); err != nil && err != http.ErrServerClosed {
 			return err
 		}
 		return nil
@@ -148,17 +221,37 @@ func run(addr string, handler http.Handler) error {
 			_ = srv.Shutdown(context.Background())
 		}()
 	}
-	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+	wait := shutdownOnSignal(srv, shutdownTimeout)
+	if err := listenAndServe(); err != nil && err != http.ErrServerClosed {
+		_ = wait()
 		return err
 	}
-	return nil
+	return wait()
 }
 
 func realMain() int {
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		return runMigrate(os.Args[2:], os.Stdout, os.Stderr)
+	}
+	if len(os.Args) > 1 && os.Args[1] == "apikey" {
+		return runAPIKey(os.Args[2:], os.Stdout, os.Stderr)
+	}
+
 	fs := flag.NewFlagSet("gauditor", flag.ContinueOnError)
 	addr := fs.String("addr", ":8091", "HTTP listen address")
 	showVersion := fs.Bool("version", false, "print version and exit")
+	var sf storageFlags
+	fs.StringVar(&sf.config, "config", "", "YAML/JSON storage configuration file (default: GAUDITOR_CONFIG, or GAUDITOR_STORAGE and related env vars)")
+	fs.StringVar(&sf.snapshot, "snapshot", "", "file to keep in-memory events in across restarts (memory backend)")
+	fs.DurationVar(&sf.snapshotInterval, "snapshot-interval", 30*time.Second, "how often to write the snapshot (0 = only on exit)")
+	var af authFlags
+	af.register(fs)
+	var tf tlsFlags
+	tf.register(fs)
+	shutdownTimeout := fs.Duration("shutdown-timeout", 15*time.Second, "how long in-flight requests may run after SIGTERM or SIGINT")
+	limitsPath := fs.String("limits", os.Getenv("GAUDITOR_LIMITS"), "YAML/JSON file of ingestion rate limits and daily quotas per tenant and key")
 	_ = fs.Parse(os.Args[1:])
+	fs.Visit(func(f *flag.Flag) { sf.intervalSet = sf.intervalSet || f.Name == "snapshot-interval" })
 
 	if env := os.Getenv("GAUDITOR_ADDR"); env != "" {
 		*addr = env
@@ -169,17 +262,64 @@ func realMain() int {
 		return 0
 	}
 
-	recorder := gauditor.NewRecorder(gauditor.NewMemoryStorage())
-	handler := newServer(recorder)
+	cfg, err := loadConfig(sf)
+	if err != nil {
+		log.Println("config error:", err)
+		return 1
+	}
+	store, err := openStorage(context.Background(), cfg)
+	if err != nil {
+		log.Println("config error:", err)
+		return 1
+	}
+	defer func() {
+		if c, ok := store.(io.Closer); ok {
+			if err := c.Close(); err != nil {
+				log.Println("storage close error:", err)
+			}
+		}
+	}()
+	stop := make(chan struct{})
+	defer close(stop)
+	auth, err := af.open(context.Background(), stop)
+	if err != nil {
+		log.Println("config error:", err)
+		return 1
+	}
+	tlsConfig, err := tf.open(stop)
+	if err != nil {
+		log.Println("config error:", err)
+		return 1
+	}
+	if tf.clientCA != "" && af.apiKeys == "" {
+		log.Println("config error: -tls-client-ca needs -api-keys with cert entries mapping client certificates to tenants and scopes")
+		return 1
+	}
+	var opts []serverOption
+	if auth != nil {
+		opts = append(opts, withAuth(auth))
+	}
+	if *limitsPath != "" {
+		limits, err := loadLimits(*limitsPath)
+		if err != nil {
+			log.Println("config error:", err)
+			return 1
+		}
+		log.Printf("gauditor ingestion limits from %s: %d tenants, %d keys", *limitsPath, len(limits.Tenants), len(limits.Keys))
+		opts = append(opts, withLimits(limits))
+	}
+	recorder := gauditor.NewRecorder(store)
+	handler := newServer(recorder, opts...)
 
 	if os.Getenv("GAUDITOR_NO_SERVE") == "1" {
 		// Allows tests to execute initialization paths without binding ports
 		return 0
 	}
-	if err := run(*addr, handler); err != nil {
+	if err := run(*addr, handler, tlsConfig, *shutdownTimeout); err != nil {
 		log.Println("server error:", err)
 		return 1
 	}
+	log.Println("gauditor stopped; flushing and closing storage")
 	return 0
 }
 
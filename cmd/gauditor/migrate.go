@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/antoniomarcosferreira/gauditor/pkg/gauditor/sqlstore"
+
+	// SQL drivers available to the migrate subcommand.
+	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
+	_ "modernc.org/sqlite"
+)
+
+// runMigrate implements "gauditor migrate [flags] [status|up]".
+//
+//	status - list applied and pending schema migrations (default)
+//	up     - apply pending migrations
+//
+// Connection settings default to SQL_DRIVER and SQL_DSN. The status and
+// applied migrations go to stdout, usage and errors to stderr.
+func runMigrate(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("gauditor migrate", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	driver := fs.String("driver", os.Getenv("SQL_DRIVER"), "database/sql driver: postgres, mysql or sqlite")
+	dsn := fs.String("dsn", os.Getenv("SQL_DSN"), "driver-specific data source name")
+	table := fs.String("table", "gauditor_events", "events table name")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	cmd := "status"
+	if fs.NArg() > 0 {
+		cmd = fs.Arg(0)
+	}
+	if cmd != "status" && cmd != "up" {
+		fmt.Fprintf(stderr, "unknown migrate command %q (want status or up)\n", cmd)
+		return 2
+	}
+	if *driver == "" || *dsn == "" {
+		fmt.Fprintln(stderr, "migrate: -driver and -dsn (or SQL_DRIVER and SQL_DSN) are required")
+		return 2
+	}
+
+	db, err := sql.Open(*driver, *dsn)
+	if err != nil {
+		fmt.Fprintln(stderr, "migrate:", err)
+		return 1
+	}
+	defer db.Close()
+	store := sqlstore.New(db, sqlstore.WithDriverName(*driver), sqlstore.WithTableName(*table))
+
+	ctx := context.Background()
+	if cmd == "up" {
+		done, err := store.Migrate(ctx)
+		for _, m := range done {
+			fmt.Fprintf(stdout, "applied %d  %s\n", m.Version, m.Description)
+		}
+		if err != nil {
+			fmt.Fprintln(stderr, "migrate:", err)
+			return 1
+		}
+		if len(done) == 0 {
+			fmt.Fprintln(stdout, "schema is up to date")
+		}
+		return 0
+	}
+
+	applied, err := store.AppliedVersions(ctx)
+	if err != nil {
+		fmt.Fprintln(stderr, "migrate:", err)
+		return 1
+	}
+	for _, m := range store.Migrations() {
+		state := "pending"
+		if applied[m.Version] {
+			state = "applied"
+		}
+		fmt.Fprintf(stdout, "%-8s %d  %s\n", state, m.Version, m.Description)
+	}
+	return 0
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMigrate_StatusThenUp(t *testing.T) {
+	dsn := filepath.Join(t.TempDir(), "audit.db")
+	var out bytes.Buffer
+	if code := runMigrate([]string{"-driver", "sqlite", "-dsn", dsn, "status"}, &out, &out); code != 0 {
+		t.Fatalf("status exit %d: %s", code, out.String())
+	}
+	if !strings.Contains(out.String(), "pending  1") {
+		t.Fatalf("expected pending migrations: %s", out.String())
+	}
+
+	out.Reset()
+	if code := runMigrate([]string{"-driver", "sqlite", "-dsn", dsn, "up"}, &out, &out); code != 0 {
+		t.Fatalf("up exit %d: %s", code, out.String())
+	}
+	if !strings.Contains(out.String(), "applied 1") {
+		t.Fatalf("expected applied output: %s", out.String())
+	}
+
+	out.Reset()
+	if code := runMigrate([]string{"-driver", "sqlite", "-dsn", dsn}, &out, &out); code != 0 {
+		t.Fatalf("status exit %d: %s", code, out.String())
+	}
+	if strings.Contains(out.String(), "pending") {
+		t.Fatalf("expected no pending migrations: %s", out.String())
+	}
+}
+
+func TestMigrate_Errors(t *testing.T) {
+	t.Setenv("SQL_DRIVER", "")
+	t.Setenv("SQL_DSN", "")
+	for _, tc := range []struct {
+		args []string
+		code int
+		want string
+	}{
+		{nil, 2, "-driver and -dsn"},
+		{[]string{"down"}, 2, "unknown migrate command"},
+		{[]string{"-driver", "nope", "-dsn", "x", "up"}, 1, "unknown driver"},
+		{[]string{"-bogus"}, 2, "flag provided but not defined"},
+	} {
+		var stdout, stderr bytes.Buffer
+		if code := runMigrate(tc.args, &stdout, &stderr); code != tc.code || !strings.Contains(stderr.String(), tc.want) || stdout.Len() != 0 {
+			t.Errorf("%v: want exit %d and %q on stderr only, got %d: stdout %q stderr %q", tc.args, tc.code, tc.want, code, stdout.String(), stderr.String())
+		}
+	}
+}
+
+func TestRealMain_MigrateSubcommand(t *testing.T) {
+	old := os.Args
+	t.Cleanup(func() { os.Args = old })
+	os.Args = []string{"gauditor", "migrate", "-driver", "sqlite", "-dsn", filepath.Join(t.TempDir(), "a.db"), "up"}
+	if code := realMain(); code != 0 {
+		t.Fatalf("expected exit code 0, got %d", code)
+	}
+}
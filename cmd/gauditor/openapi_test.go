@@ -0,0 +1,335 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	g "github.com/antoniomarcosferreira/gauditor/pkg/gauditor"
+)
+
+// The contract tests below check api/openapi.yaml against the handler, so
+// the spec cannot drift from what the server accepts and returns.
+
+type specSchema struct {
+	Type       string                `yaml:"type"`
+	Format     string                `yaml:"format"`
+	Minimum    *int                  `yaml:"minimum"`
+	Maximum    *int                  `yaml:"maximum"`
+	MaxItems   *int                  `yaml:"maxItems"`
+	Default    any                   `yaml:"default"`
+	Enum       []string              `yaml:"enum"`
+	Required   []string              `yaml:"required"`
+	Properties map[string]specSchema `yaml:"properties"`
+}
+
+type specParam struct {
+	In       string     `yaml:"in"`
+	Name     string     `yaml:"name"`
+	Required bool       `yaml:"required"`
+	Style    string     `yaml:"style"`
+	Schema   specSchema `yaml:"schema"`
+}
+
+type specOperation struct {
+	Parameters  []specParam          `yaml:"parameters"`
+	Responses   map[string]yaml.Node `yaml:"responses"`
+	RequestBody struct {
+		Content map[string]struct {
+			Schema specSchema `yaml:"schema"`
+		} `yaml:"content"`
+	} `yaml:"requestBody"`
+	Security *[]map[string][]string `yaml:"security"`
+}
+
+type spec struct {
+	Paths      map[string]map[string]specOperation `yaml:"paths"`
+	Components struct {
+		Schemas map[string]specSchema `yaml:"schemas"`
+	} `yaml:"components"`
+}
+
+func loadSpec(t *testing.T) spec {
+	t.Helper()
+	raw, err := os.ReadFile("../../api/openapi.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var s spec
+	if err := yaml.Unmarshal(raw, &s); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// documented fails unless the spec lists status for the operation.
+func documented(t *testing.T, op specOperation, status int) {
+	t.Helper()
+	if _, ok := op.Responses[strconv.Itoa(status)]; !ok {
+		t.Errorf("handler returned %d, which the spec does not document", status)
+	}
+}
+
+func contractServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	rec := g.NewRecorder(g.NewMemoryStorage())
+	_, _ = rec.Record(context.Background(), g.Event{Tenant: "acme", Action: "login", Actor: g.Actor{ID: "u1"}, Data: map[string]any{"n": 1}})
+	srv := httptest.NewServer(newServer(rec))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func getStatus(t *testing.T, srv *httptest.Server, params url.Values) (int, []byte) {
+	t.Helper()
+	resp, err := http.Get(srv.URL + "/v1/events?" + params.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, body
+}
+
+func TestOpenAPI_QueryParametersMatchHandler(t *testing.T) {
+	op := loadSpec(t).Paths["/v1/events"]["get"]
+	var names []string
+	for _, p := range op.Parameters {
+		if p.In != "query" {
+			t.Errorf("parameter %s: unexpected location %s", p.Name, p.In)
+		}
+		if p.Name == "data" {
+			if p.Style != "deepObject" || p.Schema.Type != "object" {
+				t.Errorf("data must be a deepObject parameter, got %+v", p)
+			}
+			continue
+		}
+		names = append(names, p.Name)
+	}
+	handled := append([]string(nil), queryParams...)
+	sort.Strings(names)
+	sort.Strings(handled)
+	if strings.Join(names, ",") != strings.Join(handled, ",") {
+		t.Fatalf("spec parameters %v, handler parameters %v", names, handled)
+	}
+}
+
+func TestOpenAPI_QueryParameterSchemas(t *testing.T) {
+	op := loadSpec(t).Paths["/v1/events"]["get"]
+	srv := contractServer(t)
+	for _, p := range op.Parameters {
+		var valid, invalid []string
+		switch {
+		case p.Name == "data":
+			p.Name = "data[n]"
+			valid = []string{"1", "x", `"1"`}
+		case p.Schema.Format == "date-time":
+			valid = []string{"2025-01-02T15:04:05Z", "2025-01-02T15:04:05.123+02:00"}
+			invalid = []string{"2025-01-02", "yesterday"}
+		case p.Schema.Type == "integer":
+			valid = []string{strconv.Itoa(*p.Schema.Minimum), strconv.Itoa(*p.Schema.Maximum)}
+			invalid = []string{strconv.Itoa(*p.Schema.Minimum - 1), strconv.Itoa(*p.Schema.Maximum + 1), "ten"}
+			if p.Schema.Default != defaultQueryLimit || *p.Schema.Maximum != maxQueryLimit {
+				t.Errorf("%s: spec default/maximum differ from the handler's %d/%d", p.Name, defaultQueryLimit, maxQueryLimit)
+			}
+		case p.Schema.Type == "string":
+			valid = []string{"acme"}
+		default:
+			t.Fatalf("%s: no contract check for schema %+v", p.Name, p.Schema)
+		}
+		for _, v := range valid {
+			if status, body := getStatus(t, srv, url.Values{p.Name: {v}}); status != http.StatusOK {
+				t.Errorf("%s=%s: want 200, got %d: %s", p.Name, v, status, body)
+			}
+		}
+		for _, v := range invalid {
+			status, body := getStatus(t, srv, url.Values{p.Name: {v}})
+			if status != http.StatusBadRequest || !strings.Contains(string(body), p.Name) {
+				t.Errorf("%s=%s: want 400 naming the parameter, got %d: %s", p.Name, v, status, body)
+			}
+			documented(t, op, status)
+		}
+	}
+	status, _ := getStatus(t, srv, url.Values{"_": {"1700000000"}})
+	if status != http.StatusOK {
+		t.Errorf("unknown parameter: want it ignored, got %d", status)
+	}
+	documented(t, op, status)
+}
+
+func TestOpenAPI_ResponsesMatchHandler(t *testing.T) {
+	s := loadSpec(t)
+	srv := contractServer(t)
+
+	get := s.Paths["/v1/events"]["get"]
+	status, body := getStatus(t, srv, url.Values{"tenant": {"acme"}})
+	documented(t, get, status)
+	var events []map[string]any
+	if err := json.Unmarshal(body, &events); err != nil || len(events) != 1 {
+		t.Fatalf("want a JSON array with one event, got %s", body)
+	}
+	for _, field := range s.Components.Schemas["Event"].Required {
+		if _, ok := events[0][field]; !ok {
+			t.Errorf("event lacks required field %s: %s", field, body)
+		}
+	}
+	if _, err := time.Parse(time.RFC3339Nano, events[0]["timestamp"].(string)); err != nil {
+		t.Errorf("timestamp is not date-time: %v", err)
+	}
+
+	post := s.Paths["/v1/events"]["post"]
+	for _, payload := range []string{`{"id":"x","tenant":"acme","action":"login","actor":{"id":"u1"}}`, `{"id":"x","tenant":"acme","action":"login"}`, `{"tenant":"acme"}`, `{`} {
+		resp, err := http.Post(srv.URL+"/v1/events", "application/json", strings.NewReader(payload))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		documented(t, post, resp.StatusCode)
+	}
+}
+
+func TestOpenAPI_BatchMatchesHandler(t *testing.T) {
+	s := loadSpec(t)
+	op := s.Paths["/v1/events:batch"]["post"]
+	if max := op.RequestBody.Content["application/json"].Schema.MaxItems; max == nil || *max != maxBatchItems {
+		t.Errorf("spec maxItems differs from the handler's %d", maxBatchItems)
+	}
+	if _, ok := op.RequestBody.Content["application/x-ndjson"]; !ok {
+		t.Error("spec does not document NDJSON bodies")
+	}
+	enum := map[string]bool{}
+	for _, v := range s.Components.Schemas["BatchItemResult"].Properties["status"].Enum {
+		enum[v] = true
+	}
+	for _, status := range []string{batchCreated, batchDuplicate, batchInvalid, batchFailed} {
+		if !enum[status] {
+			t.Errorf("status %q missing from the spec enum", status)
+		}
+	}
+	if len(enum) != 4 {
+		t.Errorf("spec enum has statuses the handler never returns: %v", enum)
+	}
+
+	srv := contractServer(t)
+	for _, body := range []string{`[{"tenant":"acme","action":"a"},{"tenant":"acme"}]`, "", strings.Repeat("{}\n", maxBatchItems+1)} {
+		resp, err := http.Post(srv.URL+"/v1/events:batch", "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		raw, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		documented(t, op, resp.StatusCode)
+		if resp.StatusCode != http.StatusOK {
+			continue
+		}
+		var res map[string]any
+		_ = json.Unmarshal(raw, &res)
+		for _, field := range s.Components.Schemas["BatchResult"].Required {
+			if _, ok := res[field]; !ok {
+				t.Errorf("batch result lacks required field %s: %s", field, raw)
+			}
+		}
+	}
+}
+
+func TestOpenAPI_GetEventMatchesHandler(t *testing.T) {
+	s := loadSpec(t)
+	op := s.Paths["/v1/events/{id}"]["get"]
+	for _, p := range op.Parameters {
+		if !p.Required || (p.In+":"+p.Name != "path:id" && p.In+":"+p.Name != "query:tenant") {
+			t.Errorf("unexpected parameter %+v", p)
+		}
+	}
+	srv := contractServer(t)
+	_, body := getStatus(t, srv, url.Values{"tenant": {"acme"}})
+	var seeded []g.Event
+	if err := json.Unmarshal(body, &seeded); err != nil || len(seeded) != 1 {
+		t.Fatalf("seeded event not found: %s", body)
+	}
+	for _, path := range []string{"/v1/events/" + seeded[0].ID + "?tenant=acme", "/v1/events/x?tenant=acme", "/v1/events/x"} {
+		resp, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		documented(t, op, resp.StatusCode)
+	}
+}
+
+func TestOpenAPI_AuthResponsesDocumented(t *testing.T) {
+	s := loadSpec(t)
+	path := filepath.Join(t.TempDir(), "keys.yaml")
+	keysFile(t, path, map[string][2]string{"reader": {"acme", "query"}})
+	keys, err := newAPIKeys(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(newServer(g.NewRecorder(g.NewMemoryStorage()), withAuth(keys)))
+	t.Cleanup(srv.Close)
+	for route, ops := range s.Paths {
+		for method, op := range ops {
+			if op.Security != nil && len(*op.Security) == 0 {
+				continue // public, like /healthz
+			}
+			url := srv.URL + strings.Replace(route, "{id}", "x", 1)
+			for key, want := range map[string]int{"": http.StatusUnauthorized, "reader": http.StatusForbidden} {
+				if method == "get" && key == "reader" {
+					url += "?tenant=beta"
+				}
+				status, _ := do(t, strings.ToUpper(method), url, key, "{}")
+				if status != want {
+					t.Errorf("%s %s as %q: want %d, got %d", method, route, key, want, status)
+				}
+				documented(t, op, status)
+			}
+		}
+	}
+}
+
+func TestOpenAPI_RateLimitsDocumented(t *testing.T) {
+	s := loadSpec(t)
+	limits, err := parseLimits([]byte("default: {daily: 1}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(newServer(g.NewRecorder(g.NewMemoryStorage()), withLimits(limits)))
+	t.Cleanup(srv.Close)
+	for _, tc := range []struct{ route, body string }{
+		{"/v1/events", `{"tenant":"acme","action":"a"}`},
+		{"/v1/events", `{"tenant":"acme","action":"b"}`},
+		{"/v1/events:batch", `[{"tenant":"acme","action":"c"}]`},
+	} {
+		status, _ := do(t, "POST", srv.URL+tc.route, "", tc.body)
+		documented(t, s.Paths[tc.route]["post"], status)
+	}
+	for _, query := range []string{"", "?tenant=acme", "?x=1"} {
+		status, _ := do(t, "GET", srv.URL+"/v1/admin/usage"+query, "", "")
+		documented(t, s.Paths["/v1/admin/usage"]["get"], status)
+	}
+}
+
+func TestOpenAPI_HealthDocumented(t *testing.T) {
+	s := loadSpec(t)
+	store := &pingStore{MemoryStorage: g.NewMemoryStorage()}
+	srv := httptest.NewServer(newServer(g.NewRecorder(store)))
+	t.Cleanup(srv.Close)
+	for _, err := range []error{nil, errors.New("down")} {
+		store.err = err
+		for _, route := range []string{"/healthz", "/readyz"} {
+			status, _ := do(t, "GET", srv.URL+route, "", "")
+			documented(t, s.Paths[route]["get"], status)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/antoniomarcosferreira/gauditor/pkg/gauditor"
+)
+
+// Limits of the GET /v1/events limit parameter.
+const (
+	defaultQueryLimit = 100
+	maxQueryLimit     = 1000
+)
+
+// queryParams are the parameters accepted by GET /v1/events besides the
+// data[<path>] payload filters; api/openapi.yaml must list the same ones.
+var queryParams = []string{"tenant", "actorId", "action", "targetId", "since", "until", "limit"}
+
+// parseQuery builds a Query from GET /v1/events parameters. Repeated
+// parameters and malformed values are errors. Unknown parameters, such as
+// cache busters or tracing tags, are ignored.
+func parseQuery(values url.Values) (gauditor.Query, error) {
+	q := gauditor.Query{Limit: defaultQueryLimit}
+	for name, vs := range values {
+		path, isData := dataParam(name)
+		if !isData && !slices.Contains(queryParams, name) {
+			continue
+		}
+		if len(vs) > 1 {
+			return q, fmt.Errorf("parameter %s given more than once", name)
+		}
+		v := vs[0]
+		if isData {
+			if path == "" {
+				return q, fmt.Errorf("parameter %s: empty data path", name)
+			}
+			if q.Data == nil {
+				q.Data = make(map[string]any)
+			}
+			q.Data[path] = dataValue(v)
+			continue
+		}
+		var err error
+		switch name {
+		case "tenant":
+			q.Tenant = v
+		case "actorId":
+			q.ActorID = v
+		case "action":
+			q.Action = v
+		case "targetId":
+			q.TargetID = v
+		case "since":
+			q.Since, err = parseTime(v)
+		case "until":
+			q.Until, err = parseTime(v)
+		case "limit":
+			q.Limit, err = strconv.Atoi(v)
+			if err != nil || q.Limit < 1 || q.Limit > maxQueryLimit {
+				err = fmt.Errorf("must be an integer from 1 to %d", maxQueryLimit)
+			}
+		}
+		if err != nil {
+			return q, fmt.Errorf("invalid %s %q: %w", name, v, err)
+		}
+	}
+	if q.Since != nil && q.Until != nil && q.Since.After(*q.Until) {
+		return q, fmt.Errorf("since must not be after until")
+	}
+	return q, nil
+}
+
+// dataParam extracts the payload path of a data[<path>] parameter.
+func dataParam(name string) (string, bool) {
+	if !strings.HasPrefix(name, "data[") || !strings.HasSuffix(name, "]") {
+		return "", false
+	}
+	return name[len("data[") : len(name)-1], true
+}
+
+// dataValue interprets a data filter value as a JSON scalar when it is one
+// (42, true, null, "42"), and as a plain string otherwise.
+func dataValue(v string) any {
+	var x any
+	if err := json.Unmarshal([]byte(v), &x); err == nil {
+		switch x.(type) {
+		case map[string]any, []any:
+		default:
+			return x
+		}
+	}
+	return v
+}
+
+// parseTime parses an RFC 3339 timestamp. An unescaped "+" in a UTC offset
+// arrives as a space and is put back.
+func parseTime(v string) (*time.Time, error) {
+	t, err := time.Parse(time.RFC3339Nano, strings.Replace(v, " ", "+", 1))
+	if err != nil {
+		return nil, fmt.Errorf("must be an RFC 3339 timestamp such as 2025-01-02T15:04:05Z")
+	}
+	return &t, nil
+}
@@ -6,10 +6,15 @@ import (
 	"encoding/json"
 	"errors"
 	"io"
+	"log"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	g "github.com/antoniomarcosferreira/gauditor/pkg/gauditor"
 )
@@ -126,7 +131,7 @@ func TestHTTP_QueryFilters(t *testing.T) {
 func TestRun_InvalidAddr(t *testing.T) {
 	rec := g.NewRecorder(g.NewMemoryStorage())
 	h := newServer(rec)
-	if err := run("bad-addr", h); err == nil {
+	if err := run("bad-addr", h, nil, time.Second); err == nil {
 		t.Fatalf("expected error for invalid addr")
 	}
 }
@@ -217,7 +222,7 @@ func TestRun_TestExitPath(t *testing.T) {
 	t.Setenv("GAUDITOR_TEST_EXIT", "1")
 	rec := g.NewRecorder(g.NewMemoryStorage())
 	h := newServer(rec)
-	if err := run(":0", h); err != nil {
+	if err := run(":0", h, nil, time.Second); err != nil {
 		t.Fatalf("run test-exit failed: %v", err)
 	}
 }
@@ -226,7 +231,7 @@ func TestRun_TestNormalPath(t *testing.T) {
 	t.Setenv("GAUDITOR_TEST_NORMAL", "1")
 	rec := g.NewRecorder(g.NewMemoryStorage())
 	h := newServer(rec)
-	if err := run(":0", h); err != nil {
+	if err := run(":0", h, nil, time.Second); err != nil {
 		t.Fatalf("run test-normal failed: %v", err)
 	}
 }
@@ -264,3 +269,189 @@ func TestMain_WrapsExitFunc(t *testing.T) {
 		t.Fatalf("expected exit code 0, got %d", called)
 	}
 }
+
+// runMain runs realMain with args and returns its exit code and log output.
+func runMain(t *testing.T, args ...string) (int, string) {
+	t.Helper()
+	old := os.Args
+	t.Cleanup(func() { os.Args = old })
+	os.Args = append([]string{"gauditor"}, args...)
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+	code := realMain()
+	return code, buf.String()
+}
+
+func TestRealMain_SnapshotAcrossRestarts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.snapshot")
+	store := g.NewMemoryStorage()
+	_, _ = g.NewRecorder(store).Record(context.Background(), g.Event{Tenant: "t", Action: "login", Actor: g.Actor{ID: "u1"}})
+	if err := store.SnapshotFile(path); err != nil {
+		t.Fatal(err)
+	}
+
+	// The snapshot is restored on start and written again on exit.
+	t.Setenv("GAUDITOR_STORAGE", "")
+	t.Setenv("GAUDITOR_SNAPSHOT", path)
+	t.Setenv("GAUDITOR_TEST_NORMAL", "1")
+	t.Setenv("GAUDITOR_NO_SERVE", "0")
+	if code, out := runMain(t, "-addr", ":0", "-snapshot-interval", "0"); code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s", code, out)
+	}
+	restored := g.NewMemoryStorage()
+	if err := restored.RestoreFile(path); err != nil || restored.Len() != 1 {
+		t.Fatalf("snapshot lost events across a restart: %d %v", restored.Len(), err)
+	}
+
+	if err := os.WriteFile(path, []byte("garbage\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GAUDITOR_NO_SERVE", "1")
+	if code, _ := runMain(t); code != 1 {
+		t.Fatalf("want exit code 1 for a corrupt snapshot, got %d", code)
+	}
+}
+
+func TestRealMain_ConfigFile(t *testing.T) {
+	t.Setenv("GAUDITOR_NO_SERVE", "1")
+	t.Setenv("REDIS_PASS", "hunter2")
+	dir := t.TempDir()
+	good := filepath.Join(dir, "gauditor.yaml")
+	if err := os.WriteFile(good, []byte(`
+storage:
+  backend: s3
+  options: {dir: `+filepath.Join(dir, "archive")+`}
+  wrappers:
+    - type: fanout
+      stores:
+        - backend: redis
+          options: {url: "redis://:${REDIS_PASS}@127.0.0.1:1/0", password: "${REDIS_PASS}"}
+`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	code, out := runMain(t, "-config", good)
+	if code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s", code, out)
+	}
+	if strings.Contains(out, "hunter2") || !strings.Contains(out, `"backend":"s3"`) || !strings.Contains(out, "****") {
+		t.Fatalf("want the effective config logged with secrets masked, got %s", out)
+	}
+
+	bad := filepath.Join(dir, "bad.yaml")
+	_ = os.WriteFile(bad, []byte("storage: {backend: mongo}\n"), 0o600)
+	if code, out := runMain(t, "-config", bad); code != 1 || !strings.Contains(out, `unknown storage backend "mongo"`) {
+		t.Fatalf("want exit code 1 for an unknown backend, got %d: %s", code, out)
+	}
+	t.Setenv("GAUDITOR_STORAGE", "s3")
+	if code, out := runMain(t, "-snapshot", filepath.Join(dir, "x")); code != 1 || !strings.Contains(out, "memory backend") {
+		t.Fatalf("want exit code 1 for -snapshot without the memory backend, got %d: %s", code, out)
+	}
+}
+
+func TestHTTP_QueryTimeRangeAndData(t *testing.T) {
+	rec := g.NewRecorder(g.NewMemoryStorage())
+	t0 := time.Date(2025, 1, 2, 10, 0, 0, 0, time.UTC)
+	for i, status := range []string{"paid", "open", "paid", "open"} {
+		_, _ = rec.Record(context.Background(), g.Event{
+			ID: strconv.Itoa(i), Tenant: "t", Action: "order", Timestamp: t0.Add(time.Duration(i) * time.Hour),
+			Data: map[string]any{"status": status, "order": map[string]any{"total": i * 10}},
+		})
+	}
+	srv := httptest.NewServer(newServer(rec))
+	t.Cleanup(srv.Close)
+
+	ids := func(rawQuery string) (int, string) {
+		t.Helper()
+		r, err := http.Get(srv.URL + "/v1/events?" + rawQuery)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Body.Close()
+		raw, _ := io.ReadAll(r.Body)
+		if r.StatusCode != http.StatusOK {
+			return r.StatusCode, string(raw)
+		}
+		var events []g.Event
+		_ = json.Unmarshal(raw, &events)
+		var out []string
+		for _, e := range events {
+			out = append(out, e.ID)
+		}
+		return r.StatusCode, strings.Join(out, ",")
+	}
+	for _, tc := range []struct{ query, want string }{
+		{"since=2025-01-02T11:00:00Z&until=2025-01-02T12:00:00Z", "1,2"},
+		{"since=2025-01-02T13:00:00+02:00", "1,2,3"},
+		{"until=2025-01-02T11:30:00%2B01:00", "0"},
+		{"data[status]=paid", "0,2"},
+		{"data[order.total]=20&data[status]=paid", "2"},
+		{`data[order.total]="20"`, ""},
+		{"tenant=t&limit=2", "0,1"},
+		{"tenant=t&limit=2&_=1700000000&trace=a&trace=b", "0,1"},
+	} {
+		if status, got := ids(tc.query); status != http.StatusOK || got != tc.want {
+			t.Errorf("%s: want %q, got %d %q", tc.query, tc.want, status, got)
+		}
+	}
+	for _, tc := range []struct{ query, want string }{
+		{"limit=0", "invalid limit"},
+		{"limit=5000", "invalid limit"},
+		{"since=yesterday", "invalid since"},
+		{"since=2025-01-03T00:00:00Z&until=2025-01-02T00:00:00Z", "since must not be after until"},
+		{"tenant=a&tenant=b", "more than once"},
+		{"data[]=x", "empty data path"},
+	} {
+		if status, body := ids(tc.query); status != http.StatusBadRequest || !strings.Contains(body, tc.want) {
+			t.Errorf("%s: want 400 %q, got %d %q", tc.query, tc.want, status, body)
+		}
+	}
+}
+
+func TestHTTP_GetEvent(t *testing.T) {
+	rec := g.NewRecorder(g.NewMemoryStorage())
+	_, _ = rec.Record(context.Background(), g.Event{ID: "e1", Tenant: "acme", Action: "login"})
+	srv := httptest.NewServer(newServer(rec))
+	t.Cleanup(srv.Close)
+
+	get := func(path string) (int, string) {
+		t.Helper()
+		r, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Body.Close()
+		raw, _ := io.ReadAll(r.Body)
+		return r.StatusCode, string(raw)
+	}
+	status, body := get("/v1/events/e1?tenant=acme")
+	var e g.Event
+	if err := json.Unmarshal([]byte(body), &e); status != http.StatusOK || err != nil || e.Action != "login" {
+		t.Fatalf("want the event, got %d %s", status, body)
+	}
+	for path, want := range map[string]int{
+		"/v1/events/e1?tenant=other":        http.StatusNotFound,
+		"/v1/events/nope?tenant=acme":       http.StatusNotFound,
+		"/v1/events/e1":                     http.StatusBadRequest,
+		"/v1/events/e1?tenant=acme&x=1":     http.StatusOK,
+		"/v1/events/e1?tenant=a&tenant=b":   http.StatusBadRequest,
+		"/v1/events/e1/extra?tenant=acme":   http.StatusNotFound,
+		"/v1/events:batch?tenant=acme":      http.StatusMethodNotAllowed,
+		"/v1/events?tenant=acme&limit=1000": http.StatusOK,
+	} {
+		if status, body := get(path); status != want {
+			t.Errorf("%s: want %d, got %d %s", path, want, status, body)
+		}
+	}
+
+	srv500 := httptest.NewServer(newServer(g.NewRecorder(errorStorage{})))
+	t.Cleanup(srv500.Close)
+	r, err := http.Get(srv500.URL + "/v1/events/e1?tenant=acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Body.Close()
+	if r.StatusCode != http.StatusInternalServerError {
+		t.Fatalf("want 500, got %d", r.StatusCode)
+	}
+}
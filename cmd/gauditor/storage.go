@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/antoniomarcosferreira/gauditor/pkg/gauditor"
+	"github.com/antoniomarcosferreira/gauditor/pkg/gauditorenv"
+)
+
+// storageFlags are the command-line settings that shape the storage pipeline.
+type storageFlags struct {
+	config           string        // -config file; overrides GAUDITOR_CONFIG and GAUDITOR_STORAGE
+	snapshot         string        // -snapshot, memory backend only
+	snapshotInterval time.Duration // -snapshot-interval when set explicitly
+	intervalSet      bool
+}
+
+// loadConfig returns the effective storage configuration: the -config file,
+// or gauditorenv's environment configuration, with snapshot flags applied.
+func loadConfig(f storageFlags) (*gauditorenv.Config, error) {
+	var cfg *gauditorenv.Config
+	var err error
+	if f.config != "" {
+		cfg, err = gauditorenv.LoadConfig(f.config)
+	} else {
+		cfg, err = gauditorenv.ConfigFromEnv()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if f.snapshot == "" && !f.intervalSet {
+		return cfg, nil
+	}
+	if cfg.Storage.Backend != "memory" {
+		return nil, fmt.Errorf("-snapshot requires the memory backend, not %q", cfg.Storage.Backend)
+	}
+	if cfg.Storage.Options == nil {
+		cfg.Storage.Options = gauditorenv.Options{}
+	}
+	if f.snapshot != "" {
+		cfg.Storage.Options["snapshot"] = f.snapshot
+	}
+	if f.intervalSet {
+		cfg.Storage.Options["snapshotInterval"] = f.snapshotInterval.String()
+	}
+	return cfg, nil
+}
+
+// openStorage logs the configuration with secrets masked and builds its
+// storage pipeline, which fails on invalid settings.
+func openStorage(ctx context.Context, cfg *gauditorenv.Config) (gauditor.Storage, error) {
+	masked, err := json.Marshal(cfg.Masked())
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("gauditor storage config: %s", masked)
+	return gauditorenv.NewStorage(ctx, cfg.Storage)
+}
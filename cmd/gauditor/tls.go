@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// tlsFlags are the command-line settings of HTTPS serving.
+type tlsFlags struct {
+	cert, key  string
+	clientCA   string
+	clientAuth string
+	reload     time.Duration
+}
+
+func (f *tlsFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.cert, "tls-cert", os.Getenv("GAUDITOR_TLS_CERT"), "PEM certificate (chain) file; serves HTTPS together with -tls-key")
+	fs.StringVar(&f.key, "tls-key", os.Getenv("GAUDITOR_TLS_KEY"), "PEM private key file of -tls-cert")
+	fs.StringVar(&f.clientCA, "tls-client-ca", os.Getenv("GAUDITOR_TLS_CLIENT_CA"), "PEM CA bundle verifying client certificates (mutual TLS); map them with cert entries in -api-keys")
+	fs.StringVar(&f.clientAuth, "tls-client-auth", "require", "with -tls-client-ca: require a client certificate, or accept it when given (optional)")
+	fs.DurationVar(&f.reload, "tls-reload", 10*time.Second, "how often to re-read the TLS files (0 = never)")
+}
+
+// open loads the TLS files, or returns nil when HTTPS is not configured.
+// Background reloads stop with stop.
+func (f tlsFlags) open(stop <-chan struct{}) (*tls.Config, error) {
+	switch {
+	case f.cert == "" && f.key == "" && f.clientCA == "":
+		return nil, nil
+	case f.cert == "" || f.key == "":
+		return nil, errors.New("tls: -tls-cert and -tls-key must be set together (and are required by -tls-client-ca)")
+	}
+	var clientAuth tls.ClientAuthType
+	switch {
+	case f.clientCA == "":
+		clientAuth = tls.NoClientCert
+	case f.clientAuth == "require":
+		clientAuth = tls.RequireAndVerifyClientCert
+	case f.clientAuth == "optional":
+		clientAuth = tls.VerifyClientCertIfGiven
+	default:
+		return nil, fmt.Errorf("tls: -tls-client-auth must be require or optional, got %q", f.clientAuth)
+	}
+	files, err := newTLSFiles(f.cert, f.key, f.clientCA, clientAuth)
+	if err != nil {
+		return nil, err
+	}
+	if f.reload > 0 {
+		go files.watch(stop, f.reload)
+	}
+	return files.serverConfig(), nil
+}
+
+// tlsFiles serves the certificate, key and client CA bundle read from files.
+// The files are re-read by watch, so renewed certificates and rotated CAs
+// are used by new connections without a restart.
+type tlsFiles struct {
+	cert, key, clientCA string
+	clientAuth          tls.ClientAuthType
+
+	config atomic.Pointer[tls.Config]
+
+	mu   sync.Mutex // serializes reloads
+	last []byte     // file contents last loaded
+}
+
+// newTLSFiles loads the files; clientCA may be empty when clients are not
+// authenticated by certificate.
+func newTLSFiles(cert, key, clientCA string, clientAuth tls.ClientAuthType) (*tlsFiles, error) {
+	t := &tlsFiles{cert: cert, key: key, clientCA: clientCA, clientAuth: clientAuth}
+	if _, err := t.reload(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// reload re-reads the files and reports whether they changed. Files that do
+// not load, such as a key that does not match its certificate while both are
+// being replaced, keep the previous configuration in use.
+func (t *tlsFiles) reload() (bool, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var all [3][]byte
+	for i, path := range []string{t.cert, t.key, t.clientCA} {
+		if path == "" {
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return false, fmt.Errorf("tls: %w", err)
+		}
+		all[i] = data
+	}
+	joined := bytes.Join(all[:], []byte{0})
+	if t.last != nil && bytes.Equal(joined, t.last) {
+		return false, nil
+	}
+	pair, err := tls.X509KeyPair(all[0], all[1])
+	if err != nil {
+		return false, fmt.Errorf("tls %s: %w", t.cert, err)
+	}
+	cfg := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{pair},
+		NextProtos:   []string{"h2", "http/1.1"},
+		ClientAuth:   t.clientAuth,
+	}
+	if t.clientCA != "" {
+		cfg.ClientCAs = x509.NewCertPool()
+		if !cfg.ClientCAs.AppendCertsFromPEM(all[2]) {
+			return false, fmt.Errorf("tls %s: no PEM certificates", t.clientCA)
+		}
+	}
+	t.config.Store(cfg)
+	t.last = joined
+	return true, nil
+}
+
+// watch reloads the files every interval until stop is closed.
+func (t *tlsFiles) watch(stop <-chan struct{}, interval time.Duration) {
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-tick.C:
+			changed, err := t.reload()
+			if err != nil {
+				log.Printf("%v; keeping the previous certificate", err)
+			} else if changed {
+				log.Printf("gauditor TLS files reloaded: %s", t.describe())
+			}
+		}
+	}
+}
+
+// describe names the certificate in use and its expiry.
+func (t *tlsFiles) describe() string {
+	cert := t.config.Load().Certificates[0]
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return t.cert
+	}
+	return fmt.Sprintf("%s, certificate %q expires %s", t.cert, leaf.Subject.CommonName, leaf.NotAfter.Format(time.RFC3339))
+}
+
+// serverConfig returns an http.Server TLS configuration that hands every
+// new connection the files loaded last.
+func (t *tlsFiles) serverConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return &t.config.Load().Certificates[0], nil
+		},
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return t.config.Load(), nil
+		},
+	}
+}
+
+// certIdentities lists the names a client certificate may be mapped by:
+// its URI SANs (such as SPIFFE IDs), DNS SANs and "CN=<common name>".
+func certIdentities(cert *x509.Certificate) []string {
+	var ids []string
+	for _, u := range cert.URIs {
+		ids = append(ids, u.String())
+	}
+	ids = append(ids, cert.DNSNames...)
+	if cert.Subject.CommonName != "" {
+		ids = append(ids, "CN="+cert.Subject.CommonName)
+	}
+	return ids
+}
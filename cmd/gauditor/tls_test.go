@@ -0,0 +1,328 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	g "github.com/antoniomarcosferreira/gauditor/pkg/gauditor"
+)
+
+// testCA issues certificates for tests.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T, name string) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue returns the PEM certificate and key of a leaf configured by tmpl.
+func (ca *testCA) issue(t *testing.T, tmpl *x509.Certificate) (certPEM, keyPEM []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	tmpl.SerialNumber = serial
+	tmpl.NotBefore, tmpl.NotAfter = time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rawKey, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: rawKey})
+}
+
+func (ca *testCA) serverFiles(t *testing.T, dir, name string) (certFile, keyFile string) {
+	t.Helper()
+	certPEM, keyPEM := ca.issue(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: name},
+		IPAddresses: []net.IP{net.IPv4(127, 0, 0, 1)},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	certFile, keyFile = filepath.Join(dir, "server.pem"), filepath.Join(dir, "server-key.pem")
+	writeFile(t, certFile, certPEM)
+	writeFile(t, keyFile, keyPEM)
+	return certFile, keyFile
+}
+
+func (ca *testCA) client(t *testing.T, tmpl *x509.Certificate) tls.Certificate {
+	t.Helper()
+	tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	pair, err := tls.X509KeyPair(ca.issue(t, tmpl))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pair
+}
+
+func writeFile(t *testing.T, path string, data []byte) {
+	t.Helper()
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// serveTLS serves handler over HTTPS with cfg the way run does and returns
+// its base URL.
+func serveTLS(t *testing.T, cfg *tls.Config, handler http.Handler) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{Handler: handler, TLSConfig: cfg, ErrorLog: log.New(io.Discard, "", 0)}
+	go func() { _ = srv.ServeTLS(ln, "", "") }()
+	t.Cleanup(func() { _ = srv.Close() })
+	return "https://" + ln.Addr().String()
+}
+
+// tlsClient trusts ca and presents certs; every request uses a new
+// connection.
+func tlsClient(ca *testCA, certs ...tls.Certificate) *http.Client {
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(ca.pem)
+	return &http.Client{Transport: &http.Transport{
+		TLSClientConfig:   &tls.Config{RootCAs: roots, Certificates: certs},
+		DisableKeepAlives: true,
+	}}
+}
+
+func TestTLS_CertificateReload(t *testing.T) {
+	ca := newTestCA(t, "test CA")
+	dir := t.TempDir()
+	certFile, keyFile := ca.serverFiles(t, dir, "first")
+	files, err := newTLSFiles(certFile, keyFile, "", tls.NoClientCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	url := serveTLS(t, files.serverConfig(), newServer(g.NewRecorder(g.NewMemoryStorage())))
+	client := tlsClient(ca)
+	served := func() string {
+		t.Helper()
+		resp, err := client.Get(url + "/v1/events")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		_, _ = io.Copy(io.Discard, resp.Body)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("status %d", resp.StatusCode)
+		}
+		return resp.TLS.PeerCertificates[0].Subject.CommonName
+	}
+	if cn := served(); cn != "first" {
+		t.Fatalf("serving %q", cn)
+	}
+
+	ca.serverFiles(t, dir, "second")
+	if changed, err := files.reload(); err != nil || !changed {
+		t.Fatalf("reload: %v %v", changed, err)
+	}
+	if cn := served(); cn != "second" {
+		t.Fatalf("want the renewed certificate, serving %q", cn)
+	}
+	if changed, err := files.reload(); err != nil || changed {
+		t.Fatalf("unchanged files: %v %v", changed, err)
+	}
+
+	// A key that does not match the certificate keeps the last good pair.
+	_, otherKey := ca.issue(t, &x509.Certificate{})
+	writeFile(t, keyFile, otherKey)
+	if _, err := files.reload(); err == nil {
+		t.Fatal("want an error for a mismatched key")
+	}
+	if cn := served(); cn != "second" {
+		t.Fatalf("serving %q after a failed reload", cn)
+	}
+}
+
+func TestTLS_ClientCertificates(t *testing.T) {
+	ca, other := newTestCA(t, "producers CA"), newTestCA(t, "other CA")
+	dir := t.TempDir()
+	certFile, keyFile := ca.serverFiles(t, dir, "gauditor")
+	caFile := filepath.Join(dir, "clients.pem")
+	writeFile(t, caFile, ca.pem)
+	keysPath := filepath.Join(dir, "keys.yaml")
+	writeFile(t, keysPath, []byte(`keys:
+  - id: billing
+    cert: spiffe://prod/billing
+    tenants: [acme]
+    scopes: [ingest]
+  - id: reports
+    cert: CN=reports
+    tenants: ["*"]
+    scopes: [query]
+  - id: ops
+    hash: `+hashAPIKey("ops-key")+`
+    tenants: ["*"]
+    scopes: [query]
+`))
+	keys, err := newAPIKeys(keysPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := newServer(g.NewRecorder(g.NewMemoryStorage()), withAuth(keys))
+
+	spiffe, _ := url.Parse("spiffe://prod/billing")
+	billing := ca.client(t, &x509.Certificate{Subject: pkix.Name{CommonName: "billing-7f9c"}, URIs: []*url.URL{spiffe}})
+	reports := ca.client(t, &x509.Certificate{Subject: pkix.Name{CommonName: "reports"}})
+	unknown := ca.client(t, &x509.Certificate{Subject: pkix.Name{CommonName: "intruder"}})
+	foreign := other.client(t, &x509.Certificate{Subject: pkix.Name{CommonName: "reports"}})
+
+	call := func(base string, client *http.Client, method, path, key, body string) (int, string) {
+		t.Helper()
+		req, _ := http.NewRequest(method, base+path, strings.NewReader(body))
+		if key != "" {
+			req.Header.Set("X-API-Key", key)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return 0, err.Error()
+		}
+		defer resp.Body.Close()
+		raw, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(raw)
+	}
+
+	t.Run("require", func(t *testing.T) {
+		files, err := newTLSFiles(certFile, keyFile, caFile, tls.RequireAndVerifyClientCert)
+		if err != nil {
+			t.Fatal(err)
+		}
+		base := serveTLS(t, files.serverConfig(), handler)
+		status, body := call(base, tlsClient(ca, billing), "POST", "/v1/events", "", `{"tenant":"beta","action":"charge"}`)
+		if status != http.StatusCreated || !strings.Contains(body, `"tenant":"acme"`) {
+			t.Fatalf("SPIFFE certificate: %d %s", status, body)
+		}
+		for _, tc := range []struct {
+			name   string
+			client *http.Client
+			method string
+			want   int
+		}{
+			{"URI cert lacks query", tlsClient(ca, billing), "GET", http.StatusForbidden},
+			{"CN cert", tlsClient(ca, reports), "GET", http.StatusOK},
+			{"CN cert lacks ingest", tlsClient(ca, reports), "POST", http.StatusForbidden},
+			{"unmapped cert", tlsClient(ca, unknown), "GET", http.StatusUnauthorized},
+			{"untrusted CA", tlsClient(ca, foreign), "GET", 0},
+			{"no cert", tlsClient(ca), "GET", 0},
+		} {
+			if status, body := call(base, tc.client, tc.method, "/v1/events", "", `{"action":"x"}`); status != tc.want {
+				t.Errorf("%s: want %d, got %d %s", tc.name, tc.want, status, body)
+			}
+		}
+	})
+
+	t.Run("optional", func(t *testing.T) {
+		files, err := newTLSFiles(certFile, keyFile, caFile, tls.VerifyClientCertIfGiven)
+		if err != nil {
+			t.Fatal(err)
+		}
+		base := serveTLS(t, files.serverConfig(), handler)
+		if status, body := call(base, tlsClient(ca), "GET", "/v1/events", "ops-key", ""); status != http.StatusOK {
+			t.Fatalf("API key without a certificate: %d %s", status, body)
+		}
+		if status, _ := call(base, tlsClient(ca), "GET", "/v1/events", "", ""); status != http.StatusUnauthorized {
+			t.Fatalf("no certificate and no key: want 401, got %d", status)
+		}
+		if status, body := call(base, tlsClient(ca, reports), "GET", "/v1/events", "", ""); status != http.StatusOK {
+			t.Fatalf("CN cert: %d %s", status, body)
+		}
+	})
+}
+
+func TestParseAPIKeys_CertEntries(t *testing.T) {
+	for name, file := range map[string]string{
+		"hash and cert": "keys: [{id: a, hash: " + hashAPIKey("k") + ", cert: CN=a, tenants: [a], scopes: [query]}]",
+		"same cert":     "keys: [{id: a, cert: CN=a, tenants: [a], scopes: [query]}, {id: b, cert: CN=a, tenants: [b], scopes: [query]}]",
+		"neither":       "keys: [{id: a, tenants: [a], scopes: [query]}]",
+	} {
+		if _, err := parseAPIKeys([]byte(file)); err == nil {
+			t.Errorf("%s: want an error", name)
+		}
+	}
+}
+
+func TestRealMain_TLS(t *testing.T) {
+	t.Setenv("GAUDITOR_STORAGE", "")
+	t.Setenv("GAUDITOR_CONFIG", "")
+	t.Setenv("GAUDITOR_NO_SERVE", "1")
+	ca := newTestCA(t, "test CA")
+	dir := t.TempDir()
+	certFile, keyFile := ca.serverFiles(t, dir, "gauditor")
+	caFile := filepath.Join(dir, "ca.pem")
+	writeFile(t, caFile, ca.pem)
+	keysPath := filepath.Join(dir, "keys.yaml")
+	keysFile(t, keysPath, map[string][2]string{"k": {"acme", "query"}})
+
+	if code, out := runMain(t, "-tls-cert", certFile, "-tls-key", keyFile, "-tls-client-ca", caFile, "-api-keys", keysPath); code != 0 {
+		t.Fatalf("want startup with mutual TLS, got %d: %s", code, out)
+	}
+	for _, args := range [][]string{
+		{"-tls-cert", certFile},
+		{"-tls-cert", certFile, "-tls-key", caFile},
+		{"-tls-cert", certFile, "-tls-key", keyFile, "-tls-client-ca", caFile},
+		{"-tls-cert", certFile, "-tls-key", keyFile, "-tls-client-ca", certFile + ".missing", "-api-keys", keysPath},
+		{"-tls-cert", certFile, "-tls-key", keyFile, "-tls-client-ca", caFile, "-tls-client-auth", "maybe", "-api-keys", keysPath},
+	} {
+		if code, out := runMain(t, args...); code != 1 || !strings.Contains(out, "tls") {
+			t.Errorf("%v: want exit 1, got %d: %s", args, code, out)
+		}
+	}
+}
+
+func TestRun_TLS(t *testing.T) {
+	ca := newTestCA(t, "test CA")
+	dir := t.TempDir()
+	certFile, keyFile := ca.serverFiles(t, dir, "gauditor")
+	files, err := newTLSFiles(certFile, keyFile, "", tls.NoClientCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GAUDITOR_TEST_EXIT", "1")
+	if err := run(":0", newServer(g.NewRecorder(g.NewMemoryStorage())), files.serverConfig(), time.Second); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,404 @@
+// Package fakes3 is an in-process stand-in for the subset of the S3 API used
+// by gauditor, for tests. It serves path-style requests over httptest and keeps
+// objects in memory.
+//
+// Buckets passed to EnableObjectLock accept Object Lock headers and legal-hold
+// updates and refuse to delete locked objects. Versions are not modeled.
+package fakes3
+
+import (
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// Object is a stored object.
+type Object struct {
+	Body         []byte
+	Header       http.Header // request headers of the PUT (x-amz-*, Content-Type, ...)
+	LastModified time.Time
+}
+
+// Server is a fake S3 endpoint.
+type Server struct {
+	srv *httptest.Server
+
+	mu      sync.Mutex
+	buckets map[string]map[string]*Object
+	locking map[string]bool
+	counts  map[string]int
+	denied  map[string]bool
+}
+
+// NewServer starts a fake S3 server. Buckets are created on first write.
+func NewServer() *Server {
+	s := &Server{
+		buckets: make(map[string]map[string]*Object),
+		locking: make(map[string]bool),
+		counts:  make(map[string]int),
+		denied:  make(map[string]bool),
+	}
+	s.srv = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+// URL is the endpoint to configure on the S3 client.
+func (s *Server) URL() string { return s.srv.URL }
+
+// Close shuts the server down.
+func (s *Server) Close() { s.srv.Close() }
+
+// Client returns an S3 client using path-style requests against the server.
+func (s *Server) Client() *s3.Client {
+	return s3.New(s3.Options{
+		Region:       "us-east-1",
+		BaseEndpoint: aws.String(s.srv.URL),
+		UsePathStyle: true,
+		Credentials:  credentials.NewStaticCredentialsProvider("test", "test", ""),
+	})
+}
+
+// Object returns a stored object.
+func (s *Server) Object(bucket, key string) (*Object, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o, ok := s.buckets[bucket][key]
+	return o, ok
+}
+
+// Keys lists the keys under prefix in lexical order.
+func (s *Server) Keys(bucket, prefix string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var keys []string
+	for k := range s.buckets[bucket] {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// EnableObjectLock turns on Object Lock for a bucket, as if it had been
+// created with it.
+func (s *Server) EnableObjectLock(bucket string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.locking[bucket] = true
+}
+
+// Count reports how many requests of an operation ("PutObject", "GetObject",
+// "ListObjectsV2", "DeleteObject", "HeadObject", "HeadBucket",
+// "PutObjectLegalHold") were served.
+func (s *Server) Count(op string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.counts[op]
+}
+
+// ResetCounts clears the request counters.
+func (s *Server) ResetCounts() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.counts = make(map[string]int)
+}
+
+// Deny makes requests of an operation fail with 403 AccessDenied, which
+// clients do not retry, until called again with denied false.
+func (s *Server) Deny(op string, denied bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.denied[op] = denied
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	s.mu.Lock()
+	denied := s.denied[operation(r, key)]
+	s.mu.Unlock()
+	if denied {
+		writeError(w, http.StatusForbidden, "AccessDenied", "denied by test")
+		return
+	}
+	switch {
+	case r.Method == http.MethodPut && key != "" && r.URL.Query().Has("legal-hold"):
+		s.putLegalHold(w, r, bucket, key)
+	case r.Method == http.MethodPut && key != "":
+		s.put(w, r, bucket, key)
+	case r.Method == http.MethodGet && key == "" && r.URL.Query().Get("list-type") == "2":
+		s.list(w, r, bucket)
+	case r.Method == http.MethodGet && key != "":
+		s.get(w, bucket, key, true)
+	case r.Method == http.MethodHead && key != "":
+		s.get(w, bucket, key, false)
+	case r.Method == http.MethodHead:
+		s.count("HeadBucket") // buckets exist implicitly
+	case r.Method == http.MethodDelete && key != "":
+		s.delete(w, r, bucket, key)
+	default:
+		writeError(w, http.StatusNotImplemented, "NotImplemented", r.Method+" "+r.URL.String())
+	}
+}
+
+func operation(r *http.Request, key string) string {
+	switch {
+	case r.Method == http.MethodPut && r.URL.Query().Has("legal-hold"):
+		return "PutObjectLegalHold"
+	case r.Method == http.MethodPut:
+		return "PutObject"
+	case r.Method == http.MethodGet && key == "":
+		return "ListObjectsV2"
+	case r.Method == http.MethodGet:
+		return "GetObject"
+	case r.Method == http.MethodHead && key == "":
+		return "HeadBucket"
+	case r.Method == http.MethodHead:
+		return "HeadObject"
+	case r.Method == http.MethodDelete:
+		return "DeleteObject"
+	}
+	return r.Method
+}
+
+func (s *Server) count(op string) {
+	s.mu.Lock()
+	s.counts[op]++
+	s.mu.Unlock()
+}
+
+func (s *Server) put(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	s.count("PutObject")
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "IncompleteBody", err.Error())
+		return
+	}
+	if r.Header.Get("X-Amz-Object-Lock-Mode") != "" || r.Header.Get("X-Amz-Object-Lock-Legal-Hold") != "" {
+		if code, msg := s.checkLockRequest(r, bucket); code != "" {
+			writeError(w, http.StatusBadRequest, code, msg)
+			return
+		}
+	}
+	s.mu.Lock()
+	if s.buckets[bucket] == nil {
+		s.buckets[bucket] = make(map[string]*Object)
+	}
+	s.buckets[bucket][key] = &Object{Body: body, Header: r.Header.Clone(), LastModified: time.Now().UTC()}
+	s.mu.Unlock()
+	w.Header().Set("ETag", `"`+strconv.Itoa(len(body))+`"`)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) get(w http.ResponseWriter, bucket, key string, withBody bool) {
+	if withBody {
+		s.count("GetObject")
+	} else {
+		s.count("HeadObject")
+	}
+	o, ok := s.Object(bucket, key)
+	if !ok {
+		writeError(w, http.StatusNotFound, "NoSuchKey", key)
+		return
+	}
+	if ct := o.Header.Get("Content-Type"); ct != "" {
+		w.Header().Set("Content-Type", ct)
+	}
+	for k, v := range o.Header {
+		if lk := strings.ToLower(k); strings.HasPrefix(lk, "x-amz-meta-") || strings.HasPrefix(lk, "x-amz-object-lock-") {
+			w.Header()[k] = v
+		}
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(o.Body)))
+	w.Header().Set("Last-Modified", o.LastModified.Format(http.TimeFormat))
+	w.WriteHeader(http.StatusOK)
+	if withBody {
+		_, _ = w.Write(o.Body)
+	}
+}
+
+// checkLockRequest validates Object Lock headers on a PUT the way S3 does:
+// the bucket must have Object Lock enabled, mode and retain-until date go
+// together, and the body must carry an integrity checksum.
+func (s *Server) checkLockRequest(r *http.Request, bucket string) (code, msg string) {
+	s.mu.Lock()
+	enabled := s.locking[bucket]
+	s.mu.Unlock()
+	if !enabled {
+		return "InvalidRequest", "Bucket is missing Object Lock Configuration"
+	}
+	mode, until := r.Header.Get("X-Amz-Object-Lock-Mode"), r.Header.Get("X-Amz-Object-Lock-Retain-Until-Date")
+	if (mode == "") != (until == "") {
+		return "InvalidRequest", "x-amz-object-lock-mode and x-amz-object-lock-retain-until-date must both be supplied"
+	}
+	if mode != "" && mode != "GOVERNANCE" && mode != "COMPLIANCE" {
+		return "InvalidArgument", "Unknown wormMode directive"
+	}
+	if until != "" {
+		t, err := time.Parse(time.RFC3339, until)
+		if err != nil || !t.After(time.Now()) {
+			return "InvalidArgument", "The retain until date must be in the future"
+		}
+	}
+	if r.Header.Get("Content-Md5") == "" && !hasChecksum(r.Header) {
+		return "InvalidRequest", "Content-MD5 or x-amz-checksum header is required for Put Object requests with Object Lock parameters"
+	}
+	return "", ""
+}
+
+func hasChecksum(h http.Header) bool {
+	for k := range h {
+		if lk := strings.ToLower(k); strings.HasPrefix(lk, "x-amz-checksum-") || lk == "x-amz-sdk-checksum-algorithm" {
+			return true
+		}
+	}
+	return false
+}
+
+type legalHold struct {
+	XMLName xml.Name `xml:"LegalHold"`
+	Status  string   `xml:"Status"`
+}
+
+func (s *Server) putLegalHold(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	s.count("PutObjectLegalHold")
+	var lh legalHold
+	if err := xml.NewDecoder(r.Body).Decode(&lh); err != nil || (lh.Status != "ON" && lh.Status != "OFF") {
+		writeError(w, http.StatusBadRequest, "MalformedXML", "invalid LegalHold")
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.locking[bucket] {
+		writeError(w, http.StatusBadRequest, "InvalidRequest", "Bucket is missing Object Lock Configuration")
+		return
+	}
+	o, ok := s.buckets[bucket][key]
+	if !ok {
+		writeError(w, http.StatusNotFound, "NoSuchKey", key)
+		return
+	}
+	// Replace rather than mutate: readers hold *Object without the lock.
+	cp := *o
+	cp.Header = o.Header.Clone()
+	cp.Header.Set("X-Amz-Object-Lock-Legal-Hold", lh.Status)
+	s.buckets[bucket][key] = &cp
+	w.WriteHeader(http.StatusOK)
+}
+
+// delete removes an object unless a legal hold or an unexpired retention
+// protects it; governance retention yields to x-amz-bypass-governance-retention.
+func (s *Server) delete(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	s.count("DeleteObject")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if o, ok := s.buckets[bucket][key]; ok {
+		until, _ := time.Parse(time.RFC3339, o.Header.Get("X-Amz-Object-Lock-Retain-Until-Date"))
+		mode := o.Header.Get("X-Amz-Object-Lock-Mode")
+		bypass := mode == "GOVERNANCE" && r.Header.Get("X-Amz-Bypass-Governance-Retention") == "true"
+		switch {
+		case o.Header.Get("X-Amz-Object-Lock-Legal-Hold") == "ON":
+			writeError(w, http.StatusForbidden, "AccessDenied", "object is under legal hold")
+			return
+		case mode != "" && until.After(time.Now()) && !bypass:
+			writeError(w, http.StatusForbidden, "AccessDenied", "object is WORM protected")
+			return
+		}
+	}
+	delete(s.buckets[bucket], key)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type listResult struct {
+	XMLName               xml.Name       `xml:"ListBucketResult"`
+	Name                  string         `xml:"Name"`
+	Prefix                string         `xml:"Prefix"`
+	KeyCount              int            `xml:"KeyCount"`
+	MaxKeys               int            `xml:"MaxKeys"`
+	IsTruncated           bool           `xml:"IsTruncated"`
+	NextContinuationToken string         `xml:"NextContinuationToken,omitempty"`
+	Contents              []listObject   `xml:"Contents"`
+	CommonPrefixes        []commonPrefix `xml:"CommonPrefixes"`
+}
+
+type listObject struct {
+	Key          string `xml:"Key"`
+	LastModified string `xml:"LastModified"`
+	Size         int    `xml:"Size"`
+}
+
+type commonPrefix struct {
+	Prefix string `xml:"Prefix"`
+}
+
+func (s *Server) list(w http.ResponseWriter, r *http.Request, bucket string) {
+	s.count("ListObjectsV2")
+	q := r.URL.Query()
+	prefix, delim := q.Get("prefix"), q.Get("delimiter")
+	after := q.Get("start-after")
+	if tok := q.Get("continuation-token"); tok != "" {
+		after = tok
+	}
+	maxKeys := 1000
+	if mk, err := strconv.Atoi(q.Get("max-keys")); err == nil && mk > 0 {
+		maxKeys = mk
+	}
+	res := listResult{Name: bucket, Prefix: prefix, MaxKeys: maxKeys}
+	seen := make(map[string]bool)
+	last := ""
+	for _, k := range s.Keys(bucket, prefix) {
+		if k <= after {
+			continue
+		}
+		cp := ""
+		if delim != "" {
+			if i := strings.Index(k[len(prefix):], delim); i >= 0 {
+				cp = k[:len(prefix)+i+len(delim)]
+			}
+		}
+		if cp != "" && seen[cp] {
+			last = k
+			continue
+		}
+		if res.KeyCount == maxKeys {
+			res.IsTruncated = true
+			res.NextContinuationToken = last
+			break
+		}
+		if cp != "" {
+			seen[cp] = true
+			res.CommonPrefixes = append(res.CommonPrefixes, commonPrefix{Prefix: cp})
+			res.KeyCount++
+			last = k
+			continue
+		}
+		o, _ := s.Object(bucket, k)
+		res.Contents = append(res.Contents, listObject{Key: k, LastModified: o.LastModified.Format(time.RFC3339Nano), Size: len(o.Body)})
+		res.KeyCount++
+		last = k
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	_ = xml.NewEncoder(w).Encode(res)
+}
+
+type errorBody struct {
+	XMLName xml.Name `xml:"Error"`
+	Code    string   `xml:"Code"`
+	Message string   `xml:"Message"`
+}
+
+func writeError(w http.ResponseWriter, status int, code, msg string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	_ = xml.NewEncoder(w).Encode(errorBody{Code: code, Message: msg})
+}
@@ -0,0 +1,80 @@
+// Package blob is the small object-storage abstraction the s3store archive
+// format is written on: put, get and ordered listing by prefix over
+// slash-separated keys. S3 adapts an S3 (or S3-compatible) bucket; Dir stores
+// objects as files below a local directory, so the same layout works on disk,
+// in tests and in the cloud.
+package blob
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var (
+	// ErrNotExist is returned (wrapped) by Get for a missing key.
+	ErrNotExist = errors.New("blob: object does not exist")
+	// ErrNotSupported is returned when a driver lacks a requested feature,
+	// such as Object Lock on a local directory.
+	ErrNotSupported = errors.New("blob: not supported")
+)
+
+// Bucket stores objects under slash-separated keys.
+type Bucket interface {
+	// Put writes body under key, replacing any object there.
+	Put(ctx context.Context, key string, body []byte, opts PutOptions) error
+	// Get returns the contents and user metadata of key, or an error wrapping
+	// ErrNotExist.
+	Get(ctx context.Context, key string) ([]byte, map[string]string, error)
+	// List calls fn for the objects under prefix in lexical key order until fn
+	// returns false. See ListOptions for StartAfter and Delimiter.
+	List(ctx context.Context, prefix string, opts ListOptions, fn func(Object) bool) error
+}
+
+// Locker is implemented by buckets with S3 Object Lock semantics.
+type Locker interface {
+	// ObjectLock returns the lock settings of key.
+	ObjectLock(ctx context.Context, key string) (Lock, error)
+	// SetLegalHold places (on) or removes (off) a legal hold on key.
+	SetLegalHold(ctx context.Context, key string, on bool) error
+}
+
+// PutOptions are per-object write settings.
+type PutOptions struct {
+	ContentType string
+	// Metadata is user metadata returned by Get. Keys should be lower case
+	// (S3 lower-cases them).
+	Metadata map[string]string
+	// Lock requests Object Lock retention; buckets that are not a Locker fail
+	// with ErrNotSupported.
+	Lock *Lock
+	// ServerSideEncryption ("aws:kms", "AES256") and KMSKeyID request
+	// encryption at rest by the service; drivers without it fail with
+	// ErrNotSupported.
+	ServerSideEncryption string
+	KMSKeyID             string
+}
+
+// Lock is the Object Lock state of an object.
+type Lock struct {
+	Mode        string // "GOVERNANCE", "COMPLIANCE" or "" for none
+	RetainUntil time.Time
+	LegalHold   bool
+}
+
+// ListOptions narrow a listing.
+type ListOptions struct {
+	// StartAfter skips keys up to and including this key.
+	StartAfter string
+	// Delimiter, when set, rolls keys up to the first delimiter after the
+	// prefix into a single Object with IsPrefix set, as S3 common prefixes.
+	Delimiter string
+}
+
+// Object is a listing entry.
+type Object struct {
+	Key      string
+	Size     int64
+	ModTime  time.Time
+	IsPrefix bool // Key is a common prefix ending in the delimiter
+}
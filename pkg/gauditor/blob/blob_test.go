@@ -0,0 +1,130 @@
+package blob
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/antoniomarcosferreira/gauditor/internal/fakes3"
+)
+
+// drivers returns a fresh, empty bucket of every driver.
+func drivers(t *testing.T) map[string]Bucket {
+	t.Helper()
+	dir, err := NewDir(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := fakes3.NewServer()
+	t.Cleanup(srv.Close)
+	return map[string]Bucket{"dir": dir, "s3": NewS3(srv.Client(), "test")}
+}
+
+func list(t *testing.T, b Bucket, prefix string, opts ListOptions, max int) string {
+	t.Helper()
+	var keys []string
+	err := b.List(context.Background(), prefix, opts, func(o Object) bool {
+		k := o.Key
+		if o.IsPrefix {
+			k += "*"
+		}
+		keys = append(keys, k)
+		return max == 0 || len(keys) < max
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Join(keys, " ")
+}
+
+func TestBucket_Conformance(t *testing.T) {
+	for name, b := range drivers(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			for _, k := range []string{"p/b/2", "p/a.txt", "p/a/1", "p/c", "q/x", "p/b/1"} {
+				if err := b.Put(ctx, k, []byte("v:"+k), PutOptions{ContentType: "text/plain"}); err != nil {
+					t.Fatalf("put %s: %v", k, err)
+				}
+			}
+			if _, meta, err := b.Get(ctx, "p/c"); err != nil || len(meta) != 0 {
+				t.Fatalf("want no metadata, got %v %v", meta, err)
+			}
+			if err := b.Put(ctx, "p/c", []byte("v2"), PutOptions{Metadata: map[string]string{"k": "v"}}); err != nil {
+				t.Fatal(err)
+			}
+			if body, meta, err := b.Get(ctx, "p/c"); err != nil || string(body) != "v2" || meta["k"] != "v" {
+				t.Fatalf("get: %q %v %v", body, meta, err)
+			}
+			if _, _, err := b.Get(ctx, "p/missing"); !errors.Is(err, ErrNotExist) {
+				t.Fatalf("want ErrNotExist, got %v", err)
+			}
+
+			// Lexical key order: "p/a.txt" < "p/a/1" since '.' < '/'.
+			if got := list(t, b, "p/", ListOptions{}, 0); got != "p/a.txt p/a/1 p/b/1 p/b/2 p/c" {
+				t.Fatalf("list: %q", got)
+			}
+			if got := list(t, b, "p/b", ListOptions{}, 0); got != "p/b/1 p/b/2" {
+				t.Fatalf("partial prefix: %q", got)
+			}
+			if got := list(t, b, "p/", ListOptions{StartAfter: "p/a/1"}, 0); got != "p/b/1 p/b/2 p/c" {
+				t.Fatalf("start after: %q", got)
+			}
+			if got := list(t, b, "p/", ListOptions{Delimiter: "/"}, 0); got != "p/a.txt p/a/* p/b/* p/c" {
+				t.Fatalf("delimiter: %q", got)
+			}
+			if got := list(t, b, "p/", ListOptions{}, 2); got != "p/a.txt p/a/1" {
+				t.Fatalf("early stop: %q", got)
+			}
+			if got := list(t, b, "", ListOptions{StartAfter: "p/c"}, 0); got != "q/x" {
+				t.Fatalf("whole bucket: %q", got)
+			}
+			if got := list(t, b, "none/", ListOptions{}, 0); got != "" {
+				t.Fatalf("empty prefix: %q", got)
+			}
+		})
+	}
+}
+
+func TestDir_RejectsLockAndEscapes(t *testing.T) {
+	d, err := NewDir(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	lock := &Lock{Mode: "COMPLIANCE", RetainUntil: time.Now().Add(time.Hour)}
+	if err := d.Put(ctx, "a", nil, PutOptions{Lock: lock}); !errors.Is(err, ErrNotSupported) {
+		t.Fatalf("want ErrNotSupported, got %v", err)
+	}
+	if err := d.Put(ctx, "a", nil, PutOptions{ServerSideEncryption: "aws:kms"}); !errors.Is(err, ErrNotSupported) {
+		t.Fatalf("want ErrNotSupported for SSE, got %v", err)
+	}
+	for _, key := range []string{"../x", "/abs", "a/../../x", "dir/", "a/.blob-meta-b"} {
+		if err := d.Put(ctx, key, nil, PutOptions{}); err == nil {
+			t.Fatalf("key %q must be rejected", key)
+		}
+	}
+}
+
+func TestS3_ObjectLock(t *testing.T) {
+	srv := fakes3.NewServer()
+	defer srv.Close()
+	srv.EnableObjectLock("worm")
+	b := NewS3(srv.Client(), "worm")
+	ctx := context.Background()
+	until := time.Now().Add(time.Hour).Truncate(time.Second)
+	if err := b.Put(ctx, "k", []byte("x"), PutOptions{Lock: &Lock{Mode: "GOVERNANCE", RetainUntil: until}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.SetLegalHold(ctx, "k", true); err != nil {
+		t.Fatal(err)
+	}
+	l, err := b.ObjectLock(ctx, "k")
+	if err != nil || l.Mode != "GOVERNANCE" || !l.RetainUntil.Equal(until) || !l.LegalHold {
+		t.Fatalf("lock: %+v %v", l, err)
+	}
+	if _, err := b.ObjectLock(ctx, "missing"); !errors.Is(err, ErrNotExist) {
+		t.Fatalf("want ErrNotExist, got %v", err)
+	}
+}
@@ -0,0 +1,209 @@
+package blob
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// internalPrefix marks Dir's own files: writes in progress and metadata
+// sidecars. List ignores them and keys may not use it.
+const internalPrefix = ".blob-"
+
+// Dir is a Bucket storing each object as a file below a local directory,
+// with the key as its slash-separated relative path and user metadata in a
+// hidden JSON sidecar. Writes are atomic (temporary file plus rename). Object
+// Lock and server-side encryption are not supported.
+type Dir struct {
+	root string
+}
+
+// NewDir returns a Bucket rooted at dir, which is created if needed.
+func NewDir(dir string) (*Dir, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &Dir{root: dir}, nil
+}
+
+// Ping checks that the root directory still exists.
+func (d *Dir) Ping(context.Context) error {
+	info, err := os.Stat(d.root)
+	if err == nil && !info.IsDir() {
+		err = fmt.Errorf("blob: %s is not a directory", d.root)
+	}
+	return err
+}
+
+// path maps key to a file path, rejecting keys that would escape the root.
+func (d *Dir) path(key string) (string, error) {
+	if !fs.ValidPath(key) || key == "." || strings.HasSuffix(key, "/") || strings.HasPrefix(path.Base(key), internalPrefix) {
+		return "", fmt.Errorf("blob: invalid key %q", key)
+	}
+	return filepath.Join(d.root, filepath.FromSlash(key)), nil
+}
+
+// metaPath is the sidecar holding the metadata of the object at name.
+func metaPath(name string) string {
+	return filepath.Join(filepath.Dir(name), internalPrefix+"meta-"+filepath.Base(name))
+}
+
+// Put writes the metadata sidecar, or removes a stale one, then the object.
+func (d *Dir) Put(ctx context.Context, key string, body []byte, opts PutOptions) error {
+	if opts.Lock != nil {
+		return fmt.Errorf("%w: object lock on a local directory", ErrNotSupported)
+	}
+	if opts.ServerSideEncryption != "" || opts.KMSKeyID != "" {
+		return fmt.Errorf("%w: server-side encryption on a local directory", ErrNotSupported)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	name, err := d.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		return err
+	}
+	if len(opts.Metadata) > 0 {
+		meta, err := json.Marshal(opts.Metadata)
+		if err != nil {
+			return err
+		}
+		if err := writeFile(metaPath(name), meta); err != nil {
+			return err
+		}
+	} else if err := os.Remove(metaPath(name)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return writeFile(name, body)
+}
+
+// writeFile writes data to a temporary file next to name and renames it into
+// place.
+func writeFile(name string, data []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(name), internalPrefix+"*")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		_ = os.Remove(f.Name())
+		return err
+	}
+	if err := os.Rename(f.Name(), name); err != nil {
+		_ = os.Remove(f.Name())
+		return err
+	}
+	return nil
+}
+
+// Get reads the file of key and its metadata sidecar, if any.
+func (d *Dir) Get(ctx context.Context, key string) ([]byte, map[string]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	name, err := d.path(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	body, err := os.ReadFile(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil, fmt.Errorf("%w: %s", ErrNotExist, key)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	var meta map[string]string
+	raw, err := os.ReadFile(metaPath(name))
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(raw, &meta); err != nil {
+			return nil, nil, fmt.Errorf("blob: metadata of %s: %w", key, err)
+		}
+	case !errors.Is(err, fs.ErrNotExist):
+		return nil, nil, err
+	}
+	return body, meta, nil
+}
+
+// List walks the deepest directory containing prefix. Subtrees that sort
+// entirely before StartAfter are skipped; the remaining keys are sorted as
+// S3 sorts them, which differs from directory order when names contain
+// characters below '/'.
+func (d *Dir) List(ctx context.Context, prefix string, opts ListOptions, fn func(Object) bool) error {
+	dir := "."
+	if i := strings.LastIndex(prefix, "/"); i > 0 {
+		dir = prefix[:i]
+	}
+	if !fs.ValidPath(dir) {
+		return fmt.Errorf("blob: invalid prefix %q", prefix)
+	}
+	var objs []Object
+	err := fs.WalkDir(os.DirFS(d.root), dir, func(key string, e fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if e.IsDir() {
+			if key == dir {
+				return nil
+			}
+			sub := key + "/"
+			if !strings.HasPrefix(sub, prefix) && !strings.HasPrefix(prefix, sub) {
+				return fs.SkipDir
+			}
+			if sub < opts.StartAfter && !strings.HasPrefix(opts.StartAfter, sub) {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasPrefix(key, prefix) || key <= opts.StartAfter || strings.HasPrefix(e.Name(), internalPrefix) {
+			return nil
+		}
+		info, err := e.Info()
+		if err != nil {
+			return err
+		}
+		objs = append(objs, Object{Key: key, Size: info.Size(), ModTime: info.ModTime().UTC()})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	sort.Slice(objs, func(i, j int) bool { return objs[i].Key < objs[j].Key })
+	last := ""
+	for _, o := range objs {
+		if opts.Delimiter != "" {
+			if i := strings.Index(o.Key[len(prefix):], opts.Delimiter); i >= 0 {
+				cp := o.Key[:len(prefix)+i+len(opts.Delimiter)]
+				if cp == last {
+					continue
+				}
+				last = cp
+				o = Object{Key: cp, IsPrefix: true}
+			}
+		}
+		if !fn(o) {
+			return nil
+		}
+	}
+	return nil
+}
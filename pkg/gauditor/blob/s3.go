@@ -0,0 +1,147 @@
+package blob
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+// S3 is a Bucket backed by an S3 bucket. It also implements Locker.
+type S3 struct {
+	client *s3.Client
+	bucket string
+}
+
+// NewS3 returns a Bucket for bucket, accessed through client.
+func NewS3(client *s3.Client, bucket string) *S3 {
+	return &S3{client: client, bucket: bucket}
+}
+
+// Put uploads body, switching to multipart uploads for large bodies. Object
+// Lock uploads carry a SHA-256 checksum, which S3 requires.
+func (b *S3) Put(ctx context.Context, key string, body []byte, opts PutOptions) error {
+	in := &s3.PutObjectInput{
+		Bucket: aws.String(b.bucket),
+		Key:    aws.String(key),
+		Body:   bytes.NewReader(body),
+	}
+	if opts.ContentType != "" {
+		in.ContentType = aws.String(opts.ContentType)
+	}
+	if len(opts.Metadata) > 0 {
+		in.Metadata = opts.Metadata
+	}
+	if opts.ServerSideEncryption != "" {
+		in.ServerSideEncryption = types.ServerSideEncryption(opts.ServerSideEncryption)
+	}
+	if opts.KMSKeyID != "" {
+		in.SSEKMSKeyId = aws.String(opts.KMSKeyID)
+	}
+	if l := opts.Lock; l != nil {
+		if l.Mode != "" {
+			in.ObjectLockMode = types.ObjectLockMode(l.Mode)
+			in.ObjectLockRetainUntilDate = aws.Time(l.RetainUntil.UTC())
+		}
+		if l.LegalHold {
+			in.ObjectLockLegalHoldStatus = types.ObjectLockLegalHoldStatusOn
+		}
+		in.ChecksumAlgorithm = types.ChecksumAlgorithmSha256
+	}
+	_, err := manager.NewUploader(b.client).Upload(ctx, in)
+	return err
+}
+
+// Ping checks that the bucket exists and is accessible with HeadBucket.
+func (b *S3) Ping(ctx context.Context) error {
+	_, err := b.client.HeadBucket(ctx, &s3.HeadBucketInput{Bucket: aws.String(b.bucket)})
+	return err
+}
+
+// Get downloads key.
+func (b *S3) Get(ctx context.Context, key string) ([]byte, map[string]string, error) {
+	out, err := b.client.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String(b.bucket), Key: aws.String(key)})
+	if err != nil {
+		return nil, nil, notExist(err, key)
+	}
+	defer out.Body.Close()
+	body, err := io.ReadAll(out.Body)
+	return body, out.Metadata, err
+}
+
+// List pages through ListObjectsV2, merging objects and common prefixes into
+// one ordered stream.
+func (b *S3) List(ctx context.Context, prefix string, opts ListOptions, fn func(Object) bool) error {
+	in := &s3.ListObjectsV2Input{Bucket: aws.String(b.bucket), Prefix: aws.String(prefix)}
+	if opts.StartAfter != "" {
+		in.StartAfter = aws.String(opts.StartAfter)
+	}
+	if opts.Delimiter != "" {
+		in.Delimiter = aws.String(opts.Delimiter)
+	}
+	pager := s3.NewListObjectsV2Paginator(b.client, in)
+	for pager.HasMorePages() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return err
+		}
+		objs := make([]Object, 0, len(page.Contents)+len(page.CommonPrefixes))
+		for _, o := range page.Contents {
+			objs = append(objs, Object{Key: aws.ToString(o.Key), Size: aws.ToInt64(o.Size), ModTime: aws.ToTime(o.LastModified)})
+		}
+		for _, cp := range page.CommonPrefixes {
+			objs = append(objs, Object{Key: aws.ToString(cp.Prefix), IsPrefix: true})
+		}
+		sort.Slice(objs, func(i, j int) bool { return objs[i].Key < objs[j].Key })
+		for _, o := range objs {
+			if !fn(o) {
+				return nil
+			}
+		}
+	}
+	return nil
+}
+
+// ObjectLock reads the lock settings of key with HeadObject.
+func (b *S3) ObjectLock(ctx context.Context, key string) (Lock, error) {
+	head, err := b.client.HeadObject(ctx, &s3.HeadObjectInput{Bucket: aws.String(b.bucket), Key: aws.String(key)})
+	if err != nil {
+		return Lock{}, notExist(err, key)
+	}
+	return Lock{
+		Mode:        string(head.ObjectLockMode),
+		RetainUntil: aws.ToTime(head.ObjectLockRetainUntilDate),
+		LegalHold:   head.ObjectLockLegalHoldStatus == types.ObjectLockLegalHoldStatusOn,
+	}, nil
+}
+
+// SetLegalHold toggles the legal hold of key.
+func (b *S3) SetLegalHold(ctx context.Context, key string, on bool) error {
+	status := types.ObjectLockLegalHoldStatusOff
+	if on {
+		status = types.ObjectLockLegalHoldStatusOn
+	}
+	_, err := b.client.PutObjectLegalHold(ctx, &s3.PutObjectLegalHoldInput{
+		Bucket:    aws.String(b.bucket),
+		Key:       aws.String(key),
+		LegalHold: &types.ObjectLockLegalHold{Status: status},
+	})
+	return err
+}
+
+// notExist wraps ErrNotExist around S3 "no such key" errors.
+func notExist(err error, key string) error {
+	var api smithy.APIError
+	if errors.As(err, &api) && (api.ErrorCode() == "NoSuchKey" || api.ErrorCode() == "NotFound") {
+		return fmt.Errorf("%w: %s: %v", ErrNotExist, key, err)
+	}
+	return err
+}
@@ -4,3 +4,10 @@ import "errors"
 
 // ErrInvalidEvent is returned when required fields are missing.
 var ErrInvalidEvent = errors.New("invalid event: missing required fields")
+
+// ErrDuplicateEvent is returned by storages that detect an event ID already
+// stored for the tenant. Redis and S3 backends overwrite it instead.
+var ErrDuplicateEvent = errors.New("duplicate event: id already stored")
+
+// ErrNotFound is returned by Get when no event has the ID in the tenant.
+var ErrNotFound = errors.New("event not found")
@@ -0,0 +1,316 @@
+package gauditor
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// MemoryIndex selects an optional secondary index of MemoryStorage. Events
+// are always partitioned by tenant.
+type MemoryIndex int
+
+// Secondary indexes for WithMemoryIndexes.
+const (
+	IndexActor MemoryIndex = iota + 1
+	IndexAction
+	IndexTarget
+)
+
+// MemoryOption configures a MemoryStorage.
+type MemoryOption func(*MemoryStorage)
+
+// WithMemoryIndexes maintains per-tenant indexes on the given fields, so
+// queries filtering on them only visit matching events.
+func WithMemoryIndexes(indexes ...MemoryIndex) MemoryOption {
+	return func(m *MemoryStorage) {
+		for _, idx := range indexes {
+			if idx >= IndexActor && idx <= IndexTarget {
+				m.indexed[idx] = true
+			}
+		}
+	}
+}
+
+// WithMemoryCapacity bounds each tenant to its perTenant most recent events;
+// older ones are evicted as new ones arrive, like a ring buffer. Zero means
+// unbounded.
+func WithMemoryCapacity(perTenant int) MemoryOption {
+	return func(m *MemoryStorage) { m.capacity = perTenant }
+}
+
+// MemoryStorage is an in-memory implementation of Storage for development/testing.
+// Events are kept per tenant in timestamp order, so queries need no sorting
+// and stop early at Until or Limit.
+type MemoryStorage struct {
+	mu       sync.RWMutex
+	tenants  map[string]*memTenant
+	seq      uint64
+	indexed  [IndexTarget + 1]bool
+	capacity int
+}
+
+// NewMemoryStorage constructs a new MemoryStorage.
+func NewMemoryStorage(opts ...MemoryOption) *MemoryStorage {
+	m := &MemoryStorage{tenants: make(map[string]*memTenant)}
+	for _, o := range opts {
+		o(m)
+	}
+	return m
+}
+
+// memEntry is a stored event; seq breaks timestamp ties in insertion order.
+type memEntry struct {
+	seq   uint64
+	event Event
+}
+
+func (a *memEntry) before(b *memEntry) bool {
+	if a.event.Timestamp.Equal(b.event.Timestamp) {
+		return a.seq < b.seq
+	}
+	return a.event.Timestamp.Before(b.event.Timestamp)
+}
+
+// memList holds entries in (timestamp, seq) order. Dropping the first entry
+// is O(1); the backing array is compacted once half of it is dead.
+type memList struct {
+	entries []*memEntry
+	head    int
+}
+
+func (l *memList) items() []*memEntry { return l.entries[l.head:] }
+
+func (l *memList) len() int { return len(l.entries) - l.head }
+
+// insert places e in order; appending in timestamp order is O(1).
+func (l *memList) insert(e *memEntry) {
+	items := l.items()
+	i := l.head + sort.Search(len(items), func(i int) bool { return e.before(items[i]) })
+	l.entries = append(l.entries, nil)
+	copy(l.entries[i+1:], l.entries[i:])
+	l.entries[i] = e
+}
+
+// remove deletes e, which is usually the first entry.
+func (l *memList) remove(e *memEntry) {
+	items := l.items()
+	i := sort.Search(len(items), func(i int) bool { return !items[i].before(e) })
+	if i == len(items) || items[i] != e {
+		return
+	}
+	if i > 0 {
+		copy(items[1:i+1], items[:i])
+	}
+	l.entries[l.head] = nil
+	l.head++
+	if l.head >= 32 && l.head*2 >= len(l.entries) {
+		l.entries = append([]*memEntry(nil), l.entries[l.head:]...)
+		l.head = 0
+	}
+}
+
+// memTenant is one tenant's events, by ID and in secondary indexes.
+type memTenant struct {
+	all     memList
+	ids     map[string]*memEntry
+	indexes [IndexTarget + 1]map[string]*memList
+}
+
+func indexKey(idx MemoryIndex, e Event) string {
+	switch idx {
+	case IndexActor:
+		return e.Actor.ID
+	case IndexAction:
+		return e.Action
+	case IndexTarget:
+		return e.Target.ID
+	}
+	return ""
+}
+
+func queryKey(idx MemoryIndex, q Query) string {
+	switch idx {
+	case IndexActor:
+		return q.ActorID
+	case IndexAction:
+		return q.Action
+	case IndexTarget:
+		return q.TargetID
+	}
+	return ""
+}
+
+// Save inserts the event in timestamp order, evicting the tenant's oldest
+// event when it is over capacity. An ID already stored for the tenant fails
+// with ErrDuplicateEvent.
+func (m *MemoryStorage) Save(ctx context.Context, event Event) (Event, error) {
+	select {
+	case <-ctx.Done():
+		return event, ctx.Err()
+	default:
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return event, m.insertLocked(event)
+}
+
+func (m *MemoryStorage) insertLocked(event Event) error {
+	t := m.tenants[event.Tenant]
+	if t == nil {
+		t = &memTenant{ids: make(map[string]*memEntry)}
+		m.tenants[event.Tenant] = t
+	}
+	if _, dup := t.ids[event.ID]; dup && event.ID != "" {
+		return fmt.Errorf("%w: %s", ErrDuplicateEvent, event.ID)
+	}
+	m.seq++
+	e := &memEntry{seq: m.seq, event: event}
+	t.all.insert(e)
+	if event.ID != "" {
+		t.ids[event.ID] = e
+	}
+	for idx := IndexActor; idx <= IndexTarget; idx++ {
+		key := indexKey(idx, event)
+		if !m.indexed[idx] || key == "" {
+			continue
+		}
+		if t.indexes[idx] == nil {
+			t.indexes[idx] = make(map[string]*memList)
+		}
+		l := t.indexes[idx][key]
+		if l == nil {
+			l = &memList{}
+			t.indexes[idx][key] = l
+		}
+		l.insert(e)
+	}
+	if m.capacity > 0 && t.all.len() > m.capacity {
+		t.evict(t.all.items()[0])
+	}
+	return nil
+}
+
+func (t *memTenant) evict(e *memEntry) {
+	t.all.remove(e)
+	if t.ids[e.event.ID] == e {
+		delete(t.ids, e.event.ID)
+	}
+	for idx := IndexActor; idx <= IndexTarget; idx++ {
+		key := indexKey(idx, e.event)
+		l := t.indexes[idx][key]
+		if l == nil {
+			continue
+		}
+		l.remove(e)
+		if l.len() == 0 {
+			delete(t.indexes[idx], key)
+		}
+	}
+}
+
+// Get returns the tenant's event with the given ID from the ID map.
+func (m *MemoryStorage) Get(ctx context.Context, tenant, id string) (Event, error) {
+	if err := ctx.Err(); err != nil {
+		return Event{}, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if t := m.tenants[tenant]; t != nil {
+		if e := t.ids[id]; e != nil {
+			return e.event, nil
+		}
+	}
+	return Event{}, fmt.Errorf("%w: %s", ErrNotFound, id)
+}
+
+// candidates returns the shortest list that holds every event of t matching
+// q's indexed filters.
+func (m *MemoryStorage) candidates(t *memTenant, q Query) []*memEntry {
+	best := t.all.items()
+	for idx := IndexActor; idx <= IndexTarget; idx++ {
+		key := queryKey(idx, q)
+		if !m.indexed[idx] || key == "" {
+			continue
+		}
+		l := t.indexes[idx][key]
+		if l == nil {
+			return nil
+		}
+		if items := l.items(); len(items) < len(best) {
+			best = items
+		}
+	}
+	return best
+}
+
+// Query returns events matching the filter. Results are sorted by timestamp ascending.
+func (m *MemoryStorage) Query(ctx context.Context, q Query) ([]Event, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var tenants []*memTenant
+	if q.Tenant != "" {
+		if t := m.tenants[q.Tenant]; t != nil {
+			tenants = append(tenants, t)
+		}
+	} else {
+		for _, t := range m.tenants {
+			tenants = append(tenants, t)
+		}
+	}
+
+	var matched []*memEntry
+	for _, t := range tenants {
+		n := 0
+		items := m.candidates(t, q)
+		i := 0
+		if q.Since != nil {
+			i = sort.Search(len(items), func(i int) bool { return !items[i].event.Timestamp.Before(*q.Since) })
+		}
+		for ; i < len(items); i++ {
+			e := items[i]
+			if q.Until != nil && e.event.Timestamp.After(*q.Until) {
+				break
+			}
+			if i%1024 == 0 && ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			if !q.Matches(e.event) {
+				continue
+			}
+			matched = append(matched, e)
+			if n++; q.Limit > 0 && n == q.Limit {
+				break
+			}
+		}
+	}
+	if len(tenants) > 1 {
+		sort.Slice(matched, func(i, j int) bool { return matched[i].before(matched[j]) })
+	}
+	if q.Limit > 0 && len(matched) > q.Limit {
+		matched = matched[:q.Limit]
+	}
+	results := make([]Event, len(matched))
+	for i, e := range matched {
+		results[i] = e.event
+	}
+	return results, nil
+}
+
+// Len returns the number of stored events.
+func (m *MemoryStorage) Len() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	n := 0
+	for _, t := range m.tenants {
+		n += t.all.len()
+	}
+	return n
+}
@@ -0,0 +1,67 @@
+package gauditor
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+)
+
+// Matches reports whether e satisfies every filter in q except Limit.
+// Storage implementations that filter in application code should use it so
+// all backends agree on semantics.
+func (q Query) Matches(e Event) bool {
+	if q.Tenant != "" && e.Tenant != q.Tenant {
+		return false
+	}
+	if q.ActorID != "" && e.Actor.ID != q.ActorID {
+		return false
+	}
+	if q.Action != "" && e.Action != q.Action {
+		return false
+	}
+	if q.TargetID != "" && e.Target.ID != q.TargetID {
+		return false
+	}
+	if q.Since != nil && e.Timestamp.Before(*q.Since) {
+		return false
+	}
+	if q.Until != nil && e.Timestamp.After(*q.Until) {
+		return false
+	}
+	for key, want := range q.Data {
+		got, ok := DataPath(e.Data, key)
+		if !ok || !jsonEqual(got, want) {
+			return false
+		}
+	}
+	return true
+}
+
+// DataPath looks up a dot-separated path such as "order.id" in data.
+func DataPath(data map[string]any, path string) (any, bool) {
+	var cur any = data
+	for _, key := range strings.Split(path, ".") {
+		m, ok := cur.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		if cur, ok = m[key]; !ok {
+			return nil, false
+		}
+	}
+	return cur, true
+}
+
+// jsonEqual compares values by their JSON encoding, so numeric types and
+// decoded/undecoded maps compare as they would after a storage round trip.
+func jsonEqual(a, b any) bool {
+	ja, err := json.Marshal(a)
+	if err != nil {
+		return false
+	}
+	jb, err := json.Marshal(b)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(ja, jb)
+}
@@ -2,6 +2,7 @@ package gauditor
 
 import (
 	"context"
+	"fmt"
 	"time"
 
 	"github.com/google/uuid"
@@ -55,6 +56,21 @@ func (r *Recorder) Record(ctx context.Context, e Event) (Event, error) {
 	return r.store.Save(ctx, e)
 }
 
+// Get returns the event of tenant with the given ID, or ErrNotFound. Storages
+// implementing Getter look it up directly; others are scanned with Query.
+func (r *Recorder) Get(ctx context.Context, tenant, id string) (Event, error) {
+	if tenant == "" || id == "" {
+		return Event{}, fmt.Errorf("%w: tenant and id are required", ErrNotFound)
+	}
+	return getEvent(ctx, r.store, tenant, id)
+}
+
+// Ping reports whether the storage is reachable, for storages implementing
+// Pinger; it is meant for readiness checks.
+func (r *Recorder) Ping(ctx context.Context) error {
+	return pingStorage(ctx, r.store)
+}
+
 // Query retrieves events from the underlying Storage that match the provided filter.
 // The ordering and pagination are storage-defined; MemoryStorage returns ascending by timestamp.
 func (r *Recorder) Query(ctx context.Context, q Query) ([]Event, error) {
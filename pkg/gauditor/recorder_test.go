@@ -2,6 +2,7 @@ package gauditor
 
 import (
 	"context"
+	"errors"
 	"testing"
 	"time"
 )
@@ -39,6 +40,32 @@ func TestRecorder_RecordInvalid(t *testing.T) {
 	}
 }
 
+// scanOnlyStorage hides the Getter of the storage it wraps.
+type scanOnlyStorage struct{ Storage }
+
+func TestRecorder_Get(t *testing.T) {
+	ctx := context.Background()
+	mem := NewMemoryStorage()
+	stores := map[string]Storage{
+		"getter":  mem,
+		"scan":    scanOnlyStorage{mem},
+		"wrapped": NewRetryStorage(NewRedactingStorage(NewFanOutStorage(mem), "actor.ip"), 2, time.Millisecond),
+	}
+	_, _ = mem.Save(ctx, Event{ID: "a", Tenant: "t", Action: "x"})
+	_, _ = mem.Save(ctx, Event{ID: "b", Tenant: "u", Action: "y"})
+	for name, store := range stores {
+		rec := NewRecorder(store)
+		if e, err := rec.Get(ctx, "t", "a"); err != nil || e.Action != "x" {
+			t.Errorf("%s: got %+v, %v", name, e, err)
+		}
+		for _, k := range [][2]string{{"t", "b"}, {"t", "missing"}, {"", "a"}, {"t", ""}} {
+			if _, err := rec.Get(ctx, k[0], k[1]); !errors.Is(err, ErrNotFound) {
+				t.Errorf("%s: Get(%q, %q): want ErrNotFound, got %v", name, k[0], k[1], err)
+			}
+		}
+	}
+}
+
 func TestMemoryStorage_QueryFilters(t *testing.T) {
 	store := NewMemoryStorage()
 	rec := NewRecorder(store, WithClock(func() time.Time { return time.Unix(1000, 0).UTC() }))
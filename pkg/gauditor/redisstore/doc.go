@@ -1,6 +1,17 @@
-// Package redisstore provides a simple Redis-backed gauditor.Storage implementation.
+// Package redisstore provides a Redis-backed gauditor.Storage implementation.
 //
-// It stores events in a Redis list per tenant (newest-first) and filters
-// in application code on query. This is ideal for development and demos.
-// Production users may want to implement pagination and sharding.
+// Events are stored per tenant in a hash keyed by event ID, with sorted sets
+// scored by timestamp for the tenant and for each actor, action and target.
+// Query intersects the relevant sets and ranges them by score inside Redis
+// (ZINTERSTORE/ZRANGEBYSCORE in a Lua script), so only matching events are
+// transferred. Saves are atomic (MULTI/EXEC). An empty Query.Tenant searches
+// every tenant.
+//
+// WithStreams also appends each event to a per-tenant Redis Stream trimmed with
+// MAXLEN. Store.NewConsumer reads those streams through a consumer group with
+// acknowledgement (Ack) and recovery of stale pending entries (Claim); Run
+// combines both for long-running workers such as alerting or search indexing.
+//
+// Data written by earlier versions as one list per tenant can be converted
+// with Store.MigrateLists.
 package redisstore
@@ -2,7 +2,17 @@ package redisstore
 
 import (
 	"context"
+	"crypto/sha1"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	gauditor "github.com/antoniomarcosferreira/gauditor/pkg/gauditor"
 	"github.com/redis/go-redis/v9"
@@ -10,11 +20,20 @@ import (
 
 // Store implements gauditor.Storage backed by Redis.
 //
-// This implementation is intentionally simple and best for demos/development.
-// Events are appended to a Redis list per-tenant and filtered in-application.
+// Each tenant has a hash of event payloads keyed by ID, a sorted set of IDs
+// scored by timestamp, and one sorted set per actor, action and target value
+// (also scored by timestamp). Queries intersect the relevant sets and range
+// them by score inside Redis.
+//
+// All keys of a tenant share the hash tag "{tenant}", so they live on the same
+// Redis Cluster slot and multi-key commands and transactions stay valid. A
+// tenant must therefore be non-empty and free of '{', '}' and ':'.
 type Store struct {
-	rdb       *redis.Client
+	rdb       redis.UniversalClient
 	keyPrefix string
+
+	streams      bool
+	streamMaxLen int64
 }
 
 // Option configures the Store.
@@ -23,8 +42,9 @@ type Option func(*Store)
 // WithKeyPrefix sets a prefix for Redis keys. Default: "gauditor:".
 func WithKeyPrefix(prefix string) Option { return func(s *Store) { s.keyPrefix = prefix } }
 
-// New constructs a Redis-backed Store.
-func New(rdb *redis.Client, opts ...Option) *Store {
+// New constructs a Redis-backed Store. rdb may be a single-node, Sentinel
+// (failover) or Cluster client.
+func New(rdb redis.UniversalClient, opts ...Option) *Store {
 	s := &Store{rdb: rdb, keyPrefix: "gauditor:"}
 	for _, o := range opts {
 		o(s)
@@ -32,54 +52,311 @@ func New(rdb *redis.Client, opts ...Option) *Store {
 	return s
 }
 
-func (s *Store) keyForTenant(tenant string) string { return s.keyPrefix + tenant + ":events" }
+// Key layout, all under keyPrefix:
+//
+//	tenants                       set of tenants with events
+//	{<tenant>}:data               hash id -> event JSON
+//	{<tenant>}:ts                 zset id scored by timestamp
+//	{<tenant>}:actor:<id>         zset id scored by timestamp
+//	{<tenant>}:action:<action>    zset id scored by timestamp
+//	{<tenant>}:target:<id>        zset id scored by timestamp
+//	{<tenant>}:stream             stream of event JSON, see WithStreams
+//	<tenant>:events               legacy list layout, see MigrateLists
+func (s *Store) tenantKey(tenant string, parts ...string) string {
+	return s.keyPrefix + "{" + tenant + "}:" + strings.Join(parts, ":")
+}
+
+func (s *Store) tenantsKey() string { return s.keyPrefix + "tenants" }
+
+// checkTenant rejects tenants that break the "{tenant}" hash tag: Redis
+// Cluster ignores an empty tag, and a brace or colon could make one tenant's
+// keys collide with another's.
+func checkTenant(tenant string) error {
+	if tenant == "" || strings.ContainsAny(tenant, "{}:") {
+		return fmt.Errorf("redisstore: tenant %q must be non-empty and free of '{', '}' and ':'", tenant)
+	}
+	return nil
+}
+
+// validTenants drops tenants checkTenant rejects, which only a store written
+// before the check may list.
+func validTenants(tenants []string) []string {
+	valid := tenants[:0]
+	for _, t := range tenants {
+		if checkTenant(t) == nil {
+			valid = append(valid, t)
+		}
+	}
+	return valid
+}
+
+// score is the timestamp in Unix microseconds, which a float64 holds exactly.
+func score(t time.Time) float64 { return float64(t.UnixMicro()) }
+
+// indexKeys returns the secondary index sets an event belongs to.
+func (s *Store) indexKeys(e gauditor.Event) []string {
+	keys := []string{s.tenantKey(e.Tenant, "ts"), s.tenantKey(e.Tenant, "action", e.Action)}
+	if e.Actor.ID != "" {
+		keys = append(keys, s.tenantKey(e.Tenant, "actor", e.Actor.ID))
+	}
+	if e.Target.ID != "" {
+		keys = append(keys, s.tenantKey(e.Tenant, "target", e.Target.ID))
+	}
+	return keys
+}
 
-// Save pushes the event to the tenant list (newest-first).
+// Save stores the payload and its index entries atomically (MULTI/EXEC).
+// Tenants rejected by checkTenant are an ErrInvalidEvent.
 func (s *Store) Save(ctx context.Context, e gauditor.Event) (gauditor.Event, error) {
+	if err := checkTenant(e.Tenant); err != nil {
+		return e, fmt.Errorf("%w: %w", gauditor.ErrInvalidEvent, err)
+	}
 	raw, err := json.Marshal(e)
 	if err != nil {
 		return e, err
 	}
-	if err := s.rdb.LPush(ctx, s.keyForTenant(e.Tenant), raw).Err(); err != nil {
+	// The tenants set lives on its own slot, so it is written outside the
+	// transaction; registering a tenant twice is harmless.
+	if err := s.rdb.SAdd(ctx, s.tenantsKey(), e.Tenant).Err(); err != nil {
 		return e, err
 	}
-	return e, nil
+	_, err = s.rdb.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		s.write(ctx, p, e, raw)
+		if s.streams {
+			s.appendStream(ctx, p, e, raw)
+		}
+		return nil
+	})
+	return e, err
+}
+
+func (s *Store) write(ctx context.Context, p redis.Pipeliner, e gauditor.Event, raw []byte) {
+	p.HSet(ctx, s.tenantKey(e.Tenant, "data"), e.ID, raw)
+	member := redis.Z{Score: score(e.Timestamp), Member: e.ID}
+	for _, key := range s.indexKeys(e) {
+		p.ZAdd(ctx, key, member)
+	}
 }
 
-// Query scans the tenant list newest-to-oldest, returning ascending by timestamp.
+// queryScript intersects the index sets (KEYS[3:]) into a temporary key
+// (KEYS[2]) when more than one filter applies, ranges it by score and returns
+// the matching payloads from the data hash (KEYS[1]).
+var queryScript = redis.NewScript(`
+local n = #KEYS - 2
+local src = KEYS[3]
+if n > 1 then
+  local args = {KEYS[2], n}
+  for i = 3, #KEYS do args[#args + 1] = KEYS[i] end
+  args[#args + 1] = 'AGGREGATE'
+  args[#args + 1] = 'MIN'
+  redis.call('ZINTERSTORE', unpack(args))
+  src = KEYS[2]
+end
+local ids
+if tonumber(ARGV[3]) > 0 then
+  ids = redis.call('ZRANGEBYSCORE', src, ARGV[1], ARGV[2], 'LIMIT', 0, ARGV[3])
+else
+  ids = redis.call('ZRANGEBYSCORE', src, ARGV[1], ARGV[2])
+end
+if n > 1 then redis.call('DEL', KEYS[2]) end
+local out = {}
+for i = 1, #ids, 500 do
+  local vals = redis.call('HMGET', KEYS[1], unpack(ids, i, math.min(i + 499, #ids)))
+  for _, v in ipairs(vals) do out[#out + 1] = v end
+end
+return out
+`)
+
+// Query resolves filters and the time range in Redis and returns events in
+// ascending timestamp order. An empty Tenant queries every tenant.
 func (s *Store) Query(ctx context.Context, q gauditor.Query) ([]gauditor.Event, error) {
+	tenants := []string{q.Tenant}
 	if q.Tenant == "" {
-		return nil, nil
+		var err error
+		if tenants, err = s.rdb.SMembers(ctx, s.tenantsKey()).Result(); err != nil {
+			return nil, err
+		}
+		tenants = validTenants(tenants)
+		sort.Strings(tenants)
+	} else if err := checkTenant(q.Tenant); err != nil {
+		return nil, err
+	}
+	var results []gauditor.Event
+	for _, tenant := range tenants {
+		events, err := s.queryTenant(ctx, tenant, q)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, events...)
+	}
+	sort.SliceStable(results, func(i, j int) bool { return results[i].Timestamp.Before(results[j].Timestamp) })
+	if q.Limit > 0 && len(results) > q.Limit {
+		results = results[:q.Limit]
+	}
+	return results, nil
+}
+
+// tmpSeq keeps temporary intersection keys unique within the process.
+var tmpSeq atomic.Uint64
+
+func (s *Store) queryTenant(ctx context.Context, tenant string, q gauditor.Query) ([]gauditor.Event, error) {
+	keys := []string{s.tenantKey(tenant, "data"), s.tenantKey(tenant, "tmp", strconv.FormatInt(time.Now().UnixNano(), 36), strconv.FormatUint(tmpSeq.Add(1), 36))}
+	if q.ActorID != "" {
+		keys = append(keys, s.tenantKey(tenant, "actor", q.ActorID))
+	}
+	if q.Action != "" {
+		keys = append(keys, s.tenantKey(tenant, "action", q.Action))
 	}
-	vals, err := s.rdb.LRange(ctx, s.keyForTenant(q.Tenant), 0, -1).Result()
+	if q.TargetID != "" {
+		keys = append(keys, s.tenantKey(tenant, "target", q.TargetID))
+	}
+	if len(keys) == 2 {
+		keys = append(keys, s.tenantKey(tenant, "ts"))
+	}
+	minScore, maxScore := "-inf", "+inf"
+	if q.Since != nil {
+		minScore = strconv.FormatInt(q.Since.UnixMicro(), 10)
+	}
+	if q.Until != nil {
+		maxScore = strconv.FormatInt(q.Until.UnixMicro(), 10)
+	}
+	// Scores have microsecond precision, so a Since with a sub-microsecond part
+	// may admit a few earlier events that Matches drops below; only push Limit
+	// into Redis when every candidate is known to match.
+	limit := 0
+	if q.Limit > 0 && len(q.Data) == 0 && (q.Since == nil || q.Since.Nanosecond()%1000 == 0) {
+		limit = q.Limit
+	}
+	vals, err := queryScript.Run(ctx, s.rdb, keys, minScore, maxScore, limit).Slice()
 	if err != nil {
 		return nil, err
 	}
 	results := make([]gauditor.Event, 0, len(vals))
-	for i := len(vals) - 1; i >= 0; i-- {
+	for _, v := range vals {
+		raw, ok := v.(string)
+		if !ok {
+			continue // index entry without payload
+		}
 		var e gauditor.Event
-		if err := json.Unmarshal([]byte(vals[i]), &e); err != nil {
+		if err := json.Unmarshal([]byte(raw), &e); err != nil {
 			continue
 		}
-		if q.ActorID != "" && e.Actor.ID != q.ActorID {
+		if !q.Matches(e) {
 			continue
 		}
-		if q.Action != "" && e.Action != q.Action {
-			continue
+		results = append(results, e)
+	}
+	return results, nil
+}
+
+// Ping checks that Redis answers.
+func (s *Store) Ping(ctx context.Context) error { return s.rdb.Ping(ctx).Err() }
+
+// Get reads the event's payload from the tenant's data hash with HGET.
+func (s *Store) Get(ctx context.Context, tenant, id string) (gauditor.Event, error) {
+	var e gauditor.Event
+	if err := checkTenant(tenant); err != nil {
+		return e, err
+	}
+	raw, err := s.rdb.HGet(ctx, s.tenantKey(tenant, "data"), id).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return e, fmt.Errorf("%w: %s", gauditor.ErrNotFound, id)
+	}
+	if err != nil {
+		return e, err
+	}
+	err = json.Unmarshal(raw, &e)
+	return e, err
+}
+
+// MigrateLists converts tenants stored in the legacy list layout
+// ("<tenant>:events", newest-first) into the indexed layout and deletes each
+// list once all its events are indexed. It returns how many events were
+// migrated and is safe to re-run; events without an ID get one derived from
+// their payload so re-runs do not duplicate them. Lists of tenants that
+// checkTenant rejects are left in place.
+func (s *Store) MigrateLists(ctx context.Context) (int, error) {
+	keys, err := s.scan(ctx, s.keyPrefix+"*:events")
+	if err != nil {
+		return 0, err
+	}
+	migrated := 0
+	for _, key := range keys {
+		typ, err := s.rdb.Type(ctx, key).Result()
+		if err != nil {
+			return migrated, err
 		}
-		if q.TargetID != "" && e.Target.ID != q.TargetID {
+		if typ != "list" {
 			continue
 		}
-		if q.Since != nil && e.Timestamp.Before(*q.Since) {
-			continue
+		vals, err := s.rdb.LRange(ctx, key, 0, -1).Result()
+		if err != nil {
+			return migrated, err
 		}
-		if q.Until != nil && e.Timestamp.After(*q.Until) {
+		tenant := strings.TrimSuffix(strings.TrimPrefix(key, s.keyPrefix), ":events")
+		if checkTenant(tenant) != nil {
 			continue
 		}
-		results = append(results, e)
-		if q.Limit > 0 && len(results) >= q.Limit {
-			break
+		if err := s.rdb.SAdd(ctx, s.tenantsKey(), tenant).Err(); err != nil {
+			return migrated, err
+		}
+		n := 0
+		_, err = s.rdb.TxPipelined(ctx, func(p redis.Pipeliner) error {
+			for _, raw := range vals {
+				var e gauditor.Event
+				if err := json.Unmarshal([]byte(raw), &e); err != nil {
+					continue
+				}
+				if e.ID == "" {
+					sum := sha1.Sum([]byte(raw))
+					e.ID = "legacy-" + hex.EncodeToString(sum[:])
+				}
+				e.Tenant = tenant
+				payload, err := json.Marshal(e)
+				if err != nil {
+					return err
+				}
+				s.write(ctx, p, e, payload)
+				n++
+			}
+			return nil
+		})
+		if err != nil {
+			return migrated, err
 		}
+		// The list hashes to another cluster slot than the new keys, so it is
+		// deleted only after the transaction above succeeded.
+		if err := s.rdb.Del(ctx, key).Err(); err != nil {
+			return migrated, err
+		}
+		migrated += n
 	}
-	return results, nil
+	return migrated, nil
+}
+
+// scan returns the keys matching pattern, on every master of a cluster.
+func (s *Store) scan(ctx context.Context, pattern string) ([]string, error) {
+	var (
+		mu   sync.Mutex
+		keys []string
+	)
+	scanNode := func(ctx context.Context, c *redis.Client) error {
+		iter := c.Scan(ctx, 0, pattern, 100).Iterator()
+		for iter.Next(ctx) {
+			mu.Lock()
+			keys = append(keys, iter.Val())
+			mu.Unlock()
+		}
+		return iter.Err()
+	}
+	if c, ok := s.rdb.(*redis.ClusterClient); ok {
+		err := c.ForEachMaster(ctx, scanNode)
+		sort.Strings(keys)
+		return keys, err
+	}
+	iter := s.rdb.Scan(ctx, 0, pattern, 100).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	return keys, iter.Err()
 }
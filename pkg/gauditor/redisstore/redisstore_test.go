@@ -0,0 +1,215 @@
+package redisstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	gauditor "github.com/antoniomarcosferreira/gauditor/pkg/gauditor"
+	"github.com/redis/go-redis/v9"
+)
+
+func newTestStore(t *testing.T, opts ...Option) (*Store, *miniredis.Miniredis) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = rdb.Close() })
+	return New(rdb, opts...), mr
+}
+
+var base = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func seed(t *testing.T, s *Store) {
+	t.Helper()
+	events := []gauditor.Event{
+		{ID: "1", Timestamp: base, Tenant: "a", Actor: gauditor.Actor{ID: "u1"}, Action: "create", Target: gauditor.Target{ID: "doc1"}},
+		{ID: "2", Timestamp: base.Add(time.Minute), Tenant: "a", Actor: gauditor.Actor{ID: "u2"}, Action: "update", Target: gauditor.Target{ID: "doc1"}},
+		{ID: "3", Timestamp: base.Add(2 * time.Minute), Tenant: "a", Actor: gauditor.Actor{ID: "u1"}, Action: "update", Target: gauditor.Target{ID: "doc2"}, Data: map[string]any{"k": "v"}},
+		{ID: "4", Timestamp: base.Add(90 * time.Second), Tenant: "b", Actor: gauditor.Actor{ID: "u1"}, Action: "update"},
+	}
+	for _, e := range events {
+		if _, err := s.Save(context.Background(), e); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func ids(events []gauditor.Event) string {
+	out := ""
+	for _, e := range events {
+		out += e.ID
+	}
+	return out
+}
+
+func TestQuery_IndexesAndRanges(t *testing.T) {
+	s, mr := newTestStore(t)
+	seed(t, s)
+	ctx := context.Background()
+	since, until := base.Add(30*time.Second), base.Add(2*time.Minute)
+	cases := []struct {
+		name string
+		q    gauditor.Query
+		want string
+	}{
+		{"tenant", gauditor.Query{Tenant: "a"}, "123"},
+		{"actor", gauditor.Query{Tenant: "a", ActorID: "u1"}, "13"},
+		{"actor and action", gauditor.Query{Tenant: "a", ActorID: "u1", Action: "update"}, "3"},
+		{"target", gauditor.Query{Tenant: "a", TargetID: "doc1"}, "12"},
+		{"range", gauditor.Query{Tenant: "a", Since: &since, Until: &until}, "23"},
+		{"limit", gauditor.Query{Tenant: "a", Limit: 2}, "12"},
+		{"data", gauditor.Query{Tenant: "a", Data: map[string]any{"k": "v"}, Limit: 1}, "3"},
+		{"no match", gauditor.Query{Tenant: "a", ActorID: "u2", TargetID: "doc2"}, ""},
+		{"all tenants", gauditor.Query{Action: "update"}, "243"},
+		{"all tenants limit", gauditor.Query{Limit: 3}, "124"},
+	}
+	for _, tc := range cases {
+		got, err := s.Query(ctx, tc.q)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if ids(got) != tc.want {
+			t.Errorf("%s: got %q want %q", tc.name, ids(got), tc.want)
+		}
+	}
+	for _, k := range mr.Keys() {
+		if strings.Contains(k, ":tmp:") {
+			t.Fatalf("temporary key left behind: %s", k)
+		}
+	}
+	if n := len(mr.Keys()); n != 13 {
+		t.Fatalf("unexpected key count %d: %v", n, mr.Keys())
+	}
+}
+
+func TestGet(t *testing.T) {
+	s, _ := newTestStore(t)
+	seed(t, s)
+	ctx := context.Background()
+	if e, err := s.Get(ctx, "a", "3"); err != nil || e.Data["k"] != "v" || !e.Timestamp.Equal(base.Add(2*time.Minute)) {
+		t.Fatalf("got %+v, %v", e, err)
+	}
+	for _, k := range [][2]string{{"a", "4"}, {"a", "9"}, {"c", "1"}} {
+		if _, err := s.Get(ctx, k[0], k[1]); !errors.Is(err, gauditor.ErrNotFound) {
+			t.Fatalf("Get(%s, %s): want ErrNotFound, got %v", k[0], k[1], err)
+		}
+	}
+}
+
+func TestPing(t *testing.T) {
+	s, mr := newTestStore(t)
+	ctx := context.Background()
+	if err := s.Ping(ctx); err != nil {
+		t.Fatal(err)
+	}
+	mr.Close()
+	if err := s.Ping(ctx); err == nil {
+		t.Fatal("want an error once Redis is gone")
+	}
+}
+
+func TestMigrateLists(t *testing.T) {
+	s, mr := newTestStore(t)
+	ctx := context.Background()
+	legacy := []gauditor.Event{
+		{ID: "old1", Timestamp: base, Tenant: "a", Action: "create"},
+		{Timestamp: base.Add(time.Second), Tenant: "a", Action: "update"},
+	}
+	for _, e := range legacy {
+		raw, _ := json.Marshal(e)
+		if _, err := mr.Lpush("gauditor:a:events", string(raw)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := mr.Lpush("gauditor:a:events", "not json"); err != nil {
+		t.Fatal(err)
+	}
+	n, err := s.MigrateLists(ctx)
+	if err != nil || n != 2 {
+		t.Fatalf("migrate: n=%d err=%v", n, err)
+	}
+	if mr.Exists("gauditor:a:events") {
+		t.Fatal("legacy list not removed")
+	}
+	got, _ := s.Query(ctx, gauditor.Query{Tenant: "a", Action: "update"})
+	if len(got) != 1 || got[0].ID == "" {
+		t.Fatalf("migrated event not indexed: %+v", got)
+	}
+	if n, err := s.MigrateLists(ctx); err != nil || n != 0 {
+		t.Fatalf("re-run should be a no-op: n=%d err=%v", n, err)
+	}
+}
+
+func TestKeys_ShareTenantHashTag(t *testing.T) {
+	s, mr := newTestStore(t, WithStreams(0))
+	seed(t, s)
+	for _, k := range mr.Keys() {
+		if k == "gauditor:tenants" {
+			continue
+		}
+		if !strings.HasPrefix(k, "gauditor:{a}:") && !strings.HasPrefix(k, "gauditor:{b}:") {
+			t.Fatalf("key without tenant hash tag: %s", k)
+		}
+	}
+	if !mr.Exists("gauditor:{a}:stream") {
+		t.Fatalf("stream key differs from the documented layout: %v", mr.Keys())
+	}
+}
+
+func TestTenant_MustKeepItsHashTag(t *testing.T) {
+	s, mr := newTestStore(t)
+	ctx := context.Background()
+	for _, tenant := range []string{"", "a}:action:x", "{a}", "a:b"} {
+		if _, err := s.Save(ctx, gauditor.Event{ID: "1", Tenant: tenant, Action: "x"}); !errors.Is(err, gauditor.ErrInvalidEvent) {
+			t.Errorf("Save(%q): want ErrInvalidEvent, got %v", tenant, err)
+		}
+		if tenant == "" {
+			continue // every tenant
+		}
+		if _, err := s.Query(ctx, gauditor.Query{Tenant: tenant}); err == nil {
+			t.Errorf("Query(%q): want an error", tenant)
+		}
+		if _, err := s.Get(ctx, tenant, "1"); err == nil || errors.Is(err, gauditor.ErrNotFound) {
+			t.Errorf("Get(%q): want an error, got %v", tenant, err)
+		}
+	}
+	if keys := mr.Keys(); len(keys) != 0 {
+		t.Fatalf("nothing should be written, got %v", keys)
+	}
+
+	// A tenant listed by an older store is skipped when querying every tenant.
+	seed(t, s)
+	if _, err := mr.SAdd("gauditor:tenants", "a}:x"); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := s.Query(ctx, gauditor.Query{}); err != nil || ids(got) != "1243" {
+		t.Fatalf("every tenant: %v %v", ids(got), err)
+	}
+}
+
+func TestClusterClient(t *testing.T) {
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClusterClient(&redis.ClusterOptions{Addrs: []string{mr.Addr()}})
+	t.Cleanup(func() { _ = rdb.Close() })
+	s := New(rdb, WithStreams(0))
+	ctx := context.Background()
+	if _, err := mr.Lpush("gauditor:old:events", `{"id":"o1","tenant":"old","action":"x"}`); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := s.MigrateLists(ctx); err != nil || n != 1 {
+		t.Fatalf("migrate via cluster client: n=%d err=%v", n, err)
+	}
+	seed(t, s)
+	got, err := s.Query(ctx, gauditor.Query{Tenant: "a", ActorID: "u1", Action: "update"})
+	if err != nil || ids(got) != "3" {
+		t.Fatalf("query via cluster client: %q %v", ids(got), err)
+	}
+	msgs, err := s.NewConsumer("g", "c", WithBlock(5*time.Millisecond)).Read(ctx)
+	if err != nil || len(msgs) != 4 {
+		t.Fatalf("consume via cluster client: %d %v", len(msgs), err)
+	}
+}
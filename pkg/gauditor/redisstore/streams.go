@@ -0,0 +1,318 @@
+package redisstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	gauditor "github.com/antoniomarcosferreira/gauditor/pkg/gauditor"
+	"github.com/redis/go-redis/v9"
+)
+
+// streamField is the stream entry field holding the event JSON.
+const streamField = "event"
+
+// WithStreams additionally appends every saved event to the per-tenant stream
+// "<prefix>{<tenant>}:stream" (XADD, in the same MULTI/EXEC as the indexed write), so
+// downstream services can consume audit events through consumer groups.
+// Streams are trimmed to roughly maxLen entries; zero or less disables trimming.
+func WithStreams(maxLen int64) Option {
+	return func(s *Store) {
+		s.streams = true
+		s.streamMaxLen = maxLen
+	}
+}
+
+func (s *Store) streamKey(tenant string) string { return s.tenantKey(tenant, "stream") }
+
+func (s *Store) appendStream(ctx context.Context, p redis.Pipeliner, e gauditor.Event, raw []byte) {
+	args := &redis.XAddArgs{Stream: s.streamKey(e.Tenant), Values: []any{streamField, raw}}
+	if s.streamMaxLen > 0 {
+		args.MaxLen = s.streamMaxLen
+		args.Approx = true
+	}
+	p.XAdd(ctx, args)
+}
+
+// Message is one event delivered to a Consumer.
+type Message struct {
+	// Stream is the Redis key of the tenant stream and ID the entry ID; both
+	// are needed to acknowledge the message.
+	Stream string
+	ID     string
+	Event  gauditor.Event
+}
+
+// Consumer reads events from tenant streams as a member of a consumer group.
+// Messages stay pending in the group until acknowledged; messages left pending
+// by a crashed or failing consumer are reclaimed after an idle timeout.
+type Consumer struct {
+	store   *Store
+	group   string
+	name    string
+	tenants []string
+
+	count   int64
+	block   time.Duration
+	minIdle time.Duration
+	startID string
+
+	mu     sync.Mutex
+	groups map[string]bool // streams whose group is known to exist
+}
+
+// ConsumerOption configures a Consumer.
+type ConsumerOption func(*Consumer)
+
+// WithTenants restricts the consumer to the given tenants. By default it
+// follows every tenant that has events, picking up new tenants as they appear.
+func WithTenants(tenants ...string) ConsumerOption {
+	return func(c *Consumer) { c.tenants = tenants }
+}
+
+// WithReadCount sets the maximum number of messages read per stream per call. Default: 100.
+func WithReadCount(n int64) ConsumerOption { return func(c *Consumer) { c.count = n } }
+
+// WithBlock sets how long Read waits for new messages. Default: 1s.
+func WithBlock(d time.Duration) ConsumerOption { return func(c *Consumer) { c.block = d } }
+
+// WithClaimMinIdle sets how long a message must have been pending before
+// another consumer may reclaim it. Default: 1m.
+func WithClaimMinIdle(d time.Duration) ConsumerOption { return func(c *Consumer) { c.minIdle = d } }
+
+// WithStartID sets where a newly created group starts: "0" (the default)
+// delivers the whole retained stream, "$" only events saved afterwards.
+func WithStartID(id string) ConsumerOption { return func(c *Consumer) { c.startID = id } }
+
+// NewConsumer returns a Consumer named name in group. The Store's key prefix
+// determines the streams read; WithStreams is only needed on the writing side.
+func (s *Store) NewConsumer(group, name string, opts ...ConsumerOption) *Consumer {
+	c := &Consumer{
+		store:   s,
+		group:   group,
+		name:    name,
+		count:   100,
+		block:   time.Second,
+		minIdle: time.Minute,
+		startID: "0",
+		groups:  make(map[string]bool),
+	}
+	for _, o := range opts {
+		o(c)
+	}
+	return c
+}
+
+// streams returns the stream keys to read, creating the group where missing.
+func (c *Consumer) streams(ctx context.Context) ([]string, error) {
+	tenants := c.tenants
+	if len(tenants) == 0 {
+		var err error
+		if tenants, err = c.store.rdb.SMembers(ctx, c.store.tenantsKey()).Result(); err != nil {
+			return nil, err
+		}
+		tenants = validTenants(tenants)
+		sort.Strings(tenants)
+	}
+	keys := make([]string, 0, len(tenants))
+	for _, t := range tenants {
+		if err := checkTenant(t); err != nil {
+			return nil, err
+		}
+		key := c.store.streamKey(t)
+		if err := c.ensureGroup(ctx, key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func (c *Consumer) ensureGroup(ctx context.Context, stream string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.groups[stream] {
+		return nil
+	}
+	err := c.store.rdb.XGroupCreateMkStream(ctx, stream, c.group, c.startID).Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return err
+	}
+	c.groups[stream] = true
+	return nil
+}
+
+// Read returns new messages for this consumer, waiting up to the block
+// duration. It returns no messages and no error when nothing arrived.
+func (c *Consumer) Read(ctx context.Context) ([]Message, error) {
+	keys, err := c.streams(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		// Nothing to read yet; wait like a blocking read would.
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(c.block):
+			return nil, nil
+		}
+	}
+	if _, ok := c.store.rdb.(*redis.ClusterClient); ok {
+		return c.readEach(ctx, keys)
+	}
+	return c.read(ctx, keys, c.block)
+}
+
+// read issues one XREADGROUP over keys; block < 0 does not wait.
+func (c *Consumer) read(ctx context.Context, keys []string, block time.Duration) ([]Message, error) {
+	streams := make([]string, 0, 2*len(keys))
+	streams = append(streams, keys...)
+	for range keys {
+		streams = append(streams, ">")
+	}
+	res, err := c.store.rdb.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group:    c.group,
+		Consumer: c.name,
+		Streams:  streams,
+		Count:    c.count,
+		Block:    block,
+	}).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var out []Message
+	for _, st := range res {
+		out = append(out, c.decode(ctx, st.Stream, st.Messages)...)
+	}
+	return out, nil
+}
+
+// readEach polls tenant streams one at a time, since on a cluster they hash to
+// different slots and cannot share one XREADGROUP. It waits for the block
+// duration only when every stream was empty.
+func (c *Consumer) readEach(ctx context.Context, keys []string) ([]Message, error) {
+	var out []Message
+	for _, key := range keys {
+		msgs, err := c.read(ctx, []string{key}, -1)
+		if err != nil {
+			return out, err
+		}
+		out = append(out, msgs...)
+	}
+	if len(out) > 0 {
+		return out, nil
+	}
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-time.After(c.block):
+		return nil, nil
+	}
+}
+
+// Claim takes over messages that have been pending in the group for longer
+// than the minimum idle time, for example because their consumer crashed or
+// did not acknowledge them, and returns them for reprocessing.
+func (c *Consumer) Claim(ctx context.Context) ([]Message, error) {
+	keys, err := c.streams(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var out []Message
+	for _, key := range keys {
+		start := "0-0"
+		for {
+			msgs, next, err := c.store.rdb.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+				Stream:   key,
+				Group:    c.group,
+				Consumer: c.name,
+				MinIdle:  c.minIdle,
+				Start:    start,
+				Count:    c.count,
+			}).Result()
+			if err != nil {
+				return out, err
+			}
+			out = append(out, c.decode(ctx, key, msgs)...)
+			if next == "0-0" || next == "" {
+				break
+			}
+			start = next
+		}
+	}
+	return out, nil
+}
+
+// decode converts stream entries to messages. Entries that do not hold a valid
+// event can never be processed, so they are acknowledged and dropped.
+func (c *Consumer) decode(ctx context.Context, stream string, msgs []redis.XMessage) []Message {
+	out := make([]Message, 0, len(msgs))
+	for _, m := range msgs {
+		var e gauditor.Event
+		raw, _ := m.Values[streamField].(string)
+		if err := json.Unmarshal([]byte(raw), &e); err != nil {
+			_ = c.store.rdb.XAck(ctx, stream, c.group, m.ID).Err()
+			continue
+		}
+		out = append(out, Message{Stream: stream, ID: m.ID, Event: e})
+	}
+	return out
+}
+
+// Ack acknowledges processed messages so they leave the pending list.
+func (c *Consumer) Ack(ctx context.Context, msgs ...Message) error {
+	byStream := make(map[string][]string)
+	for _, m := range msgs {
+		byStream[m.Stream] = append(byStream[m.Stream], m.ID)
+	}
+	for stream, ids := range byStream {
+		if err := c.store.rdb.XAck(ctx, stream, c.group, ids...).Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Run reclaims stale pending messages, then reads new ones, calling handle for
+// each and acknowledging those it processed without error, until ctx is
+// cancelled. Messages whose handler failed stay pending and are redelivered by
+// Claim once idle long enough, so handlers should be idempotent.
+func (c *Consumer) Run(ctx context.Context, handle func(context.Context, Message) error) error {
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		claimed, err := c.Claim(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		fresh, err := c.Read(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		for _, m := range append(claimed, fresh...) {
+			if err := handle(ctx, m); err != nil {
+				continue
+			}
+			// Acknowledge even if ctx was cancelled meanwhile, so handled
+			// messages are not redelivered after shutdown.
+			if err := c.Ack(context.WithoutCancel(ctx), m); err != nil {
+				return err
+			}
+		}
+	}
+}
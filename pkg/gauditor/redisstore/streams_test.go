@@ -0,0 +1,112 @@
+package redisstore
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	gauditor "github.com/antoniomarcosferreira/gauditor/pkg/gauditor"
+)
+
+func TestStreams_XAddWithTrimming(t *testing.T) {
+	s, mr := newTestStore(t, WithStreams(3))
+	ctx := context.Background()
+	for i := 0; i < 5; i++ {
+		e := gauditor.Event{ID: string(rune('a' + i)), Timestamp: base.Add(time.Duration(i) * time.Second), Tenant: "t", Action: "x"}
+		if _, err := s.Save(ctx, e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	entries, err := mr.Stream("gauditor:{t}:stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("want stream trimmed to 3 entries, got %d", len(entries))
+	}
+	// The indexed layout is still written.
+	if got, _ := s.Query(ctx, gauditor.Query{Tenant: "t"}); len(got) != 5 {
+		t.Fatalf("want 5 queryable events, got %d", len(got))
+	}
+}
+
+func TestConsumer_ReadAckAndClaim(t *testing.T) {
+	s, _ := newTestStore(t, WithStreams(0))
+	ctx := context.Background()
+	for _, tenant := range []string{"a", "b"} {
+		if _, err := s.Save(ctx, gauditor.Event{ID: tenant + "1", Timestamp: base, Tenant: tenant, Action: "x"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c1 := s.NewConsumer("alerts", "c1", WithBlock(10*time.Millisecond), WithClaimMinIdle(20*time.Millisecond))
+	msgs, err := c1.Read(ctx)
+	if err != nil || len(msgs) != 2 {
+		t.Fatalf("want 2 messages, got %d (%v)", len(msgs), err)
+	}
+	// Acknowledge only the first; the second stays pending as if c1 crashed.
+	if err := c1.Ack(ctx, msgs[0]); err != nil {
+		t.Fatal(err)
+	}
+	if more, _ := c1.Read(ctx); len(more) != 0 {
+		t.Fatalf("delivered messages must not be read again: %+v", more)
+	}
+
+	c2 := s.NewConsumer("alerts", "c2", WithBlock(10*time.Millisecond), WithClaimMinIdle(20*time.Millisecond))
+	if claimed, _ := c2.Claim(ctx); len(claimed) != 0 {
+		t.Fatalf("fresh pending messages must not be claimed: %+v", claimed)
+	}
+	time.Sleep(30 * time.Millisecond)
+	claimed, err := c2.Claim(ctx)
+	if err != nil || len(claimed) != 1 || claimed[0].Event.ID != msgs[1].Event.ID {
+		t.Fatalf("want pending message reclaimed, got %+v (%v)", claimed, err)
+	}
+	if err := c2.Ack(ctx, claimed...); err != nil {
+		t.Fatal(err)
+	}
+	pending, err := s.rdb.XPending(ctx, claimed[0].Stream, "alerts").Result()
+	if err != nil || pending.Count != 0 {
+		t.Fatalf("want nothing pending, got %+v (%v)", pending, err)
+	}
+
+	// Independent groups each see every event.
+	other := s.NewConsumer("search", "s1", WithBlock(10*time.Millisecond))
+	if got, _ := other.Read(ctx); len(got) != 2 {
+		t.Fatalf("second group should receive all events, got %d", len(got))
+	}
+}
+
+func TestConsumer_RunRedeliversFailures(t *testing.T) {
+	s, _ := newTestStore(t, WithStreams(0))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := s.Save(ctx, gauditor.Event{ID: "e1", Timestamp: base, Tenant: "t", Action: "x"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	calls := 0
+	c := s.NewConsumer("g", "c", WithTenants("t"), WithBlock(5*time.Millisecond), WithClaimMinIdle(10*time.Millisecond))
+	err := c.Run(ctx, func(_ context.Context, m Message) error {
+		mu.Lock()
+		defer mu.Unlock()
+		calls++
+		if calls == 1 {
+			return errors.New("index unavailable")
+		}
+		cancel()
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("want context.Canceled, got %v", err)
+	}
+	if calls != 2 {
+		t.Fatalf("want failed message redelivered once, got %d calls", calls)
+	}
+	pending, _ := s.rdb.XPending(context.Background(), s.streamKey("t"), "g").Result()
+	if pending.Count != 0 {
+		t.Fatalf("handled message must be acknowledged, %d pending", pending.Count)
+	}
+}
@@ -0,0 +1,197 @@
+package s3store
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	gauditor "github.com/antoniomarcosferreira/gauditor/pkg/gauditor"
+)
+
+var (
+	// ErrClosed is returned by Save after Close.
+	ErrClosed = errors.New("s3store: store closed")
+	// ErrBufferFull is returned by Save when a buffer holds more than four
+	// times its flush size, typically because uploads keep failing.
+	ErrBufferFull = errors.New("s3store: buffer full")
+)
+
+// batcher buffers events under a key (a tenant, a partition) and hands each
+// batch to upload once it reaches maxBytes of NDJSON or its oldest event has
+// waited maxAge. A background goroutine flushes due batches; failed batches
+// are kept and retried. Batches being uploaded stay visible to pending until
+// their upload succeeds.
+type batcher struct {
+	maxBytes int
+	maxAge   time.Duration
+	upload   func(ctx context.Context, key string, b *batch) error
+
+	mu        sync.Mutex
+	batches   map[string]*batch
+	uploading map[string][]*batch
+	closed    bool
+
+	kick chan struct{}
+	stop chan struct{}
+	done chan struct{}
+}
+
+// batch is the pending events of one key, with their NDJSON encoding.
+type batch struct {
+	lines  bytes.Buffer
+	events []gauditor.Event
+	opened time.Time
+}
+
+func newBatcher(maxBytes int, maxAge time.Duration, upload func(context.Context, string, *batch) error) *batcher {
+	return &batcher{maxBytes: maxBytes, maxAge: maxAge, upload: upload,
+		batches: make(map[string]*batch), uploading: make(map[string][]*batch)}
+}
+
+// start launches the background flusher.
+func (g *batcher) start() {
+	g.kick = make(chan struct{}, 1)
+	g.stop = make(chan struct{})
+	g.done = make(chan struct{})
+	tick := g.maxAge / 4
+	if tick < 10*time.Millisecond {
+		tick = 10 * time.Millisecond
+	}
+	go func() {
+		defer close(g.done)
+		t := time.NewTicker(tick)
+		defer t.Stop()
+		for {
+			select {
+			case <-g.stop:
+				return
+			case <-t.C:
+			case <-g.kick:
+			}
+			// Errors leave the events buffered; the next run retries them.
+			_ = g.flush(context.Background(), false)
+		}
+	}()
+}
+
+// add buffers e under key.
+func (g *batcher) add(key string, e gauditor.Event) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.closed {
+		return ErrClosed
+	}
+	b := g.batches[key]
+	if b == nil {
+		b = &batch{opened: time.Now()}
+		g.batches[key] = b
+	}
+	if b.lines.Len()+len(line) > 4*g.maxBytes {
+		return ErrBufferFull
+	}
+	b.lines.Write(line)
+	b.lines.WriteByte('\n')
+	b.events = append(b.events, e)
+	if b.lines.Len() >= g.maxBytes {
+		select {
+		case g.kick <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// flush uploads the batches that are due, or all of them when all is set.
+func (g *batcher) flush(ctx context.Context, all bool) error {
+	g.mu.Lock()
+	due := make(map[string]*batch)
+	for key, b := range g.batches {
+		if all || b.lines.Len() >= g.maxBytes || time.Since(b.opened) >= g.maxAge {
+			due[key] = b
+			delete(g.batches, key)
+			g.uploading[key] = append(g.uploading[key], b)
+		}
+	}
+	g.mu.Unlock()
+
+	var errs []error
+	for key, b := range due {
+		err := g.upload(ctx, key, b)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("s3store: flush %q: %w", key, err))
+		}
+		g.settle(key, b, err)
+	}
+	return errors.Join(errs...)
+}
+
+// settle retires an uploaded batch or, when its upload failed, puts it back
+// ahead of events buffered meanwhile.
+func (g *batcher) settle(key string, b *batch, err error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	up := g.uploading[key]
+	for i := range up {
+		if up[i] == b {
+			up = append(up[:i], up[i+1:]...)
+			break
+		}
+	}
+	if len(up) == 0 {
+		delete(g.uploading, key)
+	} else {
+		g.uploading[key] = up
+	}
+	if err == nil {
+		return
+	}
+	if cur := g.batches[key]; cur != nil {
+		b.lines.Write(cur.lines.Bytes())
+		b.events = append(b.events, cur.events...)
+	}
+	g.batches[key] = b
+}
+
+// close stops the background flusher and flushes everything left. Calling it
+// again only retries the flush.
+func (g *batcher) close() error {
+	g.mu.Lock()
+	wasClosed := g.closed
+	g.closed = true
+	g.mu.Unlock()
+	if !wasClosed {
+		close(g.stop)
+		<-g.done
+	}
+	return g.flush(context.Background(), true)
+}
+
+// pending returns the buffered events whose key satisfies match, including
+// those being uploaded. Read it before listing the bucket: an upload that
+// completes in between is then seen twice rather than missed.
+func (g *batcher) pending(match func(key string) bool) []gauditor.Event {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	var out []gauditor.Event
+	for key, up := range g.uploading {
+		if match(key) {
+			for _, b := range up {
+				out = append(out, b.events...)
+			}
+		}
+	}
+	for key, b := range g.batches {
+		if match(key) {
+			out = append(out, b.events...)
+		}
+	}
+	return out
+}
@@ -1,5 +1,24 @@
 // Package s3store provides an S3-backed gauditor.Storage implementation.
+// The layout and query logic run on blob.Bucket, so NewWithBucket stores the
+// same archive format on a local directory (blob.Dir) or any other driver.
+//
+// By default each event is stored as a JSON object under a configurable prefix
+// and tenant, keyed by event time so Query can list only the requested range. WithSegments buffers events per tenant instead and writes them as
+// gzip-compressed NDJSON segments ("<tenant>/segments/...ndjson.gz"), flushed by
+// size or age, each described by a Manifest ("<tenant>/manifests/...json") with
+// its time range, event count and SHA-256 checksum. Query reads the manifests to
+// skip segments outside the requested time range; Close flushes what is left.
+//
+// WithRetention and WithTenantRetention write objects with S3 Object Lock
+// (governance or compliance retention, optional legal hold); VerifyLock reports
+// objects whose lock settings fall short.
+//
+// WithEncryption encrypts objects client-side with per-object AES-GCM data keys
+// wrapped by a KeyProvider; WithSSEKMS requests SSE-KMS at rest.
+//
+// ParquetStore writes Hive-partitioned Parquet ("tenant=<t>/dt=<YYYY-MM-DD>/")
+// for analytics engines such as Athena, Trino and DuckDB, either as a Storage
+// or fed by Export from any other store.
 //
-// Each event is stored as a JSON object under a configurable prefix and tenant.
 // Queries list and filter client-side and are suitable for append-only/archive use.
 package s3store
@@ -0,0 +1,200 @@
+package s3store
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	"github.com/antoniomarcosferreira/gauditor/pkg/gauditor/blob"
+)
+
+// Object metadata written for client-side encrypted objects.
+const (
+	metaCipher = "gauditor-cipher" // encryptionScheme
+	metaKey    = "gauditor-key"    // base64 wrapped data key
+	metaKeyID  = "gauditor-key-id" // KeyProvider key ID
+)
+
+// encryptionScheme names the object format: AES-256-GCM with a random 96-bit
+// nonce prepended to the ciphertext and the object key as additional data.
+const encryptionScheme = "AES256-GCM/v1"
+
+// ErrNoKeyProvider is returned when reading an encrypted object from a store
+// without WithEncryption.
+var ErrNoKeyProvider = errors.New("s3store: object is encrypted but no key provider is configured")
+
+// KeyProvider wraps and unwraps the per-object data keys used by
+// WithEncryption, typically by calling a KMS or HSM. Wrapped keys are stored
+// in object metadata next to keyID, so providers can rotate key-encryption
+// keys and still unwrap older objects.
+type KeyProvider interface {
+	WrapKey(ctx context.Context, dataKey []byte) (wrapped []byte, keyID string, err error)
+	UnwrapKey(ctx context.Context, wrapped []byte, keyID string) ([]byte, error)
+}
+
+// WithEncryption encrypts every object (event, segment and manifest)
+// client-side before upload: each gets a fresh AES-256 data key, wrapped by kp
+// and stored in the object's metadata. Objects written without encryption
+// stay readable, so it can be enabled on an existing archive.
+func WithEncryption(kp KeyProvider) Option {
+	return func(s *Store) { s.keys = kp }
+}
+
+// WithSSEKMS asks S3 to encrypt objects at rest with SSE-KMS under keyID, or
+// the bucket's AWS managed key when keyID is empty. It combines with
+// WithEncryption; drivers other than S3 fail with blob.ErrNotSupported.
+func WithSSEKMS(keyID string) Option {
+	return func(s *Store) { s.sse = &sseSettings{algorithm: "aws:kms", keyID: keyID} }
+}
+
+// sseSettings request server-side encryption on uploads.
+type sseSettings struct{ algorithm, keyID string }
+
+func (sse *sseSettings) apply(opts *blob.PutOptions) {
+	if sse != nil {
+		opts.ServerSideEncryption, opts.KMSKeyID = sse.algorithm, sse.keyID
+	}
+}
+
+// put writes body under key with tenant's lock settings, encrypting it when
+// WithEncryption is set.
+func (s *Store) put(ctx context.Context, tenant, key string, body []byte, contentType string) error {
+	opts := s.putOptions(tenant, contentType)
+	s.sse.apply(&opts)
+	if s.keys != nil {
+		var err error
+		if body, opts.Metadata, err = seal(ctx, s.keys, key, body); err != nil {
+			return fmt.Errorf("s3store: encrypt %s: %w", key, err)
+		}
+	}
+	return s.b.Put(ctx, key, body, opts)
+}
+
+// get reads key, decrypting it if it was written with WithEncryption.
+func (s *Store) get(ctx context.Context, key string) ([]byte, error) {
+	body, meta, err := s.b.Get(ctx, key)
+	if err != nil || meta[metaCipher] == "" {
+		return body, err
+	}
+	if s.keys == nil {
+		return nil, fmt.Errorf("%w: %s", ErrNoKeyProvider, key)
+	}
+	body, err = open(ctx, s.keys, key, body, meta)
+	if err != nil {
+		return nil, fmt.Errorf("s3store: decrypt %s: %w", key, err)
+	}
+	return body, nil
+}
+
+// seal encrypts body under a new data key bound to key and returns the
+// ciphertext with the metadata needed to open it.
+func seal(ctx context.Context, kp KeyProvider, key string, body []byte) ([]byte, map[string]string, error) {
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, nil, err
+	}
+	wrapped, keyID, err := kp.WrapKey(ctx, dataKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	out := make([]byte, aead.NonceSize(), aead.NonceSize()+len(body)+aead.Overhead())
+	if _, err := rand.Read(out); err != nil {
+		return nil, nil, err
+	}
+	out = aead.Seal(out, out, body, []byte(key))
+	return out, map[string]string{
+		metaCipher: encryptionScheme,
+		metaKey:    base64.StdEncoding.EncodeToString(wrapped),
+		metaKeyID:  keyID,
+	}, nil
+}
+
+// open reverses seal.
+func open(ctx context.Context, kp KeyProvider, key string, body []byte, meta map[string]string) ([]byte, error) {
+	if meta[metaCipher] != encryptionScheme {
+		return nil, fmt.Errorf("unknown scheme %q", meta[metaCipher])
+	}
+	wrapped, err := base64.StdEncoding.DecodeString(meta[metaKey])
+	if err != nil {
+		return nil, fmt.Errorf("wrapped key: %w", err)
+	}
+	dataKey, err := kp.UnwrapKey(ctx, wrapped, meta[metaKeyID])
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(body) < aead.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	return aead.Open(nil, body[:aead.NonceSize()], body[aead.NonceSize():], []byte(key))
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Keyring is a KeyProvider holding AES-256 key-encryption keys in memory.
+// New data keys are wrapped with the current key; older keys stay available
+// to unwrap objects written before a rotation. For production, prefer a
+// provider backed by a KMS so keys never leave it.
+type Keyring struct {
+	current string
+	keys    map[string]cipher.AEAD
+}
+
+// NewKeyring returns a Keyring wrapping with keys[current]. Every key must be
+// 32 bytes.
+func NewKeyring(current string, keys map[string][]byte) (*Keyring, error) {
+	if _, ok := keys[current]; !ok {
+		return nil, fmt.Errorf("s3store: keyring: current key %q not found", current)
+	}
+	k := &Keyring{current: current, keys: make(map[string]cipher.AEAD, len(keys))}
+	for id, key := range keys {
+		if len(key) != 32 {
+			return nil, fmt.Errorf("s3store: keyring: key %q must be 32 bytes, got %d", id, len(key))
+		}
+		aead, err := newGCM(key)
+		if err != nil {
+			return nil, err
+		}
+		k.keys[id] = aead
+	}
+	return k, nil
+}
+
+// WrapKey encrypts dataKey with the current key.
+func (k *Keyring) WrapKey(_ context.Context, dataKey []byte) ([]byte, string, error) {
+	aead := k.keys[k.current]
+	out := make([]byte, aead.NonceSize(), aead.NonceSize()+len(dataKey)+aead.Overhead())
+	if _, err := rand.Read(out); err != nil {
+		return nil, "", err
+	}
+	return aead.Seal(out, out, dataKey, []byte(k.current)), k.current, nil
+}
+
+// UnwrapKey decrypts a data key wrapped with keyID.
+func (k *Keyring) UnwrapKey(_ context.Context, wrapped []byte, keyID string) ([]byte, error) {
+	aead, ok := k.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("keyring: unknown key %q", keyID)
+	}
+	if len(wrapped) < aead.NonceSize() {
+		return nil, errors.New("keyring: wrapped key too short")
+	}
+	return aead.Open(nil, wrapped[:aead.NonceSize()], wrapped[aead.NonceSize():], []byte(keyID))
+}
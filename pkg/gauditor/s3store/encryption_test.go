@@ -0,0 +1,134 @@
+package s3store
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	gauditor "github.com/antoniomarcosferreira/gauditor/pkg/gauditor"
+	"github.com/antoniomarcosferreira/gauditor/pkg/gauditor/blob"
+)
+
+func keyring(t *testing.T, current string, ids ...string) *Keyring {
+	t.Helper()
+	keys := make(map[string][]byte)
+	for _, id := range ids {
+		keys[id] = bytes.Repeat([]byte(id[:1]), 32)
+	}
+	k, err := NewKeyring(current, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func TestEncryption_ObjectsAndSegments(t *testing.T) {
+	srv := newFake(t)
+	ctx := context.Background()
+	kr := keyring(t, "k1", "k1")
+	s := New(srv.Client(), bucket, "logs", WithEncryption(kr), WithSSEKMS("arn:aws:kms:us-east-1:1:key/audit"))
+	seg := New(srv.Client(), bucket, "logs", WithEncryption(kr), WithSegments(1<<20, time.Hour))
+	defer seg.Close()
+	if _, err := s.Save(ctx, event("a", 0)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := seg.Save(ctx, event("b", time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if err := seg.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	keys := srv.Keys(bucket, "logs/acme/")
+	if len(keys) != 3 {
+		t.Fatalf("want event, segment and manifest, got %v", keys)
+	}
+	for _, key := range keys {
+		obj, _ := srv.Object(bucket, key)
+		if bytes.Contains(obj.Body, []byte("acme")) || bytes.Contains(obj.Body, []byte("minTimestamp")) {
+			t.Fatalf("%s is stored in plaintext", key)
+		}
+		if obj.Header.Get("X-Amz-Meta-Gauditor-Cipher") != encryptionScheme || obj.Header.Get("X-Amz-Meta-Gauditor-Key-Id") != "k1" {
+			t.Fatalf("%s: missing encryption metadata: %v", key, obj.Header)
+		}
+	}
+	obj, _ := srv.Object(bucket, keys[0])
+	if obj.Header.Get("X-Amz-Server-Side-Encryption") != "aws:kms" ||
+		obj.Header.Get("X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id") != "arn:aws:kms:us-east-1:1:key/audit" {
+		t.Fatalf("missing SSE-KMS headers: %v", obj.Header)
+	}
+
+	got, err := s.Query(ctx, gauditor.Query{Tenant: "acme"})
+	if err != nil || ids(got) != "ab" {
+		t.Fatalf("query over encrypted objects: %q %v", ids(got), err)
+	}
+}
+
+func TestEncryption_KeyRotationAndPlaintextObjects(t *testing.T) {
+	b, err := blob.NewDir(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	_, _ = NewWithBucket(b, "logs").Save(ctx, event("a", 0))
+	_, _ = NewWithBucket(b, "logs", WithEncryption(keyring(t, "k1", "k1"))).Save(ctx, event("b", time.Second))
+	rotated := NewWithBucket(b, "logs", WithEncryption(keyring(t, "k2", "k1", "k2")))
+	_, _ = rotated.Save(ctx, event("c", 2*time.Second))
+
+	got, err := rotated.Query(ctx, gauditor.Query{Tenant: "acme"})
+	if err != nil || ids(got) != "abc" {
+		t.Fatalf("query across plaintext and rotated keys: %q %v", ids(got), err)
+	}
+	if _, err := NewWithBucket(b, "logs").Query(ctx, gauditor.Query{Tenant: "acme"}); !errors.Is(err, ErrNoKeyProvider) {
+		t.Fatalf("want ErrNoKeyProvider, got %v", err)
+	}
+	if _, err := NewWithBucket(b, "logs", WithEncryption(keyring(t, "k2", "k2"))).Query(ctx, gauditor.Query{Tenant: "acme"}); err == nil || !strings.Contains(err.Error(), `unknown key "k1"`) {
+		t.Fatalf("want unknown key error, got %v", err)
+	}
+	if _, err := NewWithBucket(b, "logs", WithSSEKMS("")).Save(ctx, event("d", 0)); !errors.Is(err, blob.ErrNotSupported) {
+		t.Fatalf("want ErrNotSupported for SSE-KMS on a directory, got %v", err)
+	}
+}
+
+func TestEncryption_TamperingIsDetected(t *testing.T) {
+	srv := newFake(t)
+	ctx := context.Background()
+	s := New(srv.Client(), bucket, "logs", WithEncryption(keyring(t, "k1", "k1")))
+	_, _ = s.Save(ctx, event("a", 0))
+	_, _ = s.Save(ctx, event("b", time.Second))
+	keys := srv.Keys(bucket, "logs/acme/")
+
+	// Swapping ciphertexts between keys fails: the object key is authenticated.
+	a, _ := srv.Object(bucket, keys[0])
+	b, _ := srv.Object(bucket, keys[1])
+	a.Body, b.Body = b.Body, a.Body
+	a.Header, b.Header = b.Header, a.Header
+	if _, err := s.Query(ctx, gauditor.Query{Tenant: "acme"}); err == nil || !strings.Contains(err.Error(), "decrypt") {
+		t.Fatalf("want decrypt error after swapping objects, got %v", err)
+	}
+}
+
+func TestNewKeyring_Validation(t *testing.T) {
+	if _, err := NewKeyring("k1", map[string][]byte{"k1": make([]byte, 16)}); err == nil {
+		t.Fatal("want error for a 16-byte key")
+	}
+	if _, err := NewKeyring("k2", map[string][]byte{"k1": make([]byte, 32)}); err == nil {
+		t.Fatal("want error for a missing current key")
+	}
+}
+
+func TestParquetStore_SSEKMS(t *testing.T) {
+	srv := newFake(t)
+	p := NewParquetStore(srv.Client(), bucket, "lake", WithParquetSSEKMS(""))
+	defer p.Close()
+	if err := p.WriteEvents(context.Background(), []gauditor.Event{event("a", 0)}); err != nil {
+		t.Fatal(err)
+	}
+	obj, _ := srv.Object(bucket, srv.Keys(bucket, "lake/")[0])
+	if obj.Header.Get("X-Amz-Server-Side-Encryption") != "aws:kms" {
+		t.Fatalf("missing SSE-KMS header: %v", obj.Header)
+	}
+}
@@ -0,0 +1,87 @@
+package s3store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	gauditor "github.com/antoniomarcosferreira/gauditor/pkg/gauditor"
+	"github.com/antoniomarcosferreira/gauditor/pkg/gauditor/blob"
+)
+
+// WithIDIndex writes a secondary key per event, "<tenant>/ids/<id>", holding
+// the key of the object that contains the event: its per-event object or,
+// with WithSegments, its segment's manifest. Get then reads one or two
+// objects instead of scanning the tenant. Events saved without it are only
+// found by the scan.
+func WithIDIndex() Option { return func(s *Store) { s.idIndex = true } }
+
+// idKey is the secondary key of an event ID.
+func (s *Store) idKey(tenant, id string) string {
+	return s.tenantPrefix(tenant) + "ids/" + url.PathEscape(id)
+}
+
+// putIDs points the ID keys of events at the object key holding them.
+func (s *Store) putIDs(ctx context.Context, tenant, key string, events []gauditor.Event) error {
+	ids := make([]string, len(events))
+	for i, e := range events {
+		ids[i] = e.ID
+	}
+	_, err := fetchAll(ctx, s.concurrency, ids, func(ctx context.Context, id string) (struct{}, error) {
+		return struct{}{}, s.put(ctx, tenant, s.idKey(tenant, id), []byte(key), "text/plain")
+	})
+	return err
+}
+
+// Get returns the tenant's event with the given ID. Buffered events are
+// checked first; with WithIDIndex the secondary key locates the object,
+// otherwise the tenant's events are scanned.
+func (s *Store) Get(ctx context.Context, tenant, id string) (gauditor.Event, error) {
+	for _, e := range s.bufferedEvents(tenant) {
+		if e.ID == id {
+			return e, nil
+		}
+	}
+	events, err := s.candidates(ctx, tenant, id)
+	if err != nil {
+		return gauditor.Event{}, err
+	}
+	for _, e := range events {
+		if e.ID == id && e.Tenant == tenant {
+			return e, nil
+		}
+	}
+	return gauditor.Event{}, fmt.Errorf("%w: %s", gauditor.ErrNotFound, id)
+}
+
+// candidates returns the events that may have the ID.
+func (s *Store) candidates(ctx context.Context, tenant, id string) ([]gauditor.Event, error) {
+	if !s.idIndex {
+		return s.Query(ctx, gauditor.Query{Tenant: tenant})
+	}
+	ref, err := s.get(ctx, s.idKey(tenant, id))
+	if errors.Is(err, blob.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	key := string(ref)
+	if !strings.HasPrefix(key, s.manifestsPrefix(tenant)) {
+		e, err := s.readEvent(ctx, key)
+		if errors.Is(err, blob.ErrNotExist) {
+			return nil, nil
+		}
+		return []gauditor.Event{e}, err
+	}
+	m, err := s.readManifest(ctx, key)
+	if errors.Is(err, blob.ErrNotExist) {
+		return nil, nil // segment upload failed before its manifest
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.readSegment(ctx, m)
+}
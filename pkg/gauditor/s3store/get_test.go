@@ -0,0 +1,50 @@
+package s3store
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	gauditor "github.com/antoniomarcosferreira/gauditor/pkg/gauditor"
+)
+
+func TestGet_IDIndex(t *testing.T) {
+	srv := newFake(t)
+	ctx := context.Background()
+	plain := New(srv.Client(), bucket, "logs", WithIDIndex())
+	seg := New(srv.Client(), bucket, "logs", WithIDIndex(), WithSegments(1<<20, time.Hour))
+	defer seg.Close()
+	if _, err := plain.Save(ctx, event("a", 0)); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"b", "c/d"} {
+		if _, err := seg.Save(ctx, event(id, time.Second)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if e, err := seg.Get(ctx, "acme", "b"); err != nil || e.ID != "b" {
+		t.Fatalf("buffered event: %+v, %v", e, err)
+	}
+	if err := seg.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if keys := srv.Keys(bucket, "logs/acme/ids/"); len(keys) != 3 {
+		t.Fatalf("want one ID key per event, got %v", keys)
+	}
+	for _, id := range []string{"a", "b", "c/d"} {
+		if e, err := plain.Get(ctx, "acme", id); err != nil || e.ID != id {
+			t.Fatalf("Get %s: %+v, %v", id, e, err)
+		}
+	}
+	for _, tenant := range []string{"acme", "other"} {
+		id := map[string]string{"acme": "missing", "other": "a"}[tenant]
+		if _, err := plain.Get(ctx, tenant, id); !errors.Is(err, gauditor.ErrNotFound) {
+			t.Fatalf("Get(%s, %s): want ErrNotFound, got %v", tenant, id, err)
+		}
+	}
+	// Without the index, Get scans the tenant.
+	if e, err := New(srv.Client(), bucket, "logs").Get(ctx, "acme", "c/d"); err != nil || e.ID != "c/d" {
+		t.Fatalf("scan: %+v, %v", e, err)
+	}
+}
@@ -0,0 +1,168 @@
+package s3store
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/antoniomarcosferreira/gauditor/pkg/gauditor/blob"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// lockClockSkew is how far a retain-until date may fall short of
+// LastModified+Period before VerifyLock reports it: the date is computed from
+// the local clock before the upload, LastModified by S3 after it.
+const lockClockSkew = time.Minute
+
+// Retention is the S3 Object Lock applied to objects written by the Store.
+// The bucket must have Object Lock enabled and implement blob.Locker.
+type Retention struct {
+	// Mode is types.ObjectLockModeGovernance or types.ObjectLockModeCompliance.
+	// Governance retention can be lifted by principals with
+	// s3:BypassGovernanceRetention; compliance retention cannot be shortened
+	// or removed by anyone, including the root account.
+	Mode types.ObjectLockMode
+	// Period is added to the upload time to get the retain-until date.
+	Period time.Duration
+	// LegalHold places a legal hold on every new object.
+	LegalHold bool
+}
+
+// WithRetention writes every object with Object Lock retention r, unless the
+// tenant has an override from WithTenantRetention.
+func WithRetention(r Retention) Option {
+	return func(s *Store) { s.retention = &r }
+}
+
+// WithTenantRetention overrides the default retention for one tenant, e.g. a
+// longer compliance period for a regulated customer.
+func WithTenantRetention(tenant string, r Retention) Option {
+	return func(s *Store) {
+		if s.tenantRetention == nil {
+			s.tenantRetention = make(map[string]Retention)
+		}
+		s.tenantRetention[tenant] = r
+	}
+}
+
+// retentionFor resolves the retention of tenant's objects.
+func (s *Store) retentionFor(tenant string) (Retention, bool) {
+	if r, ok := s.tenantRetention[tenant]; ok {
+		return r, true
+	}
+	if s.retention != nil {
+		return *s.retention, true
+	}
+	return Retention{}, false
+}
+
+// putOptions returns the write options of an object of tenant, with the
+// tenant's Object Lock retention if any.
+func (s *Store) putOptions(tenant, contentType string) blob.PutOptions {
+	opts := blob.PutOptions{ContentType: contentType}
+	r, ok := s.retentionFor(tenant)
+	if !ok {
+		return opts
+	}
+	opts.Lock = &blob.Lock{LegalHold: r.LegalHold}
+	if r.Mode != "" {
+		opts.Lock.Mode = string(r.Mode)
+		opts.Lock.RetainUntil = time.Now().Add(r.Period).UTC()
+	}
+	return opts
+}
+
+// locker returns the bucket's Object Lock support.
+func (s *Store) locker() (blob.Locker, error) {
+	l, ok := s.b.(blob.Locker)
+	if !ok {
+		return nil, fmt.Errorf("s3store: %w: bucket has no object lock", blob.ErrNotSupported)
+	}
+	return l, nil
+}
+
+// SetLegalHold places (on) or removes (off) a legal hold on the object at key.
+// A legal hold prevents deletion regardless of retention until it is removed.
+func (s *Store) SetLegalHold(ctx context.Context, key string, on bool) error {
+	l, err := s.locker()
+	if err != nil {
+		return err
+	}
+	return l.SetLegalHold(ctx, key, on)
+}
+
+// LockIssue is an object whose Object Lock settings do not meet the
+// configured retention.
+type LockIssue struct {
+	Key     string
+	Problem string
+}
+
+// VerifyLock inspects the lock settings of every object of tenant, or of all
+// tenants when tenant is empty, and reports the objects that are not locked
+// or are locked less strictly than their tenant's retention requires: a
+// weaker mode, a retain-until date shorter than LastModified plus the period,
+// or a missing legal hold. Objects of tenants without configured retention
+// are only required to carry some lock.
+func (s *Store) VerifyLock(ctx context.Context, tenant string) ([]LockIssue, error) {
+	l, err := s.locker()
+	if err != nil {
+		return nil, err
+	}
+	root := s.rootPrefix()
+	prefix := root
+	if tenant != "" {
+		prefix = s.tenantPrefix(tenant)
+	}
+	objs, err := s.listObjects(ctx, listing{prefix: prefix})
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, len(objs))
+	for i, o := range objs {
+		keys[i] = o.Key
+	}
+	locks, err := fetchAll(ctx, s.concurrency, keys, l.ObjectLock)
+	if err != nil {
+		return nil, err
+	}
+	var issues []LockIssue
+	for i, o := range objs {
+		owner, _, _ := strings.Cut(strings.TrimPrefix(o.Key, root), "/")
+		if p := s.lockProblem(owner, locks[i], o.ModTime); p != "" {
+			issues = append(issues, LockIssue{Key: o.Key, Problem: p})
+		}
+	}
+	return issues, nil
+}
+
+// lockProblem describes how lock, on an object last modified at modTime,
+// falls short of tenant's retention, or returns "" when it does not.
+func (s *Store) lockProblem(tenant string, lock blob.Lock, modTime time.Time) string {
+	r, ok := s.retentionFor(tenant)
+	if !ok {
+		if lock.Mode == "" && !lock.LegalHold {
+			return "no object lock"
+		}
+		return ""
+	}
+	var problems []string
+	switch {
+	case r.Mode == "":
+	case lock.Mode == "" || lock.RetainUntil.IsZero():
+		problems = append(problems, "no retention")
+	case r.Mode == types.ObjectLockModeCompliance && lock.Mode != string(types.ObjectLockModeCompliance):
+		problems = append(problems, fmt.Sprintf("mode %s, want %s", lock.Mode, r.Mode))
+	case !modTime.IsZero():
+		want := modTime.Add(r.Period)
+		if lock.RetainUntil.Before(want.Add(-lockClockSkew)) {
+			problems = append(problems, fmt.Sprintf("retained until %s, want at least %s",
+				lock.RetainUntil.UTC().Format(time.RFC3339), want.UTC().Format(time.RFC3339)))
+		}
+	}
+	if r.LegalHold && !lock.LegalHold {
+		problems = append(problems, "no legal hold")
+	}
+	return strings.Join(problems, "; ")
+}
@@ -0,0 +1,180 @@
+package s3store
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	gauditor "github.com/antoniomarcosferreira/gauditor/pkg/gauditor"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+const year = 365 * 24 * time.Hour
+
+func TestObjectLock_DefaultAndTenantRetention(t *testing.T) {
+	srv := newFake(t)
+	srv.EnableObjectLock(bucket)
+	s := New(srv.Client(), bucket, "logs",
+		WithRetention(Retention{Mode: types.ObjectLockModeGovernance, Period: 30 * 24 * time.Hour}),
+		WithTenantRetention("bank", Retention{Mode: types.ObjectLockModeCompliance, Period: 7 * year, LegalHold: true}),
+	)
+	ctx := context.Background()
+	if _, err := s.Save(ctx, event("a", 0)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Save(ctx, gauditor.Event{ID: "b", Timestamp: base, Tenant: "bank", Action: "wire.sent"}); err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(prefix, mode string, period time.Duration, hold string) {
+		t.Helper()
+		keys := srv.Keys(bucket, prefix)
+		if len(keys) != 1 {
+			t.Fatalf("want one object under %s, got %v", prefix, keys)
+		}
+		obj, _ := srv.Object(bucket, keys[0])
+		if got := obj.Header.Get("X-Amz-Object-Lock-Mode"); got != mode {
+			t.Fatalf("%s: mode %q, want %q", prefix, got, mode)
+		}
+		until, err := time.Parse(time.RFC3339, obj.Header.Get("X-Amz-Object-Lock-Retain-Until-Date"))
+		if err != nil {
+			t.Fatalf("%s: retain-until: %v", prefix, err)
+		}
+		if d := until.Sub(time.Now().Add(period)); d > time.Minute || d < -time.Minute {
+			t.Fatalf("%s: retained until %s, want about now+%s", prefix, until, period)
+		}
+		if got := obj.Header.Get("X-Amz-Object-Lock-Legal-Hold"); got != hold {
+			t.Fatalf("%s: legal hold %q, want %q", prefix, got, hold)
+		}
+	}
+	check("logs/acme/", "GOVERNANCE", 30*24*time.Hour, "")
+	check("logs/bank/", "COMPLIANCE", 7*year, "ON")
+
+	got, err := s.Query(ctx, gauditor.Query{Tenant: "bank"})
+	if err != nil || ids(got) != "b" {
+		t.Fatalf("locked objects must stay readable: %q %v", ids(got), err)
+	}
+}
+
+func TestObjectLock_SegmentsAndManifestsAreLocked(t *testing.T) {
+	srv := newFake(t)
+	srv.EnableObjectLock(bucket)
+	s := New(srv.Client(), bucket, "logs", WithSegments(1<<20, time.Hour),
+		WithRetention(Retention{Mode: types.ObjectLockModeCompliance, Period: year}))
+	defer s.Close()
+	ctx := context.Background()
+	_, _ = s.Save(ctx, event("a", 0))
+	if err := s.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	issues, err := s.VerifyLock(ctx, "acme")
+	if err != nil || len(issues) != 0 {
+		t.Fatalf("segment and manifest should be locked: %v %v", issues, err)
+	}
+	if n := len(srv.Keys(bucket, "logs/acme/")); n != 2 {
+		t.Fatalf("want segment and manifest, got %d objects", n)
+	}
+}
+
+func TestObjectLock_BucketWithoutLockRejectsUploads(t *testing.T) {
+	srv := newFake(t)
+	s := New(srv.Client(), bucket, "logs", WithRetention(Retention{Mode: types.ObjectLockModeGovernance, Period: time.Hour}))
+	if _, err := s.Save(context.Background(), event("a", 0)); err == nil || !strings.Contains(err.Error(), "Object Lock") {
+		t.Fatalf("want Object Lock configuration error, got %v", err)
+	}
+}
+
+func TestObjectLock_LegalHoldAndDeletion(t *testing.T) {
+	srv := newFake(t)
+	srv.EnableObjectLock(bucket)
+	cli := srv.Client()
+	s := New(cli, bucket, "logs", WithRetention(Retention{Mode: types.ObjectLockModeGovernance, Period: time.Hour}))
+	ctx := context.Background()
+	_, _ = s.Save(ctx, event("a", 0))
+	key := srv.Keys(bucket, "logs/acme/")[0]
+	del := func(bypass bool) error {
+		_, err := cli.DeleteObject(ctx, &s3.DeleteObjectInput{Bucket: aws.String(bucket), Key: aws.String(key), BypassGovernanceRetention: aws.Bool(bypass)})
+		return err
+	}
+
+	if err := del(false); err == nil {
+		t.Fatal("governance retention must block plain deletes")
+	}
+	if err := s.SetLegalHold(ctx, key, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := del(true); err == nil {
+		t.Fatal("legal hold must block deletes even with governance bypass")
+	}
+	if err := s.SetLegalHold(ctx, key, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := del(true); err != nil {
+		t.Fatalf("governance bypass without legal hold: %v", err)
+	}
+	if _, ok := srv.Object(bucket, key); ok {
+		t.Fatal("object should be gone")
+	}
+}
+
+func TestVerifyLock_ReportsWeakOrMissingLocks(t *testing.T) {
+	srv := newFake(t)
+	srv.EnableObjectLock(bucket)
+	ctx := context.Background()
+	bank := func(id string) gauditor.Event {
+		return gauditor.Event{ID: id, Timestamp: base, Tenant: "bank", Action: "wire.sent"}
+	}
+	// Objects written before retention was configured, or with a weaker one.
+	_, _ = New(srv.Client(), bucket, "logs").Save(ctx, event("plain", 0))
+	_, _ = New(srv.Client(), bucket, "logs").Save(ctx, bank("plain"))
+	_, _ = New(srv.Client(), bucket, "logs",
+		WithRetention(Retention{Mode: types.ObjectLockModeGovernance, Period: 7 * year})).Save(ctx, bank("governance"))
+	_, _ = New(srv.Client(), bucket, "logs",
+		WithRetention(Retention{Mode: types.ObjectLockModeCompliance, Period: year})).Save(ctx, bank("short"))
+
+	s := New(srv.Client(), bucket, "logs",
+		WithTenantRetention("bank", Retention{Mode: types.ObjectLockModeCompliance, Period: 7 * year, LegalHold: true}))
+	_, _ = s.Save(ctx, bank("ok"))
+	_, _ = s.Save(ctx, gauditor.Event{ID: "other", Timestamp: base, Tenant: "other", Action: "x"})
+
+	issues, err := s.VerifyLock(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"acme/plain":      "no object lock", // no retention configured: any lock will do
+		"other/other":     "no object lock",
+		"bank/plain":      "no retention; no legal hold",
+		"bank/governance": "mode GOVERNANCE, want COMPLIANCE; no legal hold",
+		"bank/short":      "retained until",
+	}
+	got := lockIssues(issues)
+	if len(got) != len(want) {
+		t.Fatalf("want %d issues, got %v", len(want), issues)
+	}
+	for k, p := range want {
+		if !strings.HasPrefix(got[k], p) {
+			t.Fatalf("%s: problem %q, want %q", k, got[k], p)
+		}
+	}
+
+	// Scoped to one tenant.
+	issues, err = s.VerifyLock(ctx, "acme")
+	if err != nil || len(issues) != 1 {
+		t.Fatalf("acme only: %v %v", issues, err)
+	}
+}
+
+// lockIssues maps "<tenant>/<event id>" to the reported problem.
+func lockIssues(issues []LockIssue) map[string]string {
+	out := make(map[string]string)
+	for _, is := range issues {
+		parts := strings.Split(is.Key, "/")
+		id := strings.TrimSuffix(parts[len(parts)-1][strings.Index(parts[len(parts)-1], "-")+1:], ".json")
+		out[parts[1]+"/"+id] = is.Problem
+	}
+	return out
+}
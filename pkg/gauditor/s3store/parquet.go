@@ -0,0 +1,407 @@
+package s3store
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	gauditor "github.com/antoniomarcosferreira/gauditor/pkg/gauditor"
+	"github.com/antoniomarcosferreira/gauditor/pkg/gauditor/blob"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/parquet-go/parquet-go"
+	"github.com/parquet-go/parquet-go/compress/snappy"
+)
+
+// Parquet buffer defaults used when WithParquetBuffer gets non-positive values.
+const (
+	DefaultParquetBytes = 64 << 20
+	DefaultParquetAge   = 5 * time.Minute
+)
+
+// dateLayout is the format of the dt partition value.
+const dateLayout = "2006-01-02"
+
+// ParquetRow is the stable columnar schema of exported events. Tenant and date
+// are Hive partition columns taken from the object path
+// ("tenant=<tenant>/dt=<YYYY-MM-DD>/"), so they are not repeated in the file.
+// Actor and target are flattened; attributes and data are JSON strings.
+type ParquetRow struct {
+	ID              string    `parquet:"id"`
+	Timestamp       time.Time `parquet:"ts,timestamp(microsecond)"`
+	Action          string    `parquet:"action"`
+	ActorID         string    `parquet:"actor_id,optional"`
+	ActorIP         string    `parquet:"actor_ip,optional"`
+	ActorUserAgent  string    `parquet:"actor_user_agent,optional"`
+	ActorAttributes string    `parquet:"actor_attributes,optional,json"`
+	TargetID        string    `parquet:"target_id,optional"`
+	TargetType      string    `parquet:"target_type,optional"`
+	TargetName      string    `parquet:"target_name,optional"`
+	Data            string    `parquet:"data,optional,json"`
+}
+
+// NewParquetRow flattens e into the Parquet schema.
+func NewParquetRow(e gauditor.Event) (ParquetRow, error) {
+	r := ParquetRow{
+		ID:             e.ID,
+		Timestamp:      e.Timestamp.UTC(),
+		Action:         e.Action,
+		ActorID:        e.Actor.ID,
+		ActorIP:        e.Actor.IP,
+		ActorUserAgent: e.Actor.UserAgent,
+		TargetID:       e.Target.ID,
+		TargetType:     e.Target.Type,
+		TargetName:     e.Target.Name,
+	}
+	if len(e.Actor.Attributes) > 0 {
+		raw, err := json.Marshal(e.Actor.Attributes)
+		if err != nil {
+			return r, err
+		}
+		r.ActorAttributes = string(raw)
+	}
+	if len(e.Data) > 0 {
+		raw, err := json.Marshal(e.Data)
+		if err != nil {
+			return r, err
+		}
+		r.Data = string(raw)
+	}
+	return r, nil
+}
+
+// Event rebuilds the event stored in r for tenant. Timestamps carry
+// microsecond precision.
+func (r ParquetRow) Event(tenant string) (gauditor.Event, error) {
+	e := gauditor.Event{
+		ID:        r.ID,
+		Timestamp: r.Timestamp.UTC(),
+		Tenant:    tenant,
+		Action:    r.Action,
+		Actor:     gauditor.Actor{ID: r.ActorID, IP: r.ActorIP, UserAgent: r.ActorUserAgent},
+		Target:    gauditor.Target{ID: r.TargetID, Type: r.TargetType, Name: r.TargetName},
+	}
+	if r.ActorAttributes != "" {
+		if err := json.Unmarshal([]byte(r.ActorAttributes), &e.Actor.Attributes); err != nil {
+			return e, err
+		}
+	}
+	if r.Data != "" {
+		if err := json.Unmarshal([]byte(r.Data), &e.Data); err != nil {
+			return e, err
+		}
+	}
+	return e, nil
+}
+
+// ParquetStore writes events as Snappy-compressed Parquet files laid out in
+// Hive partitions, "<prefix>/tenant=<tenant>/dt=<YYYY-MM-DD>/part-*.parquet",
+// for Athena, Trino, DuckDB and similar engines. It implements
+// gauditor.Storage: Save buffers events per partition and Query reads only
+// the partitions within [Since, Until]. It can also be fed in bulk with
+// WriteEvents or Export.
+type ParquetStore struct {
+	b      blob.Bucket
+	prefix string
+	sse    *sseSettings
+
+	buf *batcher
+}
+
+// ParquetOption configures a ParquetStore.
+type ParquetOption func(*ParquetStore)
+
+// WithParquetBuffer sets when Save flushes a partition's buffer to a new file:
+// after maxBytes of buffered events (measured as JSON) or once the oldest has
+// waited maxAge. Larger files query faster. Defaults: 64 MiB, 5 minutes.
+func WithParquetBuffer(maxBytes int, maxAge time.Duration) ParquetOption {
+	return func(p *ParquetStore) {
+		p.buf.maxBytes, p.buf.maxAge = maxBytes, maxAge
+	}
+}
+
+// WithParquetSSEKMS asks S3 to encrypt Parquet files at rest with SSE-KMS
+// under keyID, or the bucket's AWS managed key when keyID is empty. Files are
+// not encrypted client-side so query engines can read them; restrict the
+// bucket and key policy to the readers that should see the data.
+func WithParquetSSEKMS(keyID string) ParquetOption {
+	return func(p *ParquetStore) { p.sse = &sseSettings{algorithm: "aws:kms", keyID: keyID} }
+}
+
+// NewParquetStore constructs a Parquet writer under prefix in bucket. Call
+// Close before exiting to flush buffered events.
+func NewParquetStore(client *s3.Client, bucket, prefix string, opts ...ParquetOption) *ParquetStore {
+	return NewParquetStoreWithBucket(blob.NewS3(client, bucket), prefix, opts...)
+}
+
+// NewParquetStoreWithBucket constructs a Parquet writer under prefix on any
+// blob.Bucket.
+func NewParquetStoreWithBucket(b blob.Bucket, prefix string, opts ...ParquetOption) *ParquetStore {
+	prefix = strings.Trim(prefix, "/")
+	if prefix == "" {
+		prefix = "gauditor"
+	}
+	p := &ParquetStore{b: b, prefix: prefix}
+	p.buf = newBatcher(DefaultParquetBytes, DefaultParquetAge, func(ctx context.Context, partition string, b *batch) error {
+		return p.writePartition(ctx, partition, b.events)
+	})
+	for _, o := range opts {
+		o(p)
+	}
+	if p.buf.maxBytes <= 0 {
+		p.buf.maxBytes = DefaultParquetBytes
+	}
+	if p.buf.maxAge <= 0 {
+		p.buf.maxAge = DefaultParquetAge
+	}
+	p.buf.start()
+	return p
+}
+
+// partition returns the Hive partition path of e, without prefix.
+func partition(e gauditor.Event) string {
+	return "tenant=" + hiveEscape(e.Tenant) + "/dt=" + e.Timestamp.UTC().Format(dateLayout)
+}
+
+// hiveEscape percent-encodes characters that are not safe in a Hive partition
+// value, as Hive does.
+func hiveEscape(v string) string {
+	var b strings.Builder
+	for i := 0; i < len(v); i++ {
+		c := v[i]
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.' {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+// Save buffers e in its partition.
+func (p *ParquetStore) Save(ctx context.Context, e gauditor.Event) (gauditor.Event, error) {
+	return e, p.buf.add(partition(e), e)
+}
+
+// Flush writes every buffered partition.
+func (p *ParquetStore) Flush(ctx context.Context) error { return p.buf.flush(ctx, true) }
+
+// Close flushes buffered events and stops the background flusher.
+func (p *ParquetStore) Close() error { return p.buf.close() }
+
+// WriteEvents writes events immediately, one file per partition touched,
+// bypassing the buffer.
+func (p *ParquetStore) WriteEvents(ctx context.Context, events []gauditor.Event) error {
+	groups := make(map[string][]gauditor.Event)
+	for _, e := range events {
+		key := partition(e)
+		groups[key] = append(groups[key], e)
+	}
+	keys := make([]string, 0, len(groups))
+	for k := range groups {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if err := p.writePartition(ctx, k, groups[k]); err != nil {
+			return fmt.Errorf("s3store: write %s: %w", k, err)
+		}
+	}
+	return nil
+}
+
+func (p *ParquetStore) writePartition(ctx context.Context, partition string, events []gauditor.Event) error {
+	rows := make([]ParquetRow, 0, len(events))
+	for _, e := range events {
+		r, err := NewParquetRow(e)
+		if err != nil {
+			return err
+		}
+		rows = append(rows, r)
+	}
+	sort.SliceStable(rows, func(i, j int) bool { return rows[i].Timestamp.Before(rows[j].Timestamp) })
+	var body bytes.Buffer
+	if err := parquet.Write(&body, rows, parquet.Compression(&snappy.Codec{})); err != nil {
+		return err
+	}
+	id, err := segmentID()
+	if err != nil {
+		return err
+	}
+	key := path.Join(p.prefix, partition, "part-"+rows[0].Timestamp.Format(keyTimeLayout)+"-"+id+".parquet")
+	opts := blob.PutOptions{ContentType: "application/vnd.apache.parquet"}
+	p.sse.apply(&opts)
+	return p.b.Put(ctx, key, body.Bytes(), opts)
+}
+
+// Query reads the partitions of q.Tenant (every tenant when empty) whose date
+// lies within [Since, Until] and filters their rows. Unflushed events are
+// included.
+func (p *ParquetStore) Query(ctx context.Context, q gauditor.Query) ([]gauditor.Event, error) {
+	buffered := p.buf.pending(func(string) bool { return true })
+	tenants := []string{hiveEscape(q.Tenant)}
+	if q.Tenant == "" {
+		dirs, err := p.listDirs(ctx, p.prefix+"/tenant=")
+		if err != nil {
+			return nil, err
+		}
+		tenants = tenants[:0]
+		for _, d := range dirs {
+			tenants = append(tenants, strings.TrimSuffix(strings.TrimPrefix(d, p.prefix+"/tenant="), "/"))
+		}
+	}
+	var out []gauditor.Event
+	for _, tenant := range tenants {
+		events, err := p.queryTenant(ctx, tenant, q)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, events...)
+	}
+	out = appendBuffered(out, buffered, q)
+	sortByTime(out)
+	if q.Limit > 0 && len(out) > q.Limit {
+		out = out[:q.Limit]
+	}
+	return out, nil
+}
+
+func (p *ParquetStore) queryTenant(ctx context.Context, escTenant string, q gauditor.Query) ([]gauditor.Event, error) {
+	tenant, err := hiveUnescape(escTenant)
+	if err != nil {
+		return nil, err
+	}
+	tenantPrefix := p.prefix + "/tenant=" + escTenant + "/dt="
+	days, err := p.listDirs(ctx, tenantPrefix)
+	if err != nil {
+		return nil, err
+	}
+	var out []gauditor.Event
+	for _, d := range days {
+		dt := strings.TrimSuffix(strings.TrimPrefix(d, tenantPrefix), "/")
+		if q.Since != nil && dt < q.Since.UTC().Format(dateLayout) {
+			continue
+		}
+		if q.Until != nil && dt > q.Until.UTC().Format(dateLayout) {
+			continue
+		}
+		keys, err := p.listKeys(ctx, d)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			rows, err := p.readFile(ctx, key)
+			if err != nil {
+				return nil, err
+			}
+			for _, r := range rows {
+				e, err := r.Event(tenant)
+				if err != nil {
+					return nil, fmt.Errorf("s3store: %s: %w", key, err)
+				}
+				if q.Matches(e) {
+					out = append(out, e)
+				}
+			}
+		}
+	}
+	return out, nil
+}
+
+func (p *ParquetStore) readFile(ctx context.Context, key string) ([]ParquetRow, error) {
+	raw, _, err := p.b.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := parquet.Read[ParquetRow](bytes.NewReader(raw), int64(len(raw)))
+	if err != nil {
+		return nil, fmt.Errorf("s3store: %s: %w", key, err)
+	}
+	return rows, nil
+}
+
+// listDirs returns the common prefixes directly below prefix.
+func (p *ParquetStore) listDirs(ctx context.Context, prefix string) ([]string, error) {
+	var dirs []string
+	err := p.b.List(ctx, prefix, blob.ListOptions{Delimiter: "/"}, func(o blob.Object) bool {
+		if o.IsPrefix {
+			dirs = append(dirs, o.Key)
+		}
+		return true
+	})
+	return dirs, err
+}
+
+func (p *ParquetStore) listKeys(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+	err := p.b.List(ctx, prefix, blob.ListOptions{}, func(o blob.Object) bool {
+		if strings.HasSuffix(o.Key, ".parquet") {
+			keys = append(keys, o.Key)
+		}
+		return true
+	})
+	return keys, err
+}
+
+func hiveUnescape(v string) (string, error) {
+	if !strings.Contains(v, "%") {
+		return v, nil
+	}
+	var b strings.Builder
+	for i := 0; i < len(v); i++ {
+		if v[i] != '%' {
+			b.WriteByte(v[i])
+			continue
+		}
+		if i+2 >= len(v) {
+			return "", fmt.Errorf("s3store: invalid partition value %q", v)
+		}
+		c, err := strconv.ParseUint(v[i+1:i+3], 16, 8)
+		if err != nil {
+			return "", fmt.Errorf("s3store: invalid partition value %q", v)
+		}
+		b.WriteByte(byte(c))
+		i += 2
+	}
+	return b.String(), nil
+}
+
+// Export copies the events matching q from src into dst as Parquet files and
+// returns how many were written. When q has both Since and Until the range is
+// exported one UTC day at a time to bound memory; q.Limit is ignored.
+func Export(ctx context.Context, src gauditor.Storage, dst *ParquetStore, q gauditor.Query) (int, error) {
+	q.Limit = 0
+	if q.Since == nil || q.Until == nil {
+		events, err := src.Query(ctx, q)
+		if err != nil {
+			return 0, err
+		}
+		return len(events), dst.WriteEvents(ctx, events)
+	}
+	total := 0
+	start, end := q.Since.UTC(), q.Until.UTC()
+	for from := start; !from.After(end); {
+		next := time.Date(from.Year(), from.Month(), from.Day()+1, 0, 0, 0, 0, time.UTC)
+		until := next.Add(-time.Nanosecond)
+		if until.After(end) {
+			until = end
+		}
+		day := q
+		day.Since, day.Until = &from, &until
+		events, err := src.Query(ctx, day)
+		if err != nil {
+			return total, err
+		}
+		if err := dst.WriteEvents(ctx, events); err != nil {
+			return total, err
+		}
+		total += len(events)
+		from = next
+	}
+	return total, nil
+}
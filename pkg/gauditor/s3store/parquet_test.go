@@ -0,0 +1,142 @@
+package s3store
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	gauditor "github.com/antoniomarcosferreira/gauditor/pkg/gauditor"
+	"github.com/antoniomarcosferreira/gauditor/pkg/gauditor/blob"
+	"github.com/parquet-go/parquet-go"
+)
+
+func TestParquetStore_HivePartitionsAndSchema(t *testing.T) {
+	srv := newFake(t)
+	p := NewParquetStore(srv.Client(), bucket, "lake", WithParquetBuffer(1<<20, time.Hour))
+	ctx := context.Background()
+	events := []gauditor.Event{
+		{ID: "a", Timestamp: base, Tenant: "acme", Action: "doc.read", Actor: gauditor.Actor{ID: "u1", Attributes: map[string]any{"role": "admin"}}, Target: gauditor.Target{ID: "d1", Type: "doc"}, Data: map[string]any{"n": 1.0}},
+		{ID: "b", Timestamp: base.Add(24 * time.Hour), Tenant: "acme", Action: "doc.read"},
+		{ID: "c", Timestamp: base, Tenant: "a/b c", Action: "x"},
+	}
+	for _, e := range events {
+		if _, err := p.Save(ctx, e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, _ := p.Query(ctx, gauditor.Query{Tenant: "acme"}); ids(got) != "ab" {
+		t.Fatalf("buffered events should be queryable, got %q", ids(got))
+	}
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	keys := srv.Keys(bucket, "lake/")
+	wantDirs := []string{"lake/tenant=a%2Fb%20c/dt=2024-03-01/part-", "lake/tenant=acme/dt=2024-03-01/part-", "lake/tenant=acme/dt=2024-03-02/part-"}
+	if len(keys) != len(wantDirs) {
+		t.Fatalf("unexpected objects: %v", keys)
+	}
+	for i, k := range keys {
+		if !strings.HasPrefix(k, wantDirs[i]) || !strings.HasSuffix(k, ".parquet") {
+			t.Fatalf("key %s not in partition %s", k, wantDirs[i])
+		}
+	}
+
+	obj, _ := srv.Object(bucket, keys[1])
+	f, err := parquet.OpenFile(bytes.NewReader(obj.Body), int64(len(obj.Body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cols []string
+	for _, field := range f.Schema().Fields() {
+		cols = append(cols, field.Name())
+	}
+	want := "id,ts,action,actor_id,actor_ip,actor_user_agent,actor_attributes,target_id,target_type,target_name,data"
+	if strings.Join(cols, ",") != want {
+		t.Fatalf("schema changed:\n got %s\nwant %s", strings.Join(cols, ","), want)
+	}
+
+	got, err := p.Query(ctx, gauditor.Query{Tenant: "acme", ActorID: "u1"})
+	if err != nil || len(got) != 1 {
+		t.Fatalf("query: %+v %v", got, err)
+	}
+	e := got[0]
+	if !e.Timestamp.Equal(base) || e.Actor.Attributes["role"] != "admin" || e.Target.Type != "doc" || e.Data["n"] != 1.0 {
+		t.Fatalf("round trip lost data: %+v", e)
+	}
+	if all, _ := p.Query(ctx, gauditor.Query{}); ids(all) != "acb" && ids(all) != "cab" {
+		t.Fatalf("query across tenants: %q", ids(all))
+	}
+	if other, _ := p.Query(ctx, gauditor.Query{Tenant: "a/b c"}); ids(other) != "c" || other[0].Tenant != "a/b c" {
+		t.Fatalf("escaped tenant: %+v", other)
+	}
+}
+
+func TestParquetStore_QueryPrunesPartitions(t *testing.T) {
+	srv := newFake(t)
+	p := NewParquetStore(srv.Client(), bucket, "lake")
+	defer p.Close()
+	ctx := context.Background()
+	var events []gauditor.Event
+	for day := 0; day < 5; day++ {
+		events = append(events, gauditor.Event{ID: string(rune('a' + day)), Timestamp: base.Add(time.Duration(day) * 24 * time.Hour), Tenant: "acme", Action: "x"})
+	}
+	if err := p.WriteEvents(ctx, events); err != nil {
+		t.Fatal(err)
+	}
+	srv.ResetCounts()
+	since, until := base.Add(24*time.Hour), base.Add(48*time.Hour)
+	got, err := p.Query(ctx, gauditor.Query{Tenant: "acme", Since: &since, Until: &until})
+	if err != nil || ids(got) != "bc" {
+		t.Fatalf("range query: %q %v", ids(got), err)
+	}
+	if n := srv.Count("GetObject"); n != 2 {
+		t.Fatalf("want only 2 partitions read, got %d GETs", n)
+	}
+}
+
+func TestParquetStore_LocalDirectory(t *testing.T) {
+	b, err := blob.NewDir(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := NewParquetStoreWithBucket(b, "lake")
+	defer p.Close()
+	ctx := context.Background()
+	events := []gauditor.Event{
+		{ID: "a", Timestamp: base, Tenant: "acme", Action: "x"},
+		{ID: "b", Timestamp: base.Add(24 * time.Hour), Tenant: "a/b", Action: "x"},
+	}
+	if err := p.WriteEvents(ctx, events); err != nil {
+		t.Fatal(err)
+	}
+	got, err := p.Query(ctx, gauditor.Query{})
+	if err != nil || ids(got) != "ab" || got[1].Tenant != "a/b" {
+		t.Fatalf("query across tenants on disk: %v %v", got, err)
+	}
+}
+
+func TestExport_FromMemoryStorage(t *testing.T) {
+	srv := newFake(t)
+	p := NewParquetStore(srv.Client(), bucket, "lake")
+	defer p.Close()
+	ctx := context.Background()
+	mem := gauditor.NewMemoryStorage()
+	for i := 0; i < 6; i++ {
+		_, _ = mem.Save(ctx, gauditor.Event{ID: string(rune('a' + i)), Timestamp: base.Add(time.Duration(i) * 12 * time.Hour), Tenant: "acme", Action: "x"})
+	}
+	since, until := base, base.Add(48*time.Hour)
+	n, err := Export(ctx, mem, p, gauditor.Query{Tenant: "acme", Since: &since, Until: &until, Limit: 1})
+	if err != nil || n != 5 {
+		t.Fatalf("export: n=%d err=%v", n, err)
+	}
+	if files := srv.Keys(bucket, "lake/tenant=acme/"); len(files) != 3 {
+		t.Fatalf("want one file per day, got %v", files)
+	}
+	got, _ := p.Query(ctx, gauditor.Query{Tenant: "acme"})
+	if ids(got) != "abcde" {
+		t.Fatalf("exported events: %q", ids(got))
+	}
+}
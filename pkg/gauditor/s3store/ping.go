@@ -0,0 +1,23 @@
+package s3store
+
+import (
+	"context"
+
+	gauditor "github.com/antoniomarcosferreira/gauditor/pkg/gauditor"
+	"github.com/antoniomarcosferreira/gauditor/pkg/gauditor/blob"
+)
+
+// Ping checks that the bucket is reachable.
+func (s *Store) Ping(ctx context.Context) error { return pingBucket(ctx, s.b, s.rootPrefix()) }
+
+// Ping checks that the bucket is reachable.
+func (p *ParquetStore) Ping(ctx context.Context) error { return pingBucket(ctx, p.b, p.prefix+"/") }
+
+// pingBucket pings buckets that support it, such as blob.S3 and blob.Dir,
+// and lists the first entry under prefix of others.
+func pingBucket(ctx context.Context, b blob.Bucket, prefix string) error {
+	if p, ok := b.(gauditor.Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return b.List(ctx, prefix, blob.ListOptions{Delimiter: "/"}, func(blob.Object) bool { return false })
+}
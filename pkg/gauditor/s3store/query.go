@@ -0,0 +1,251 @@
+package s3store
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	gauditor "github.com/antoniomarcosferreira/gauditor/pkg/gauditor"
+	"github.com/antoniomarcosferreira/gauditor/pkg/gauditor/blob"
+)
+
+// DefaultQueryConcurrency is the number of objects Query fetches in parallel.
+const DefaultQueryConcurrency = 8
+
+// objectTimeLayout is the time part at the start of per-event object keys
+// below the tenant prefix; it sorts chronologically.
+const objectTimeLayout = "2006/01/02/15/04/05.000000000"
+
+// maxDayPrefixes bounds how many per-day listings a range query issues before
+// falling back to a single listing from StartAfter.
+const maxDayPrefixes = 62
+
+// WithQueryConcurrency sets how many objects Query fetches in parallel.
+// Default: DefaultQueryConcurrency.
+func WithQueryConcurrency(n int) Option {
+	return func(s *Store) {
+		if n > 0 {
+			s.concurrency = n
+		}
+	}
+}
+
+// listing is one bucket listing: keys under prefix after startAfter,
+// stopping at the first key for which stop reports true.
+type listing struct {
+	prefix     string
+	startAfter string
+	stop       func(key string) bool
+}
+
+func (s *Store) list(ctx context.Context, l listing) ([]string, error) {
+	objs, err := s.listObjects(ctx, l)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, len(objs))
+	for i, o := range objs {
+		keys[i] = o.Key
+	}
+	return keys, nil
+}
+
+func (s *Store) listObjects(ctx context.Context, l listing) ([]blob.Object, error) {
+	var objs []blob.Object
+	err := s.b.List(ctx, l.prefix, blob.ListOptions{StartAfter: l.startAfter}, func(o blob.Object) bool {
+		if l.stop != nil && l.stop(o.Key) {
+			return false
+		}
+		objs = append(objs, o)
+		return true
+	})
+	return objs, err
+}
+
+// eventKeyTime parses the event time from a per-event object key.
+func (s *Store) eventKeyTime(tenant, key string) (time.Time, bool) {
+	rest := strings.TrimPrefix(key, s.tenantPrefix(tenant))
+	if len(rest) < len(objectTimeLayout) {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(objectTimeLayout, rest[:len(objectTimeLayout)])
+	return t, err == nil
+}
+
+// eventListings derives the listings of per-event objects for [since, until]
+// from the time-structured keys: StartAfter skips everything before since,
+// short ranges are split into per-day prefixes, and listing stops past until.
+func (s *Store) eventListings(tenant string, since, until *time.Time) []listing {
+	base := s.tenantPrefix(tenant)
+	startAfter := ""
+	if since != nil {
+		// Keys are "<time>-<id>.json", so every key at or after since sorts
+		// after the bare time.
+		startAfter = base + since.UTC().Format(objectTimeLayout)
+	}
+	stop := func(key string) bool {
+		rest := strings.TrimPrefix(key, base)
+		if rest == "" || rest[0] < '0' || rest[0] > '9' {
+			return true // past the time-structured keys (manifests/, segments/)
+		}
+		if until == nil {
+			return false
+		}
+		t, ok := s.eventKeyTime(tenant, key)
+		return ok && t.After(*until)
+	}
+	if since == nil || until == nil {
+		return []listing{{prefix: base, startAfter: startAfter, stop: stop}}
+	}
+	first := time.Date(since.UTC().Year(), since.UTC().Month(), since.UTC().Day(), 0, 0, 0, 0, time.UTC)
+	last := until.UTC()
+	if last.Before(first) {
+		return nil
+	}
+	if last.Sub(first) > maxDayPrefixes*24*time.Hour {
+		return []listing{{prefix: base, startAfter: startAfter, stop: stop}}
+	}
+	var out []listing
+	for day := first; !day.After(last); day = day.AddDate(0, 0, 1) {
+		l := listing{prefix: base + day.Format("2006/01/02/"), stop: stop}
+		if day.Equal(first) {
+			l.startAfter = startAfter
+		}
+		out = append(out, l)
+	}
+	return out
+}
+
+// manifestListing lists segment manifests. Their names start with the
+// segment's minimum timestamp, so listing stops at the first manifest that
+// begins after until.
+func (s *Store) manifestListing(tenant string, until *time.Time) listing {
+	prefix := s.manifestsPrefix(tenant)
+	l := listing{prefix: prefix}
+	if until != nil {
+		l.stop = func(key string) bool {
+			minTS, _, ok := manifestKeyRange(prefix, key)
+			return ok && minTS.After(*until)
+		}
+	}
+	return l
+}
+
+// manifestKeyRange parses "<min>_<max>_<id>.json" manifest names.
+func manifestKeyRange(prefix, key string) (time.Time, time.Time, bool) {
+	parts := strings.SplitN(strings.TrimPrefix(key, prefix), "_", 3)
+	if len(parts) != 3 {
+		return time.Time{}, time.Time{}, false
+	}
+	minTS, err1 := time.Parse(keyTimeLayout, parts[0])
+	maxTS, err2 := time.Parse(keyTimeLayout, parts[1])
+	return minTS, maxTS, err1 == nil && err2 == nil
+}
+
+// fetchAll runs fetch for every key with at most n in flight and returns the
+// results in key order. The first error cancels the remaining fetches.
+func fetchAll[T any](ctx context.Context, n int, keys []string, fetch func(context.Context, string) (T, error)) ([]T, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	out := make([]T, len(keys))
+	sem := make(chan struct{}, n)
+	var (
+		wg       sync.WaitGroup
+		once     sync.Once
+		firstErr error
+	)
+	for i, key := range keys {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			v, err := fetch(ctx, key)
+			if err != nil {
+				once.Do(func() { firstErr = err; cancel() })
+				return
+			}
+			out[i] = v
+		}()
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return out, ctx.Err()
+}
+
+// Query reads the tenant's events within [Since, Until] and filters them
+// client-side. Per-event objects are located from their time-structured keys
+// and segments from their manifest names, so objects outside the range are
+// neither listed nor fetched. Objects are fetched with bounded parallelism;
+// results are sorted by timestamp before Limit applies. Events still buffered
+// by WithSegments are included. An empty Tenant returns nothing.
+func (s *Store) Query(ctx context.Context, q gauditor.Query) ([]gauditor.Event, error) {
+	if q.Tenant == "" {
+		return nil, nil
+	}
+	buffered := s.bufferedEvents(q.Tenant)
+	var eventKeys []string
+	for _, l := range s.eventListings(q.Tenant, q.Since, q.Until) {
+		keys, err := s.list(ctx, l)
+		if err != nil {
+			return nil, err
+		}
+		eventKeys = append(eventKeys, keys...)
+	}
+	manifestKeys, err := s.list(ctx, s.manifestListing(q.Tenant, q.Until))
+	if err != nil {
+		return nil, err
+	}
+	prefix := s.manifestsPrefix(q.Tenant)
+	kept := manifestKeys[:0]
+	for _, key := range manifestKeys {
+		if _, maxTS, ok := manifestKeyRange(prefix, key); ok && q.Since != nil && maxTS.Before(*q.Since) {
+			continue
+		}
+		kept = append(kept, key)
+	}
+
+	events, err := fetchAll(ctx, s.concurrency, eventKeys, s.readEvent)
+	if err != nil {
+		return nil, err
+	}
+	segments, err := fetchAll(ctx, s.concurrency, kept, func(ctx context.Context, key string) ([]gauditor.Event, error) {
+		m, err := s.readManifest(ctx, key)
+		if err != nil || !m.Overlaps(q.Since, q.Until) {
+			return nil, err
+		}
+		return s.readSegment(ctx, m)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var out []gauditor.Event
+	for _, e := range events {
+		if q.Matches(e) {
+			out = append(out, e)
+		}
+	}
+	for _, seg := range segments {
+		for _, e := range seg {
+			if q.Matches(e) {
+				out = append(out, e)
+			}
+		}
+	}
+	out = appendBuffered(out, buffered, q)
+	sortByTime(out)
+	if q.Limit > 0 && len(out) > q.Limit {
+		out = out[:q.Limit]
+	}
+	return out, nil
+}
@@ -5,129 +5,121 @@ import (
 	"encoding/json"
 	"fmt"
 	"path"
+	"sort"
 	"strings"
 
 	gauditor "github.com/antoniomarcosferreira/gauditor/pkg/gauditor"
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/antoniomarcosferreira/gauditor/pkg/gauditor/blob"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 )
 
-// Store implements gauditor.Storage by writing JSON lines to S3.
-// Save appends one object per event; Query lists and filters.
+// Store implements gauditor.Storage by writing JSON to a blob.Bucket, S3 by
+// default. By default Save uploads one object per event; WithSegments batches
+// events into compressed NDJSON segments instead. Query lists and filters.
 // Suitable for append-only use; not optimized for massive queries.
 type Store struct {
-	client *s3.Client
-	bucket string
+	b      blob.Bucket
 	prefix string
+
+	concurrency     int
+	seg             *batcher
+	retention       *Retention
+	tenantRetention map[string]Retention
+	keys            KeyProvider
+	sse             *sseSettings
+	idIndex         bool
 }
 
+// Option configures the Store.
+type Option func(*Store)
+
 // New constructs an S3-backed store.
-func New(client *s3.Client, bucket, prefix string) *Store {
+func New(client *s3.Client, bucket, prefix string, opts ...Option) *Store {
+	return NewWithBucket(blob.NewS3(client, bucket), prefix, opts...)
+}
+
+// NewWithBucket constructs a store on any blob.Bucket, such as a blob.Dir for
+// a local archive with the same layout as in S3.
+func NewWithBucket(b blob.Bucket, prefix string, opts ...Option) *Store {
 	prefix = strings.TrimSuffix(prefix, "/")
-	return &Store{client: client, bucket: bucket, prefix: prefix}
+	s := &Store{b: b, prefix: prefix, concurrency: DefaultQueryConcurrency}
+	for _, o := range opts {
+		o(s)
+	}
+	if s.seg != nil {
+		s.seg.start()
+	}
+	return s
 }
 
-func (s *Store) objectKey(e gauditor.Event) string {
-	ts := e.Timestamp.UTC().Format("2006/01/02/15/04/05.000000000")
-	name := fmt.Sprintf("%s-%s.json", ts, e.ID)
+// rootPrefix is the key prefix, with trailing slash, of all tenants' objects.
+func (s *Store) rootPrefix() string {
 	if s.prefix == "" {
-		return path.Join("gauditor", e.Tenant, name)
+		return "gauditor/"
 	}
-	return path.Join(s.prefix, e.Tenant, name)
+	return s.prefix + "/"
 }
 
-// Save uploads the event as a JSON object.
+// tenantPrefix is the key prefix, with trailing slash, of a tenant's objects.
+func (s *Store) tenantPrefix(tenant string) string {
+	return path.Join(s.rootPrefix(), tenant) + "/"
+}
+
+func (s *Store) objectKey(e gauditor.Event) string {
+	ts := e.Timestamp.UTC().Format("2006/01/02/15/04/05.000000000")
+	return s.tenantPrefix(e.Tenant) + fmt.Sprintf("%s-%s.json", ts, e.ID)
+}
+
+// Save uploads the event as a JSON object, or buffers it with WithSegments.
 func (s *Store) Save(ctx context.Context, e gauditor.Event) (gauditor.Event, error) {
+	if s.seg != nil {
+		return e, s.seg.add(e.Tenant, e)
+	}
 	body, err := json.Marshal(e)
 	if err != nil {
 		return e, err
 	}
 	key := s.objectKey(e)
-	uploader := manager.NewUploader(s.client)
-	_, err = uploader.Upload(ctx, &s3.PutObjectInput{
-		Bucket:      aws.String(s.bucket),
-		Key:         aws.String(key),
-		Body:        strings.NewReader(string(body)),
-		ContentType: aws.String("application/json"),
-	})
-	return e, err
-}
-
-// Query lists objects under tenant prefix and filters client-side.
-func (s *Store) Query(ctx context.Context, q gauditor.Query) ([]gauditor.Event, error) {
-	if q.Tenant == "" {
-		return nil, nil
+	if err := s.put(ctx, e.Tenant, key, body, "application/json"); err != nil {
+		return e, err
 	}
-	prefix := path.Join(s.prefix, q.Tenant)
-	if s.prefix == "" {
-		prefix = path.Join("gauditor", q.Tenant)
+	if s.idIndex {
+		return e, s.putIDs(ctx, e.Tenant, key, []gauditor.Event{e})
 	}
+	return e, nil
+}
 
-	pager := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{
-		Bucket: aws.String(s.bucket),
-		Prefix: aws.String(prefix),
-	})
-	var out []gauditor.Event
-	for pager.HasMorePages() {
-		page, err := pager.NextPage(ctx)
-		if err != nil {
-			return nil, err
-		}
-		for _, obj := range page.Contents {
-			// Optional time filter using LastModified to short-circuit
-			if q.Since != nil && obj.LastModified.Before(*q.Since) {
-				continue
-			}
-			if q.Until != nil && obj.LastModified.After(*q.Until) {
-				continue
-			}
-			// Fetch object
-			get, err := s.client.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String(s.bucket), Key: obj.Key})
-			if err != nil {
-				return nil, err
-			}
-			var e gauditor.Event
-			dec := json.NewDecoder(get.Body)
-			_ = dec.Decode(&e)
-			_ = get.Body.Close()
-			// Apply precise filters
-			if q.ActorID != "" && e.Actor.ID != q.ActorID {
-				continue
-			}
-			if q.Action != "" && e.Action != q.Action {
-				continue
-			}
-			if q.TargetID != "" && e.Target.ID != q.TargetID {
-				continue
-			}
-			if q.Since != nil && e.Timestamp.Before((*q.Since).UTC()) {
-				continue
-			}
-			if q.Until != nil && e.Timestamp.After((*q.Until).UTC()) {
-				continue
-			}
-			out = append(out, e)
-			if q.Limit > 0 && len(out) >= q.Limit {
-				return out, nil
-			}
-		}
+// readEvent fetches one per-event object.
+func (s *Store) readEvent(ctx context.Context, key string) (gauditor.Event, error) {
+	var e gauditor.Event
+	body, err := s.get(ctx, key)
+	if err != nil {
+		return e, err
 	}
-	// S3 does not guarantee order; best-effort sort by timestamp
-	sortByTime(out)
-	return out, nil
+	// Undecodable objects yield a zero event, which no query matches.
+	_ = json.Unmarshal(body, &e)
+	return e, nil
 }
 
 func sortByTime(events []gauditor.Event) {
-	if len(events) < 2 {
-		return
+	sort.SliceStable(events, func(i, j int) bool { return events[i].Timestamp.Before(events[j].Timestamp) })
+}
+
+// appendBuffered appends the buffered events matching q to out, skipping
+// those already read from the bucket because their upload completed while
+// the query ran.
+func appendBuffered(out, buffered []gauditor.Event, q gauditor.Query) []gauditor.Event {
+	if len(buffered) == 0 {
+		return out
+	}
+	read := make(map[[2]string]bool, len(out))
+	for _, e := range out {
+		read[[2]string{e.Tenant, e.ID}] = true
 	}
-	// insertion sort for tiny slices to avoid importing sort package
-	for i := 1; i < len(events); i++ {
-		j := i
-		for j > 0 && events[j-1].Timestamp.After(events[j].Timestamp) {
-			events[j-1], events[j] = events[j], events[j-1]
-			j--
+	for _, e := range buffered {
+		if q.Matches(e) && (e.ID == "" || !read[[2]string{e.Tenant, e.ID}]) {
+			out = append(out, e)
 		}
 	}
+	return out
 }
@@ -0,0 +1,407 @@
+package s3store
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/antoniomarcosferreira/gauditor/internal/fakes3"
+	gauditor "github.com/antoniomarcosferreira/gauditor/pkg/gauditor"
+	"github.com/antoniomarcosferreira/gauditor/pkg/gauditor/blob"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+const bucket = "audit"
+
+var base = time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+func newFake(t *testing.T) *fakes3.Server {
+	t.Helper()
+	srv := fakes3.NewServer()
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func event(id string, offset time.Duration) gauditor.Event {
+	return gauditor.Event{ID: id, Timestamp: base.Add(offset), Tenant: "acme", Action: "doc.read", Actor: gauditor.Actor{ID: "u1"}}
+}
+
+func ids(events []gauditor.Event) string {
+	var b strings.Builder
+	for _, e := range events {
+		b.WriteString(e.ID)
+	}
+	return b.String()
+}
+
+func TestSave_PerEventObjects(t *testing.T) {
+	srv := newFake(t)
+	s := New(srv.Client(), bucket, "logs/")
+	ctx := context.Background()
+	for i, id := range []string{"b", "a", "c"} {
+		if _, err := s.Save(ctx, event(id, time.Duration(-i)*time.Minute)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if keys := srv.Keys(bucket, "logs/acme/"); len(keys) != 3 {
+		t.Fatalf("want one object per event, got %v", keys)
+	}
+	got, err := s.Query(ctx, gauditor.Query{Tenant: "acme", Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids(got) != "ca" {
+		t.Fatalf("limit must apply after sorting by time, got %q", ids(got))
+	}
+}
+
+func TestSegments_FlushBySizeAndManifest(t *testing.T) {
+	srv := newFake(t)
+	s := New(srv.Client(), bucket, "logs", WithSegments(300, time.Hour))
+	defer s.Close()
+	ctx := context.Background()
+	for i := 0; i < 4; i++ {
+		if _, err := s.Save(ctx, event(string(rune('a'+i)), time.Duration(i)*time.Second)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for len(srv.Keys(bucket, "logs/acme/manifests/")) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("size-triggered flush did not happen")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if err := s.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	var total int
+	for _, key := range srv.Keys(bucket, "logs/acme/manifests/") {
+		obj, _ := srv.Object(bucket, key)
+		var m Manifest
+		if err := json.Unmarshal(obj.Body, &m); err != nil {
+			t.Fatal(err)
+		}
+		seg, ok := srv.Object(bucket, m.Key)
+		if !ok {
+			t.Fatalf("manifest %s points to missing segment %s", key, m.Key)
+		}
+		zr, err := gzip.NewReader(bytes.NewReader(seg.Body))
+		if err != nil {
+			t.Fatalf("segment is not gzip: %v", err)
+		}
+		raw, _ := io.ReadAll(zr)
+		if lines := strings.Count(string(raw), "\n"); lines != m.Count {
+			t.Fatalf("manifest count %d, segment has %d lines", m.Count, lines)
+		}
+		if m.MinTimestamp.After(m.MaxTimestamp) || m.SHA256 == "" || m.Bytes != int64(len(seg.Body)) {
+			t.Fatalf("inconsistent manifest: %+v", m)
+		}
+		total += m.Count
+	}
+	if total != 4 {
+		t.Fatalf("want 4 events across segments, got %d", total)
+	}
+	got, err := s.Query(ctx, gauditor.Query{Tenant: "acme"})
+	if err != nil || ids(got) != "abcd" {
+		t.Fatalf("query over segments: %q %v", ids(got), err)
+	}
+}
+
+func TestSegments_FlushByAge(t *testing.T) {
+	srv := newFake(t)
+	s := New(srv.Client(), bucket, "logs", WithSegments(1<<20, 20*time.Millisecond))
+	defer s.Close()
+	if _, err := s.Save(context.Background(), event("a", 0)); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for len(srv.Keys(bucket, "logs/acme/segments/")) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("age-triggered flush did not happen")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestSegments_QuerySkipsSegmentsByManifest(t *testing.T) {
+	srv := newFake(t)
+	s := New(srv.Client(), bucket, "logs", WithSegments(1<<20, time.Hour))
+	defer s.Close()
+	ctx := context.Background()
+	for day := 0; day < 3; day++ {
+		for i := 0; i < 2; i++ {
+			e := event(string(rune('a'+2*day+i)), time.Duration(day)*24*time.Hour+time.Duration(i)*time.Minute)
+			if _, err := s.Save(ctx, e); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := s.Flush(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Buffered, not yet flushed events are visible too.
+	if _, err := s.Save(ctx, event("z", 72*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	srv.ResetCounts()
+	since, until := base.Add(24*time.Hour), base.Add(25*time.Hour)
+	got, err := s.Query(ctx, gauditor.Query{Tenant: "acme", Since: &since, Until: &until})
+	if err != nil || ids(got) != "cd" {
+		t.Fatalf("range query: %q %v", ids(got), err)
+	}
+	// Manifest names carry the time range, so only the overlapping manifest
+	// and its segment are fetched.
+	if n := srv.Count("GetObject"); n != 2 {
+		t.Fatalf("want 2 GETs, got %d", n)
+	}
+	all, _ := s.Query(ctx, gauditor.Query{Tenant: "acme"})
+	if ids(all) != "abcdefz" {
+		t.Fatalf("unexpected events: %q", ids(all))
+	}
+}
+
+func TestSegments_ChecksumMismatch(t *testing.T) {
+	srv := newFake(t)
+	s := New(srv.Client(), bucket, "logs", WithSegments(1<<20, time.Hour))
+	defer s.Close()
+	ctx := context.Background()
+	_, _ = s.Save(ctx, event("a", 0))
+	if err := s.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	seg, _ := srv.Object(bucket, srv.Keys(bucket, "logs/acme/segments/")[0])
+	seg.Body[len(seg.Body)-1] ^= 0xff
+	if _, err := s.Query(ctx, gauditor.Query{Tenant: "acme"}); err == nil || !strings.Contains(err.Error(), "checksum") {
+		t.Fatalf("want checksum error, got %v", err)
+	}
+}
+
+func TestSegments_CloseFlushesAndRejectsSaves(t *testing.T) {
+	srv := newFake(t)
+	s := New(srv.Client(), bucket, "logs", WithSegments(1<<20, time.Hour))
+	ctx := context.Background()
+	_, _ = s.Save(ctx, event("a", 0))
+	_, _ = s.Save(ctx, gauditor.Event{ID: "b", Timestamp: base, Tenant: "other", Action: "x"})
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(srv.Keys(bucket, "logs/")); n != 4 {
+		t.Fatalf("want a segment and manifest per tenant, got %d objects", n)
+	}
+	if _, err := s.Save(ctx, event("c", 0)); !errors.Is(err, ErrClosed) {
+		t.Fatalf("want ErrClosed, got %v", err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("second Close: %v", err)
+	}
+}
+
+func TestSegments_FailedFlushKeepsEvents(t *testing.T) {
+	srv := newFake(t)
+	s := New(srv.Client(), bucket, "logs", WithSegments(1<<20, time.Hour))
+	defer s.Close()
+	ctx := context.Background()
+	_, _ = s.Save(ctx, event("a", 0))
+	srv.Deny("PutObject", true)
+	if err := s.Flush(ctx); err == nil {
+		t.Fatal("want flush error while uploads are denied")
+	}
+	if got := s.bufferedEvents("acme"); len(got) != 1 {
+		t.Fatalf("failed flush must keep events buffered, got %d", len(got))
+	}
+	srv.Deny("PutObject", false)
+	_, _ = s.Save(ctx, event("b", time.Second))
+	if err := s.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	got, _ := s.Query(ctx, gauditor.Query{Tenant: "acme"})
+	if ids(got) != "ab" || len(srv.Keys(bucket, "logs/acme/manifests/")) != 1 {
+		t.Fatalf("retried flush should upload one segment with both events, got %q", ids(got))
+	}
+}
+
+// gatedBucket holds every Put until release is closed, announcing it on put.
+type gatedBucket struct {
+	blob.Bucket
+	put     chan string
+	release chan struct{}
+}
+
+func (b *gatedBucket) Put(ctx context.Context, key string, body []byte, opts blob.PutOptions) error {
+	b.put <- key
+	<-b.release
+	return b.Bucket.Put(ctx, key, body, opts)
+}
+
+func TestSegments_UploadingEventsStayVisible(t *testing.T) {
+	dir, err := blob.NewDir(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &gatedBucket{Bucket: dir, put: make(chan string, 16), release: make(chan struct{})}
+	s := NewWithBucket(b, "logs", WithSegments(1<<20, time.Hour))
+	defer s.Close()
+	ctx := context.Background()
+	_, _ = s.Save(ctx, event("a", 0))
+	flushed := make(chan error, 1)
+	go func() { flushed <- s.Flush(ctx) }()
+	<-b.put
+	if got, err := s.Query(ctx, gauditor.Query{Tenant: "acme"}); err != nil || ids(got) != "a" {
+		t.Fatalf("event being uploaded: want it queryable, got %q %v", ids(got), err)
+	}
+	if _, err := s.Get(ctx, "acme", "a"); err != nil {
+		t.Fatalf("event being uploaded: %v", err)
+	}
+	close(b.release)
+	if err := <-flushed; err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := s.Query(ctx, gauditor.Query{Tenant: "acme"}); ids(got) != "a" || len(s.bufferedEvents("acme")) != 0 {
+		t.Fatalf("uploaded event: want it once from the bucket, got %q", ids(got))
+	}
+}
+
+func TestAppendBuffered_SkipsUploaded(t *testing.T) {
+	read := []gauditor.Event{event("a", 0)}
+	buffered := []gauditor.Event{event("a", 0), event("b", time.Second)}
+	if got := appendBuffered(read, buffered, gauditor.Query{Tenant: "acme"}); ids(got) != "ab" {
+		t.Fatalf("want each event once, got %q", ids(got))
+	}
+}
+
+func TestQuery_RangeUsesKeyTimes(t *testing.T) {
+	srv := newFake(t)
+	s := New(srv.Client(), bucket, "logs", WithQueryConcurrency(2))
+	ctx := context.Background()
+	// Two events a day over five days, saved out of order.
+	for day := 4; day >= 0; day-- {
+		for i := 1; i >= 0; i-- {
+			e := event(string(rune('a'+2*day+i)), time.Duration(day)*24*time.Hour+time.Duration(i)*time.Hour)
+			if _, err := s.Save(ctx, e); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	srv.ResetCounts()
+	since, until := base.Add(24*time.Hour+30*time.Minute), base.Add(72*time.Hour)
+	got, err := s.Query(ctx, gauditor.Query{Tenant: "acme", Since: &since, Until: &until})
+	if err != nil || ids(got) != "defg" {
+		t.Fatalf("range query: %q %v", ids(got), err)
+	}
+	// Only the objects within the range are fetched.
+	if n := srv.Count("GetObject"); n != 4 {
+		t.Fatalf("want 4 GETs, got %d", n)
+	}
+	// One listing per day plus the manifests.
+	if n := srv.Count("ListObjectsV2"); n != 4 {
+		t.Fatalf("want 4 listings, got %d", n)
+	}
+
+	srv.ResetCounts()
+	got, err = s.Query(ctx, gauditor.Query{Tenant: "acme", Since: &since, Limit: 3})
+	if err != nil || ids(got) != "def" {
+		t.Fatalf("open-ended range with limit: %q %v", ids(got), err)
+	}
+	if n := srv.Count("GetObject"); n != 7 {
+		t.Fatalf("StartAfter should skip earlier objects, got %d GETs", n)
+	}
+}
+
+func TestQuery_FetchErrorFails(t *testing.T) {
+	srv := newFake(t)
+	s := New(srv.Client(), bucket, "logs")
+	ctx := context.Background()
+	for i := 0; i < 10; i++ {
+		_, _ = s.Save(ctx, event(string(rune('a'+i)), time.Duration(i)*time.Second))
+	}
+	srv.Deny("GetObject", true)
+	if _, err := s.Query(ctx, gauditor.Query{Tenant: "acme"}); err == nil {
+		t.Fatal("want error when objects cannot be fetched")
+	}
+}
+
+func TestStore_LocalDirectory(t *testing.T) {
+	dir := t.TempDir()
+	b, err := blob.NewDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	s := NewWithBucket(b, "logs", WithSegments(1<<20, time.Hour))
+	defer s.Close()
+	for i, id := range []string{"c", "a", "b"} {
+		if _, err := s.Save(ctx, event(id, time.Duration(2-i)*24*time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Flush(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Per-event objects written next to the segments, as after switching modes.
+	plain := NewWithBucket(b, "logs")
+	if _, err := plain.Save(ctx, event("d", 72*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "logs", "acme", "2024", "03", "04")); err != nil {
+		t.Fatalf("per-event object should use the S3 key layout on disk: %v", err)
+	}
+
+	since, until := base.Add(24*time.Hour), base.Add(72*time.Hour)
+	got, err := plain.Query(ctx, gauditor.Query{Tenant: "acme", Since: &since, Until: &until})
+	if err != nil || ids(got) != "acd" {
+		t.Fatalf("range query on disk: %q %v", ids(got), err)
+	}
+	if _, err := plain.VerifyLock(ctx, "acme"); !errors.Is(err, blob.ErrNotSupported) {
+		t.Fatalf("want ErrNotSupported from VerifyLock, got %v", err)
+	}
+	locked := NewWithBucket(b, "logs", WithRetention(Retention{Mode: types.ObjectLockModeGovernance, Period: time.Hour}))
+	if _, err := locked.Save(ctx, event("e", 0)); !errors.Is(err, blob.ErrNotSupported) {
+		t.Fatalf("want ErrNotSupported for locked writes, got %v", err)
+	}
+}
+
+// listOnlyBucket hides the Ping of the bucket it wraps.
+type listOnlyBucket struct{ blob.Bucket }
+
+func TestPing(t *testing.T) {
+	ctx := context.Background()
+	srv := newFake(t)
+	s := New(srv.Client(), bucket, "logs")
+	if err := s.Ping(ctx); err != nil || srv.Count("HeadBucket") != 1 {
+		t.Fatalf("want one HeadBucket, got %d: %v", srv.Count("HeadBucket"), err)
+	}
+	srv.Deny("HeadBucket", true)
+	if err := s.Ping(ctx); err == nil {
+		t.Fatal("want an error when the bucket is not accessible")
+	}
+
+	dir := filepath.Join(t.TempDir(), "archive")
+	b, err := blob.NewDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := NewParquetStoreWithBucket(b, "logs").Ping(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := NewWithBucket(listOnlyBucket{b}, "logs").Ping(ctx); err != nil {
+		t.Fatalf("buckets without Ping are listed: %v", err)
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := NewWithBucket(b, "logs").Ping(ctx); err == nil {
+		t.Fatal("want an error for a removed directory")
+	}
+}
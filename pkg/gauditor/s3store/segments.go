@@ -0,0 +1,202 @@
+package s3store
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	gauditor "github.com/antoniomarcosferreira/gauditor/pkg/gauditor"
+)
+
+// Segment defaults used when WithSegments gets non-positive values.
+const (
+	DefaultSegmentBytes = 8 << 20
+	DefaultSegmentAge   = time.Minute
+)
+
+// keyTimeLayout formats timestamps in object keys so they sort chronologically.
+const keyTimeLayout = "20060102T150405.000000000Z"
+
+// Manifest describes one segment object. It is stored as JSON next to the
+// segment and written after it, so a manifest always refers to a complete
+// segment.
+type Manifest struct {
+	Version      int       `json:"version"`
+	Tenant       string    `json:"tenant"`
+	Key          string    `json:"key"`
+	MinTimestamp time.Time `json:"minTimestamp"`
+	MaxTimestamp time.Time `json:"maxTimestamp"`
+	Count        int       `json:"count"`
+	Bytes        int64     `json:"bytes"`
+	SHA256       string    `json:"sha256"` // hex digest of the compressed segment
+	CreatedAt    time.Time `json:"createdAt"`
+}
+
+// Overlaps reports whether the segment may hold events within [since, until].
+func (m Manifest) Overlaps(since, until *time.Time) bool {
+	if since != nil && m.MaxTimestamp.Before(*since) {
+		return false
+	}
+	if until != nil && m.MinTimestamp.After(*until) {
+		return false
+	}
+	return true
+}
+
+// WithSegments switches Save to a buffered writer: events are accumulated per
+// tenant and flushed as one gzip-compressed NDJSON segment once the buffer
+// reaches maxBytes (uncompressed) or its oldest event has waited maxAge. Each
+// segment gets a Manifest that Query reads to skip segments outside the
+// requested time range.
+//
+// Buffered events are only in memory until flushed: call Close (or Flush) before
+// the process exits. Failed uploads are retried on the next flush.
+func WithSegments(maxBytes int, maxAge time.Duration) Option {
+	return func(s *Store) {
+		if maxBytes <= 0 {
+			maxBytes = DefaultSegmentBytes
+		}
+		if maxAge <= 0 {
+			maxAge = DefaultSegmentAge
+		}
+		s.seg = newBatcher(maxBytes, maxAge, s.uploadSegment)
+	}
+}
+
+func (s *Store) segmentsPrefix(tenant string) string  { return s.tenantPrefix(tenant) + "segments/" }
+func (s *Store) manifestsPrefix(tenant string) string { return s.tenantPrefix(tenant) + "manifests/" }
+
+// Flush uploads every buffered event as segments. It is a no-op without
+// WithSegments.
+func (s *Store) Flush(ctx context.Context) error {
+	if s.seg == nil {
+		return nil
+	}
+	return s.seg.flush(ctx, true)
+}
+
+// Close flushes buffered events and stops the background flusher. Save fails
+// with ErrClosed afterwards. Close returns the flush error, if any; the events
+// that could not be uploaded remain buffered, so Flush may be retried.
+func (s *Store) Close() error {
+	if s.seg == nil {
+		return nil
+	}
+	return s.seg.close()
+}
+
+// uploadSegment writes the batch of tenant as a segment and its manifest.
+func (s *Store) uploadSegment(ctx context.Context, tenant string, b *batch) error {
+	var body bytes.Buffer
+	zw := gzip.NewWriter(&body)
+	if _, err := zw.Write(b.lines.Bytes()); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	m := Manifest{Version: 1, Tenant: tenant, Count: len(b.events), Bytes: int64(body.Len()), CreatedAt: time.Now().UTC()}
+	m.MinTimestamp, m.MaxTimestamp = b.events[0].Timestamp.UTC(), b.events[0].Timestamp.UTC()
+	for _, e := range b.events[1:] {
+		if e.Timestamp.Before(m.MinTimestamp) {
+			m.MinTimestamp = e.Timestamp.UTC()
+		}
+		if e.Timestamp.After(m.MaxTimestamp) {
+			m.MaxTimestamp = e.Timestamp.UTC()
+		}
+	}
+	sum := sha256.Sum256(body.Bytes())
+	m.SHA256 = hex.EncodeToString(sum[:])
+	id, err := segmentID()
+	if err != nil {
+		return err
+	}
+	name := m.MinTimestamp.Format(keyTimeLayout) + "_" + m.MaxTimestamp.Format(keyTimeLayout) + "_" + id
+	m.Key = s.segmentsPrefix(tenant) + name + ".ndjson.gz"
+
+	if err := s.put(ctx, tenant, m.Key, body.Bytes(), "application/x-ndjson"); err != nil {
+		return err
+	}
+	manifest, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	// The manifest is written last: until it exists the segment is invisible,
+	// so a failed upload retried under a new name leaves no duplicates, and
+	// ID keys pointing at the missing manifest are overwritten by the retry.
+	manifestKey := s.manifestsPrefix(tenant) + name + ".json"
+	if s.idIndex {
+		if err := s.putIDs(ctx, tenant, manifestKey, b.events); err != nil {
+			return err
+		}
+	}
+	return s.put(ctx, tenant, manifestKey, manifest, "application/json")
+}
+
+func segmentID() (string, error) {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b[:]), nil
+}
+
+// bufferedEvents returns the unflushed events of tenant.
+func (s *Store) bufferedEvents(tenant string) []gauditor.Event {
+	if s.seg == nil {
+		return nil
+	}
+	return s.seg.pending(func(key string) bool { return key == tenant })
+}
+
+// readManifest fetches and decodes a manifest object.
+func (s *Store) readManifest(ctx context.Context, key string) (Manifest, error) {
+	var m Manifest
+	body, err := s.get(ctx, key)
+	if err != nil {
+		return m, err
+	}
+	err = json.Unmarshal(body, &m)
+	return m, err
+}
+
+// readSegment fetches a segment, verifies it against its manifest and decodes
+// its events.
+func (s *Store) readSegment(ctx context.Context, m Manifest) ([]gauditor.Event, error) {
+	raw, err := s.get(ctx, m.Key)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(raw)
+	if !strings.EqualFold(hex.EncodeToString(sum[:]), m.SHA256) {
+		return nil, fmt.Errorf("s3store: segment %s: checksum mismatch", m.Key)
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(raw))
+	if err != nil {
+		return nil, fmt.Errorf("s3store: segment %s: %w", m.Key, err)
+	}
+	defer zr.Close()
+	events := make([]gauditor.Event, 0, m.Count)
+	dec := json.NewDecoder(zr)
+	for {
+		var e gauditor.Event
+		if err := dec.Decode(&e); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("s3store: segment %s: %w", m.Key, err)
+		}
+		events = append(events, e)
+	}
+	if len(events) != m.Count {
+		return nil, fmt.Errorf("s3store: segment %s: %d events, manifest says %d", m.Key, len(events), m.Count)
+	}
+	return events, nil
+}
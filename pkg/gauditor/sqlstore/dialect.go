@@ -0,0 +1,281 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Dialect captures the SQL differences between the supported databases:
+// placeholder syntax, DDL, timestamp and JSON column types.
+//
+// Use one of the provided dialects (Postgres, MySQL, SQLite) with WithDialect,
+// or let New detect it from the driver registered on the *sql.DB.
+type Dialect interface {
+	// Name returns a short identifier such as "postgres", "mysql" or "sqlite".
+	Name() string
+	// Placeholder returns the bind parameter for the n-th (1-based) argument.
+	Placeholder(n int) string
+	// SchemaStatements returns the DDL statements that create the events table
+	// and its tenant/time index. Statements must be safe to re-run.
+	SchemaStatements(table string) []string
+	// TimeValue converts a timestamp into the value bound for the ts column.
+	TimeValue(t time.Time) any
+	// CreateIndex returns the DDL for a secondary index on table.
+	CreateIndex(table, name string, columns ...string) string
+	// LockMigrations takes an exclusive lock on conn that serialises concurrent
+	// migrators sharing the same name, and returns the function releasing it.
+	// Dialects whose lock is a transaction commit the work done on conn when
+	// unlock is told the migration succeeded, and roll it back otherwise.
+	LockMigrations(ctx context.Context, conn *sql.Conn, name string) (unlock func(ok bool) error, err error)
+	// NativeJSONStatements converts payload columns to the native JSON type and
+	// indexes them (see WithNativeJSON). It may return nil.
+	NativeJSONStatements(table string) []string
+	// DataPredicate returns a WHERE fragment for one Query.Data entry; bind
+	// appends an argument and returns its placeholder.
+	DataPredicate(f DataFilter, bind func(any) string) (string, error)
+	// DataPathIndex returns DDL indexing one declared hot data path. It may return nil.
+	DataPathIndex(table string, path []string) []string
+	// TablesLikeQuery returns a query with one LIKE pattern argument listing
+	// table names in the current schema.
+	TablesLikeQuery() string
+}
+
+var (
+	// Postgres targets PostgreSQL (lib/pq, pgx stdlib) using $n placeholders,
+	// TIMESTAMPTZ and TEXT JSON columns.
+	Postgres Dialect = postgresDialect{}
+	// MySQL targets MySQL/MariaDB using ? placeholders, DATETIME(6) and LONGTEXT
+	// JSON columns. The tenant index is declared inline because MySQL lacks
+	// CREATE INDEX IF NOT EXISTS.
+	MySQL Dialect = mysqlDialect{}
+	// SQLite targets SQLite (modernc.org/sqlite, mattn/go-sqlite3). Timestamps are
+	// stored as fixed-width UTC text so they sort and compare lexically.
+	SQLite Dialect = sqliteDialect{}
+)
+
+// DialectForDriver returns the dialect matching a database/sql driver name such
+// as "postgres", "pgx", "mysql", "sqlite" or "sqlite3".
+func DialectForDriver(name string) (Dialect, bool) {
+	switch strings.ToLower(name) {
+	case "postgres", "postgresql", "pgx", "pq", "cloudsqlpostgres":
+		return Postgres, true
+	case "mysql", "mariadb":
+		return MySQL, true
+	case "sqlite", "sqlite3":
+		return SQLite, true
+	}
+	return nil, false
+}
+
+// detectDialect inspects the driver type name (e.g. *pq.Driver, *mysql.MySQLDriver,
+// *sqlite.Driver) and falls back to MySQL-style placeholders when unknown.
+func detectDialect(drv driver.Driver) Dialect {
+	if drv == nil {
+		return MySQL
+	}
+	t := reflect.TypeOf(drv)
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	name := strings.ToLower(t.PkgPath() + "." + t.Name())
+	switch {
+	case strings.Contains(name, "pq."), strings.Contains(name, "pgx"), strings.Contains(name, "postgres"):
+		return Postgres
+	case strings.Contains(name, "sqlite"):
+		return SQLite
+	default:
+		return MySQL
+	}
+}
+
+type postgresDialect struct{}
+
+func (postgresDialect) Name() string              { return "postgres" }
+func (postgresDialect) Placeholder(n int) string  { return "$" + strconv.Itoa(n) }
+func (postgresDialect) TimeValue(t time.Time) any { return t.UTC() }
+func (postgresDialect) SchemaStatements(table string) []string {
+	return []string{
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+  id          VARCHAR(64) PRIMARY KEY,
+  ts          TIMESTAMPTZ NOT NULL,
+  tenant      VARCHAR(128) NOT NULL,
+  actor_id    VARCHAR(128) NULL,
+  action      VARCHAR(128) NOT NULL,
+  target_id   VARCHAR(128) NULL,
+  actor_json  TEXT NULL,
+  target_json TEXT NULL,
+  data_json   TEXT NULL
+)`, table),
+		fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s (tenant, ts)", indexName(table, "tenant_ts"), table),
+	}
+}
+
+func (postgresDialect) CreateIndex(table, name string, columns ...string) string {
+	return fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s (%s)", name, table, strings.Join(columns, ", "))
+}
+
+// LockMigrations uses a session-level advisory lock keyed by the hashed name.
+func (postgresDialect) LockMigrations(ctx context.Context, conn *sql.Conn, name string) (func(bool) error, error) {
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock(hashtext($1))", name); err != nil {
+		return nil, err
+	}
+	return func(bool) error {
+		_, err := conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock(hashtext($1))", name)
+		return err
+	}, nil
+}
+
+func (postgresDialect) TablesLikeQuery() string {
+	return "SELECT tablename FROM pg_tables WHERE schemaname = current_schema() AND tablename LIKE $1"
+}
+
+type mysqlDialect struct{}
+
+func (mysqlDialect) Name() string              { return "mysql" }
+func (mysqlDialect) Placeholder(int) string    { return "?" }
+func (mysqlDialect) TimeValue(t time.Time) any { return t.UTC() }
+func (mysqlDialect) SchemaStatements(table string) []string {
+	return []string{
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+  id          VARCHAR(64) PRIMARY KEY,
+  ts          DATETIME(6) NOT NULL,
+  tenant      VARCHAR(128) NOT NULL,
+  actor_id    VARCHAR(128) NULL,
+  action      VARCHAR(128) NOT NULL,
+  target_id   VARCHAR(128) NULL,
+  actor_json  LONGTEXT NULL,
+  target_json LONGTEXT NULL,
+  data_json   LONGTEXT NULL,
+  INDEX %s (tenant, ts)
+)`, table, indexName(table, "tenant_ts")),
+	}
+}
+
+// CreateIndex omits IF NOT EXISTS, which MySQL does not support; migrations run once.
+func (mysqlDialect) CreateIndex(table, name string, columns ...string) string {
+	return fmt.Sprintf("CREATE INDEX %s ON %s (%s)", name, table, strings.Join(columns, ", "))
+}
+
+func (mysqlDialect) TablesLikeQuery() string {
+	return "SELECT table_name FROM information_schema.tables WHERE table_schema = DATABASE() AND table_name LIKE ?"
+}
+
+// mysqlLockTimeout is how long GET_LOCK waits, in seconds, for another migrator.
+const mysqlLockTimeout = 60
+
+// LockMigrations uses a named user lock (GET_LOCK) held by the connection.
+func (mysqlDialect) LockMigrations(ctx context.Context, conn *sql.Conn, name string) (func(bool) error, error) {
+	var got sql.NullInt64
+	if err := conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, ?)", name, mysqlLockTimeout).Scan(&got); err != nil {
+		return nil, err
+	}
+	if !got.Valid || got.Int64 != 1 {
+		return nil, fmt.Errorf("sqlstore: timed out waiting for migration lock %q", name)
+	}
+	return func(bool) error {
+		_, err := conn.ExecContext(context.Background(), "SELECT RELEASE_LOCK(?)", name)
+		return err
+	}, nil
+}
+
+// sqliteTimeLayout is fixed width so lexical order matches chronological order.
+const sqliteTimeLayout = "2006-01-02T15:04:05.000000000Z"
+
+type sqliteDialect struct{}
+
+func (sqliteDialect) Name() string              { return "sqlite" }
+func (sqliteDialect) Placeholder(int) string    { return "?" }
+func (sqliteDialect) TimeValue(t time.Time) any { return t.UTC().Format(sqliteTimeLayout) }
+func (sqliteDialect) SchemaStatements(table string) []string {
+	return []string{
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+  id          TEXT PRIMARY KEY,
+  ts          TEXT NOT NULL,
+  tenant      TEXT NOT NULL,
+  actor_id    TEXT NULL,
+  action      TEXT NOT NULL,
+  target_id   TEXT NULL,
+  actor_json  TEXT NULL,
+  target_json TEXT NULL,
+  data_json   TEXT NULL
+)`, table),
+		fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s (tenant, ts)", indexName(table, "tenant_ts"), table),
+	}
+}
+
+func (sqliteDialect) CreateIndex(table, name string, columns ...string) string {
+	return fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s (%s)", name, table, strings.Join(columns, ", "))
+}
+
+// LockMigrations opens an IMMEDIATE transaction, which holds the database write
+// lock until it ends. Concurrent migrators wait according to busy_timeout. The
+// migration is committed only when it succeeded, so a failed one leaves the
+// schema as it found it.
+func (sqliteDialect) LockMigrations(ctx context.Context, conn *sql.Conn, _ string) (func(bool) error, error) {
+	if _, err := conn.ExecContext(ctx, "BEGIN IMMEDIATE"); err != nil {
+		return nil, err
+	}
+	return func(ok bool) error {
+		end := "ROLLBACK"
+		if ok {
+			end = "COMMIT"
+		}
+		_, err := conn.ExecContext(context.Background(), end)
+		return err
+	}, nil
+}
+
+func (sqliteDialect) TablesLikeQuery() string {
+	return "SELECT name FROM sqlite_master WHERE type = 'table' AND name LIKE ?"
+}
+
+// indexName derives an index identifier from the table name, e.g.
+// idx_gauditor_events_tenant_ts. Dots from schema-qualified names are replaced.
+func indexName(table, suffix string) string {
+	return "idx_" + strings.ReplaceAll(table, ".", "_") + "_" + suffix
+}
+
+// scanTime accepts the representations drivers return for the ts column:
+// time.Time (pq, pgx, mysql with parseTime), or text/bytes (SQLite, mysql).
+type scanTime struct{ t time.Time }
+
+var textTimeLayouts = []string{
+	sqliteTimeLayout,
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999-07:00",
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999",
+}
+
+func (s *scanTime) Scan(src any) error {
+	switch v := src.(type) {
+	case time.Time:
+		s.t = v.UTC()
+		return nil
+	case string:
+		return s.parse(v)
+	case []byte:
+		return s.parse(string(v))
+	case nil:
+		s.t = time.Time{}
+		return nil
+	}
+	return fmt.Errorf("sqlstore: cannot scan %T into timestamp", src)
+}
+
+func (s *scanTime) parse(v string) error {
+	for _, layout := range textTimeLayouts {
+		if t, err := time.Parse(layout, v); err == nil {
+			s.t = t.UTC()
+			return nil
+		}
+	}
+	return fmt.Errorf("sqlstore: unrecognized timestamp %q", v)
+}
@@ -1,6 +1,30 @@
-// Package sqlstore implements gauditor.Storage via database/sql for Postgres/MySQL.
+// Package sqlstore implements gauditor.Storage via database/sql for Postgres, MySQL and SQLite.
+//
+// SQL differences (placeholders, DDL, timestamp and JSON column types) are handled
+// by a Dialect. New detects the dialect from the registered driver; use WithDialect
+// or WithDriverName to choose it explicitly.
 //
 // The default table name is "gauditor_events". Use WithTablePrefix or WithTableName
-// to change it when sharing the database with your application. EnsureSchema creates
-// the table and index if missing. Save/Query provide a minimal, portable mapping.
+// to change it when sharing the database with your application. Save/Query provide a
+// minimal, portable mapping.
+//
+// The schema evolves through ordered, versioned migrations recorded in the
+// "<table>_migrations" table. Migrate (or EnsureSchema) applies pending ones under a
+// dialect-specific lock so concurrent instances do not race; PendingMigrations
+// reports what would run. The "gauditor migrate" CLI subcommand wraps both.
+//
+// gauditor.Query.Data predicates are translated per dialect: JSONB containment plus
+// equality on Postgres, JSON equality or generated columns on MySQL, json_extract on SQLite.
+// WithNativeJSON switches payload columns to JSONB/JSON with GIN indexes, and
+// WithIndexedDataPaths declares hot paths that get their own index.
+//
+// WithMonthlyPartitions range-partitions events by month: natively on Postgres,
+// one table per month elsewhere. Queries only read partitions overlapping
+// Since/Until, and MaintainPartitions creates upcoming months and drops expired
+// ones (WithPartitionRetention).
+//
+// SaveTx and ContextWithTx write the event through the caller's *sql.Tx so it
+// commits or rolls back with the business change. WithOutbox also queues the
+// event in "<table>_outbox"; a Relay forwards queued events to other Storage
+// backends with at-least-once delivery.
 package sqlstore
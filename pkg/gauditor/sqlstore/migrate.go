@@ -0,0 +1,206 @@
+package sqlstore
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Migration is one ordered, versioned schema change for the events table.
+// Up returns the statements to execute for the given dialect and table name.
+//
+// Migrations are recorded in the "<table>_migrations" metadata table once all
+// their statements succeed. On Postgres and MySQL they are not wrapped in a
+// transaction (MySQL DDL is not transactional), so statements should be
+// idempotent where the dialect allows. On SQLite a Migrate call runs in one
+// transaction, rolled back if any statement fails.
+//
+// Versions below 1000 form the core sequence every store applies. Migrations
+// enabled by an option number theirs in a block of their own (1000s for
+// WithNativeJSON, 2000s for WithOutbox). Each sequence is applied in order,
+// independently of the others, so enabling an option on an existing database
+// applies its block without running anything out of order.
+type Migration struct {
+	Version     int
+	Description string
+	Up          func(d Dialect, table string) []string
+}
+
+// sequenceBlock is the version range of one migration sequence.
+const sequenceBlock = 1000
+
+// migrations is the ordered core sequence of built-in schema versions. Append
+// only; never edit or renumber a released migration.
+var migrations = []Migration{
+	{
+		Version:     1,
+		Description: "create events table with tenant/time index",
+		Up:          func(d Dialect, table string) []string { return d.SchemaStatements(table) },
+	},
+	{
+		Version:     2,
+		Description: "index actor, action and target lookups",
+		Up:          lookupIndexes,
+	},
+	{
+		Version:     tenantKeyVersion,
+		Description: "key events by tenant and id",
+		Up:          tenantKeyStatements,
+	},
+}
+
+// Migrations returns the migrations planned for this store in version order:
+// the built-in ones plus those enabled by options such as WithNativeJSON.
+func (s *Store) Migrations() []Migration {
+	out := make([]Migration, len(migrations), len(migrations)+3)
+	copy(out, migrations)
+	if s.nativeJSON {
+		out = append(out, nativeJSONMigration)
+	}
+	if s.outbox {
+		out = append(out, outboxMigration, outboxTenantMigration)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Version < out[j].Version })
+	return out
+}
+
+// migrationsTable is the metadata table tracking applied versions.
+func (s *Store) migrationsTable() string { return s.table + "_migrations" }
+
+func (s *Store) ensureMigrationsTable(ctx context.Context, exec execer) error {
+	stmt := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+  version     INTEGER PRIMARY KEY,
+  description VARCHAR(255) NOT NULL,
+  applied_at  VARCHAR(40) NOT NULL
+)`, s.migrationsTable())
+	_, err := exec.ExecContext(ctx, stmt)
+	return err
+}
+
+// AppliedVersions returns the migration versions recorded as applied,
+// creating the metadata table if it does not exist yet.
+func (s *Store) AppliedVersions(ctx context.Context) (map[int]bool, error) {
+	if err := s.ensureMigrationsTable(ctx, s.bb); err != nil {
+		return nil, err
+	}
+	return s.appliedVersions(ctx, s.bb)
+}
+
+func (s *Store) appliedVersions(ctx context.Context, q queryer) (map[int]bool, error) {
+	rows, err := q.QueryContext(ctx, fmt.Sprintf("SELECT version FROM %s", s.migrationsTable()))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	applied := make(map[int]bool)
+	for rows.Next() {
+		var v int
+		if err := rows.Scan(&v); err != nil {
+			return nil, err
+		}
+		applied[v] = true
+	}
+	return applied, rows.Err()
+}
+
+// PendingMigrations returns the migrations that have not been applied yet.
+func (s *Store) PendingMigrations(ctx context.Context) ([]Migration, error) {
+	applied, err := s.AppliedVersions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return pending(s.Migrations(), applied), nil
+}
+
+func pending(all []Migration, applied map[int]bool) []Migration {
+	var out []Migration
+	for _, m := range all {
+		if !applied[m.Version] {
+			out = append(out, m)
+		}
+	}
+	return out
+}
+
+// stillApplied returns the migrations of done recorded as applied, dropping
+// those a failed Migrate rolled back.
+func (s *Store) stillApplied(done []Migration) []Migration {
+	applied, err := s.appliedVersions(context.Background(), s.bb)
+	if err != nil {
+		return done
+	}
+	kept := done[:0]
+	for _, m := range done {
+		if applied[m.Version] {
+			kept = append(kept, m)
+		}
+	}
+	return kept
+}
+
+// checkOrder rejects a pending migration when a later version of its sequence
+// is already applied: running it now would reorder the sequence's history.
+func checkOrder(todo []Migration, applied map[int]bool) error {
+	for _, m := range todo {
+		for v := range applied {
+			if v/sequenceBlock == m.Version/sequenceBlock && v > m.Version {
+				return fmt.Errorf("sqlstore: migration %d (%s) is pending but %d is already applied; apply it manually", m.Version, m.Description, v)
+			}
+		}
+	}
+	return nil
+}
+
+// Migrate applies all pending migrations in order and returns the ones applied.
+//
+// A dialect-specific lock (advisory lock, GET_LOCK, or an IMMEDIATE transaction
+// on SQLite) is held on a dedicated connection for the duration, so concurrent
+// application instances calling Migrate do not race; the losers wait and then
+// find nothing pending. On error, the migrations returned are those still
+// applied: on SQLite, none.
+func (s *Store) Migrate(ctx context.Context) (done []Migration, err error) {
+	conn, err := s.bb.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	unlock, err := s.dialect.LockMigrations(ctx, conn, s.table+"_migrate")
+	if err != nil {
+		return nil, fmt.Errorf("sqlstore: acquire migration lock: %w", err)
+	}
+	defer func() {
+		if uerr := unlock(err == nil); uerr != nil && err == nil {
+			err = fmt.Errorf("sqlstore: release migration lock: %w", uerr)
+		}
+		if err != nil && len(done) > 0 {
+			done = s.stillApplied(done)
+		}
+	}()
+
+	if err := s.ensureMigrationsTable(ctx, conn); err != nil {
+		return nil, err
+	}
+	applied, err := s.appliedVersions(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+	todo := pending(s.Migrations(), applied)
+	if err := checkOrder(todo, applied); err != nil {
+		return nil, err
+	}
+	for _, m := range todo {
+		for _, stmt := range m.Up(s.migrationDialect(), s.table) {
+			if _, err := conn.ExecContext(ctx, stmt); err != nil {
+				return done, fmt.Errorf("sqlstore: migration %d (%s): %w", m.Version, m.Description, err)
+			}
+		}
+		record := fmt.Sprintf("INSERT INTO %s (version, description, applied_at) VALUES (%s)", s.migrationsTable(), s.placeholders(3))
+		if _, err := conn.ExecContext(ctx, record, m.Version, m.Description, time.Now().UTC().Format(time.RFC3339)); err != nil {
+			return done, fmt.Errorf("sqlstore: record migration %d: %w", m.Version, err)
+		}
+		done = append(done, m)
+	}
+	return done, nil
+}
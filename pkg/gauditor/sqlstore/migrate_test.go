@@ -0,0 +1,190 @@
+package sqlstore
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	gauditor "github.com/antoniomarcosferreira/gauditor/pkg/gauditor"
+)
+
+func TestMigrate_AppliesPendingOnce(t *testing.T) {
+	ctx := context.Background()
+	s := New(openSQLite(t))
+	pend, err := s.PendingMigrations(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pend) != len(s.Migrations()) {
+		t.Fatalf("want all %d pending, got %d", len(s.Migrations()), len(pend))
+	}
+	done, err := s.Migrate(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(done) != len(pend) {
+		t.Fatalf("want %d applied, got %d", len(pend), len(done))
+	}
+	done, err = s.Migrate(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(done) != 0 {
+		t.Fatalf("want nothing applied on rerun, got %d", len(done))
+	}
+	pend, _ = s.PendingMigrations(ctx)
+	if len(pend) != 0 {
+		t.Fatalf("want no pending, got %d", len(pend))
+	}
+}
+
+func TestMigrate_AdoptsLegacyTable(t *testing.T) {
+	ctx := context.Background()
+	db := openSQLite(t)
+	// Table as created before migrations existed.
+	for _, stmt := range SQLite.SchemaStatements("gauditor_events") {
+		if _, err := db.ExecContext(ctx, stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := db.ExecContext(ctx, "INSERT INTO gauditor_events (id, ts, tenant, action) VALUES ('old', '2024-01-01T00:00:00.000000000Z', 't', 'x')"); err != nil {
+		t.Fatal(err)
+	}
+	s := New(db)
+	if err := s.EnsureSchema(ctx); err != nil {
+		t.Fatalf("ensure schema over legacy table: %v", err)
+	}
+	var n int
+	if err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM gauditor_events").Scan(&n); err != nil || n != 1 {
+		t.Fatalf("legacy row lost: n=%d err=%v", n, err)
+	}
+}
+
+func TestMigrate_ConcurrentInstances(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "shared.db")
+	const instances = 4
+	var (
+		wg    sync.WaitGroup
+		mu    sync.Mutex
+		total int
+		errs  []error
+	)
+	for i := 0; i < instances; i++ {
+		s := New(openSQLiteFile(t, path), WithTablePrefix("app_"))
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			done, err := s.Migrate(ctx)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs = append(errs, fmt.Errorf("instance %d: %w", i, err))
+			}
+			total += len(done)
+		}(i)
+	}
+	wg.Wait()
+	if len(errs) > 0 {
+		t.Fatalf("concurrent migrate errors: %v", errs)
+	}
+	if total != len(migrations) {
+		t.Fatalf("want each migration applied exactly once (%d), got %d", len(migrations), total)
+	}
+}
+
+func TestMigrate_OptionEnabledLater(t *testing.T) {
+	ctx := context.Background()
+	db := openSQLite(t)
+	if _, err := New(db, WithOutbox()).Migrate(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// Native JSON has a sequence of its own, so it applies after the outbox.
+	s := New(db, WithOutbox(), WithNativeJSON())
+	done, err := s.Migrate(ctx)
+	if err != nil || len(done) != 1 || done[0].Version != nativeJSONMigration.Version {
+		t.Fatalf("want the native JSON migration applied, got %+v %v", done, err)
+	}
+
+	// A pending migration below an applied one of its sequence is refused.
+	record := fmt.Sprintf("INSERT INTO %s (version, description, applied_at) VALUES (%d, 'future', 'now')", s.migrationsTable(), outboxTenantMigration.Version+1)
+	if _, err := db.ExecContext(ctx, record); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s WHERE version = %d", s.migrationsTable(), outboxTenantMigration.Version)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Migrate(ctx); err == nil {
+		t.Fatal("want an error for a migration pending out of order")
+	}
+}
+
+func TestMigrate_FailureRollsBackOnSQLite(t *testing.T) {
+	ctx := context.Background()
+	db := openSQLite(t)
+	s := New(db)
+	if err := s.EnsureSchema(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Save(ctx, gauditor.Event{ID: "e1", Timestamp: time.Now(), Tenant: "t", Action: "x"}); err != nil {
+		t.Fatal(err)
+	}
+	// A migration that succeeds, then one failing after its rebuild dropped
+	// the table, as a rebuild interrupted before its rename would.
+	core := migrations
+	t.Cleanup(func() { migrations = core })
+	next := core[len(core)-1].Version + 1
+	migrations = append(core[:len(core):len(core)],
+		Migration{Version: next, Description: "add column", Up: func(_ Dialect, table string) []string {
+			return []string{"ALTER TABLE " + table + " ADD COLUMN note TEXT"}
+		}},
+		Migration{Version: next + 1, Description: "broken rebuild", Up: func(_ Dialect, table string) []string {
+			rebuild := sqliteRebuild(table, "CREATE TABLE %s (id TEXT)", "id")
+			return append(rebuild[:len(rebuild)-1], "SELECT no_such_column FROM "+table+"_rekey")
+		}},
+	)
+	if done, err := s.Migrate(ctx); err == nil || len(done) != 0 {
+		t.Fatalf("want the failure and nothing applied, got %+v %v", done, err)
+	}
+	if e, err := s.Get(ctx, "t", "e1"); err != nil || e.Action != "x" {
+		t.Fatalf("table must be unchanged: %+v %v", e, err)
+	}
+	if _, err := db.ExecContext(ctx, "SELECT note FROM gauditor_events"); err == nil {
+		t.Fatal("the successful migration must be rolled back too")
+	}
+	if pend, err := s.PendingMigrations(ctx); err != nil || len(pend) != 2 {
+		t.Fatalf("want both migrations pending, got %+v %v", pend, err)
+	}
+}
+
+func TestMigrate_RebuildKeepsInterruptedCopy(t *testing.T) {
+	ctx := context.Background()
+	db := openSQLite(t)
+	s := New(db)
+	// The state an interrupted rebuild committed before rollback existed: the
+	// rows only in the copy, the table itself gone, migration 3 pending.
+	for _, stmt := range append(SQLite.SchemaStatements("gauditor_events_rekey"),
+		"INSERT INTO gauditor_events_rekey (id, ts, tenant, action) VALUES ('e1', '2024-01-01T00:00:00.000000000Z', 't', 'x')") {
+		if _, err := db.ExecContext(ctx, stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.ensureMigrationsTable(ctx, db); err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []int{1, 2} {
+		if _, err := db.ExecContext(ctx, fmt.Sprintf("INSERT INTO %s (version, description, applied_at) VALUES (%d, 'old', 'now')", s.migrationsTable(), v)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := s.Migrate(ctx); err == nil {
+		t.Fatal("want the rebuild refused without its table")
+	}
+	var n int
+	if err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM gauditor_events_rekey").Scan(&n); err != nil || n != 1 {
+		t.Fatalf("the copy must be kept: n=%d err=%v", n, err)
+	}
+}
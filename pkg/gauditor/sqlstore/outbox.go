@@ -0,0 +1,205 @@
+package sqlstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	gauditor "github.com/antoniomarcosferreira/gauditor/pkg/gauditor"
+)
+
+// WithOutbox additionally writes every saved event into the "<table>_outbox"
+// table in the same transaction. A Relay forwards outbox entries to other
+// Storage backends and deletes them once delivered.
+func WithOutbox() Option { return func(s *Store) { s.outbox = true } }
+
+// outboxMigration creates the outbox table when WithOutbox is set.
+var outboxMigration = Migration{
+	Version:     2001,
+	Description: "transactional outbox table",
+	Up: func(d Dialect, table string) []string {
+		payload := "TEXT"
+		if d.Name() == "mysql" {
+			payload = "LONGTEXT"
+		}
+		outbox := table + "_outbox"
+		return []string{
+			fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+  id         VARCHAR(64) PRIMARY KEY,
+  seq        VARCHAR(40) NOT NULL,
+  payload    %s NOT NULL,
+  attempts   INTEGER NOT NULL DEFAULT 0,
+  last_error TEXT NULL
+)`, outbox, payload),
+			d.CreateIndex(outbox, indexName(outbox, "seq"), "seq"),
+		}
+	},
+}
+
+// outboxTenantMigration keys outbox entries by tenant and id, like events
+// (see tenantKeyStatements). Entries enqueued before it keep an empty tenant.
+var outboxTenantMigration = Migration{
+	Version:     2002,
+	Description: "key outbox entries by tenant and id",
+	Up: func(d Dialect, table string) []string {
+		outbox := table + "_outbox"
+		switch d.Name() {
+		case "postgres":
+			add := fmt.Sprintf("ALTER TABLE %s ADD COLUMN IF NOT EXISTS tenant VARCHAR(128) NOT NULL DEFAULT ''", outbox)
+			return append([]string{add}, postgresRekey(outbox, "tenant, id")...)
+		case "mysql":
+			return []string{fmt.Sprintf("ALTER TABLE %s ADD COLUMN tenant VARCHAR(128) NOT NULL DEFAULT '', DROP PRIMARY KEY, ADD PRIMARY KEY (tenant, id)", outbox)}
+		case "sqlite":
+			rebuild := sqliteRebuild(outbox, `CREATE TABLE %s (
+  tenant     TEXT NOT NULL DEFAULT '',
+  id         TEXT NOT NULL,
+  seq        TEXT NOT NULL,
+  payload    TEXT NOT NULL,
+  attempts   INTEGER NOT NULL DEFAULT 0,
+  last_error TEXT NULL,
+  PRIMARY KEY (tenant, id)
+)`, "id, seq, payload, attempts, last_error")
+			return append(rebuild, d.CreateIndex(outbox, indexName(outbox, "seq"), "seq"))
+		}
+		return nil
+	},
+}
+
+func (s *Store) outboxTable() string { return s.table + "_outbox" }
+
+// enqueue writes the outbox entry for e. seq orders entries by event time
+// using the fixed-width text layout, which sorts the same on every dialect.
+func (s *Store) enqueue(ctx context.Context, exec execer, e gauditor.Event) error {
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	query := fmt.Sprintf("INSERT INTO %s (tenant, id, seq, payload) VALUES (%s)", s.outboxTable(), s.placeholders(4))
+	_, err = exec.ExecContext(ctx, query, e.Tenant, e.ID, e.Timestamp.UTC().Format(sqliteTimeLayout), string(payload))
+	return err
+}
+
+// Relay forwards outbox entries to other Storage backends with at-least-once
+// delivery: an entry is deleted only after every target accepted it, so a crash
+// or a failing target leads to redelivery. Targets should therefore tolerate
+// duplicate event IDs.
+//
+// Run a single Relay per outbox table; concurrent relays may deliver the same
+// entry more than once.
+type Relay struct {
+	store    *Store
+	targets  []gauditor.Storage
+	interval time.Duration
+	batch    int
+	onError  func(error)
+}
+
+// RelayOption configures a Relay.
+type RelayOption func(*Relay)
+
+// WithPollInterval sets how often the outbox is polled when idle or after a
+// failure. Default: 1s.
+func WithPollInterval(d time.Duration) RelayOption { return func(r *Relay) { r.interval = d } }
+
+// WithBatchSize sets how many entries are read per poll. Default: 100.
+func WithBatchSize(n int) RelayOption { return func(r *Relay) { r.batch = n } }
+
+// WithRelayErrorHandler receives delivery errors from Run. Default: ignored.
+func WithRelayErrorHandler(fn func(error)) RelayOption { return func(r *Relay) { r.onError = fn } }
+
+// NewRelay constructs a Relay draining the outbox of store into targets.
+// store must be configured WithOutbox.
+func NewRelay(store *Store, targets []gauditor.Storage, opts ...RelayOption) *Relay {
+	r := &Relay{store: store, targets: targets, interval: time.Second, batch: 100, onError: func(error) {}}
+	for _, o := range opts {
+		o(r)
+	}
+	return r
+}
+
+// Run forwards entries until ctx is cancelled, then returns ctx.Err().
+func (r *Relay) Run(ctx context.Context) error {
+	for {
+		n, err := r.ForwardOnce(ctx)
+		if err != nil && !errors.Is(err, context.Canceled) {
+			r.onError(err)
+		}
+		if n == r.batch && err == nil {
+			continue // more may be waiting
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(r.interval):
+		}
+	}
+}
+
+type outboxEntry struct {
+	tenant  string
+	id      string
+	payload string
+}
+
+// ForwardOnce delivers up to one batch of entries in event-time order and
+// returns how many were delivered. It stops at the first failure, recording
+// the attempt on the entry, so later entries are not delivered ahead of it.
+func (r *Relay) ForwardOnce(ctx context.Context) (int, error) {
+	s := r.store
+	query := fmt.Sprintf("SELECT tenant, id, payload FROM %s ORDER BY seq ASC, tenant ASC, id ASC LIMIT %s", s.outboxTable(), s.dialect.Placeholder(1))
+	rows, err := s.bb.QueryContext(ctx, query, r.batch)
+	if err != nil {
+		return 0, err
+	}
+	var entries []outboxEntry
+	for rows.Next() {
+		var en outboxEntry
+		if err := rows.Scan(&en.tenant, &en.id, &en.payload); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		entries = append(entries, en)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	del := fmt.Sprintf("DELETE FROM %s WHERE tenant = %s AND id = %s", s.outboxTable(), s.dialect.Placeholder(1), s.dialect.Placeholder(2))
+	for i, en := range entries {
+		if err := r.deliver(ctx, en); err != nil {
+			r.recordFailure(en, err)
+			return i, fmt.Errorf("sqlstore: relay event %s: %w", en.id, err)
+		}
+		if _, err := s.bb.ExecContext(ctx, del, en.tenant, en.id); err != nil {
+			return i, err
+		}
+	}
+	return len(entries), nil
+}
+
+func (r *Relay) deliver(ctx context.Context, en outboxEntry) error {
+	var e gauditor.Event
+	if err := json.Unmarshal([]byte(en.payload), &e); err != nil {
+		return err
+	}
+	for _, t := range r.targets {
+		// A duplicate is an earlier delivery that was not acknowledged.
+		if _, err := t.Save(ctx, e); err != nil && !errors.Is(err, gauditor.ErrDuplicateEvent) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Relay) recordFailure(en outboxEntry, cause error) {
+	s := r.store
+	query := fmt.Sprintf("UPDATE %s SET attempts = attempts + 1, last_error = %s WHERE tenant = %s AND id = %s",
+		s.outboxTable(), s.dialect.Placeholder(1), s.dialect.Placeholder(2), s.dialect.Placeholder(3))
+	// Use a fresh context: the failure may stem from ctx being cancelled.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, _ = s.bb.ExecContext(ctx, query, cause.Error(), en.tenant, en.id)
+}
@@ -0,0 +1,202 @@
+package sqlstore
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	gauditor "github.com/antoniomarcosferreira/gauditor/pkg/gauditor"
+)
+
+func countRows(t *testing.T, s *Store, table string) int {
+	t.Helper()
+	var n int
+	if err := s.bb.QueryRowContext(context.Background(), "SELECT COUNT(*) FROM "+table).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestSaveTx_CommitsAndRollsBackWithCaller(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t)
+	e := gauditor.Event{ID: "e1", Timestamp: time.Now().UTC(), Tenant: "t", Action: "x"}
+
+	tx, err := s.bb.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.SaveTx(ctx, tx, e); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	if n := countRows(t, s, s.table); n != 0 {
+		t.Fatalf("rolled back audit row persisted: %d", n)
+	}
+
+	tx, _ = s.bb.BeginTx(ctx, nil)
+	if _, err := s.SaveTx(ctx, tx, e); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if n := countRows(t, s, s.table); n != 1 {
+		t.Fatalf("committed audit row missing: %d", n)
+	}
+}
+
+func TestRecorder_ContextWithTx(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t)
+	rec := gauditor.NewRecorder(s)
+	if _, err := s.bb.ExecContext(ctx, "CREATE TABLE accounts (id TEXT PRIMARY KEY)"); err != nil {
+		t.Fatal(err)
+	}
+
+	tx, _ := s.bb.BeginTx(ctx, nil)
+	if _, err := tx.ExecContext(ctx, "INSERT INTO accounts (id) VALUES ('a1')"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rec.Record(ContextWithTx(ctx, tx), gauditor.Event{Tenant: "t", Action: "account.create"}); err != nil {
+		t.Fatal(err)
+	}
+	_ = tx.Rollback()
+	if countRows(t, s, "accounts") != 0 || countRows(t, s, s.table) != 0 {
+		t.Fatalf("business and audit rows must roll back together")
+	}
+
+	tx, _ = s.bb.BeginTx(ctx, nil)
+	_, _ = tx.ExecContext(ctx, "INSERT INTO accounts (id) VALUES ('a1')")
+	if _, err := rec.Record(ContextWithTx(ctx, tx), gauditor.Event{Tenant: "t", Action: "account.create"}); err != nil {
+		t.Fatal(err)
+	}
+	_ = tx.Commit()
+	if countRows(t, s, "accounts") != 1 || countRows(t, s, s.table) != 1 {
+		t.Fatalf("business and audit rows must commit together")
+	}
+}
+
+func TestSaveTx_CreatesPartitionOutsideTx(t *testing.T) {
+	ctx := context.Background()
+	s := newPartitionedStore(t)
+	april := time.Date(2024, 4, 2, 0, 0, 0, 0, time.UTC)
+
+	// The partition outlives a rolled back transaction and is remembered.
+	tx, _ := s.bb.BeginTx(ctx, nil)
+	if _, err := s.SaveTx(ctx, tx, gauditor.Event{ID: "gone", Timestamp: april, Tenant: "t", Action: "x"}); err != nil {
+		t.Fatal(err)
+	}
+	_ = tx.Rollback()
+	if !s.partitions.ready[s.partitionName(monthStart(april))] {
+		t.Fatal("partition created for a transaction is not cached")
+	}
+	parts, err := s.Partitions(ctx)
+	if err != nil || len(parts) == 0 || parts[len(parts)-1].Name != s.partitionName(monthStart(april)) {
+		t.Fatalf("partition rolled back with the caller: %v %v", parts, err)
+	}
+
+	tx, _ = s.bb.BeginTx(ctx, nil)
+	e := gauditor.Event{ID: "p1", Timestamp: april, Tenant: "t", Action: "x"}
+	if _, err := s.SaveTx(ctx, tx, e); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	res, err := s.Query(ctx, gauditor.Query{Tenant: "t"})
+	if err != nil || len(res) != 1 {
+		t.Fatalf("event saved in tx not queryable: %v %v", res, err)
+	}
+}
+
+// flakyStorage fails the first n saves.
+type flakyStorage struct {
+	*gauditor.MemoryStorage
+	failures int32
+}
+
+func (f *flakyStorage) Save(ctx context.Context, e gauditor.Event) (gauditor.Event, error) {
+	if atomic.AddInt32(&f.failures, -1) >= 0 {
+		return e, errors.New("target unavailable")
+	}
+	return f.MemoryStorage.Save(ctx, e)
+}
+
+func TestOutbox_RelayAtLeastOnce(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t, WithOutbox())
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// Rolled back events never reach the outbox.
+	tx, _ := s.bb.BeginTx(ctx, nil)
+	_, _ = s.SaveTx(ctx, tx, gauditor.Event{ID: "gone", Timestamp: base, Tenant: "t", Action: "x"})
+	_ = tx.Rollback()
+
+	for i, id := range []string{"b", "a", "c"} {
+		if _, err := s.Save(ctx, gauditor.Event{ID: id, Timestamp: base.Add(time.Duration(i) * time.Second), Tenant: "t", Action: "x"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := countRows(t, s, s.outboxTable()); n != 3 {
+		t.Fatalf("want 3 outbox entries, got %d", n)
+	}
+
+	target := &flakyStorage{MemoryStorage: gauditor.NewMemoryStorage(), failures: 1}
+	relay := NewRelay(s, []gauditor.Storage{target})
+
+	if n, err := relay.ForwardOnce(ctx); err == nil || n != 0 {
+		t.Fatalf("want failure on first delivery, got n=%d err=%v", n, err)
+	}
+	var attempts int
+	var lastErr string
+	if err := s.bb.QueryRowContext(ctx, "SELECT attempts, last_error FROM "+s.outboxTable()+" WHERE id = 'b'").Scan(&attempts, &lastErr); err != nil {
+		t.Fatal(err)
+	}
+	if attempts != 1 || lastErr != "target unavailable" {
+		t.Fatalf("failure not recorded: attempts=%d err=%q", attempts, lastErr)
+	}
+
+	// An earlier delivery of "a" that was never acknowledged is not an error.
+	_, _ = target.MemoryStorage.Save(ctx, gauditor.Event{ID: "a", Timestamp: base.Add(time.Second), Tenant: "t", Action: "x"})
+	n, err := relay.ForwardOnce(ctx)
+	if err != nil || n != 3 {
+		t.Fatalf("want 3 delivered, got n=%d err=%v", n, err)
+	}
+	if n := countRows(t, s, s.outboxTable()); n != 0 {
+		t.Fatalf("delivered entries must be removed, %d left", n)
+	}
+	got, _ := target.Query(ctx, gauditor.Query{Tenant: "t"})
+	if len(got) != 3 || got[0].ID != "b" || got[2].ID != "c" {
+		t.Fatalf("unexpected forwarded events: %+v", got)
+	}
+}
+
+func TestOutbox_RelayRunStopsOnCancel(t *testing.T) {
+	s := newTestStore(t, WithOutbox())
+	_, _ = s.Save(context.Background(), gauditor.Event{ID: "r1", Timestamp: time.Now().UTC(), Tenant: "t", Action: "x"})
+	target := gauditor.NewMemoryStorage()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- NewRelay(s, []gauditor.Storage{target}, WithPollInterval(5*time.Millisecond)).Run(ctx) }()
+
+	deadline := time.After(2 * time.Second)
+	for {
+		if res, _ := target.Query(context.Background(), gauditor.Query{}); len(res) == 1 {
+			break
+		}
+		select {
+		case <-deadline:
+			t.Fatal("relay did not forward in time")
+		case <-time.After(5 * time.Millisecond):
+		}
+	}
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Fatalf("want context.Canceled, got %v", err)
+	}
+}
@@ -0,0 +1,320 @@
+package sqlstore
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	gauditor "github.com/antoniomarcosferreira/gauditor/pkg/gauditor"
+)
+
+// Partition is one monthly range of the events table, covering [From, To).
+type Partition struct {
+	Name string
+	From time.Time
+	To   time.Time
+}
+
+// partitioning holds the monthly partitioning settings and the partitions
+// already known to exist.
+type partitioning struct {
+	ahead int
+
+	mu    sync.Mutex
+	ready map[string]bool
+}
+
+// WithMonthlyPartitions range-partitions the events table by month (UTC).
+//
+// Postgres uses native declarative partitioning: the table is created with
+// PARTITION BY RANGE (ts) and a primary key of (id, ts), so enable it on a new
+// table. Other dialects keep the base table for existing rows and write new
+// events to one table per month named "<table>_pYYYYMM".
+//
+// Partitions are created on demand when an event for a new month is saved; EnsureSchema
+// and MaintainPartitions also create the current month plus ahead upcoming months.
+// On-demand creation runs outside any caller transaction (see SaveTx). SQLite
+// allows one writer, so callers that write in a transaction before SaveTx
+// should rely on MaintainPartitions having created the month in advance.
+func WithMonthlyPartitions(ahead int) Option {
+	return func(s *Store) {
+		if ahead < 0 {
+			ahead = 0
+		}
+		s.partitions = &partitioning{ahead: ahead, ready: make(map[string]bool)}
+	}
+}
+
+// WithPartitionRetention makes MaintainPartitions drop partitions older than the
+// given number of whole months before the current one. Zero keeps everything.
+// It has no effect without WithMonthlyPartitions.
+func WithPartitionRetention(months int) Option {
+	return func(s *Store) { s.retainMonths = months }
+}
+
+// nativePartitioner is implemented by dialects with declarative partitioning.
+type nativePartitioner interface {
+	PartitionedSchemaStatements(table string) []string
+	CreatePartition(table, partition string, from, to time.Time) string
+}
+
+func (postgresDialect) PartitionedSchemaStatements(table string) []string {
+	return []string{
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+  id          VARCHAR(64) NOT NULL,
+  ts          TIMESTAMPTZ NOT NULL,
+  tenant      VARCHAR(128) NOT NULL,
+  actor_id    VARCHAR(128) NULL,
+  action      VARCHAR(128) NOT NULL,
+  target_id   VARCHAR(128) NULL,
+  actor_json  TEXT NULL,
+  target_json TEXT NULL,
+  data_json   TEXT NULL,
+  PRIMARY KEY (id, ts)
+) PARTITION BY RANGE (ts)`, table),
+		fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s (tenant, ts)", indexName(table, "tenant_ts"), table),
+	}
+}
+
+func (postgresDialect) CreatePartition(table, partition string, from, to time.Time) string {
+	return fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s PARTITION OF %s FOR VALUES FROM ('%s') TO ('%s')",
+		partition, table, from.UTC().Format(time.RFC3339), to.UTC().Format(time.RFC3339))
+}
+
+// partitionedPostgres swaps in the partitioned parent DDL for migration 1.
+type partitionedPostgres struct{ postgresDialect }
+
+func (partitionedPostgres) SchemaStatements(table string) []string {
+	return postgresDialect{}.PartitionedSchemaStatements(table)
+}
+
+// migrationDialect is the dialect handed to migrations of the base table.
+func (s *Store) migrationDialect() Dialect {
+	if s.partitions != nil {
+		if _, ok := s.dialect.(postgresDialect); ok {
+			return partitionedPostgres{}
+		}
+	}
+	return s.dialect
+}
+
+func (s *Store) native() (nativePartitioner, bool) {
+	np, ok := s.dialect.(nativePartitioner)
+	return np, ok
+}
+
+func monthStart(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+func (s *Store) partitionName(month time.Time) string {
+	return s.table + "_p" + month.Format("200601")
+}
+
+// insertTable returns the table an event with timestamp ts is written to,
+// creating its partition when needed.
+func (s *Store) insertTable(ctx context.Context, ts time.Time) (string, error) {
+	if s.partitions == nil {
+		return s.table, nil
+	}
+	name, err := s.ensurePartition(ctx, monthStart(ts))
+	if err != nil {
+		return "", err
+	}
+	if _, ok := s.native(); ok {
+		return s.table, nil
+	}
+	return name, nil
+}
+
+// ensurePartition creates the partition for month if it is not known to exist.
+// Fallback tables are created in the shape of the core migrations up to 3,
+// then replay the later planned migrations and data path indexes, so they
+// match the base table's layout.
+//
+// The DDL always runs on the pool, never in a caller's transaction: MySQL
+// commits the open transaction implicitly on DDL, and a partition created
+// outside it survives a rollback, so it can be remembered.
+func (s *Store) ensurePartition(ctx context.Context, month time.Time) (string, error) {
+	name := s.partitionName(month)
+	p := s.partitions
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.ready[name] {
+		return name, nil
+	}
+	var stmts []string
+	if np, ok := s.native(); ok {
+		stmts = []string{np.CreatePartition(s.table, name, month, month.AddDate(0, 1, 0))}
+	} else {
+		exists, err := s.tableExists(ctx, name)
+		if err != nil {
+			return "", err
+		}
+		if exists {
+			p.ready[name] = true
+			return name, nil
+		}
+		stmts = tenantKeyedTable(s.dialect, name)
+		for _, m := range s.Migrations() {
+			if m.Version <= tenantKeyVersion || m.Version/sequenceBlock == outboxMigration.Version/sequenceBlock {
+				continue // created above; the outbox is shared, not per partition
+			}
+			stmts = append(stmts, m.Up(s.dialect, name)...)
+		}
+		for _, dp := range s.dataPaths {
+			path, err := identPath(dp)
+			if err != nil {
+				return "", err
+			}
+			stmts = append(stmts, s.dialect.DataPathIndex(name, path)...)
+		}
+	}
+	for _, stmt := range stmts {
+		if _, err := s.bb.ExecContext(ctx, stmt); err != nil && !isDuplicateDDL(err) {
+			return "", fmt.Errorf("sqlstore: create partition %s: %w", name, err)
+		}
+	}
+	p.ready[name] = true
+	return name, nil
+}
+
+// tableExists reports whether the table name exists in the current schema.
+func (s *Store) tableExists(ctx context.Context, name string) (bool, error) {
+	rows, err := s.bb.QueryContext(ctx, s.dialect.TablesLikeQuery(), name)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var got string
+		if err := rows.Scan(&got); err != nil {
+			return false, err
+		}
+		if got == name { // "_" in name matches any character
+			return true, nil
+		}
+	}
+	return false, rows.Err()
+}
+
+// Partitions lists the existing monthly partitions in chronological order.
+func (s *Store) Partitions(ctx context.Context) ([]Partition, error) {
+	if s.partitions == nil {
+		return nil, nil
+	}
+	rows, err := s.bb.QueryContext(ctx, s.dialect.TablesLikeQuery(), s.table+"_p%")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	pattern := regexp.MustCompile("^" + regexp.QuoteMeta(s.table) + `_p(\d{6})$`)
+	var out []Partition
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		m := pattern.FindStringSubmatch(name)
+		if m == nil {
+			continue
+		}
+		from, err := time.Parse("200601", m[1])
+		if err != nil {
+			continue
+		}
+		out = append(out, Partition{Name: name, From: from, To: from.AddDate(0, 1, 0)})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].From.Before(out[j].From) })
+	return out, nil
+}
+
+// queryTables returns the tables a query must read. Native partitioning prunes
+// in the database; the fallback reads the base table plus every monthly table
+// overlapping [Since, Until].
+func (s *Store) queryTables(ctx context.Context, q gauditor.Query) ([]string, error) {
+	if s.partitions == nil {
+		return []string{s.table}, nil
+	}
+	if _, ok := s.native(); ok {
+		return []string{s.table}, nil
+	}
+	parts, err := s.Partitions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	tables := []string{s.table}
+	for _, p := range parts {
+		if q.Since != nil && !p.To.After(*q.Since) {
+			continue
+		}
+		if q.Until != nil && p.From.After(*q.Until) {
+			continue
+		}
+		tables = append(tables, p.Name)
+	}
+	return tables, nil
+}
+
+// EnsurePartitions creates the partitions for the month containing from and
+// the following ahead months.
+func (s *Store) EnsurePartitions(ctx context.Context, from time.Time, ahead int) error {
+	if s.partitions == nil {
+		return nil
+	}
+	month := monthStart(from)
+	for i := 0; i <= ahead; i++ {
+		if _, err := s.ensurePartition(ctx, month.AddDate(0, i, 0)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DropPartitionsBefore drops every partition whose whole range ends at or
+// before cutoff and returns their names. Rows in the base table are untouched.
+func (s *Store) DropPartitionsBefore(ctx context.Context, cutoff time.Time) ([]string, error) {
+	parts, err := s.Partitions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var dropped []string
+	for _, p := range parts {
+		if p.To.After(cutoff) {
+			continue
+		}
+		if _, err := s.bb.ExecContext(ctx, "DROP TABLE "+p.Name); err != nil {
+			return dropped, fmt.Errorf("sqlstore: drop partition %s: %w", p.Name, err)
+		}
+		s.partitions.mu.Lock()
+		delete(s.partitions.ready, p.Name)
+		s.partitions.mu.Unlock()
+		dropped = append(dropped, p.Name)
+	}
+	return dropped, nil
+}
+
+// MaintainPartitions creates upcoming partitions and, with WithPartitionRetention,
+// drops expired ones. Run it periodically (for example daily) with the current time.
+func (s *Store) MaintainPartitions(ctx context.Context, now time.Time) error {
+	if s.partitions == nil {
+		return nil
+	}
+	if err := s.EnsurePartitions(ctx, now, s.partitions.ahead); err != nil {
+		return err
+	}
+	if s.retainMonths > 0 {
+		cutoff := monthStart(now).AddDate(0, -s.retainMonths, 0)
+		if _, err := s.DropPartitionsBefore(ctx, cutoff); err != nil {
+			return err
+		}
+	}
+	return nil
+}
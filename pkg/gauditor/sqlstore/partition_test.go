@@ -0,0 +1,180 @@
+package sqlstore
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	gauditor "github.com/antoniomarcosferreira/gauditor/pkg/gauditor"
+)
+
+func newPartitionedStore(t *testing.T, opts ...Option) *Store {
+	t.Helper()
+	s := New(openSQLite(t), append([]Option{WithMonthlyPartitions(0)}, opts...)...)
+	// Migrate only: EnsureSchema would also create partitions around time.Now().
+	if _, err := s.Migrate(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func partitionNames(t *testing.T, s *Store) string {
+	t.Helper()
+	parts, err := s.Partitions(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, len(parts))
+	for i, p := range parts {
+		names[i] = p.Name
+	}
+	return strings.Join(names, ",")
+}
+
+func TestPartitions_FallbackCreatedTenantKeyed(t *testing.T) {
+	ctx := context.Background()
+	s := newPartitionedStore(t)
+	if _, err := s.Save(ctx, gauditor.Event{ID: "e1", Timestamp: time.Date(2024, 1, 10, 0, 0, 0, 0, time.UTC), Tenant: "t", Action: "x"}); err != nil {
+		t.Fatal(err)
+	}
+	var ddl string
+	if err := s.bb.QueryRowContext(ctx, "SELECT sql FROM sqlite_master WHERE name = 'gauditor_events_p202401'").Scan(&ddl); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(ddl, "PRIMARY KEY (tenant, id)") {
+		t.Fatalf("partition not created tenant keyed: %s", ddl)
+	}
+	var n int
+	if err := s.bb.QueryRowContext(ctx, "SELECT COUNT(*) FROM sqlite_master WHERE type = 'index' AND tbl_name = 'gauditor_events_p202401' AND name LIKE 'idx_%'").Scan(&n); err != nil || n != 4 {
+		t.Fatalf("want the tenant/time and lookup indexes, got %d %v", n, err)
+	}
+}
+
+func TestPartitions_FallbackRoutesPrunesAndDrops(t *testing.T) {
+	ctx := context.Background()
+	s := newPartitionedStore(t)
+	// A row written before partitioning was enabled stays in the base table.
+	legacy := gauditor.Event{ID: "legacy", Timestamp: time.Date(2023, 12, 31, 0, 0, 0, 0, time.UTC), Tenant: "t", Action: "x"}
+	if err := s.insert(ctx, s.bb, s.table, legacy); err != nil {
+		t.Fatal(err)
+	}
+	for i, month := range []time.Month{time.January, time.February, time.March} {
+		e := gauditor.Event{ID: month.String(), Timestamp: time.Date(2024, month, 10, 0, 0, 0, 0, time.UTC), Tenant: "t", Action: "x"}
+		if i == 1 {
+			e.Action = "y"
+		}
+		if _, err := s.Save(ctx, e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := partitionNames(t, s); got != "gauditor_events_p202401,gauditor_events_p202402,gauditor_events_p202403" {
+		t.Fatalf("unexpected partitions: %s", got)
+	}
+
+	for _, id := range []string{"legacy", "February"} {
+		if e, err := s.Get(ctx, "t", id); err != nil || e.ID != id {
+			t.Fatalf("Get %s across partitions: %+v, %v", id, e, err)
+		}
+	}
+
+	all, err := s.Query(ctx, gauditor.Query{Tenant: "t"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 4 || all[0].ID != "legacy" || all[3].ID != "March" {
+		t.Fatalf("union across partitions not ordered: %+v", all)
+	}
+
+	since := time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)
+	tables, err := s.queryTables(ctx, gauditor.Query{Since: &since})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(tables, ",") != "gauditor_events,gauditor_events_p202402,gauditor_events_p202403" {
+		t.Fatalf("since did not prune: %v", tables)
+	}
+	until := time.Date(2024, 1, 31, 0, 0, 0, 0, time.UTC)
+	tables, _ = s.queryTables(ctx, gauditor.Query{Until: &until})
+	if strings.Join(tables, ",") != "gauditor_events,gauditor_events_p202401" {
+		t.Fatalf("until did not prune: %v", tables)
+	}
+
+	res, _ := s.Query(ctx, gauditor.Query{Tenant: "t", Since: &since, Limit: 1})
+	if len(res) != 1 || res[0].ID != "February" {
+		t.Fatalf("limit must apply across partitions: %+v", res)
+	}
+	res, _ = s.Query(ctx, gauditor.Query{Action: "y"})
+	if len(res) != 1 || res[0].ID != "February" {
+		t.Fatalf("filters must apply to every partition: %+v", res)
+	}
+
+	dropped, err := s.DropPartitionsBefore(ctx, time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(dropped, ",") != "gauditor_events_p202401,gauditor_events_p202402" {
+		t.Fatalf("unexpected dropped partitions: %v", dropped)
+	}
+	res, _ = s.Query(ctx, gauditor.Query{Tenant: "t"})
+	if len(res) != 2 || res[1].ID != "March" {
+		t.Fatalf("dropped partitions still visible: %+v", res)
+	}
+
+	// Writing to a dropped month recreates its partition.
+	if _, err := s.Save(ctx, gauditor.Event{ID: "late", Timestamp: time.Date(2024, 1, 20, 0, 0, 0, 0, time.UTC), Tenant: "t", Action: "x"}); err != nil {
+		t.Fatalf("save into dropped month: %v", err)
+	}
+}
+
+func TestPartitions_MaintainCreatesAheadAndAppliesRetention(t *testing.T) {
+	ctx := context.Background()
+	s := newPartitionedStore(t, WithMonthlyPartitions(2), WithPartitionRetention(1))
+	if err := s.MaintainPartitions(ctx, time.Date(2024, 5, 15, 0, 0, 0, 0, time.UTC)); err != nil {
+		t.Fatal(err)
+	}
+	if got := partitionNames(t, s); got != "gauditor_events_p202405,gauditor_events_p202406,gauditor_events_p202407" {
+		t.Fatalf("unexpected partitions after first run: %s", got)
+	}
+	if err := s.MaintainPartitions(ctx, time.Date(2024, 7, 2, 0, 0, 0, 0, time.UTC)); err != nil {
+		t.Fatal(err)
+	}
+	if got := partitionNames(t, s); got != "gauditor_events_p202406,gauditor_events_p202407,gauditor_events_p202408,gauditor_events_p202409" {
+		t.Fatalf("unexpected partitions after retention: %s", got)
+	}
+}
+
+func TestPartitions_FallbackTablesCarryIndexes(t *testing.T) {
+	ctx := context.Background()
+	s := newPartitionedStore(t, WithIndexedDataPaths("order.id"))
+	if err := s.EnsurePartitions(ctx, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), 0); err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	err := s.bb.QueryRowContext(ctx, "SELECT COUNT(*) FROM sqlite_master WHERE type = 'index' AND tbl_name = 'gauditor_events_p202401' AND name LIKE 'idx_%'").Scan(&n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// tenant/ts, actor, action, target and the data path index.
+	if n != 5 {
+		t.Fatalf("want 5 indexes on partition, got %d", n)
+	}
+}
+
+func TestPartitions_PostgresNativeDDL(t *testing.T) {
+	s := &Store{table: "gauditor_events", dialect: Postgres}
+	WithMonthlyPartitions(1)(s)
+	ddl := s.migrationDialect().SchemaStatements(s.table)
+	if !strings.Contains(ddl[0], "PARTITION BY RANGE (ts)") || !strings.Contains(ddl[0], "PRIMARY KEY (id, ts)") {
+		t.Fatalf("unexpected partitioned DDL: %s", ddl[0])
+	}
+	got := Postgres.(nativePartitioner).CreatePartition(s.table, s.partitionName(time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)), time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC))
+	want := "CREATE TABLE IF NOT EXISTS gauditor_events_p202402 PARTITION OF gauditor_events FOR VALUES FROM ('2024-02-01T00:00:00Z') TO ('2024-03-01T00:00:00Z')"
+	if got != want {
+		t.Fatalf("partition DDL:\n got %s\nwant %s", got, want)
+	}
+	tables, _ := s.queryTables(context.Background(), gauditor.Query{})
+	if len(tables) != 1 || tables[0] != "gauditor_events" {
+		t.Fatalf("native partitioning should query the parent: %v", tables)
+	}
+}
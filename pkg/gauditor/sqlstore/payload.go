@@ -0,0 +1,255 @@
+package sqlstore
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// DataFilter is one gauditor.Query.Data predicate handed to Dialect.DataPredicate.
+type DataFilter struct {
+	// Path holds the object keys from the root of Event.Data, e.g. ["order", "id"].
+	Path []string
+	// Value is the expected JSON scalar.
+	Value any
+	// Native reports whether payload columns use the database JSON type
+	// (see WithNativeJSON).
+	Native bool
+	// Indexed reports whether the path was declared with WithIndexedDataPaths.
+	Indexed bool
+}
+
+// WithNativeJSON stores actor/target/data payloads in the database's JSON type:
+// JSONB with GIN indexes on Postgres, JSON on MySQL. SQLite keeps TEXT and uses
+// its JSON functions. Existing tables are converted by an extra migration.
+func WithNativeJSON() Option { return func(s *Store) { s.nativeJSON = true } }
+
+// WithIndexedDataPaths declares hot Event.Data paths (dot-separated, e.g.
+// "order.id") that get a dedicated index: a generated column on MySQL, an
+// expression index on SQLite. Postgres relies on the JSONB GIN index instead.
+// Path segments may only contain letters, digits and underscores.
+func WithIndexedDataPaths(paths ...string) Option {
+	return func(s *Store) { s.dataPaths = append(s.dataPaths, paths...) }
+}
+
+var (
+	errInvalidDataPath = errors.New("sqlstore: invalid data path")
+	identSegment       = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+)
+
+// splitDataPath validates a dot-separated path. Segments are embedded in JSON
+// path literals, so quotes and backslashes are rejected.
+func splitDataPath(path string) ([]string, error) {
+	parts := strings.Split(path, ".")
+	for _, p := range parts {
+		if p == "" || strings.ContainsAny(p, `"'\`) {
+			return nil, fmt.Errorf("%w %q", errInvalidDataPath, path)
+		}
+	}
+	return parts, nil
+}
+
+// identPath validates a path used in DDL, where segments become identifiers.
+func identPath(path string) ([]string, error) {
+	parts, err := splitDataPath(path)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range parts {
+		if !identSegment.MatchString(p) {
+			return nil, fmt.Errorf("%w %q: segments must match [A-Za-z0-9_]+", errInvalidDataPath, path)
+		}
+	}
+	return parts, nil
+}
+
+// jsonPathExpr renders a MySQL/SQLite JSON path such as $."order"."id". It is
+// inlined as a SQL literal so SQLite can match expression indexes.
+func jsonPathExpr(path []string) string {
+	var b strings.Builder
+	b.WriteString("$")
+	for _, p := range path {
+		b.WriteString(`."`)
+		b.WriteString(p)
+		b.WriteString(`"`)
+	}
+	return b.String()
+}
+
+// nestedJSON builds {"order":{"id":value}} for Postgres containment.
+func nestedJSON(path []string, value any) (string, error) {
+	v := value
+	for i := len(path) - 1; i >= 0; i-- {
+		v = map[string]any{path[i]: v}
+	}
+	b, err := json.Marshal(v)
+	return string(b), err
+}
+
+// scalarText renders a JSON scalar the way JSON_UNQUOTE/->> would.
+func scalarText(v any) (string, error) {
+	if s, ok := v.(string); ok {
+		return s, nil
+	}
+	b, err := json.Marshal(v)
+	return string(b), err
+}
+
+// dataColumn names the index of a path, e.g. data_order_id, and the MySQL
+// generated column of releases that declared it VARCHAR(255).
+func dataColumn(path []string) string {
+	return column("data_", path)
+}
+
+// textColumn names the MySQL generated column for a path, e.g. data_text_order_id.
+func textColumn(path []string) string {
+	return column("data_text_", path)
+}
+
+func column(prefix string, path []string) string {
+	name := prefix + strings.ToLower(strings.Join(path, "_"))
+	if len(name) > 64 {
+		name = name[:64]
+	}
+	return name
+}
+
+// nativeJSONMigration converts payload columns when WithNativeJSON is set. It is
+// part of the plan only for stores with the option, so enabling it later still
+// applies it once.
+var nativeJSONMigration = Migration{
+	Version:     1001,
+	Description: "native JSON payload columns",
+	Up:          func(d Dialect, table string) []string { return d.NativeJSONStatements(table) },
+}
+
+// Postgres
+
+func (postgresDialect) NativeJSONStatements(table string) []string {
+	return []string{
+		fmt.Sprintf(`ALTER TABLE %s
+  ALTER COLUMN actor_json TYPE JSONB USING actor_json::jsonb,
+  ALTER COLUMN target_json TYPE JSONB USING target_json::jsonb,
+  ALTER COLUMN data_json TYPE JSONB USING data_json::jsonb`, table),
+		fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s USING GIN (data_json jsonb_path_ops)", indexName(table, "data_gin"), table),
+		fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s USING GIN (actor_json jsonb_path_ops)", indexName(table, "actor_gin"), table),
+	}
+}
+
+// DataPredicate uses JSONB containment (@>), which the GIN index serves, and
+// compares the value at the path too: containment alone would match objects
+// and arrays holding more than the filter. TEXT columns are cast on the fly
+// and scanned without an index.
+func (postgresDialect) DataPredicate(f DataFilter, bind func(any) string) (string, error) {
+	doc, err := nestedJSON(f.Path, f.Value)
+	if err != nil {
+		return "", err
+	}
+	value, err := json.Marshal(f.Value)
+	if err != nil {
+		return "", err
+	}
+	col := "data_json"
+	if !f.Native {
+		col = "CAST(data_json AS JSONB)"
+	}
+	pred := col + " @> CAST(" + bind(doc) + " AS JSONB)"
+	keys := make([]string, len(f.Path))
+	for i, p := range f.Path {
+		keys[i] = bind(p)
+	}
+	return fmt.Sprintf("%s AND %s #> ARRAY[%s]::text[] = CAST(%s AS JSONB)",
+		pred, col, strings.Join(keys, ", "), bind(string(value))), nil
+}
+
+func (postgresDialect) DataPathIndex(string, []string) []string { return nil }
+
+// MySQL
+
+func (mysqlDialect) NativeJSONStatements(table string) []string {
+	return []string{fmt.Sprintf("ALTER TABLE %s MODIFY actor_json JSON NULL, MODIFY target_json JSON NULL, MODIFY data_json JSON NULL", table)}
+}
+
+// DataPredicate compares the generated column of indexed paths, checking the
+// JSON type as the column holds text: true must not match "true". Other paths
+// and non-scalar values compare the extracted JSON, which is equal only when
+// it is the whole value (JSON_CONTAINS would accept a part of it).
+func (mysqlDialect) DataPredicate(f DataFilter, bind func(any) string) (string, error) {
+	extract := "JSON_EXTRACT(data_json, '" + jsonPathExpr(f.Path) + "')"
+	if types, ok := mysqlJSONTypes(f.Value); f.Indexed && ok {
+		text, err := scalarText(f.Value)
+		if err != nil {
+			return "", err
+		}
+		return textColumn(f.Path) + " = " + bind(text) + " AND JSON_TYPE(" + extract + ") IN (" + types + ")", nil
+	}
+	doc, err := json.Marshal(f.Value)
+	if err != nil {
+		return "", err
+	}
+	return extract + " = CAST(" + bind(string(doc)) + " AS JSON)", nil
+}
+
+// mysqlJSONTypes lists the JSON_TYPE names a scalar filter value matches.
+func mysqlJSONTypes(v any) (string, bool) {
+	switch v.(type) {
+	case nil:
+		return "'NULL'", true
+	case string:
+		return "'STRING'", true
+	case bool:
+		return "'BOOLEAN'", true
+	case float64, float32, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return "'INTEGER', 'UNSIGNED INTEGER', 'DOUBLE', 'DECIMAL'", true
+	}
+	return "", false
+}
+
+// DataPathIndex adds a virtual LONGTEXT column extracting the path, so no value
+// is too long for it, and indexes a prefix of it together with tenant. The
+// VARCHAR(255) column of earlier releases, which rejected longer values, is
+// dropped with its index.
+func (mysqlDialect) DataPathIndex(table string, path []string) []string {
+	col := textColumn(path)
+	return []string{
+		fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s", table, dataColumn(path)),
+		fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s LONGTEXT GENERATED ALWAYS AS (JSON_UNQUOTE(JSON_EXTRACT(data_json, '%s'))) VIRTUAL", table, col, jsonPathExpr(path)),
+		fmt.Sprintf("CREATE INDEX %s ON %s (tenant, %s(255))", indexName(table, col), table, col),
+	}
+}
+
+// SQLite
+
+func (sqliteDialect) NativeJSONStatements(string) []string { return nil }
+
+// DataPredicate uses json_extract, which returns SQL scalars (booleans as 0/1).
+func (sqliteDialect) DataPredicate(f DataFilter, bind func(any) string) (string, error) {
+	expr := "json_extract(data_json, '" + jsonPathExpr(f.Path) + "')"
+	switch f.Value.(type) {
+	case nil:
+		return "json_type(data_json, '" + jsonPathExpr(f.Path) + "') = 'null'", nil
+	case string, bool, float64, float32, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return expr + " = " + bind(f.Value), nil
+	}
+	return "", fmt.Errorf("sqlstore: data filter %q: sqlite supports scalar values only", strings.Join(f.Path, "."))
+}
+
+// DataPathIndex creates an expression index matching DataPredicate.
+func (sqliteDialect) DataPathIndex(table string, path []string) []string {
+	return []string{fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s (json_extract(data_json, '%s'))",
+		indexName(table, dataColumn(path)), table, jsonPathExpr(path))}
+}
+
+// isDuplicateDDL reports errors for re-adding an existing column or index, or
+// dropping a missing one, which ensureDataPathIndexes treats as already
+// applied: MySQL errors 1060, 1061 and 1091, or SQLSTATE 42701 and 42P07
+// (lib/pq, pgx).
+func isDuplicateDDL(err error) bool {
+	if n, ok := mysqlErrorNumber(err); ok {
+		return n == 1060 || n == 1061 || n == 1091
+	}
+	var state interface{ SQLState() string }
+	return errors.As(err, &state) && (state.SQLState() == "42701" || state.SQLState() == "42P07")
+}
@@ -0,0 +1,189 @@
+package sqlstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+
+	gauditor "github.com/antoniomarcosferreira/gauditor/pkg/gauditor"
+)
+
+func seedPayloads(t *testing.T, s *Store) {
+	t.Helper()
+	base := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	seed := []gauditor.Event{
+		{ID: "1", Timestamp: base, Tenant: "t", Action: "pay", Data: map[string]any{"order": map[string]any{"id": "o1", "total": 10}, "paid": true}},
+		{ID: "2", Timestamp: base.Add(time.Minute), Tenant: "t", Action: "pay", Data: map[string]any{"order": map[string]any{"id": "o2", "total": 12.5}, "paid": false}},
+		{ID: "3", Timestamp: base.Add(2 * time.Minute), Tenant: "t", Action: "note"},
+	}
+	for _, e := range seed {
+		if _, err := s.Save(context.Background(), e); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestQuery_DataPredicates_SQLite(t *testing.T) {
+	s := newTestStore(t)
+	seedPayloads(t, s)
+	cases := []struct {
+		data map[string]any
+		want string
+	}{
+		{map[string]any{"order.id": "o2"}, "2"},
+		{map[string]any{"order.total": 10}, "1"},
+		{map[string]any{"order.total": 12.5}, "2"},
+		{map[string]any{"paid": true}, "1"},
+		{map[string]any{"order.id": "o1", "paid": false}, ""},
+	}
+	for _, tc := range cases {
+		res, err := s.Query(context.Background(), gauditor.Query{Tenant: "t", Data: tc.data})
+		if err != nil {
+			t.Fatalf("%v: %v", tc.data, err)
+		}
+		ids := make([]string, len(res))
+		for i, e := range res {
+			ids[i] = e.ID
+		}
+		if got := strings.Join(ids, ","); got != tc.want {
+			t.Fatalf("%v: want %q, got %q", tc.data, tc.want, got)
+		}
+	}
+}
+
+func TestQuery_InvalidDataPath(t *testing.T) {
+	s := newTestStore(t)
+	_, err := s.Query(context.Background(), gauditor.Query{Data: map[string]any{"a'); DROP TABLE x; --": 1}})
+	if !errors.Is(err, errInvalidDataPath) {
+		t.Fatalf("want invalid path error, got %v", err)
+	}
+}
+
+func TestIndexedDataPaths_SQLiteUsesExpressionIndex(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t, WithIndexedDataPaths("order.id"))
+	// Second run must be a no-op.
+	if err := s.EnsureSchema(ctx); err != nil {
+		t.Fatal(err)
+	}
+	seedPayloads(t, s)
+	rows, err := s.bb.QueryContext(ctx, `EXPLAIN QUERY PLAN SELECT id FROM gauditor_events WHERE json_extract(data_json, '$."order"."id"') = ?`, "o1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var plan strings.Builder
+	for rows.Next() {
+		var id, parent, notused int
+		var detail string
+		if err := rows.Scan(&id, &parent, &notused, &detail); err != nil {
+			t.Fatal(err)
+		}
+		plan.WriteString(detail)
+	}
+	if !strings.Contains(plan.String(), "idx_gauditor_events_data_order_id") {
+		t.Fatalf("expression index not used: %s", plan.String())
+	}
+}
+
+func TestIndexedDataPaths_RejectsNonIdentifier(t *testing.T) {
+	s := New(openSQLite(t), WithIndexedDataPaths("order id"))
+	if err := s.EnsureSchema(context.Background()); !errors.Is(err, errInvalidDataPath) {
+		t.Fatalf("want invalid path error, got %v", err)
+	}
+}
+
+func TestNativeJSON_AddsMigration(t *testing.T) {
+	s := New(openSQLite(t))
+	if len(s.Migrations()) != len(migrations) {
+		t.Fatalf("native JSON migration planned without option")
+	}
+	s = New(openSQLite(t), WithNativeJSON())
+	ms := s.Migrations()
+	if ms[len(ms)-1].Version != nativeJSONMigration.Version {
+		t.Fatalf("native JSON migration missing: %+v", ms)
+	}
+	if err := s.EnsureSchema(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	seedPayloads(t, s)
+	res, err := s.Query(context.Background(), gauditor.Query{Data: map[string]any{"order.id": "o1"}})
+	if err != nil || len(res) != 1 {
+		t.Fatalf("query with native JSON on sqlite: %v %v", res, err)
+	}
+}
+
+func TestDataPredicate_PostgresAndMySQL(t *testing.T) {
+	var args []any
+	bind := func(v any) string { args = append(args, v); return Postgres.Placeholder(len(args)) }
+	f := DataFilter{Path: []string{"order", "id"}, Value: "o1", Native: true}
+
+	pred, err := Postgres.DataPredicate(f, bind)
+	want := `data_json @> CAST($1 AS JSONB) AND data_json #> ARRAY[$2, $3]::text[] = CAST($4 AS JSONB)`
+	if err != nil || pred != want || args[0] != `{"order":{"id":"o1"}}` || args[1] != "order" || args[3] != `"o1"` {
+		t.Fatalf("postgres jsonb predicate: %s %v %v", pred, args, err)
+	}
+	args = nil
+	f.Native = false
+	if pred, _ = Postgres.DataPredicate(f, bind); !strings.HasPrefix(pred, "CAST(data_json AS JSONB) @>") ||
+		!strings.Contains(pred, "CAST(data_json AS JSONB) #> ARRAY[$2, $3]::text[] = CAST($4 AS JSONB)") {
+		t.Fatalf("postgres text predicate: %s", pred)
+	}
+
+	args = nil
+	pred, _ = MySQL.DataPredicate(f, bind)
+	if pred != `JSON_EXTRACT(data_json, '$."order"."id"') = CAST($1 AS JSON)` || args[0] != `"o1"` {
+		t.Fatalf("mysql predicate: %s %v", pred, args)
+	}
+	args = nil
+	f.Indexed = true
+	f.Value = 42
+	pred, _ = MySQL.DataPredicate(f, bind)
+	if pred != `data_text_order_id = $1 AND JSON_TYPE(JSON_EXTRACT(data_json, '$."order"."id"')) IN ('INTEGER', 'UNSIGNED INTEGER', 'DOUBLE', 'DECIMAL')` || args[0] != "42" {
+		t.Fatalf("mysql generated column predicate: %s %v", pred, args)
+	}
+	args = nil
+	f.Value = true
+	if pred, _ = MySQL.DataPredicate(f, bind); !strings.HasSuffix(pred, "IN ('BOOLEAN')") || args[0] != "true" {
+		t.Fatalf("mysql bool predicate must check the JSON type: %s %v", pred, args)
+	}
+	args = nil
+	f.Value = map[string]any{"a": 1}
+	if pred, _ = MySQL.DataPredicate(f, bind); !strings.HasSuffix(pred, "= CAST($1 AS JSON)") {
+		t.Fatalf("mysql object predicate must compare JSON: %s", pred)
+	}
+	ddl := MySQL.DataPathIndex("gauditor_events", []string{"order", "id"})
+	if len(ddl) != 3 || ddl[0] != "ALTER TABLE gauditor_events DROP COLUMN data_order_id" ||
+		!strings.Contains(ddl[1], "data_text_order_id LONGTEXT GENERATED ALWAYS AS") ||
+		!strings.HasSuffix(ddl[2], "(tenant, data_text_order_id(255))") {
+		t.Fatalf("mysql generated column DDL: %v", ddl)
+	}
+	if stmts := Postgres.NativeJSONStatements("gauditor_events"); !strings.Contains(stmts[1], "USING GIN (data_json jsonb_path_ops)") {
+		t.Fatalf("postgres GIN index missing: %v", stmts)
+	}
+}
+
+func TestIsDuplicateDDL_DriverCodes(t *testing.T) {
+	for err, want := range map[error]bool{
+		&mysql.MySQLError{Number: 1060}:                          true,
+		&mysql.MySQLError{Number: 1091}:                          true,
+		fmt.Errorf("index: %w", &mysql.MySQLError{Number: 1061}): true,
+		&mysql.MySQLError{Number: 1062}:                          false,
+		&pq.Error{Code: "42P07"}:                                 true,
+		&pq.Error{Code: "42601"}:                                 false,
+		errors.New("Error 1060: Duplicate column name"):          false,
+	} {
+		if got := isDuplicateDDL(err); got != want {
+			t.Errorf("isDuplicateDDL(%v) = %v, want %v", err, got, want)
+		}
+	}
+	if !isDuplicateKey(&mysql.MySQLError{Number: 1062}) {
+		t.Error("MySQL 1062 is a duplicate key")
+	}
+}
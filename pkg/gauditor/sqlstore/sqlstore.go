@@ -4,25 +4,55 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"reflect"
+	"sort"
+	"strings"
 	"time"
 
 	gauditor "github.com/antoniomarcosferreira/gauditor/pkg/gauditor"
 )
 
 // Store implements gauditor.Storage using database/sql.
-// Compatible with Postgres and MySQL given the simple schema.
+// SQL differences between Postgres, MySQL and SQLite are handled by a Dialect.
 type Store struct {
-	bb    *sql.DB
-	table string
+	bb         *sql.DB
+	table      string
+	dialect    Dialect
+	nativeJSON bool
+	dataPaths  []string
+	partitions *partitioning
+	outbox     bool
+
+	retainMonths int
 }
 
 // New constructs a Store backed by the provided *sql.DB.
-func New(db *sql.DB) *Store { return &Store{bb: db, table: "gauditor_events"} }
+// Unless WithDialect or WithDriverName is given, the dialect is detected from
+// the driver registered on db.
+func New(db *sql.DB, opts ...Option) *Store {
+	s := &Store{bb: db, table: "gauditor_events"}
+	s.ApplyOptions(opts...)
+	return s
+}
 
 // Option configures the Store.
 type Option func(*Store)
 
+// WithDialect sets the SQL dialect explicitly.
+func WithDialect(d Dialect) Option { return func(s *Store) { s.dialect = d } }
+
+// WithDriverName selects the dialect by database/sql driver name, for example
+// "postgres", "pgx", "mysql" or "sqlite". Unknown names leave detection to New.
+func WithDriverName(name string) Option {
+	return func(s *Store) {
+		if d, ok := DialectForDriver(name); ok {
+			s.dialect = d
+		}
+	}
+}
+
 // WithTablePrefix sets a prefix for the table name. The base table is "gauditor_events".
 func WithTablePrefix(prefix string) Option {
 	return func(s *Store) { s.table = prefix + "gauditor_events" }
@@ -36,92 +66,272 @@ func (s *Store) ApplyOptions(opts ...Option) *Store {
 	for _, o := range opts {
 		o(s)
 	}
+	if s.dialect == nil && s.bb != nil {
+		s.dialect = detectDialect(s.bb.Driver())
+	}
 	return s
 }
 
-// EnsureSchema creates the minimal table if it does not exist.
-// For Postgres/MySQL compatible types.
+// Dialect returns the SQL dialect in use.
+func (s *Store) Dialect() Dialect { return s.dialect }
+
+// EnsureSchema brings the schema up to date by applying pending migrations,
+// then indexes the data paths declared with WithIndexedDataPaths and, with
+// WithMonthlyPartitions, creates the current and upcoming partitions.
+// Tables created by earlier versions are adopted, since the initial migration
+// only creates what is missing.
 func (s *Store) EnsureSchema(ctx context.Context) error {
-	stmt := fmt.Sprintf(`
-CREATE TABLE IF NOT EXISTS %s (
-  id        VARCHAR(64) PRIMARY KEY,
-  ts        TIMESTAMP NOT NULL,
-  tenant    VARCHAR(128) NOT NULL,
-  actor_id  VARCHAR(128) NULL,
-  action    VARCHAR(128) NOT NULL,
-  target_id VARCHAR(128) NULL,
-  actor_json   TEXT NULL,
-  target_json  TEXT NULL,
-  data_json    TEXT NULL
-);
-CREATE INDEX IF NOT EXISTS idx_%s_tenant_ts ON %s(tenant, ts);
-`, s.table, s.table, s.table)
-	_, err := s.bb.ExecContext(ctx, stmt)
-	return err
+	if _, err := s.Migrate(ctx); err != nil {
+		return err
+	}
+	if err := s.ensureDataPathIndexes(ctx); err != nil {
+		return err
+	}
+	if s.partitions != nil {
+		return s.EnsurePartitions(ctx, time.Now(), s.partitions.ahead)
+	}
+	return nil
+}
+
+// ensureDataPathIndexes is idempotent: duplicate column/index errors, and
+// missing column errors on drop, from dialects without IF [NOT] EXISTS are
+// ignored.
+func (s *Store) ensureDataPathIndexes(ctx context.Context) error {
+	for _, p := range s.dataPaths {
+		path, err := identPath(p)
+		if err != nil {
+			return err
+		}
+		for _, stmt := range s.dialect.DataPathIndex(s.table, path) {
+			if _, err := s.bb.ExecContext(ctx, stmt); err != nil && !isDuplicateDDL(err) {
+				return fmt.Errorf("sqlstore: index data path %q: %w", p, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Store) isIndexedPath(path string) bool {
+	for _, p := range s.dataPaths {
+		if p == path {
+			return true
+		}
+	}
+	return false
+}
+
+// execer and queryer are satisfied by *sql.DB, *sql.Conn and *sql.Tx.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+type execQueryer interface {
+	execer
+	queryer
 }
 
 // Save inserts the event row. JSON columns store full structs as JSON.
+// With partitioning enabled, the month's partition is created first if needed.
+//
+// When ctx carries a transaction (see ContextWithTx) the row is written through
+// it. With WithOutbox, the event and its outbox entry are written atomically.
 func (s *Store) Save(ctx context.Context, e gauditor.Event) (gauditor.Event, error) {
-	actorJSON, targetJSON, dataJSON, err := marshalParts(e)
+	if tx, ok := txFromContext(ctx); ok {
+		return s.SaveTx(ctx, tx, e)
+	}
+	if s.outbox {
+		tx, err := s.bb.BeginTx(ctx, nil)
+		if err != nil {
+			return e, err
+		}
+		if _, err := s.SaveTx(ctx, tx, e); err != nil {
+			_ = tx.Rollback()
+			return e, err
+		}
+		return e, tx.Commit()
+	}
+	table, err := s.insertTable(ctx, e.Timestamp)
 	if err != nil {
 		return e, err
 	}
-	query := fmt.Sprintf("INSERT INTO %s (id, ts, tenant, actor_id, action, target_id, actor_json, target_json, data_json) VALUES (?,?,?,?,?,?,?,?,?)", s.table)
-	_, err = s.bb.ExecContext(ctx,
+	return e, s.insert(ctx, s.bb, table, e)
+}
+
+// insert writes e into table using exec, which may be the pool or a transaction.
+// An ID already stored for the tenant fails with gauditor.ErrDuplicateEvent.
+func (s *Store) insert(ctx context.Context, exec execQueryer, table string, e gauditor.Event) error {
+	actorJSON, targetJSON, dataJSON, err := marshalParts(e)
+	if err != nil {
+		return err
+	}
+	if s.partitions != nil {
+		if dup, err := s.exists(ctx, exec, e.Tenant, e.ID); err != nil {
+			return err
+		} else if dup {
+			return fmt.Errorf("%w: %s", gauditor.ErrDuplicateEvent, e.ID)
+		}
+	}
+	query := fmt.Sprintf("INSERT INTO %s (id, ts, tenant, actor_id, action, target_id, actor_json, target_json, data_json) VALUES (%s)", table, s.placeholders(9))
+	_, err = exec.ExecContext(ctx,
 		query,
-		e.ID, e.Timestamp, e.Tenant, e.Actor.ID, e.Action, e.Target.ID, actorJSON, targetJSON, dataJSON,
+		e.ID, s.dialect.TimeValue(e.Timestamp), e.Tenant, e.Actor.ID, e.Action, e.Target.ID, string(actorJSON), string(targetJSON), string(dataJSON),
 	)
-	return e, err
+	if isDuplicateKey(err) {
+		return fmt.Errorf("%w: %s: %v", gauditor.ErrDuplicateEvent, e.ID, err)
+	}
+	return err
+}
+
+// isDuplicateKey reports whether err is a primary key or unique violation,
+// without importing the drivers: SQLSTATE 23505 (lib/pq, pgx), MySQL error
+// 1062, or SQLite extended codes 1555 and 2067 (modernc, mattn).
+func isDuplicateKey(err error) bool {
+	if err == nil {
+		return false
+	}
+	if n, ok := mysqlErrorNumber(err); ok {
+		return n == 1062
+	}
+	var state interface{ SQLState() string }
+	if errors.As(err, &state) && state.SQLState() == "23505" {
+		return true
+	}
+	var coded interface{ Code() int }
+	if errors.As(err, &coded) && (coded.Code() == 1555 || coded.Code() == 2067) {
+		return true
+	}
+	// mattn/go-sqlite3 exposes its codes as fields only.
+	return strings.Contains(err.Error(), "UNIQUE constraint failed")
+}
+
+// mysqlErrorNumber returns the Number of a go-sql-driver/mysql *MySQLError in
+// err's chain. The driver exposes it as a field only, so it is read by
+// reflection rather than importing the driver.
+func mysqlErrorNumber(err error) (uint16, bool) {
+	for ; err != nil; err = errors.Unwrap(err) {
+		v := reflect.ValueOf(err)
+		if v.Kind() != reflect.Pointer || v.Elem().Kind() != reflect.Struct || v.Elem().Type().Name() != "MySQLError" {
+			continue
+		}
+		if n := v.Elem().FieldByName("Number"); n.Kind() == reflect.Uint16 {
+			return uint16(n.Uint()), true
+		}
+	}
+	return 0, false
 }
 
+// selectColumns lists the columns scanned by scanEvents, in order.
+const selectColumns = "id, ts, tenant, actor_id, action, target_id, actor_json, target_json, data_json"
+
 // Query selects rows with simple filters and maps them back to events.
+// With table-per-period partitioning, only tables overlapping Since/Until are read.
 func (s *Store) Query(ctx context.Context, q gauditor.Query) ([]gauditor.Event, error) {
+	tables, err := s.queryTables(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	args := make([]any, 0, 7)
+	bind := func(v any) string {
+		args = append(args, v)
+		return s.dialect.Placeholder(len(args))
+	}
+	selects := make([]string, 0, len(tables))
+	for _, table := range tables {
+		where, err := s.where(q, bind)
+		if err != nil {
+			return nil, err
+		}
+		selects = append(selects, fmt.Sprintf("SELECT %s FROM %s %s", selectColumns, table, where))
+	}
+	limit := ""
+	if q.Limit > 0 {
+		limit = " LIMIT " + bind(q.Limit)
+	}
+	qstr := strings.Join(selects, " UNION ALL ") + " ORDER BY ts ASC" + limit
+	rows, err := s.bb.QueryContext(ctx, qstr, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanEvents(rows)
+}
+
+// Ping checks that the database answers.
+func (s *Store) Ping(ctx context.Context) error { return s.bb.PingContext(ctx) }
+
+// Get looks the event up by its primary key. With table-per-period
+// partitioning every monthly table is probed.
+func (s *Store) Get(ctx context.Context, tenant, id string) (gauditor.Event, error) {
+	rows, err := s.byID(ctx, s.bb, selectColumns, tenant, id)
+	if err != nil {
+		return gauditor.Event{}, err
+	}
+	defer rows.Close()
+	events, err := scanEvents(rows)
+	if err != nil {
+		return gauditor.Event{}, err
+	}
+	if len(events) == 0 {
+		return gauditor.Event{}, fmt.Errorf("%w: %s", gauditor.ErrNotFound, id)
+	}
+	return events[0], nil
+}
+
+// where renders the WHERE clause for q, binding arguments through bind.
+func (s *Store) where(q gauditor.Query, bind func(any) string) (string, error) {
 	where := "WHERE 1=1"
-	args := make([]any, 0, 6)
 	if q.Tenant != "" {
-		where += " AND tenant = ?"
-		args = append(args, q.Tenant)
+		where += " AND tenant = " + bind(q.Tenant)
 	}
 	if q.ActorID != "" {
-		where += " AND actor_id = ?"
-		args = append(args, q.ActorID)
+		where += " AND actor_id = " + bind(q.ActorID)
 	}
 	if q.Action != "" {
-		where += " AND action = ?"
-		args = append(args, q.Action)
+		where += " AND action = " + bind(q.Action)
 	}
 	if q.TargetID != "" {
-		where += " AND target_id = ?"
-		args = append(args, q.TargetID)
+		where += " AND target_id = " + bind(q.TargetID)
 	}
 	if q.Since != nil {
-		where += " AND ts >= ?"
-		args = append(args, q.Since)
+		where += " AND ts >= " + bind(s.dialect.TimeValue(*q.Since))
 	}
 	if q.Until != nil {
-		where += " AND ts <= ?"
-		args = append(args, q.Until)
+		where += " AND ts <= " + bind(s.dialect.TimeValue(*q.Until))
 	}
-	limit := ""
-	if q.Limit > 0 {
-		limit = " LIMIT ?"
-		args = append(args, q.Limit)
+	keys := make([]string, 0, len(q.Data))
+	for k := range q.Data {
+		keys = append(keys, k)
 	}
-	qstr := fmt.Sprintf("SELECT id, ts, tenant, actor_id, action, target_id, actor_json, target_json, data_json FROM %s ", s.table) + where + " ORDER BY ts ASC" + limit
-	rows, err := s.bb.QueryContext(ctx, qstr, args...)
-	if err != nil {
-		return nil, err
+	sort.Strings(keys)
+	for _, k := range keys {
+		path, err := splitDataPath(k)
+		if err != nil {
+			return "", err
+		}
+		pred, err := s.dialect.DataPredicate(DataFilter{Path: path, Value: q.Data[k], Native: s.nativeJSON, Indexed: s.isIndexedPath(k)}, bind)
+		if err != nil {
+			return "", err
+		}
+		where += " AND " + pred
 	}
-	defer rows.Close()
+	return where, nil
+}
+
+// scanEvents maps rows selected with selectColumns back to events.
+func scanEvents(rows *sql.Rows) ([]gauditor.Event, error) {
 	out := make([]gauditor.Event, 0)
 	for rows.Next() {
-		var id, tenant, actorID, action, targetID string
-		var ts time.Time
-		var actorJSON, targetJSON, dataJSON sql.NullString
+		var id, tenant, action string
+		var ts scanTime
+		var actorID, targetID, actorJSON, targetJSON, dataJSON sql.NullString
 		if err := rows.Scan(&id, &ts, &tenant, &actorID, &action, &targetID, &actorJSON, &targetJSON, &dataJSON); err != nil {
 			return nil, err
 		}
-		e := gauditor.Event{ID: id, Timestamp: ts, Tenant: tenant, Actor: gauditor.Actor{ID: actorID}, Action: action, Target: gauditor.Target{ID: targetID}}
+		e := gauditor.Event{ID: id, Timestamp: ts.t, Tenant: tenant, Actor: gauditor.Actor{ID: actorID.String}, Action: action, Target: gauditor.Target{ID: targetID.String}}
 		if actorJSON.Valid {
 			_ = jsonUnmarshal([]byte(actorJSON.String), &e.Actor)
 		}
@@ -136,6 +346,15 @@ func (s *Store) Query(ctx context.Context, q gauditor.Query) ([]gauditor.Event,
 	return out, rows.Err()
 }
 
+// placeholders returns a comma-separated list of n bind parameters.
+func (s *Store) placeholders(n int) string {
+	ps := make([]string, n)
+	for i := range ps {
+		ps[i] = s.dialect.Placeholder(i + 1)
+	}
+	return strings.Join(ps, ",")
+}
+
 // helpers
 func marshalParts(e gauditor.Event) (actor, target, data []byte, err error) {
 	if actor, err = jsonMarshal(e.Actor); err != nil {
@@ -0,0 +1,247 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	gauditor "github.com/antoniomarcosferreira/gauditor/pkg/gauditor"
+	_ "modernc.org/sqlite"
+)
+
+// openSQLite returns a file-backed SQLite database that lives for the test.
+func openSQLite(t *testing.T) *sql.DB {
+	t.Helper()
+	return openSQLiteFile(t, filepath.Join(t.TempDir(), "gauditor.db"))
+}
+
+// openSQLiteFile opens path with a busy timeout so concurrent writers wait.
+func openSQLiteFile(t *testing.T, path string) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite", path+"?_pragma=busy_timeout(5000)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+	return db
+}
+
+func newTestStore(t *testing.T, opts ...Option) *Store {
+	t.Helper()
+	s := New(openSQLite(t), opts...)
+	if err := s.EnsureSchema(context.Background()); err != nil {
+		t.Fatalf("ensure schema: %v", err)
+	}
+	return s
+}
+
+func TestDetectDialect_SQLite(t *testing.T) {
+	s := New(openSQLite(t))
+	if s.Dialect().Name() != "sqlite" {
+		t.Fatalf("want sqlite, got %s", s.Dialect().Name())
+	}
+}
+
+func TestDialectForDriver(t *testing.T) {
+	cases := map[string]string{"postgres": "postgres", "pgx": "postgres", "mysql": "mysql", "sqlite3": "sqlite", "SQLite": "sqlite"}
+	for driverName, want := range cases {
+		d, ok := DialectForDriver(driverName)
+		if !ok || d.Name() != want {
+			t.Fatalf("%s: want %s, got %v", driverName, want, d)
+		}
+	}
+	if _, ok := DialectForDriver("oracle"); ok {
+		t.Fatalf("unexpected dialect for unknown driver")
+	}
+}
+
+func TestPlaceholders(t *testing.T) {
+	s := &Store{dialect: Postgres}
+	if got := s.placeholders(3); got != "$1,$2,$3" {
+		t.Fatalf("postgres placeholders: %s", got)
+	}
+	s.dialect = MySQL
+	if got := s.placeholders(3); got != "?,?,?" {
+		t.Fatalf("mysql placeholders: %s", got)
+	}
+}
+
+func TestSchemaStatements_PerDialect(t *testing.T) {
+	pg := Postgres.SchemaStatements("app_gauditor_events")
+	if !strings.Contains(pg[0], "TIMESTAMPTZ") || !strings.Contains(pg[1], "IF NOT EXISTS idx_app_gauditor_events_tenant_ts") {
+		t.Fatalf("unexpected postgres DDL: %v", pg)
+	}
+	my := MySQL.SchemaStatements("gauditor_events")
+	if len(my) != 1 || strings.Contains(my[0], "CREATE INDEX") || !strings.Contains(my[0], "INDEX idx_gauditor_events_tenant_ts") {
+		t.Fatalf("unexpected mysql DDL: %v", my)
+	}
+}
+
+func TestEnsureSchema_Idempotent(t *testing.T) {
+	s := newTestStore(t)
+	if err := s.EnsureSchema(context.Background()); err != nil {
+		t.Fatalf("second ensure schema: %v", err)
+	}
+}
+
+func TestStore_SaveAndQueryRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t, WithTablePrefix("app_"))
+	ts := time.Date(2024, 5, 1, 10, 0, 0, 123456789, time.UTC)
+	in := gauditor.Event{
+		ID:        "e1",
+		Timestamp: ts,
+		Tenant:    "acme",
+		Actor:     gauditor.Actor{ID: "u1", IP: "10.0.0.1", Attributes: map[string]any{"role": "admin"}},
+		Action:    "update",
+		Target:    gauditor.Target{ID: "doc1", Type: "document"},
+		Data:      map[string]any{"field": "title"},
+	}
+	if _, err := s.Save(ctx, in); err != nil {
+		t.Fatal(err)
+	}
+	res, err := s.Query(ctx, gauditor.Query{Tenant: "acme"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 1 {
+		t.Fatalf("want 1, got %d", len(res))
+	}
+	got := res[0]
+	if !got.Timestamp.Equal(ts) {
+		t.Fatalf("timestamp mismatch: %v", got.Timestamp)
+	}
+	if got.Actor.IP != "10.0.0.1" || got.Actor.Attributes["role"] != "admin" || got.Target.Type != "document" || got.Data["field"] != "title" {
+		t.Fatalf("json parts not restored: %+v", got)
+	}
+}
+
+func TestStore_QueryFiltersOrderAndLimit(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t)
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	seed := []gauditor.Event{
+		{ID: "3", Timestamp: base.Add(3 * time.Hour), Tenant: "t", Actor: gauditor.Actor{ID: "a"}, Action: "x", Target: gauditor.Target{ID: "X"}},
+		{ID: "1", Timestamp: base.Add(1 * time.Hour), Tenant: "t", Actor: gauditor.Actor{ID: "a"}, Action: "y", Target: gauditor.Target{ID: "Y"}},
+		{ID: "2", Timestamp: base.Add(2 * time.Hour), Tenant: "t", Actor: gauditor.Actor{ID: "b"}, Action: "x", Target: gauditor.Target{ID: "X"}},
+		{ID: "4", Timestamp: base.Add(4 * time.Hour), Tenant: "other", Actor: gauditor.Actor{ID: "a"}, Action: "x"},
+	}
+	for _, e := range seed {
+		if _, err := s.Save(ctx, e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ids := func(events []gauditor.Event) string {
+		out := make([]string, len(events))
+		for i, e := range events {
+			out[i] = e.ID
+		}
+		return strings.Join(out, ",")
+	}
+
+	since := base.Add(2 * time.Hour)
+	until := base.Add(3 * time.Hour)
+	cases := []struct {
+		name string
+		q    gauditor.Query
+		want string
+	}{
+		{"tenant sorted", gauditor.Query{Tenant: "t"}, "1,2,3"},
+		{"actor", gauditor.Query{Tenant: "t", ActorID: "a"}, "1,3"},
+		{"action", gauditor.Query{Tenant: "t", Action: "x"}, "2,3"},
+		{"target", gauditor.Query{Tenant: "t", TargetID: "Y"}, "1"},
+		{"since", gauditor.Query{Tenant: "t", Since: &since}, "2,3"},
+		{"until", gauditor.Query{Tenant: "t", Until: &until}, "1,2,3"},
+		{"range", gauditor.Query{Since: &since, Until: &until}, "2,3"},
+		{"limit", gauditor.Query{Tenant: "t", Limit: 2}, "1,2"},
+		{"all tenants", gauditor.Query{ActorID: "a"}, "1,3,4"},
+	}
+	for _, tc := range cases {
+		res, err := s.Query(ctx, tc.q)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if got := ids(res); got != tc.want {
+			t.Fatalf("%s: want %s, got %s", tc.name, tc.want, got)
+		}
+	}
+}
+
+func TestStore_ViaRecorder(t *testing.T) {
+	ctx := context.Background()
+	rec := gauditor.NewRecorder(newTestStore(t))
+	if _, err := rec.Record(ctx, gauditor.Event{Tenant: "acme", Action: "login", Actor: gauditor.Actor{ID: "u1"}}); err != nil {
+		t.Fatal(err)
+	}
+	res, err := rec.Query(ctx, gauditor.Query{Tenant: "acme"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 1 || res[0].Action != "login" || res[0].Actor.ID != "u1" {
+		t.Fatalf("unexpected result: %+v", res)
+	}
+}
+
+func TestStore_DuplicateID(t *testing.T) {
+	ctx := context.Background()
+	for name, opts := range map[string][]Option{
+		"plain":       nil,
+		"partitioned": {WithMonthlyPartitions(0)},
+		"outbox":      {WithOutbox()},
+	} {
+		s := newTestStore(t, opts...)
+		e := gauditor.Event{ID: "dup", Timestamp: time.Now().UTC(), Tenant: "t", Action: "x"}
+		if _, err := s.Save(ctx, e); err != nil {
+			t.Fatal(err)
+		}
+		// A month later lands in another partition, still the same tenant's ID.
+		e.Timestamp = e.Timestamp.AddDate(0, 1, 0)
+		if _, err := s.Save(ctx, e); !errors.Is(err, gauditor.ErrDuplicateEvent) {
+			t.Fatalf("%s: want ErrDuplicateEvent, got %v", name, err)
+		}
+		e.Tenant = "u"
+		if _, err := s.Save(ctx, e); err != nil {
+			t.Fatalf("%s: IDs are scoped to the tenant: %v", name, err)
+		}
+		if got, err := s.Get(ctx, "u", "dup"); err != nil || got.Tenant != "u" {
+			t.Fatalf("%s: %+v %v", name, got, err)
+		}
+	}
+}
+
+func TestStore_Ping(t *testing.T) {
+	ctx := context.Background()
+	db := openSQLite(t)
+	s := New(db)
+	if err := s.Ping(ctx); err != nil {
+		t.Fatal(err)
+	}
+	_ = db.Close()
+	if err := s.Ping(ctx); err == nil {
+		t.Fatal("want an error from a closed database")
+	}
+}
+
+func TestStore_Get(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t)
+	e := gauditor.Event{ID: "e1", Timestamp: time.Now().UTC(), Tenant: "t", Action: "x", Data: map[string]any{"k": "v"}}
+	if _, err := s.Save(ctx, e); err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.Get(ctx, "t", "e1")
+	if err != nil || got.Action != "x" || got.Data["k"] != "v" {
+		t.Fatalf("got %+v, %v", got, err)
+	}
+	for _, tenant := range []string{"t", "other"} {
+		id := map[string]string{"t": "missing", "other": "e1"}[tenant]
+		if _, err := s.Get(ctx, tenant, id); !errors.Is(err, gauditor.ErrNotFound) {
+			t.Fatalf("Get(%s, %s): want ErrNotFound, got %v", tenant, id, err)
+		}
+	}
+}
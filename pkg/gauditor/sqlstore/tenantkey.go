@@ -0,0 +1,138 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	gauditor "github.com/antoniomarcosferreira/gauditor/pkg/gauditor"
+)
+
+// lookupIndexes returns the actor, action and target indexes of migration 2.
+func lookupIndexes(d Dialect, table string) []string {
+	return []string{
+		d.CreateIndex(table, indexName(table, "actor_ts"), "tenant", "actor_id", "ts"),
+		d.CreateIndex(table, indexName(table, "action_ts"), "tenant", "action", "ts"),
+		d.CreateIndex(table, indexName(table, "target_ts"), "tenant", "target_id", "ts"),
+	}
+}
+
+// tenantKeyVersion is the core migration keying events by tenant and id.
+const tenantKeyVersion = 3
+
+// tenantKeyStatements moves the primary key of the events table from id to
+// (tenant, id), so IDs are unique per tenant like gauditor.ErrDuplicateEvent
+// promises, and an ID taken in one tenant stays usable, and invisible, in the
+// others. Native Postgres partitions keep ts in the key, as they must.
+func tenantKeyStatements(d Dialect, table string) []string {
+	switch d.Name() {
+	case "postgres":
+		key := "tenant, id"
+		if _, ok := d.(partitionedPostgres); ok {
+			key += ", ts"
+		}
+		return postgresRekey(table, key)
+	case "mysql":
+		return []string{fmt.Sprintf("ALTER TABLE %s DROP PRIMARY KEY, ADD PRIMARY KEY (tenant, id)", table)}
+	case "sqlite":
+		rebuild := sqliteRebuild(table, "CREATE TABLE %s "+sqliteTenantKeyed, selectColumns)
+		rebuild = append(rebuild, d.CreateIndex(table, indexName(table, "tenant_ts"), "tenant", "ts"))
+		return append(rebuild, lookupIndexes(d, table)...)
+	}
+	return nil
+}
+
+// sqliteTenantKeyed is the SQLite events table as migration 3 leaves it.
+const sqliteTenantKeyed = `(
+  id          TEXT NOT NULL,
+  ts          TEXT NOT NULL,
+  tenant      TEXT NOT NULL,
+  actor_id    TEXT NULL,
+  action      TEXT NOT NULL,
+  target_id   TEXT NULL,
+  actor_json  TEXT NULL,
+  target_json TEXT NULL,
+  data_json   TEXT NULL,
+  PRIMARY KEY (tenant, id)
+)`
+
+// tenantKeyedTable creates table directly as the core migrations up to 3
+// leave it, for monthly tables created after the base one was migrated. On
+// SQLite it skips the rebuild, which would lose rows written to the new table
+// while it copies them; elsewhere re-keying an empty table is one ALTER.
+func tenantKeyedTable(d Dialect, table string) []string {
+	var stmts []string
+	if d.Name() == "sqlite" {
+		stmts = []string{
+			"CREATE TABLE IF NOT EXISTS " + table + " " + sqliteTenantKeyed,
+			d.CreateIndex(table, indexName(table, "tenant_ts"), "tenant", "ts"),
+		}
+	} else {
+		stmts = append(d.SchemaStatements(table), tenantKeyStatements(d, table)...)
+	}
+	return append(stmts, lookupIndexes(d, table)...)
+}
+
+// postgresRekey replaces the primary key of table, whatever its name.
+func postgresRekey(table, key string) []string {
+	return []string{
+		fmt.Sprintf(`DO $$
+DECLARE pk text;
+BEGIN
+  SELECT conname INTO pk FROM pg_constraint WHERE conrelid = '%s'::regclass AND contype = 'p';
+  IF pk IS NOT NULL THEN
+    EXECUTE format('ALTER TABLE %s DROP CONSTRAINT %%I', pk);
+  END IF;
+END $$`, table, table),
+		fmt.Sprintf("ALTER TABLE %s ADD PRIMARY KEY (%s)", table, key),
+	}
+}
+
+// sqliteRebuild copies columns of table into a new table made by create (a
+// format taking the table name) and swaps it in, since SQLite cannot alter a
+// primary key. Indexes are dropped with the old table; callers recreate them.
+// It runs inside Migrate's transaction, so it applies entirely or not at all.
+// The first statement fails when table is missing, so a copy left by an
+// interrupted rebuild is never dropped.
+func sqliteRebuild(table, create, columns string) []string {
+	tmp := table + "_rekey"
+	return []string{
+		"SELECT 1 FROM " + table + " LIMIT 0",
+		"DROP TABLE IF EXISTS " + tmp,
+		fmt.Sprintf(create, tmp),
+		fmt.Sprintf("INSERT INTO %s (%s) SELECT %s FROM %s", tmp, columns, columns, table),
+		"DROP TABLE " + table,
+		fmt.Sprintf("ALTER TABLE %s RENAME TO %s", tmp, table[strings.LastIndex(table, ".")+1:]),
+	}
+}
+
+// exists reports whether tenant already has an event with id, reading through
+// q so a caller's transaction sees its own rows. Partitioned stores need it:
+// monthly tables and native partitions keyed with ts cannot enforce
+// (tenant, id) across months. Concurrent saves of one ID may both pass.
+func (s *Store) exists(ctx context.Context, q queryer, tenant, id string) (bool, error) {
+	rows, err := s.byID(ctx, q, "id", tenant, id)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+	found := rows.Next()
+	return found, rows.Err()
+}
+
+// byID selects cols of the event with id in tenant from every table.
+func (s *Store) byID(ctx context.Context, q queryer, cols, tenant, id string) (*sql.Rows, error) {
+	tables, err := s.queryTables(ctx, gauditor.Query{})
+	if err != nil {
+		return nil, err
+	}
+	args := make([]any, 0, 2*len(tables))
+	selects := make([]string, 0, len(tables))
+	for _, table := range tables {
+		args = append(args, id, tenant)
+		selects = append(selects, fmt.Sprintf("SELECT %s FROM %s WHERE id = %s AND tenant = %s",
+			cols, table, s.dialect.Placeholder(len(args)-1), s.dialect.Placeholder(len(args))))
+	}
+	return q.QueryContext(ctx, strings.Join(selects, " UNION ALL "), args...)
+}
@@ -0,0 +1,52 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+
+	gauditor "github.com/antoniomarcosferreira/gauditor/pkg/gauditor"
+)
+
+type txKey struct{}
+
+// ContextWithTx binds tx to ctx so Store.Save writes through it. This lets a
+// regular gauditor.Recorder record inside the caller's transaction, applying
+// its usual defaults and validation:
+//
+//	tx, _ := db.BeginTx(ctx, nil)
+//	// ... business writes on tx ...
+//	_, err := rec.Record(sqlstore.ContextWithTx(ctx, tx), event)
+//	// commit or roll back both together
+//
+// The transaction must belong to the same database as the Store.
+func ContextWithTx(ctx context.Context, tx *sql.Tx) context.Context {
+	return context.WithValue(ctx, txKey{}, tx)
+}
+
+func txFromContext(ctx context.Context) (*sql.Tx, bool) {
+	tx, ok := ctx.Value(txKey{}).(*sql.Tx)
+	return tx, ok && tx != nil
+}
+
+// SaveTx inserts the event through the caller-supplied transaction, so the audit
+// row commits or rolls back with the business change. With WithOutbox the
+// outbox entry is written in the same transaction. A missing monthly partition
+// is created beforehand on the pool, so no DDL runs in tx.
+//
+// Unlike Recorder.Record, SaveTx applies no defaults or validation; set ID and
+// Timestamp yourself or use ContextWithTx with a Recorder.
+func (s *Store) SaveTx(ctx context.Context, tx *sql.Tx, e gauditor.Event) (gauditor.Event, error) {
+	table, err := s.insertTable(ctx, e.Timestamp)
+	if err != nil {
+		return e, err
+	}
+	if err := s.insert(ctx, tx, table, e); err != nil {
+		return e, err
+	}
+	if s.outbox {
+		if err := s.enqueue(ctx, tx, e); err != nil {
+			return e, err
+		}
+	}
+	return e, nil
+}
@@ -1,10 +1,6 @@
 package gauditor
 
-import (
-	"context"
-	"sort"
-	"sync"
-)
+import "context"
 
 // Storage defines persistence for events.
 type Storage interface {
@@ -12,70 +8,42 @@ type Storage interface {
 	Query(ctx context.Context, query Query) ([]Event, error)
 }
 
-// MemoryStorage is an in-memory implementation of Storage for development/testing.
-type MemoryStorage struct {
-	mu     sync.RWMutex
-	events []Event
+// Getter is implemented by storages that can look up one event by ID without
+// scanning. Get returns ErrNotFound when the tenant has no event with the ID;
+// an event of another tenant is never returned.
+type Getter interface {
+	Get(ctx context.Context, tenant, id string) (Event, error)
 }
 
-// NewMemoryStorage constructs a new MemoryStorage.
-func NewMemoryStorage() *MemoryStorage {
-	return &MemoryStorage{events: make([]Event, 0, 1024)}
+// Pinger is implemented by storages backed by a service that may be
+// unreachable, such as a database. Ping returns an error unless the service
+// answers; storages that do not implement it are always reachable.
+type Pinger interface {
+	Ping(ctx context.Context) error
 }
 
-// Save appends an event.
-func (m *MemoryStorage) Save(ctx context.Context, event Event) (Event, error) {
-	select {
-	case <-ctx.Done():
-		return event, ctx.Err()
-	default:
+// pingStorage pings s when it implements Pinger.
+func pingStorage(ctx context.Context, s Storage) error {
+	if p, ok := s.(Pinger); ok {
+		return p.Ping(ctx)
 	}
-	m.mu.Lock()
-	m.events = append(m.events, event)
-	m.mu.Unlock()
-	return event, nil
+	return nil
 }
 
-// Query returns events matching the filter. Results are sorted by timestamp ascending.
-func (m *MemoryStorage) Query(ctx context.Context, q Query) ([]Event, error) {
-	select {
-	case <-ctx.Done():
-		return nil, ctx.Err()
-	default:
+// getEvent looks id up through Getter when s implements it, and otherwise
+// scans the tenant's events.
+func getEvent(ctx context.Context, s Storage, tenant, id string) (Event, error) {
+	if g, ok := s.(Getter); ok {
+		return g.Get(ctx, tenant, id)
 	}
-	m.mu.RLock()
-	defer m.mu.RUnlock()
-
-	results := make([]Event, 0, len(m.events))
-	for _, e := range m.events {
-		if ctx.Err() != nil {
-			return nil, ctx.Err()
-		}
-		if q.Tenant != "" && e.Tenant != q.Tenant {
-			continue
-		}
-		if q.ActorID != "" && e.Actor.ID != q.ActorID {
-			continue
-		}
-		if q.Action != "" && e.Action != q.Action {
-			continue
-		}
-		if q.TargetID != "" && e.Target.ID != q.TargetID {
-			continue
-		}
-		if q.Since != nil && e.Timestamp.Before(*q.Since) {
-			continue
-		}
-		if q.Until != nil && e.Timestamp.After(*q.Until) {
-			continue
-		}
-		results = append(results, e)
+	events, err := s.Query(ctx, Query{Tenant: tenant})
+	if err != nil {
+		return Event{}, err
 	}
-
-	sort.Slice(results, func(i, j int) bool { return results[i].Timestamp.Before(results[j].Timestamp) })
-
-	if q.Limit > 0 && len(results) > q.Limit {
-		results = results[:q.Limit]
+	for _, e := range events {
+		if e.ID == id && e.Tenant == tenant {
+			return e, nil
+		}
 	}
-	return results, nil
+	return Event{}, ErrNotFound
 }
@@ -2,6 +2,11 @@ package gauditor
 
 import (
 	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 )
@@ -68,3 +73,178 @@ func TestMemoryStorage_TargetFilter(t *testing.T) {
 		t.Fatalf("target filter failed")
 	}
 }
+
+func TestMemoryStorage_DataFilter(t *testing.T) {
+	store := NewMemoryStorage()
+	rec := NewRecorder(store)
+	_, _ = rec.Record(context.Background(), Event{Tenant: "t", Action: "a", Data: map[string]any{"order": map[string]any{"id": "o1", "total": 5}}})
+	_, _ = rec.Record(context.Background(), Event{Tenant: "t", Action: "b", Data: map[string]any{"order": map[string]any{"id": "o2", "total": 7.5}}})
+	_, _ = rec.Record(context.Background(), Event{Tenant: "t", Action: "c"})
+
+	res, err := store.Query(context.Background(), Query{Tenant: "t", Data: map[string]any{"order.id": "o2"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 1 || res[0].Action != "b" {
+		t.Fatalf("data path filter failed: %+v", res)
+	}
+	res, _ = store.Query(context.Background(), Query{Tenant: "t", Data: map[string]any{"order.total": 5.0}})
+	if len(res) != 1 || res[0].Action != "a" {
+		t.Fatalf("numeric data filter should compare as JSON: %+v", res)
+	}
+	res, _ = store.Query(context.Background(), Query{Tenant: "t", Data: map[string]any{"order.id": "o1", "order.total": 7.5}})
+	if len(res) != 0 {
+		t.Fatalf("all data filters must match: %+v", res)
+	}
+}
+
+func TestMemoryStorage_OrderedInsertAndTies(t *testing.T) {
+	store := NewMemoryStorage()
+	ctx := context.Background()
+	t0 := time.Unix(1_000, 0).UTC()
+	for _, e := range []Event{
+		{ID: "c", Tenant: "t", Timestamp: t0.Add(2 * time.Second)},
+		{ID: "a", Tenant: "t", Timestamp: t0},
+		{ID: "b1", Tenant: "t", Timestamp: t0.Add(time.Second)},
+		{ID: "b2", Tenant: "t", Timestamp: t0.Add(time.Second)},
+		{ID: "x", Tenant: "u", Timestamp: t0.Add(time.Second / 2)},
+	} {
+		_, _ = store.Save(ctx, e)
+	}
+	res, _ := store.Query(ctx, Query{Tenant: "t"})
+	if got := eventIDs(res); got != "a b1 b2 c" {
+		t.Fatalf("want timestamp order with ties in insertion order, got %q", got)
+	}
+	res, _ = store.Query(ctx, Query{Limit: 3})
+	if got := eventIDs(res); got != "a x b1" {
+		t.Fatalf("cross-tenant query must merge in order before the limit, got %q", got)
+	}
+}
+
+func TestMemoryStorage_IndexesMatchScan(t *testing.T) {
+	ctx := context.Background()
+	plain := NewMemoryStorage()
+	indexed := NewMemoryStorage(WithMemoryIndexes(IndexActor, IndexAction, IndexTarget))
+	t0 := time.Unix(1_000, 0).UTC()
+	for i := 0; i < 200; i++ {
+		e := Event{
+			ID:        strconv.Itoa(i),
+			Tenant:    []string{"t", "u"}[i%2],
+			Timestamp: t0.Add(time.Duration((i*37)%200) * time.Second),
+			Action:    []string{"read", "write", "delete"}[i%3],
+			Actor:     Actor{ID: []string{"alice", "bob", "carol", "dave", "erin"}[i%5]},
+			Target:    Target{ID: []string{"doc1", "doc2", ""}[i%7%3]},
+		}
+		_, _ = plain.Save(ctx, e)
+		_, _ = indexed.Save(ctx, e)
+	}
+	since, until := t0.Add(20*time.Second), t0.Add(150*time.Second)
+	for _, q := range []Query{
+		{Tenant: "t", ActorID: "bob"},
+		{Tenant: "u", Action: "write", TargetID: "doc2"},
+		{Action: "read", Since: &since, Until: &until, Limit: 7},
+		{Tenant: "t", ActorID: "nobody"},
+		{Tenant: "t", TargetID: "doc1", Limit: 3},
+	} {
+		want, _ := plain.Query(ctx, q)
+		got, _ := indexed.Query(ctx, q)
+		if eventIDs(got) != eventIDs(want) {
+			t.Fatalf("%+v: indexed %q, scan %q", q, eventIDs(got), eventIDs(want))
+		}
+	}
+}
+
+func TestMemoryStorage_CapacityEvictsOldestPerTenant(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStorage(WithMemoryCapacity(3), WithMemoryIndexes(IndexActor))
+	t0 := time.Unix(1_000, 0).UTC()
+	for i := 0; i < 100; i++ {
+		_, _ = store.Save(ctx, Event{ID: strconv.Itoa(i), Tenant: "t", Actor: Actor{ID: "a" + strconv.Itoa(i)}, Timestamp: t0.Add(time.Duration(i) * time.Second)})
+	}
+	_, _ = store.Save(ctx, Event{ID: "other", Tenant: "u", Timestamp: t0})
+	res, _ := store.Query(ctx, Query{Tenant: "t"})
+	if got := eventIDs(res); got != "97 98 99" {
+		t.Fatalf("want the 3 newest events, got %q", got)
+	}
+	if res, _ := store.Query(ctx, Query{Tenant: "t", ActorID: "a5"}); len(res) != 0 {
+		t.Fatalf("evicted events must leave the indexes, got %v", res)
+	}
+	if n := len(store.tenants["t"].indexes[IndexActor]); n != 3 {
+		t.Fatalf("want 3 actor index entries, got %d", n)
+	}
+	if store.Len() != 4 {
+		t.Fatalf("other tenants keep their own budget, got %d events", store.Len())
+	}
+	// An event older than everything kept is evicted right away.
+	_, _ = store.Save(ctx, Event{ID: "old", Tenant: "t", Timestamp: t0})
+	if res, _ := store.Query(ctx, Query{Tenant: "t"}); eventIDs(res) != "97 98 99" {
+		t.Fatalf("late old event should not displace newer ones, got %q", eventIDs(res))
+	}
+}
+
+func TestMemoryStorage_SnapshotRestore(t *testing.T) {
+	ctx := context.Background()
+	src := NewMemoryStorage()
+	t0 := time.Unix(1_000, 0).UTC()
+	for i := 0; i < 5; i++ {
+		_, _ = src.Save(ctx, Event{ID: strconv.Itoa(i), Tenant: "t", Action: "x", Timestamp: t0.Add(time.Duration(i) * time.Second), Data: map[string]any{"n": i}})
+	}
+	_, _ = src.Save(ctx, Event{ID: "u", Tenant: "u", Timestamp: t0})
+	path := filepath.Join(t.TempDir(), "mem.snapshot")
+	if err := src.SnapshotFile(path); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := NewMemoryStorage(WithMemoryCapacity(2))
+	_, _ = dst.Save(ctx, Event{ID: "stale", Tenant: "t", Timestamp: t0})
+	if err := dst.RestoreFile(path); err != nil {
+		t.Fatal(err)
+	}
+	res, _ := dst.Query(ctx, Query{})
+	if got := eventIDs(res); got != "u 3 4" {
+		t.Fatalf("restore should replace contents and apply capacity, got %q", got)
+	}
+	if res[2].Data["n"] != 4.0 || !res[2].Timestamp.Equal(t0.Add(4*time.Second)) {
+		t.Fatalf("event not restored faithfully: %+v", res[2])
+	}
+
+	if err := dst.RestoreFile(filepath.Join(t.TempDir(), "missing")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("want os.ErrNotExist, got %v", err)
+	}
+	if err := dst.Restore(strings.NewReader("{\"format\":\"other\"}\n")); err == nil {
+		t.Fatal("want error for a foreign file")
+	}
+	if err := dst.Restore(strings.NewReader("{\"format\":\"gauditor-memory\",\"version\":1,\"count\":2}\n{}\n")); err == nil {
+		t.Fatal("want error for a truncated snapshot")
+	}
+	if dst.Len() != 3 {
+		t.Fatalf("failed restores must keep the current contents, got %d events", dst.Len())
+	}
+}
+
+func eventIDs(events []Event) string {
+	ids := make([]string, len(events))
+	for i, e := range events {
+		ids[i] = e.ID
+	}
+	return strings.Join(ids, " ")
+}
+
+func TestMemoryStorage_DuplicateIDs(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStorage(WithMemoryCapacity(1))
+	if _, err := store.Save(ctx, Event{ID: "a", Tenant: "t"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Save(ctx, Event{ID: "a", Tenant: "t"}); !errors.Is(err, ErrDuplicateEvent) {
+		t.Fatalf("want ErrDuplicateEvent, got %v", err)
+	}
+	if _, err := store.Save(ctx, Event{ID: "a", Tenant: "u"}); err != nil {
+		t.Fatalf("IDs are scoped to the tenant: %v", err)
+	}
+	// Once evicted, an ID may be stored again.
+	_, _ = store.Save(ctx, Event{ID: "b", Tenant: "t", Timestamp: time.Unix(1, 0)})
+	if _, err := store.Save(ctx, Event{ID: "a", Tenant: "t", Timestamp: time.Unix(2, 0)}); err != nil {
+		t.Fatalf("evicted ID rejected: %v", err)
+	}
+}
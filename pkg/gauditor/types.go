@@ -33,12 +33,17 @@ type Event struct {
 
 // Query defines filters for retrieving events.
 // Limit applies after filtering; storage may cap the maximum.
+//
+// Data filters on the event payload: every entry must match. Keys are
+// dot-separated paths into Event.Data (for example "order.id") and values are
+// compared as JSON scalars, so 5 and 5.0 are equal.
 type Query struct {
-	Tenant   string     `json:"tenant,omitempty"`
-	ActorID  string     `json:"actorId,omitempty"`
-	Action   string     `json:"action,omitempty"`
-	TargetID string     `json:"targetId,omitempty"`
-	Since    *time.Time `json:"since,omitempty"`
-	Until    *time.Time `json:"until,omitempty"`
-	Limit    int        `json:"limit,omitempty"`
+	Tenant   string         `json:"tenant,omitempty"`
+	ActorID  string         `json:"actorId,omitempty"`
+	Action   string         `json:"action,omitempty"`
+	TargetID string         `json:"targetId,omitempty"`
+	Since    *time.Time     `json:"since,omitempty"`
+	Until    *time.Time     `json:"until,omitempty"`
+	Data     map[string]any `json:"data,omitempty"`
+	Limit    int            `json:"limit,omitempty"`
 }
@@ -0,0 +1,233 @@
+package gauditor
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Redacted replaces values removed by NewRedactingStorage.
+const Redacted = "[REDACTED]"
+
+// The wrappers below implement io.Closer by closing the stores they wrap that
+// implement it, so closing the outermost store of a pipeline flushes and
+// releases every backend.
+func closeStorage(s Storage) error {
+	if c, ok := s.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+type retryStorage struct {
+	next     Storage
+	attempts int
+	backoff  time.Duration
+}
+
+// NewRetryStorage retries failed Save, Query and Get calls on next up to
+// attempts times in total, sleeping backoff before the first retry and
+// doubling it after each one. Invalid events, duplicates, missing events and
+// context errors are not retried. A duplicate reported by a retry means an earlier attempt was
+// stored, so that Save succeeds.
+func NewRetryStorage(next Storage, attempts int, backoff time.Duration) Storage {
+	if attempts < 1 {
+		attempts = 1
+	}
+	return &retryStorage{next: next, attempts: attempts, backoff: backoff}
+}
+
+// do calls fn until it succeeds or fails for good, and reports whether the
+// returned result came from a retry.
+func (r *retryStorage) do(ctx context.Context, fn func() error) (retried bool, err error) {
+	wait := r.backoff
+	for i := 0; i < r.attempts; i++ {
+		if i > 0 {
+			t := time.NewTimer(wait)
+			select {
+			case <-ctx.Done():
+				t.Stop()
+				return retried, err
+			case <-t.C:
+			}
+			wait *= 2
+			retried = true
+		}
+		if err = fn(); err == nil || errors.Is(err, ErrInvalidEvent) || errors.Is(err, ErrDuplicateEvent) || errors.Is(err, ErrNotFound) || ctx.Err() != nil {
+			return retried, err
+		}
+	}
+	return retried, err
+}
+
+func (r *retryStorage) Save(ctx context.Context, event Event) (Event, error) {
+	out := event
+	retried, err := r.do(ctx, func() (err error) {
+		out, err = r.next.Save(ctx, event)
+		return err
+	})
+	if retried && errors.Is(err, ErrDuplicateEvent) {
+		return event, nil
+	}
+	return out, err
+}
+
+func (r *retryStorage) Query(ctx context.Context, q Query) ([]Event, error) {
+	var out []Event
+	_, err := r.do(ctx, func() (err error) {
+		out, err = r.next.Query(ctx, q)
+		return err
+	})
+	return out, err
+}
+
+func (r *retryStorage) Get(ctx context.Context, tenant, id string) (Event, error) {
+	var out Event
+	_, err := r.do(ctx, func() (err error) {
+		out, err = getEvent(ctx, r.next, tenant, id)
+		return err
+	})
+	return out, err
+}
+
+// Ping is not retried: readiness checks should see failures as they happen.
+func (r *retryStorage) Ping(ctx context.Context) error { return pingStorage(ctx, r.next) }
+
+func (r *retryStorage) Close() error { return closeStorage(r.next) }
+
+type fanOutStorage struct {
+	primary Storage
+	others  []Storage
+}
+
+// NewFanOutStorage saves every event to primary and to each of others
+// concurrently, failing unless all of them succeed; the event returned is the
+// one stored by primary. Queries are served by primary alone.
+func NewFanOutStorage(primary Storage, others ...Storage) Storage {
+	return &fanOutStorage{primary: primary, others: others}
+}
+
+func (f *fanOutStorage) Save(ctx context.Context, event Event) (Event, error) {
+	errs := make([]error, len(f.others))
+	var wg sync.WaitGroup
+	for i, s := range f.others {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, errs[i] = s.Save(ctx, event)
+		}()
+	}
+	out, err := f.primary.Save(ctx, event)
+	wg.Wait()
+	return out, errors.Join(append([]error{err}, errs...)...)
+}
+
+func (f *fanOutStorage) Query(ctx context.Context, q Query) ([]Event, error) {
+	return f.primary.Query(ctx, q)
+}
+
+func (f *fanOutStorage) Get(ctx context.Context, tenant, id string) (Event, error) {
+	return getEvent(ctx, f.primary, tenant, id)
+}
+
+// Ping pings every store, since Save fails unless all of them succeed.
+func (f *fanOutStorage) Ping(ctx context.Context) error {
+	errs := []error{pingStorage(ctx, f.primary)}
+	for _, s := range f.others {
+		errs = append(errs, pingStorage(ctx, s))
+	}
+	return errors.Join(errs...)
+}
+
+func (f *fanOutStorage) Close() error {
+	errs := []error{closeStorage(f.primary)}
+	for _, s := range f.others {
+		errs = append(errs, closeStorage(s))
+	}
+	return errors.Join(errs...)
+}
+
+type redactingStorage struct {
+	next  Storage
+	paths [][]string
+}
+
+// NewRedactingStorage replaces the values at paths with Redacted before
+// events reach next. Paths are dot-separated and rooted at the event:
+// "actor.ip", "actor.userAgent", "actor.attributes.<key>", "target.name" or
+// "data.<path>" (for example "data.card.number"). Missing values are left
+// absent and the caller's event is not modified.
+func NewRedactingStorage(next Storage, paths ...string) Storage {
+	r := &redactingStorage{next: next}
+	for _, p := range paths {
+		r.paths = append(r.paths, strings.Split(p, "."))
+	}
+	return r
+}
+
+func (r *redactingStorage) Save(ctx context.Context, event Event) (Event, error) {
+	event.Actor.Attributes = cloneMap(event.Actor.Attributes)
+	event.Data = cloneMap(event.Data)
+	for _, p := range r.paths {
+		redactPath(&event, p)
+	}
+	return r.next.Save(ctx, event)
+}
+
+func (r *redactingStorage) Query(ctx context.Context, q Query) ([]Event, error) {
+	return r.next.Query(ctx, q)
+}
+
+func (r *redactingStorage) Get(ctx context.Context, tenant, id string) (Event, error) {
+	return getEvent(ctx, r.next, tenant, id)
+}
+
+func (r *redactingStorage) Ping(ctx context.Context) error { return pingStorage(ctx, r.next) }
+
+func (r *redactingStorage) Close() error { return closeStorage(r.next) }
+
+func redactPath(e *Event, p []string) {
+	switch {
+	case len(p) == 2 && p[0] == "actor" && p[1] == "ip" && e.Actor.IP != "":
+		e.Actor.IP = Redacted
+	case len(p) == 2 && p[0] == "actor" && p[1] == "userAgent" && e.Actor.UserAgent != "":
+		e.Actor.UserAgent = Redacted
+	case len(p) == 2 && p[0] == "target" && p[1] == "name" && e.Target.Name != "":
+		e.Target.Name = Redacted
+	case len(p) > 2 && p[0] == "actor" && p[1] == "attributes":
+		redactMap(e.Actor.Attributes, p[2:])
+	case len(p) > 1 && p[0] == "data":
+		redactMap(e.Data, p[1:])
+	}
+}
+
+// redactMap redacts path in m, copying nested maps on the way so values
+// shared with the caller are not modified.
+func redactMap(m map[string]any, path []string) {
+	for len(path) > 1 {
+		child, ok := m[path[0]].(map[string]any)
+		if !ok {
+			return
+		}
+		child = cloneMap(child)
+		m[path[0]] = child
+		m, path = child, path[1:]
+	}
+	if _, ok := m[path[0]]; ok {
+		m[path[0]] = Redacted
+	}
+}
+
+func cloneMap(m map[string]any) map[string]any {
+	if m == nil {
+		return nil
+	}
+	out := make(map[string]any, len(m))
+	for k, v := range m {
+		out[k] = v
+	}
+	return out
+}
@@ -0,0 +1,154 @@
+package gauditor
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+// flakyStorage fails the first failures calls, then delegates to MemoryStorage.
+type flakyStorage struct {
+	*MemoryStorage
+	failures int
+	calls    int
+}
+
+func (f *flakyStorage) Save(ctx context.Context, e Event) (Event, error) {
+	if f.calls++; f.calls <= f.failures {
+		return e, errors.New("unavailable")
+	}
+	return f.MemoryStorage.Save(ctx, e)
+}
+
+func TestRetryStorage(t *testing.T) {
+	ctx := context.Background()
+	flaky := &flakyStorage{MemoryStorage: NewMemoryStorage(), failures: 2}
+	if _, err := NewRetryStorage(flaky, 3, time.Millisecond).Save(ctx, Event{ID: "a", Tenant: "t"}); err != nil || flaky.Len() != 1 {
+		t.Fatalf("want success on the third attempt: %v", err)
+	}
+
+	flaky = &flakyStorage{MemoryStorage: NewMemoryStorage(), failures: 5}
+	if _, err := NewRetryStorage(flaky, 3, time.Millisecond).Save(ctx, Event{ID: "a", Tenant: "t"}); err == nil || flaky.calls != 3 {
+		t.Fatalf("want failure after 3 attempts, got %v after %d", err, flaky.calls)
+	}
+
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	flaky = &flakyStorage{MemoryStorage: NewMemoryStorage(), failures: 5}
+	if _, err := NewRetryStorage(flaky, 3, time.Hour).Save(cctx, Event{ID: "a", Tenant: "t"}); err == nil || flaky.calls != 1 {
+		t.Fatalf("a canceled context must stop retries, got %v after %d", err, flaky.calls)
+	}
+}
+
+func TestFanOutStorage(t *testing.T) {
+	ctx := context.Background()
+	primary, copy1 := NewMemoryStorage(), NewMemoryStorage()
+	s := NewFanOutStorage(primary, copy1)
+	if _, err := s.Save(ctx, Event{ID: "a", Tenant: "t"}); err != nil {
+		t.Fatal(err)
+	}
+	if primary.Len() != 1 || copy1.Len() != 1 {
+		t.Fatalf("want the event in every store, got %d and %d", primary.Len(), copy1.Len())
+	}
+	failing := &flakyStorage{MemoryStorage: NewMemoryStorage(), failures: 1}
+	if _, err := NewFanOutStorage(primary, failing).Save(ctx, Event{ID: "b", Tenant: "t"}); err == nil {
+		t.Fatal("want an error when a secondary store fails")
+	}
+}
+
+func TestRedactingStorage(t *testing.T) {
+	ctx := context.Background()
+	mem := NewMemoryStorage()
+	s := NewRedactingStorage(mem, "actor.ip", "actor.attributes.email", "data.card.number", "data.missing", "target.name")
+	in := Event{
+		ID: "a", Tenant: "t",
+		Actor:  Actor{ID: "u", IP: "10.0.0.1", Attributes: map[string]any{"email": "a@example.com", "role": "admin"}},
+		Target: Target{ID: "x"},
+		Data:   map[string]any{"card": map[string]any{"number": "4111", "brand": "visa"}},
+	}
+	if _, err := s.Save(ctx, in); err != nil {
+		t.Fatal(err)
+	}
+	got, _ := mem.Query(ctx, Query{})
+	e := got[0]
+	card := e.Data["card"].(map[string]any)
+	if e.Actor.IP != Redacted || e.Actor.Attributes["email"] != Redacted || card["number"] != Redacted {
+		t.Fatalf("values not redacted: %+v", e)
+	}
+	if e.Actor.Attributes["role"] != "admin" || card["brand"] != "visa" || e.Target.Name != "" {
+		t.Fatalf("unrelated values changed: %+v", e)
+	}
+	if _, ok := e.Data["missing"]; ok {
+		t.Fatal("missing values must stay absent")
+	}
+	if in.Actor.Attributes["email"] != "a@example.com" || in.Data["card"].(map[string]any)["number"] != "4111" {
+		t.Fatal("the caller's event was modified")
+	}
+}
+
+type closingStorage struct {
+	*MemoryStorage
+	closed int
+}
+
+func (c *closingStorage) Close() error { c.closed++; return nil }
+
+func TestWrappers_CloseWrappedStores(t *testing.T) {
+	a, b := &closingStorage{MemoryStorage: NewMemoryStorage()}, &closingStorage{MemoryStorage: NewMemoryStorage()}
+	s := NewRetryStorage(NewRedactingStorage(NewFanOutStorage(a, NewMemoryStorage(), b), "data.x"), 1, 0)
+	if err := s.(io.Closer).Close(); err != nil || a.closed != 1 || b.closed != 1 {
+		t.Fatalf("want both closers called once, got %d %d %v", a.closed, b.closed, err)
+	}
+}
+
+// lostAckStorage stores the first Save but reports it as failed.
+type lostAckStorage struct {
+	*MemoryStorage
+	calls int
+}
+
+func (l *lostAckStorage) Save(ctx context.Context, e Event) (Event, error) {
+	out, err := l.MemoryStorage.Save(ctx, e)
+	if l.calls++; l.calls == 1 {
+		return out, errors.New("timeout")
+	}
+	return out, err
+}
+
+func TestRetryStorage_DuplicateAfterRetry(t *testing.T) {
+	ctx := context.Background()
+	lost := &lostAckStorage{MemoryStorage: NewMemoryStorage()}
+	if _, err := NewRetryStorage(lost, 3, time.Millisecond).Save(ctx, Event{ID: "a", Tenant: "t"}); err != nil || lost.Len() != 1 {
+		t.Fatalf("a duplicate on retry means the first attempt was stored: %v", err)
+	}
+	if _, err := NewRetryStorage(lost, 3, time.Millisecond).Save(ctx, Event{ID: "a", Tenant: "t"}); !errors.Is(err, ErrDuplicateEvent) || lost.calls != 3 {
+		t.Fatalf("a duplicate on the first attempt must fail without retries, got %v after %d calls", err, lost.calls)
+	}
+}
+
+type pingingStorage struct {
+	*MemoryStorage
+	err   error
+	pings int
+}
+
+func (p *pingingStorage) Ping(context.Context) error { p.pings++; return p.err }
+
+func TestWrappers_Ping(t *testing.T) {
+	ctx := context.Background()
+	if err := NewRecorder(NewMemoryStorage()).Ping(ctx); err != nil {
+		t.Fatalf("storages without Ping are reachable: %v", err)
+	}
+	up := &pingingStorage{MemoryStorage: NewMemoryStorage()}
+	down := &pingingStorage{MemoryStorage: NewMemoryStorage(), err: errors.New("connection refused")}
+	rec := NewRecorder(NewRetryStorage(NewRedactingStorage(NewFanOutStorage(up, NewMemoryStorage(), down), "data.x"), 3, 0))
+	if err := rec.Ping(ctx); !errors.Is(err, down.err) || up.pings != 1 || down.pings != 1 {
+		t.Fatalf("want every store pinged once, got %d %d %v", up.pings, down.pings, err)
+	}
+	down.err = nil
+	if err := rec.Ping(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
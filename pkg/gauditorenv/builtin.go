@@ -0,0 +1,273 @@
+package gauditorenv
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/redis/go-redis/v9"
+
+	gauditor "github.com/antoniomarcosferreira/gauditor/pkg/gauditor"
+	"github.com/antoniomarcosferreira/gauditor/pkg/gauditor/blob"
+	"github.com/antoniomarcosferreira/gauditor/pkg/gauditor/redisstore"
+	"github.com/antoniomarcosferreira/gauditor/pkg/gauditor/s3store"
+	"github.com/antoniomarcosferreira/gauditor/pkg/gauditor/sqlstore"
+)
+
+func init() {
+	Register("memory", newMemory)
+	Register("redis", newRedis)
+	Register("sql", newSQL)
+	Register("s3", newS3)
+	RegisterWrapper("retry", newRetry)
+	RegisterWrapper("fanout", newFanOut)
+	RegisterWrapper("redact", newRedact)
+}
+
+// memoryOptions configure the "memory" backend.
+type memoryOptions struct {
+	Capacity         int      `json:"capacity"`         // per tenant; 0 = unbounded
+	Indexes          []string `json:"indexes"`          // actor, action, target
+	Snapshot         string   `json:"snapshot"`         // file kept across restarts
+	SnapshotInterval Duration `json:"snapshotInterval"` // default 30s; 0 = only on Close
+}
+
+func newMemory(_ context.Context, opts Options) (gauditor.Storage, error) {
+	o := memoryOptions{SnapshotInterval: Duration(30 * time.Second)}
+	if err := opts.Decode(&o); err != nil {
+		return nil, err
+	}
+	mopts := []gauditor.MemoryOption{gauditor.WithMemoryCapacity(o.Capacity)}
+	for _, name := range o.Indexes {
+		idx, ok := map[string]gauditor.MemoryIndex{
+			"actor":  gauditor.IndexActor,
+			"action": gauditor.IndexAction,
+			"target": gauditor.IndexTarget,
+		}[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown index %q (want actor, action or target)", name)
+		}
+		mopts = append(mopts, gauditor.WithMemoryIndexes(idx))
+	}
+	store := gauditor.NewMemoryStorage(mopts...)
+	if o.Snapshot == "" {
+		return store, nil
+	}
+	return openSnapshot(store, o.Snapshot, time.Duration(o.SnapshotInterval))
+}
+
+// redisOptions configure the "redis" backend; see newRedisClient for url.
+type redisOptions struct {
+	URL          string `json:"url"`
+	Addr         string `json:"addr"`
+	Password     string `json:"password"`
+	KeyPrefix    string `json:"keyPrefix"`
+	StreamMaxLen *int64 `json:"streamMaxLen"` // enables WithStreams
+}
+
+func newRedis(_ context.Context, opts Options) (gauditor.Storage, error) {
+	o := redisOptions{KeyPrefix: "gauditor:"}
+	if err := opts.Decode(&o); err != nil {
+		return nil, err
+	}
+	rdb, err := newRedisClient(o.URL, o.Addr, o.Password)
+	if err != nil {
+		return nil, err
+	}
+	ropts := []redisstore.Option{redisstore.WithKeyPrefix(o.KeyPrefix)}
+	if o.StreamMaxLen != nil {
+		ropts = append(ropts, redisstore.WithStreams(*o.StreamMaxLen))
+	}
+	return &redisBackend{Store: redisstore.New(rdb, ropts...), rdb: rdb}, nil
+}
+
+// redisBackend closes the client the "redis" backend opened.
+type redisBackend struct {
+	*redisstore.Store
+	rdb redis.UniversalClient
+}
+
+func (b *redisBackend) Close() error { return b.rdb.Close() }
+
+// sqlOptions configure the "sql" backend. The driver must be registered with
+// database/sql by the program.
+type sqlOptions struct {
+	Driver             string   `json:"driver"`
+	DSN                string   `json:"dsn"`
+	TableName          string   `json:"tableName"`
+	TablePrefix        string   `json:"tablePrefix"`
+	EnsureSchema       *bool    `json:"ensureSchema"` // default true
+	NativeJSON         bool     `json:"nativeJSON"`
+	IndexedDataPaths   []string `json:"indexedDataPaths"`
+	MonthlyPartitions  *int     `json:"monthlyPartitions"` // months created ahead
+	PartitionRetention int      `json:"partitionRetention"`
+}
+
+func newSQL(ctx context.Context, opts Options) (gauditor.Storage, error) {
+	var o sqlOptions
+	if err := opts.Decode(&o); err != nil {
+		return nil, err
+	}
+	if o.Driver == "" {
+		return nil, errors.New("driver is required")
+	}
+	db, err := sql.Open(o.Driver, o.DSN)
+	if err != nil {
+		return nil, err
+	}
+	sopts := []sqlstore.Option{sqlstore.WithDriverName(o.Driver)}
+	if o.TablePrefix != "" {
+		sopts = append(sopts, sqlstore.WithTablePrefix(o.TablePrefix))
+	}
+	if o.TableName != "" {
+		sopts = append(sopts, sqlstore.WithTableName(o.TableName))
+	}
+	if o.NativeJSON {
+		sopts = append(sopts, sqlstore.WithNativeJSON())
+	}
+	if len(o.IndexedDataPaths) > 0 {
+		sopts = append(sopts, sqlstore.WithIndexedDataPaths(o.IndexedDataPaths...))
+	}
+	if o.MonthlyPartitions != nil {
+		sopts = append(sopts, sqlstore.WithMonthlyPartitions(*o.MonthlyPartitions))
+	}
+	if o.PartitionRetention > 0 {
+		sopts = append(sopts, sqlstore.WithPartitionRetention(o.PartitionRetention))
+	}
+	store := sqlstore.New(db, sopts...)
+	if o.EnsureSchema == nil || *o.EnsureSchema {
+		if err := store.EnsureSchema(ctx); err != nil {
+			_ = db.Close()
+			return nil, err
+		}
+	}
+	return &sqlBackend{Store: store, db: db}, nil
+}
+
+// sqlBackend closes the database the "sql" backend opened.
+type sqlBackend struct {
+	*sqlstore.Store
+	db *sql.DB
+}
+
+func (b *sqlBackend) Close() error { return b.db.Close() }
+
+// s3Options configure the "s3" backend. Dir stores the archive in a local
+// directory instead of a bucket. The ID index is on unless idIndex is false,
+// since without it every Get scans the tenant's whole archive.
+type s3Options struct {
+	Bucket           string   `json:"bucket"`
+	Prefix           string   `json:"prefix"`
+	Dir              string   `json:"dir"`
+	Region           string   `json:"region"`
+	Endpoint         string   `json:"endpoint"`
+	PathStyle        bool     `json:"pathStyle"`
+	QueryConcurrency int      `json:"queryConcurrency"`
+	SSEKMSKeyID      *string  `json:"sseKmsKeyId"` // "" = AWS managed key
+	SegmentBytes     int      `json:"segmentBytes"`
+	SegmentAge       Duration `json:"segmentAge"`
+	IDIndex          *bool    `json:"idIndex"` // default true
+}
+
+func newS3(ctx context.Context, opts Options) (gauditor.Storage, error) {
+	o := s3Options{Prefix: "gauditor"}
+	if err := opts.Decode(&o); err != nil {
+		return nil, err
+	}
+	var sopts []s3store.Option
+	if o.QueryConcurrency > 0 {
+		sopts = append(sopts, s3store.WithQueryConcurrency(o.QueryConcurrency))
+	}
+	if o.IDIndex == nil || *o.IDIndex {
+		sopts = append(sopts, s3store.WithIDIndex())
+	}
+	if o.SSEKMSKeyID != nil {
+		sopts = append(sopts, s3store.WithSSEKMS(*o.SSEKMSKeyID))
+	}
+	if o.SegmentBytes > 0 || o.SegmentAge > 0 {
+		sopts = append(sopts, s3store.WithSegments(o.SegmentBytes, time.Duration(o.SegmentAge)))
+	}
+	if o.Dir != "" {
+		if o.Bucket != "" {
+			return nil, errors.New("set either bucket or dir, not both")
+		}
+		b, err := blob.NewDir(o.Dir)
+		if err != nil {
+			return nil, err
+		}
+		return s3store.NewWithBucket(b, o.Prefix, sopts...), nil
+	}
+	if o.Bucket == "" {
+		return nil, errors.New("bucket or dir is required")
+	}
+	var loadOpts []func(*config.LoadOptions) error
+	if o.Region != "" {
+		loadOpts = append(loadOpts, config.WithRegion(o.Region))
+	}
+	cfg, err := config.LoadDefaultConfig(ctx, loadOpts...)
+	if err != nil {
+		return nil, err
+	}
+	cli := s3.NewFromConfig(cfg, func(so *s3.Options) {
+		if o.Endpoint != "" {
+			so.BaseEndpoint = &o.Endpoint
+		}
+		so.UsePathStyle = o.PathStyle
+	})
+	return s3store.New(cli, o.Bucket, o.Prefix, sopts...), nil
+}
+
+// retryOptions configure the "retry" wrapper.
+type retryOptions struct {
+	Attempts int      `json:"attempts"`
+	Backoff  Duration `json:"backoff"`
+}
+
+func newRetry(_ context.Context, next gauditor.Storage, cfg WrapperConfig) (gauditor.Storage, error) {
+	o := retryOptions{Attempts: 3, Backoff: Duration(100 * time.Millisecond)}
+	if err := cfg.Options.Decode(&o); err != nil {
+		return nil, err
+	}
+	return gauditor.NewRetryStorage(next, o.Attempts, time.Duration(o.Backoff)), nil
+}
+
+func newFanOut(ctx context.Context, next gauditor.Storage, cfg WrapperConfig) (gauditor.Storage, error) {
+	if len(cfg.Options) > 0 {
+		return nil, errors.New("fanout takes no options")
+	}
+	if len(cfg.Stores) == 0 {
+		return nil, errors.New("stores is required")
+	}
+	others := make([]gauditor.Storage, len(cfg.Stores))
+	for i, sc := range cfg.Stores {
+		s, err := NewStorage(ctx, sc)
+		if err != nil {
+			for _, built := range others[:i] {
+				closeStorage(built)
+			}
+			return nil, err
+		}
+		others[i] = s
+	}
+	return gauditor.NewFanOutStorage(next, others...), nil
+}
+
+// redactOptions configure the "redact" wrapper.
+type redactOptions struct {
+	Paths []string `json:"paths"`
+}
+
+func newRedact(_ context.Context, next gauditor.Storage, cfg WrapperConfig) (gauditor.Storage, error) {
+	var o redactOptions
+	if err := cfg.Options.Decode(&o); err != nil {
+		return nil, err
+	}
+	if len(o.Paths) == 0 {
+		return nil, errors.New("paths is required")
+	}
+	return gauditor.NewRedactingStorage(next, o.Paths...), nil
+}
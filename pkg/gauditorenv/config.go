@@ -0,0 +1,163 @@
+package gauditorenv
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the declarative configuration read by LoadConfig.
+//
+// Example (YAML; the same structure is accepted as JSON):
+//
+//	storage:
+//	  backend: sql
+//	  options:
+//	    driver: postgres
+//	    dsn: ${SQL_DSN}
+//	    tableName: audit_events
+//	  wrappers:
+//	    - type: redact
+//	      options: {paths: [actor.ip, data.password]}
+//	    - type: retry
+//	      options: {attempts: 3, backoff: 200ms}
+//	    - type: fanout
+//	      stores:
+//	        - backend: s3
+//	          options: {bucket: audit-archive}
+type Config struct {
+	Storage StorageConfig `json:"storage" yaml:"storage"`
+}
+
+// StorageConfig describes a storage pipeline: a registered backend with its
+// options, and wrappers applied around it, the first one outermost.
+type StorageConfig struct {
+	Backend  string          `json:"backend" yaml:"backend"`
+	Options  Options         `json:"options,omitempty" yaml:"options,omitempty"`
+	Wrappers []WrapperConfig `json:"wrappers,omitempty" yaml:"wrappers,omitempty"`
+}
+
+// WrapperConfig configures one registered storage wrapper. Stores lists the
+// extra pipelines of wrappers that write to several stores, like fanout.
+type WrapperConfig struct {
+	Type    string          `json:"type" yaml:"type"`
+	Options Options         `json:"options,omitempty" yaml:"options,omitempty"`
+	Stores  []StorageConfig `json:"stores,omitempty" yaml:"stores,omitempty"`
+}
+
+// Options holds the free-form options of a backend or wrapper.
+type Options map[string]any
+
+// Decode copies o into the struct pointed to by v, matching keys to its JSON
+// field names. Unknown keys are an error, so typos do not go unnoticed.
+func (o Options) Decode(v any) error {
+	raw, err := json.Marshal(o)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return fmt.Errorf("options: %w", err)
+	}
+	return nil
+}
+
+// Duration is a time.Duration written in options as a string such as
+// "250ms" or "1h".
+type Duration time.Duration
+
+// UnmarshalJSON parses a duration string.
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"1s\": %s", b)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// envRef matches ${NAME} references expanded by ParseConfig.
+var envRef = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// LoadConfig reads a YAML or JSON configuration file; see ParseConfig.
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("gauditorenv: %w", err)
+	}
+	cfg, err := ParseConfig(data)
+	if err != nil {
+		return nil, fmt.Errorf("%w (in %s)", err, path)
+	}
+	return cfg, nil
+}
+
+// ParseConfig parses a YAML or JSON configuration. ${NAME} references in
+// string values are replaced by the value of the environment variable NAME,
+// so secrets can stay out of the file; a bare $ is left alone. References
+// are expanded after parsing, so values may hold any character, and an
+// unquoted reference to a number or boolean keeps that type. Unknown fields
+// are an error.
+func ParseConfig(data []byte) (*Config, error) {
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	var cfg Config
+	if err := dec.Decode(&cfg); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, errors.New("gauditorenv: config is empty")
+		}
+		return nil, fmt.Errorf("gauditorenv: config: %w", err)
+	}
+	// The structure is valid; decode it again with references expanded.
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("gauditorenv: config: %w", err)
+	}
+	expandEnv(&doc)
+	cfg = Config{}
+	if err := doc.Decode(&cfg); err != nil {
+		return nil, fmt.Errorf("gauditorenv: config: %w", err)
+	}
+	if cfg.Storage.Backend == "" {
+		return nil, errors.New("gauditorenv: config: storage.backend is required")
+	}
+	return &cfg, nil
+}
+
+// expandEnv replaces ${NAME} references in the string values under n. The
+// tag of an unquoted value is dropped so it is resolved again from the
+// expanded text; mapping keys are left alone.
+func expandEnv(n *yaml.Node) {
+	switch n.Kind {
+	case yaml.ScalarNode:
+		if n.ShortTag() != "!!str" || !envRef.MatchString(n.Value) {
+			return
+		}
+		n.Value = envRef.ReplaceAllStringFunc(n.Value, func(ref string) string {
+			return os.Getenv(ref[2 : len(ref)-1])
+		})
+		if n.Style == 0 { // plain and untagged
+			n.Tag = ""
+		}
+	case yaml.MappingNode:
+		for i := 1; i < len(n.Content); i += 2 {
+			expandEnv(n.Content[i])
+		}
+	default:
+		for _, c := range n.Content {
+			expandEnv(c)
+		}
+	}
+}
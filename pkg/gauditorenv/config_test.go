@@ -0,0 +1,257 @@
+package gauditorenv
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	gauditor "github.com/antoniomarcosferreira/gauditor/pkg/gauditor"
+	_ "modernc.org/sqlite"
+)
+
+// captured receives the events saved through the "capture" test backend.
+var captured = gauditor.NewMemoryStorage()
+
+// closable counts the Close calls on stores built by the "closable" test backend.
+type closable struct {
+	gauditor.Storage
+	closed *int
+}
+
+func (c closable) Close() error { *c.closed++; return nil }
+
+var closed int
+
+func init() {
+	Register("capture", func(context.Context, Options) (gauditor.Storage, error) { return captured, nil })
+	Register("closable", func(context.Context, Options) (gauditor.Storage, error) {
+		return closable{gauditor.NewMemoryStorage(), &closed}, nil
+	})
+}
+
+func writeConfig(t *testing.T, name, body string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestNewRecorderFromEnv_ConfigPipeline(t *testing.T) {
+	t.Setenv("AUDIT_DB", filepath.Join(t.TempDir(), "audit.db"))
+	t.Setenv("GAUDITOR_CONFIG", writeConfig(t, "gauditor.yaml", `
+storage:
+  backend: sql
+  options:
+    driver: sqlite
+    dsn: ${AUDIT_DB}
+    tableName: audit_log
+  wrappers:
+    - type: redact
+      options: {paths: [data.password]}
+    - type: retry
+      options: {attempts: 2, backoff: 1ms}
+    - type: fanout
+      stores:
+        - backend: capture
+`))
+	ctx := context.Background()
+	rec, err := NewRecorderFromEnv(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rec.Record(ctx, gauditor.Event{Tenant: "t", Action: "login", Data: map[string]any{"password": "hunter2"}}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := rec.Query(ctx, gauditor.Query{Tenant: "t"})
+	if err != nil || len(got) != 1 || got[0].Data["password"] != gauditor.Redacted {
+		t.Fatalf("want one redacted event in the SQL table, got %+v %v", got, err)
+	}
+	if copies, _ := captured.Query(ctx, gauditor.Query{Tenant: "t"}); len(copies) != 1 || copies[0].Data["password"] != gauditor.Redacted {
+		t.Fatalf("fanout store: %+v", copies)
+	}
+	if err := rec.Ping(ctx); err != nil {
+		t.Fatalf("the SQL backend is pinged through the wrappers: %v", err)
+	}
+}
+
+func TestParseConfig_JSONAndErrors(t *testing.T) {
+	cfg, err := ParseConfig([]byte(`{"storage": {"backend": "memory", "options": {"capacity": 10, "indexes": ["actor"]}}}`))
+	if err != nil || cfg.Storage.Backend != "memory" {
+		t.Fatalf("json config: %+v %v", cfg, err)
+	}
+	if _, err := NewStorage(context.Background(), cfg.Storage); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct{ config, want string }{
+		{"", "empty"},
+		{"storage: {backend: memory, wrapers: []}", "wrapers"},
+		{"storage: {options: {}}", "storage.backend is required"},
+	} {
+		if _, err := ParseConfig([]byte(tc.config)); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%q: want error containing %q, got %v", tc.config, tc.want, err)
+		}
+	}
+	for _, tc := range []struct {
+		cfg  StorageConfig
+		want string
+	}{
+		{StorageConfig{Backend: "cassandra"}, `unknown storage backend "cassandra"`},
+		{StorageConfig{Backend: "memory", Options: Options{"capacty": 1}}, `unknown field "capacty"`},
+		{StorageConfig{Backend: "memory", Options: Options{"indexes": []any{"tenant"}}}, `unknown index "tenant"`},
+		{StorageConfig{Backend: "memory", Wrappers: []WrapperConfig{{Type: "compress"}}}, `unknown storage wrapper "compress"`},
+		{StorageConfig{Backend: "memory", Wrappers: []WrapperConfig{{Type: "retry", Options: Options{"backoff": 5}}}}, "duration"},
+		{StorageConfig{Backend: "memory", Wrappers: []WrapperConfig{{Type: "fanout"}}}, "stores is required"},
+		{StorageConfig{Backend: "s3", Options: Options{"prefix": "x"}}, "bucket or dir is required"},
+	} {
+		if _, err := NewStorage(context.Background(), tc.cfg); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%+v: want error containing %q, got %v", tc.cfg, tc.want, err)
+		}
+	}
+}
+
+func TestParseConfig_ExpandsAfterParsing(t *testing.T) {
+	t.Setenv("DSN", "postgres://u:p#1@db/audit?x='y' # not a comment\nkey: injected")
+	t.Setenv("SEG", "1048576")
+	cfg, err := ParseConfig([]byte(`
+storage:
+  backend: sql
+  options:
+    dsn: ${DSN}
+    quoted: "${SEG}"
+    segmentBytes: ${SEG}
+    literal: $HOME and $${SEG}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	o := cfg.Storage.Options
+	if o["dsn"] != os.Getenv("DSN") || len(o) != 4 {
+		t.Errorf("dsn: want the value verbatim, got %+v", o)
+	}
+	if o["quoted"] != "1048576" || o["segmentBytes"] != 1048576 {
+		t.Errorf("quoted references stay strings, plain ones are resolved: %#v %#v", o["quoted"], o["segmentBytes"])
+	}
+	if o["literal"] != "$HOME and $1048576" {
+		t.Errorf("literal: %q", o["literal"])
+	}
+}
+
+func TestNewRecorderFromEnv_Variables(t *testing.T) {
+	ctx := context.Background()
+	t.Setenv("GAUDITOR_STORAGE", "")
+	if _, err := NewRecorderFromEnv(ctx); err != nil {
+		t.Fatalf("memory is the default: %v", err)
+	}
+	t.Setenv("GAUDITOR_STORAGE", "mongo")
+	if _, err := NewRecorderFromEnv(ctx); err == nil || !strings.Contains(err.Error(), `unknown storage backend "mongo"`) {
+		t.Fatalf("unknown backends must fail, got %v", err)
+	}
+	t.Setenv("GAUDITOR_STORAGE", "S3")
+	t.Setenv("S3_PREFIX", "audit")
+	cfg, err := ConfigFromEnv()
+	if err != nil || cfg.Storage.Backend != "s3" || cfg.Storage.Options["prefix"] != "audit" {
+		t.Fatalf("config from env: %+v %v", cfg, err)
+	}
+	t.Setenv("GAUDITOR_CONFIG", filepath.Join(t.TempDir(), "missing.yaml"))
+	if _, err := NewRecorderFromEnv(ctx); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("want os.ErrNotExist for a missing config file, got %v", err)
+	}
+}
+
+func TestFanOut_ClosesBuiltStoresOnError(t *testing.T) {
+	closed = 0
+	_, err := NewStorage(context.Background(), StorageConfig{Backend: "memory", Wrappers: []WrapperConfig{{Type: "fanout", Stores: []StorageConfig{
+		{Backend: "closable"}, {Backend: "closable"}, {Backend: "nope"},
+	}}}})
+	if err == nil || closed != 2 {
+		t.Fatalf("want both built stores closed, got %d closed (%v)", closed, err)
+	}
+}
+
+func TestRegister_PanicsOnDuplicate(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("want panic")
+		}
+	}()
+	Register("memory", newMemory)
+}
+
+func TestConfig_Masked(t *testing.T) {
+	cfg := &Config{Storage: StorageConfig{
+		Backend: "sql",
+		Options: Options{"driver": "postgres", "dsn": "postgres://app:s3cret@db:5432/audit?sslmode=require"},
+		Wrappers: []WrapperConfig{{Type: "fanout", Stores: []StorageConfig{
+			{Backend: "sql", Options: Options{"dsn": "host=db user=app password=s3cret dbname=audit"}},
+			{Backend: "sql", Options: Options{"dsn": "app:s3cret@tcp(db:3306)/audit"}},
+			{Backend: "redis", Options: Options{"password": "s3cret", "keyPrefix": "audit:"}},
+		}}},
+	}}
+	raw, _ := json.Marshal(cfg.Masked())
+	if strings.Contains(string(raw), "s3cret") {
+		t.Fatalf("secret leaked: %s", raw)
+	}
+	for _, want := range []string{"postgres://app:****@db:5432/audit?sslmode=require", "password=**** dbname", "app:****@tcp(db:3306)", `"keyPrefix":"audit:"`} {
+		if !strings.Contains(string(raw), want) {
+			t.Errorf("want %q in %s", want, raw)
+		}
+	}
+	if cfg.Storage.Options["dsn"] != "postgres://app:s3cret@db:5432/audit?sslmode=require" {
+		t.Fatal("Masked modified the original config")
+	}
+}
+
+func TestMemoryBackend_Snapshot(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "mem.snapshot")
+	cfg := StorageConfig{Backend: "memory", Options: Options{"snapshot": path, "snapshotInterval": "0s"}}
+	s, err := NewStorage(ctx, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = s.Save(ctx, gauditor.Event{ID: "a", Tenant: "t"})
+	if err := s.(io.Closer).Close(); err != nil {
+		t.Fatal(err)
+	}
+	s, err = NewStorage(ctx, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.(io.Closer).Close()
+	if got, _ := s.Query(ctx, gauditor.Query{}); len(got) != 1 {
+		t.Fatalf("want the event restored, got %v", got)
+	}
+}
+
+func TestS3Backend_IDIndexByDefault(t *testing.T) {
+	ctx := context.Background()
+	for _, tc := range []struct {
+		opts    Options
+		indexed bool
+	}{
+		{Options{}, true},
+		{Options{"idIndex": false}, false},
+	} {
+		dir := t.TempDir()
+		tc.opts["dir"] = dir
+		s, err := NewStorage(ctx, StorageConfig{Backend: "s3", Options: tc.opts})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.Save(ctx, gauditor.Event{ID: "e1", Tenant: "t", Action: "login"}); err != nil {
+			t.Fatal(err)
+		}
+		keys, _ := filepath.Glob(filepath.Join(dir, "gauditor", "*", "ids", "e1"))
+		if indexed := len(keys) == 1; indexed != tc.indexed {
+			t.Errorf("%v: want indexed %v, got keys %v", tc.opts, tc.indexed, keys)
+		}
+	}
+}
@@ -1,6 +1,9 @@
 // Package gauditorenv provides a helper to construct a gauditor.Recorder from
-// environment variables, enabling a Rails-like developer experience.
+// environment variables or a configuration file, enabling a Rails-like
+// developer experience.
 //
-// Supported backends via GAUDITOR_STORAGE: memory (default), redis, sql, s3.
+// Supported backends via GAUDITOR_STORAGE: memory (default), redis, sql, s3,
+// and any backend added with Register. GAUDITOR_CONFIG names a YAML or JSON
+// file describing the storage pipeline, wrappers included; see Config.
 // See docs/Storage.md and README for the list of environment variables.
 package gauditorenv
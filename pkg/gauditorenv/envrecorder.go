@@ -2,79 +2,81 @@ package gauditorenv
 
 import (
 	"context"
-	"database/sql"
 	"os"
 	"strings"
 
-	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
-	"github.com/redis/go-redis/v9"
-
 	gauditor "github.com/antoniomarcosferreira/gauditor/pkg/gauditor"
-	"github.com/antoniomarcosferreira/gauditor/pkg/gauditor/redisstore"
-	"github.com/antoniomarcosferreira/gauditor/pkg/gauditor/s3store"
-	"github.com/antoniomarcosferreira/gauditor/pkg/gauditor/sqlstore"
 )
 
 // NewRecorderFromEnv creates a Recorder using storage configured via environment variables.
 //
-// Variables:
+// When GAUDITOR_CONFIG names a YAML or JSON file, the storage pipeline is read
+// from it (see Config) and the variables below are ignored. Otherwise:
 //
-//	GAUDITOR_STORAGE: memory | redis | sql | s3 (default: memory)
-//	Redis: REDIS_ADDR (default 127.0.0.1:6379), REDIS_KEY_PREFIX (default "gauditor:")
-//	SQL:   SQL_DRIVER (postgres|mysql), SQL_DSN (driver-specific DSN)
+//	GAUDITOR_STORAGE: memory | redis | sql | s3 | any registered backend (default: memory)
+//	Memory: GAUDITOR_SNAPSHOT (file restored on start and rewritten periodically)
+//	Redis: REDIS_URL (redis[s]://, redis[s]+sentinel://, redis[s]+cluster://; see below)
+//	       or REDIS_ADDR (default 127.0.0.1:6379), REDIS_PASSWORD,
+//	       REDIS_KEY_PREFIX (default "gauditor:")
+//	SQL:   SQL_DRIVER (postgres|mysql|sqlite), SQL_DSN (driver-specific DSN)
 //	       GAUDITOR_SQL_ENSURE_SCHEMA=1 (default) to auto-create table
 //	S3:    S3_BUCKET (required), S3_PREFIX (default "gauditor") + standard AWS_* envs
+//
+// An unknown backend is an error.
+//
+// REDIS_URL examples:
+//
+//	rediss://:secret@redis.internal:6380/2
+//	redis+sentinel://:secret@s1:26379,s2:26379/0?master=audit&sentinel_password=x
+//	rediss+cluster://:secret@n1:6379,n2:6379,n3:6379
 func NewRecorderFromEnv(ctx context.Context, opts ...gauditor.Option) (*gauditor.Recorder, error) {
-	backend := strings.ToLower(strings.TrimSpace(os.Getenv("GAUDITOR_STORAGE")))
-	if backend == "" || backend == "memory" {
-		return gauditor.NewRecorder(gauditor.NewMemoryStorage(), opts...), nil
+	cfg, err := ConfigFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	return NewRecorderFromConfig(ctx, cfg, opts...)
+}
+
+// NewRecorderFromConfig creates a Recorder with the storage pipeline of cfg.
+func NewRecorderFromConfig(ctx context.Context, cfg *Config, opts ...gauditor.Option) (*gauditor.Recorder, error) {
+	store, err := NewStorage(ctx, cfg.Storage)
+	if err != nil {
+		return nil, err
 	}
+	return gauditor.NewRecorder(store, opts...), nil
+}
 
+// ConfigFromEnv returns the configuration read by NewRecorderFromEnv: the
+// file named by GAUDITOR_CONFIG, or one built from the variables listed there.
+func ConfigFromEnv() (*Config, error) {
+	if path := os.Getenv("GAUDITOR_CONFIG"); path != "" {
+		return LoadConfig(path)
+	}
+	backend := strings.ToLower(strings.TrimSpace(os.Getenv("GAUDITOR_STORAGE")))
+	if backend == "" {
+		backend = "memory"
+	}
+	opts := Options{}
+	set := func(key, env string) {
+		if v := os.Getenv(env); v != "" {
+			opts[key] = v
+		}
+	}
 	switch backend {
+	case "memory":
+		set("snapshot", "GAUDITOR_SNAPSHOT")
 	case "redis":
-		addr := os.Getenv("REDIS_ADDR")
-		if addr == "" {
-			addr = "127.0.0.1:6379"
-		}
-		prefix := os.Getenv("REDIS_KEY_PREFIX")
-		if prefix == "" {
-			prefix = "gauditor:"
-		}
-		rdb := redis.NewClient(&redis.Options{Addr: addr})
-		store := redisstore.New(rdb, redisstore.WithKeyPrefix(prefix))
-		return gauditor.NewRecorder(store, opts...), nil
-
+		set("url", "REDIS_URL")
+		set("addr", "REDIS_ADDR")
+		set("password", "REDIS_PASSWORD")
+		set("keyPrefix", "REDIS_KEY_PREFIX")
 	case "sql":
-		driver := os.Getenv("SQL_DRIVER")
-		dsn := os.Getenv("SQL_DSN")
-		db, err := sql.Open(driver, dsn)
-		if err != nil {
-			return nil, err
-		}
-		store := sqlstore.New(db)
-		if os.Getenv("GAUDITOR_SQL_ENSURE_SCHEMA") != "0" {
-			if err := store.EnsureSchema(ctx); err != nil {
-				return nil, err
-			}
-		}
-		return gauditor.NewRecorder(store, opts...), nil
-
+		set("driver", "SQL_DRIVER")
+		set("dsn", "SQL_DSN")
+		opts["ensureSchema"] = os.Getenv("GAUDITOR_SQL_ENSURE_SCHEMA") != "0"
 	case "s3":
-		bucket := os.Getenv("S3_BUCKET")
-		prefix := os.Getenv("S3_PREFIX")
-		if prefix == "" {
-			prefix = "gauditor"
-		}
-		cfg, err := config.LoadDefaultConfig(ctx)
-		if err != nil {
-			return nil, err
-		}
-		cli := s3.NewFromConfig(cfg)
-		store := s3store.New(cli, bucket, prefix)
-		return gauditor.NewRecorder(store, opts...), nil
+		set("bucket", "S3_BUCKET")
+		set("prefix", "S3_PREFIX")
 	}
-
-	// Fallback to memory for unknown backend
-	return gauditor.NewRecorder(gauditor.NewMemoryStorage(), opts...), nil
+	return &Config{Storage: StorageConfig{Backend: backend, Options: opts}}, nil
 }
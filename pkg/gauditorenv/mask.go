@@ -0,0 +1,83 @@
+package gauditorenv
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// masked replaces secrets in Config.Masked.
+const masked = "****"
+
+var (
+	// secretKey matches option names whose values are secrets.
+	secretKey = regexp.MustCompile(`(?i)(password|passwd|secret|token|credential|apikey|privatekey)`)
+	// dsnPassword matches key=value passwords in DSNs such as
+	// "host=db user=app password=x" or "...;Password=x".
+	dsnPassword = regexp.MustCompile(`(?i)\b(password|pwd)=([^\s;&]*)`)
+	// mysqlUserinfo matches "user:password@" at the start of a MySQL DSN.
+	mysqlUserinfo = regexp.MustCompile(`^([^:@/]*):([^@]*)@`)
+)
+
+// Masked returns a copy of c that is safe to log: options named like
+// secrets are replaced by "****", and so are passwords inside URLs and DSNs.
+func (c *Config) Masked() *Config {
+	return &Config{Storage: c.Storage.masked()}
+}
+
+func (sc StorageConfig) masked() StorageConfig {
+	out := StorageConfig{Backend: sc.Backend, Options: maskOptions(sc.Options)}
+	for _, w := range sc.Wrappers {
+		mw := WrapperConfig{Type: w.Type, Options: maskOptions(w.Options)}
+		for _, s := range w.Stores {
+			mw.Stores = append(mw.Stores, s.masked())
+		}
+		out.Wrappers = append(out.Wrappers, mw)
+	}
+	return out
+}
+
+func maskOptions(o Options) Options {
+	if o == nil {
+		return nil
+	}
+	out := make(Options, len(o))
+	for k, v := range o {
+		out[k] = maskValue(k, v)
+	}
+	return out
+}
+
+func maskValue(key string, v any) any {
+	switch v := v.(type) {
+	case string:
+		if secretKey.MatchString(key) && v != "" {
+			return masked
+		}
+		return maskString(v)
+	case map[string]any:
+		return map[string]any(maskOptions(v))
+	case []any:
+		out := make([]any, len(v))
+		for i, e := range v {
+			out[i] = maskValue(key, e)
+		}
+		return out
+	}
+	return v
+}
+
+// maskString hides the password of URLs and DSNs.
+func maskString(s string) string {
+	if u, err := url.Parse(s); err == nil && u.User != nil {
+		if _, ok := u.User.Password(); ok {
+			u.User = url.UserPassword(u.User.Username(), masked)
+			s = strings.Replace(u.String(), url.QueryEscape(masked), masked, 1)
+		}
+	}
+	s = dsnPassword.ReplaceAllString(s, "${1}="+masked)
+	if strings.Contains(s, "@tcp(") || strings.Contains(s, "@unix(") {
+		s = mysqlUserinfo.ReplaceAllString(s, "${1}:"+masked+"@")
+	}
+	return s
+}
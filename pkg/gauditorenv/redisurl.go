@@ -0,0 +1,97 @@
+package gauditorenv
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// newRedisClient builds a Redis client from REDIS_URL, or from REDIS_ADDR when
+// no URL is given. Accepted URL forms:
+//
+//	redis://[user:password@]host:port[/db]
+//	redis+sentinel://[user:password@]host:port[,host:port...][/db]?master=name
+//	redis+cluster://[user:password@]host:port[,host:port...]
+//
+// The "rediss" variants (rediss://, rediss+sentinel://, rediss+cluster://)
+// enable TLS. Query parameters: master (required for sentinel),
+// sentinel_password, and insecure_skip_verify=true to disable TLS certificate
+// checks. REDIS_PASSWORD, when set, supplies the password if the URL has none.
+func newRedisClient(rawURL, addr, password string) (redis.UniversalClient, error) {
+	if rawURL == "" {
+		if addr == "" {
+			addr = "127.0.0.1:6379"
+		}
+		return redis.NewClient(&redis.Options{Addr: addr, Password: password}), nil
+	}
+	opts, mode, err := parseRedisURL(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Password == "" {
+		opts.Password = password
+	}
+	switch mode {
+	case "cluster":
+		return redis.NewClusterClient(opts.Cluster()), nil
+	case "sentinel":
+		return redis.NewFailoverClient(opts.Failover()), nil
+	}
+	return redis.NewClient(opts.Simple()), nil
+}
+
+// parseRedisURL parses the REDIS_URL forms described on newRedisClient and
+// returns the options with the deployment mode: "single", "sentinel" or "cluster".
+func parseRedisURL(rawURL string) (*redis.UniversalOptions, string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, "", fmt.Errorf("gauditorenv: invalid REDIS_URL: %w", err)
+	}
+	scheme, mode, _ := strings.Cut(u.Scheme, "+")
+	if mode == "" {
+		mode = "single"
+	}
+	if (scheme != "redis" && scheme != "rediss") || (mode != "single" && mode != "sentinel" && mode != "cluster") {
+		return nil, "", fmt.Errorf("gauditorenv: unsupported REDIS_URL scheme %q", u.Scheme)
+	}
+	opts := &redis.UniversalOptions{}
+	for _, a := range strings.Split(u.Host, ",") {
+		if a = strings.TrimSpace(a); a != "" {
+			opts.Addrs = append(opts.Addrs, a)
+		}
+	}
+	if len(opts.Addrs) == 0 {
+		return nil, "", fmt.Errorf("gauditorenv: REDIS_URL has no host")
+	}
+	if mode == "single" && len(opts.Addrs) > 1 {
+		return nil, "", fmt.Errorf("gauditorenv: REDIS_URL lists several hosts; use redis+cluster:// or redis+sentinel://")
+	}
+	if u.User != nil {
+		opts.Username = u.User.Username()
+		opts.Password, _ = u.User.Password()
+	}
+	if db := strings.Trim(u.Path, "/"); db != "" {
+		if mode == "cluster" {
+			return nil, "", fmt.Errorf("gauditorenv: Redis Cluster does not support selecting a DB")
+		}
+		if opts.DB, err = strconv.Atoi(db); err != nil {
+			return nil, "", fmt.Errorf("gauditorenv: invalid REDIS_URL db %q", db)
+		}
+	}
+	params := u.Query()
+	opts.MasterName = params.Get("master")
+	opts.SentinelPassword = params.Get("sentinel_password")
+	if mode == "sentinel" && opts.MasterName == "" {
+		return nil, "", fmt.Errorf("gauditorenv: REDIS_URL sentinel mode requires ?master=name")
+	}
+	if scheme == "rediss" {
+		skip, _ := strconv.ParseBool(params.Get("insecure_skip_verify"))
+		// ServerName is left empty so each node is verified against its own address.
+		opts.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12, InsecureSkipVerify: skip}
+	}
+	return opts, mode, nil
+}
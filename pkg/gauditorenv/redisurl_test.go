@@ -0,0 +1,52 @@
+package gauditorenv
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseRedisURL(t *testing.T) {
+	cases := []struct {
+		url      string
+		mode     string
+		addrs    []string
+		password string
+		db       int
+		master   string
+		tls      bool
+	}{
+		{"redis://localhost:6379", "single", []string{"localhost:6379"}, "", 0, "", false},
+		{"rediss://:secret@redis.internal:6380/2", "single", []string{"redis.internal:6380"}, "secret", 2, "", true},
+		{"redis+sentinel://:pw@s1:26379,s2:26379/1?master=audit&sentinel_password=sp", "sentinel", []string{"s1:26379", "s2:26379"}, "pw", 1, "audit", false},
+		{"rediss+cluster://n1:6379,n2:6379", "cluster", []string{"n1:6379", "n2:6379"}, "", 0, "", true},
+	}
+	for _, tc := range cases {
+		opts, mode, err := parseRedisURL(tc.url)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.url, err)
+		}
+		if mode != tc.mode || !reflect.DeepEqual(opts.Addrs, tc.addrs) || opts.Password != tc.password ||
+			opts.DB != tc.db || opts.MasterName != tc.master || (opts.TLSConfig != nil) != tc.tls {
+			t.Errorf("%s: unexpected result mode=%s %+v", tc.url, mode, opts)
+		}
+	}
+	if opts, _, _ := parseRedisURL("redis+sentinel://s1:26379?master=m&sentinel_password=sp"); opts.SentinelPassword != "sp" {
+		t.Errorf("sentinel password not parsed")
+	}
+}
+
+func TestParseRedisURL_Errors(t *testing.T) {
+	for _, bad := range []string{
+		"http://localhost:6379",
+		"redis+foo://localhost",
+		"redis://a:1,b:2",
+		"redis+sentinel://s1:26379",
+		"redis+cluster://n1:6379/3",
+		"redis://localhost:6379/x",
+		"redis:///0",
+	} {
+		if _, _, err := parseRedisURL(bad); err == nil {
+			t.Errorf("%s: expected error", bad)
+		}
+	}
+}
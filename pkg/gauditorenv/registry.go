@@ -0,0 +1,108 @@
+package gauditorenv
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+
+	gauditor "github.com/antoniomarcosferreira/gauditor/pkg/gauditor"
+)
+
+// Factory builds a storage backend from its configured options.
+type Factory func(ctx context.Context, opts Options) (gauditor.Storage, error)
+
+// WrapperFactory builds a storage wrapper around next. Wrappers that need
+// stores of their own, like fanout, build them with NewStorage from
+// cfg.Stores.
+type WrapperFactory func(ctx context.Context, next gauditor.Storage, cfg WrapperConfig) (gauditor.Storage, error)
+
+var (
+	registryMu sync.RWMutex
+	backends   = make(map[string]Factory)
+	wrappers   = make(map[string]WrapperFactory)
+)
+
+// Register makes a storage backend available under name, usually from the
+// init function of the package implementing it. Like database/sql.Register,
+// it panics if name is registered twice or factory is nil.
+func Register(name string, factory Factory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if factory == nil {
+		panic("gauditorenv: Register factory is nil")
+	}
+	if _, dup := backends[name]; dup {
+		panic("gauditorenv: Register called twice for backend " + name)
+	}
+	backends[name] = factory
+}
+
+// RegisterWrapper makes a storage wrapper available under name. It panics
+// if name is registered twice or factory is nil.
+func RegisterWrapper(name string, factory WrapperFactory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if factory == nil {
+		panic("gauditorenv: RegisterWrapper factory is nil")
+	}
+	if _, dup := wrappers[name]; dup {
+		panic("gauditorenv: RegisterWrapper called twice for wrapper " + name)
+	}
+	wrappers[name] = factory
+}
+
+// Backends returns the sorted names of the registered backends.
+func Backends() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	names := make([]string, 0, len(backends))
+	for name := range backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewStorage builds the storage pipeline described by cfg: the backend,
+// then its wrappers with the first one outermost, so a Save passes through
+// them in the order listed. Built-in backends and wrappers implement
+// io.Closer; close the returned storage on shutdown to flush buffers and
+// release connections.
+func NewStorage(ctx context.Context, cfg StorageConfig) (gauditor.Storage, error) {
+	registryMu.RLock()
+	factory := backends[cfg.Backend]
+	registryMu.RUnlock()
+	if factory == nil {
+		return nil, fmt.Errorf("gauditorenv: unknown storage backend %q (registered: %v)", cfg.Backend, Backends())
+	}
+	store, err := factory(ctx, cfg.Options)
+	if err != nil {
+		return nil, fmt.Errorf("gauditorenv: %s backend: %w", cfg.Backend, err)
+	}
+	for i := len(cfg.Wrappers) - 1; i >= 0; i-- {
+		w := cfg.Wrappers[i]
+		registryMu.RLock()
+		wrap := wrappers[w.Type]
+		registryMu.RUnlock()
+		if wrap == nil {
+			closeStorage(store)
+			return nil, fmt.Errorf("gauditorenv: unknown storage wrapper %q", w.Type)
+		}
+		wrapped, err := wrap(ctx, store, w)
+		if err != nil {
+			closeStorage(store)
+			return nil, fmt.Errorf("gauditorenv: %s wrapper: %w", w.Type, err)
+		}
+		store = wrapped
+	}
+	return store, nil
+}
+
+// closeStorage releases a partially built pipeline.
+func closeStorage(s gauditor.Storage) {
+	if c, ok := s.(io.Closer); ok {
+		_ = c.Close()
+	}
+}
@@ -0,0 +1,61 @@
+package gauditorenv
+
+import (
+	"errors"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	gauditor "github.com/antoniomarcosferreira/gauditor/pkg/gauditor"
+)
+
+// snapshotStorage is a memory backend kept in a snapshot file: restored when
+// opened, rewritten every interval and on Close.
+type snapshotStorage struct {
+	*gauditor.MemoryStorage
+	path string
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+func openSnapshot(store *gauditor.MemoryStorage, path string, interval time.Duration) (*snapshotStorage, error) {
+	if err := store.RestoreFile(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	s := &snapshotStorage{MemoryStorage: store, path: path, stop: make(chan struct{}), done: make(chan struct{})}
+	go s.loop(interval)
+	return s, nil
+}
+
+func (s *snapshotStorage) loop(interval time.Duration) {
+	defer close(s.done)
+	if interval <= 0 {
+		<-s.stop
+		return
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			if err := s.SnapshotFile(s.path); err != nil {
+				log.Println("gauditorenv: snapshot:", err)
+			}
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// Close stops periodic snapshots and writes a final one.
+func (s *snapshotStorage) Close() error {
+	err := errors.New("gauditorenv: snapshot storage already closed")
+	s.once.Do(func() {
+		close(s.stop)
+		<-s.done
+		err = s.SnapshotFile(s.path)
+	})
+	return err
+}